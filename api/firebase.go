@@ -2,38 +2,50 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 type FirebaseApi interface {
-	SignInWithPassword(email, password string) (map[string]interface{}, error)
-	SignUpWithPassword(name, email, password string) (map[string]interface{}, error)
-	ExchangeRefreshTokenForIDToken(refreshToken string) (map[string]interface{}, error)
+	SignInWithPassword(ctx context.Context, email, password string) (map[string]interface{}, error)
+	SignUpWithPassword(ctx context.Context, name, email, password string) (map[string]interface{}, error)
+	ExchangeRefreshTokenForIDToken(ctx context.Context, refreshToken string) (map[string]interface{}, error)
 }
 
 type firebaseApi struct {
 	apiKey string
+	client *http.Client
 }
 
 func NewFirebaseApi(apiKey string) FirebaseApi {
 	return &firebaseApi{
 		apiKey: apiKey,
+		// Outgoing calls are traced; the span name omits the URL because it carries the API key
+		client: &http.Client{
+			Transport: otelhttp.NewTransport(http.DefaultTransport,
+				otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+					return "firebase " + r.Method + " " + r.URL.Path
+				}),
+			),
+		},
 	}
 }
 
-func (fi *firebaseApi) SignInWithPassword(email, password string) (map[string]interface{}, error) {
+func (fi *firebaseApi) SignInWithPassword(ctx context.Context, email, password string) (map[string]interface{}, error) {
 	loginPayload := map[string]interface{}{
 		"email":             email,
 		"password":          password,
 		"returnSecureToken": true,
 	}
 
-	return fi.callFirebaseAuthAPI("signInWithPassword", loginPayload)
+	return fi.callFirebaseAuthAPI(ctx, "signInWithPassword", loginPayload)
 }
 
-func (fi *firebaseApi) SignUpWithPassword(name, email, password string) (map[string]interface{}, error) {
+func (fi *firebaseApi) SignUpWithPassword(ctx context.Context, name, email, password string) (map[string]interface{}, error) {
 	signUpPayload := map[string]interface{}{
 		"email":             email,
 		"password":          password,
@@ -41,19 +53,19 @@ func (fi *firebaseApi) SignUpWithPassword(name, email, password string) (map[str
 		"displayName":       name,
 	}
 
-	return fi.callFirebaseAuthAPI("signUp", signUpPayload)
+	return fi.callFirebaseAuthAPI(ctx, "signUp", signUpPayload)
 }
 
-func (fi *firebaseApi) ExchangeRefreshTokenForIDToken(refreshToken string) (map[string]interface{}, error) {
+func (fi *firebaseApi) ExchangeRefreshTokenForIDToken(ctx context.Context, refreshToken string) (map[string]interface{}, error) {
 	tokenPayload := map[string]interface{}{
 		"grant_type":    "refresh_token",
 		"refresh_token": refreshToken,
 	}
 
-	return fi.callFirebaseAuthAPI("token", tokenPayload)
+	return fi.callFirebaseAuthAPI(ctx, "token", tokenPayload)
 }
 
-func (fi *firebaseApi) callFirebaseAuthAPI(endpoint string, payload map[string]interface{}) (map[string]interface{}, error) {
+func (fi *firebaseApi) callFirebaseAuthAPI(ctx context.Context, endpoint string, payload map[string]interface{}) (map[string]interface{}, error) {
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
 		return nil, errors.New("failed to marshal request payload: " + err.Error())
@@ -64,7 +76,13 @@ func (fi *firebaseApi) callFirebaseAuthAPI(endpoint string, payload map[string]i
 		url = "https://securetoken.googleapis.com/v1/" + endpoint + "?key=" + fi.apiKey
 	}
 
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return nil, errors.New("failed to build Firebase Auth API request: " + err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := fi.client.Do(req)
 	if err != nil {
 		return nil, errors.New("failed to call Firebase Auth API: " + err.Error())
 	}
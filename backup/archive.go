@@ -0,0 +1,319 @@
+// Package backup exports accounts to ZIP archives and restores them, both
+// for a single user's "export my data" and for whole-database backups.
+//
+// An archive holds a manifest.json and, per account, a folder named after
+// the user ID with profile.json, tasks.json and tasks.csv. The CSV is for
+// people and spreadsheets; restores read the JSON.
+package backup
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/Zenk41/go-gin-htmx/models"
+)
+
+// Version is the archive format written by this package
+const Version = 1
+
+// maxEntrySize bounds how much is read from one file of an archive
+const maxEntrySize = 64 << 20
+
+// Manifest describes an archive
+type Manifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Accounts  []string  `json:"accounts"`
+	Tasks     int       `json:"tasks"`
+}
+
+// Profile is a user as stored in an archive
+type Profile struct {
+	ID                 string    `json:"id"`
+	Email              string    `json:"email"`
+	Name               string    `json:"name"`
+	Timezone           string    `json:"timezone"`
+	NotifyEmail        bool      `json:"notify_email"`
+	ReminderWebhookURL string    `json:"reminder_webhook_url,omitempty"`
+	DigestEnabled      bool      `json:"digest_enabled"`
+	DigestHour         int       `json:"digest_hour"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+	// PasswordHash and ReminderWebhookSecret are only written to full backups
+	PasswordHash          string `json:"password_hash,omitempty"`
+	ReminderWebhookSecret string `json:"reminder_webhook_secret,omitempty"`
+}
+
+// Reminder is a task reminder as stored in an archive
+type Reminder struct {
+	MinutesBefore int    `json:"minutes_before"`
+	At            string `json:"at,omitempty"`
+}
+
+// Task is a task as stored in an archive
+type Task struct {
+	ID          string     `json:"id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Status      string     `json:"status"`
+	Date        string     `json:"date"`
+	DueTime     string     `json:"due_time,omitempty"`
+	Reminders   []Reminder `json:"reminders,omitempty"`
+	Recurrence  string     `json:"recurrence,omitempty"`
+	ICalUID     string     `json:"ical_uid,omitempty"`
+	DavName     string     `json:"dav_name,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// Account is one user and their tasks
+type Account struct {
+	Profile Profile
+	Tasks   []Task
+}
+
+// ProfileOf converts a user. Secrets are left out unless withPassword,
+// which only full backups set.
+func ProfileOf(user models.User, withPassword bool) Profile {
+	profile := Profile{
+		ID:                 user.UserID,
+		Email:              user.Email,
+		Name:               user.Name,
+		Timezone:           user.Timezone,
+		NotifyEmail:        user.NotifyEmail,
+		ReminderWebhookURL: user.ReminderWebhookURL,
+		DigestEnabled:      user.DigestEnabled,
+		DigestHour:         user.DigestHour,
+		CreatedAt:          user.CreatedAt,
+		UpdatedAt:          user.UpdatedAt,
+	}
+	if withPassword {
+		profile.PasswordHash = user.Password
+		profile.ReminderWebhookSecret = user.ReminderWebhookSecret
+	}
+	return profile
+}
+
+// User converts the profile back
+func (p Profile) User() models.User {
+	return models.User{
+		UserID:                p.ID,
+		Email:                 p.Email,
+		Password:              p.PasswordHash,
+		Name:                  p.Name,
+		Timezone:              p.Timezone,
+		NotifyEmail:           p.NotifyEmail,
+		ReminderWebhookURL:    p.ReminderWebhookURL,
+		ReminderWebhookSecret: p.ReminderWebhookSecret,
+		DigestEnabled:         p.DigestEnabled,
+		DigestHour:            p.DigestHour,
+		CreatedAt:             p.CreatedAt,
+		UpdatedAt:             p.UpdatedAt,
+	}
+}
+
+// TaskOf converts a task
+func TaskOf(task models.Task) Task {
+	t := Task{
+		ID:          task.TaskID,
+		Title:       task.Title,
+		Description: task.Description,
+		Status:      task.Status,
+		Date:        task.Day().String(),
+		DueTime:     task.DueTime,
+		Recurrence:  task.Recurrence,
+		ICalUID:     task.ICalUID,
+		DavName:     task.DavName,
+		Tags:        task.Tags,
+		CreatedAt:   task.CreatedAt,
+		UpdatedAt:   task.UpdatedAt,
+	}
+	for _, r := range task.Reminders {
+		t.Reminders = append(t.Reminders, Reminder{MinutesBefore: r.MinutesBefore, At: r.At})
+	}
+	return t
+}
+
+// Payload converts the task back into a task of userID
+func (t Task) Payload(userID string) (models.TaskPayload, error) {
+	if t.ID == "" || strings.Contains(t.ID, "/") {
+		return models.TaskPayload{}, fmt.Errorf("invalid task ID %q", t.ID)
+	}
+	date, err := civil.ParseDate(t.Date)
+	if err != nil {
+		return models.TaskPayload{}, fmt.Errorf("task %s: invalid date %q", t.ID, t.Date)
+	}
+	if t.DueTime != "" {
+		if _, err := time.Parse("15:04", t.DueTime); err != nil {
+			return models.TaskPayload{}, fmt.Errorf("task %s: invalid due time %q", t.ID, t.DueTime)
+		}
+	}
+
+	payload := models.TaskPayload{
+		TaskID:      t.ID,
+		UserID:      userID,
+		Title:       t.Title,
+		Description: t.Description,
+		Status:      t.Status,
+		Date:        models.StoredDate(date),
+		DueTime:     t.DueTime,
+		Recurrence:  t.Recurrence,
+		ICalUID:     t.ICalUID,
+		DavName:     t.DavName,
+		Tags:        models.NormalizeTags(t.Tags),
+		CreatedAt:   t.CreatedAt,
+		UpdatedAt:   t.UpdatedAt,
+	}
+	for _, r := range t.Reminders {
+		payload.Reminders = append(payload.Reminders, models.Reminder{MinutesBefore: r.MinutesBefore, At: r.At})
+	}
+	return payload, nil
+}
+
+// Writer writes accounts to a ZIP archive as they come, so large backups
+// stream instead of being held in memory
+type Writer struct {
+	zw       *zip.Writer
+	manifest Manifest
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		zw:       zip.NewWriter(w),
+		manifest: Manifest{Version: Version, CreatedAt: time.Now().UTC()},
+	}
+}
+
+// Add writes an account
+func (w *Writer) Add(account Account) error {
+	dir := account.Profile.ID
+	if err := w.writeJSON(path.Join(dir, "profile.json"), account.Profile); err != nil {
+		return err
+	}
+	tasks := account.Tasks
+	if tasks == nil {
+		tasks = []Task{}
+	}
+	if err := w.writeJSON(path.Join(dir, "tasks.json"), tasks); err != nil {
+		return err
+	}
+	if err := w.writeCSV(path.Join(dir, "tasks.csv"), tasks); err != nil {
+		return err
+	}
+	w.manifest.Accounts = append(w.manifest.Accounts, account.Profile.ID)
+	w.manifest.Tasks += len(tasks)
+	return nil
+}
+
+// Close writes the manifest and finishes the archive
+func (w *Writer) Close() error {
+	if err := w.writeJSON("manifest.json", w.manifest); err != nil {
+		return err
+	}
+	return w.zw.Close()
+}
+
+func (w *Writer) writeJSON(name string, v any) error {
+	f, err := w.zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+var csvHeader = []string{"id", "title", "description", "status", "tags", "date", "due_time", "reminders", "recurrence", "created_at", "updated_at"}
+
+func (w *Writer) writeCSV(name string, tasks []Task) error {
+	f, err := w.zw.Create(name)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(f)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, t := range tasks {
+		var reminders []string
+		for _, r := range t.Reminders {
+			reminders = append(reminders, models.Reminder{MinutesBefore: r.MinutesBefore, At: r.At}.Label())
+		}
+		if err := cw.Write([]string{
+			t.ID, t.Title, t.Description, t.Status, strings.Join(t.Tags, ", "), t.Date, t.DueTime,
+			strings.Join(reminders, "; "), t.Recurrence,
+			t.CreatedAt.Format(time.RFC3339), t.UpdatedAt.Format(time.RFC3339),
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Read reads the accounts of an archive
+func Read(r io.ReaderAt, size int64) (Manifest, []Account, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return Manifest{}, nil, fmt.Errorf("not a ZIP archive: %w", err)
+	}
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	var manifest Manifest
+	if err := readJSON(files, "manifest.json", &manifest); err != nil {
+		return Manifest{}, nil, err
+	}
+	if manifest.Version < 1 || manifest.Version > Version {
+		return Manifest{}, nil, fmt.Errorf("unsupported archive version %d", manifest.Version)
+	}
+
+	accounts := make([]Account, 0, len(manifest.Accounts))
+	for _, id := range manifest.Accounts {
+		var account Account
+		if err := readJSON(files, path.Join(id, "profile.json"), &account.Profile); err != nil {
+			return Manifest{}, nil, err
+		}
+		if err := readJSON(files, path.Join(id, "tasks.json"), &account.Tasks); err != nil {
+			return Manifest{}, nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return manifest, accounts, nil
+}
+
+func readJSON(files map[string]*zip.File, name string, v any) error {
+	f, ok := files[name]
+	if !ok {
+		return fmt.Errorf("archive has no %s", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, maxEntrySize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxEntrySize {
+		return errors.New(name + " is larger than " + strconv.Itoa(maxEntrySize>>20) + " MB")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
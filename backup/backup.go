@@ -0,0 +1,200 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/Zenk41/go-gin-htmx/history"
+	"github.com/Zenk41/go-gin-htmx/models"
+	"github.com/Zenk41/go-gin-htmx/utils"
+	"github.com/Zenk41/go-gin-htmx/webhooks"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ReminderScheduler reschedules the reminders of restored tasks
+type ReminderScheduler interface {
+	Reschedule(ctx context.Context, task models.Task, loc *time.Location) error
+}
+
+// Export writes the account of one user, without secrets
+func Export(ctx context.Context, w io.Writer, user models.User, tasks models.TaskRepository) error {
+	list, err := tasks.GetAllTasks(ctx, user.UserID)
+	if err != nil {
+		return err
+	}
+
+	aw := NewWriter(w)
+	if err := aw.Add(account(user, *list, false)); err != nil {
+		return err
+	}
+	return aw.Close()
+}
+
+// Backup writes every user and task of the repositories, password hashes
+// included, and returns the manifest it wrote
+func Backup(ctx context.Context, w io.Writer, users models.UserRepository, tasks models.TaskRepository) (Manifest, error) {
+	all, err := users.ListUsers(ctx)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	aw := NewWriter(w)
+	for _, user := range all {
+		list, err := tasks.GetAllTasks(ctx, user.UserID)
+		if err != nil {
+			return Manifest{}, fmt.Errorf("tasks of %s: %w", user.UserID, err)
+		}
+		if err := aw.Add(account(user, *list, true)); err != nil {
+			return Manifest{}, err
+		}
+	}
+	return aw.manifest, aw.Close()
+}
+
+func account(user models.User, tasks []models.Task, withPassword bool) Account {
+	account := Account{Profile: ProfileOf(user, withPassword)}
+	for _, task := range tasks {
+		account.Tasks = append(account.Tasks, TaskOf(task))
+	}
+	return account
+}
+
+// Result counts what a restore did
+type Result struct {
+	// Users is the number of users created
+	Users int
+	// Restored is the number of tasks created or overwritten
+	Restored int
+	// Kept is the number of tasks left alone because they are the same as,
+	// or newer than, the archived version
+	Kept int
+	// Problems explains each task that could not be fully restored
+	Problems []string
+}
+
+// Restorer writes archived accounts back through the repositories, so it
+// restores into whichever backend they are. Restoring the same archive
+// twice changes nothing the second time.
+type Restorer struct {
+	Users models.UserRepository
+	Tasks models.TaskRepository
+	// Reminders is optional; restored tasks get their reminders scheduled
+	Reminders ReminderScheduler
+}
+
+// Restore restores every account of a full backup under its own user ID,
+// creating the users that are missing
+func (r *Restorer) Restore(ctx context.Context, accounts []Account) (Result, error) {
+	var result Result
+	for _, account := range accounts {
+		user, err := r.Users.GetUser(ctx, account.Profile.ID)
+		switch {
+		case status.Code(err) == codes.NotFound:
+			created := account.Profile.User()
+			if err := r.Users.CreateUser(ctx, created); err != nil {
+				return result, fmt.Errorf("user %s: %w", account.Profile.ID, err)
+			}
+			user = &created
+			result.Users++
+		case err != nil:
+			return result, fmt.Errorf("user %s: %w", account.Profile.ID, err)
+		}
+
+		if err := r.restoreTasks(ctx, *user, account.Tasks, &result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// RestoreInto restores an exported account into user, whatever ID it was
+// exported from. Settings are taken over when the export is newer.
+func (r *Restorer) RestoreInto(ctx context.Context, user models.User, account Account) (Result, error) {
+	var result Result
+	profile := account.Profile
+	if profile.UpdatedAt.After(user.UpdatedAt) {
+		user.Name = profile.Name
+		if utils.IsValidTimezone(profile.Timezone) {
+			user.Timezone = profile.Timezone
+		}
+		user.NotifyEmail = profile.NotifyEmail
+		if err := checkWebhookURL(ctx, profile.ReminderWebhookURL); err != nil {
+			result.Problems = append(result.Problems, fmt.Sprintf("reminder webhook not restored: %v", err))
+		} else {
+			user.ReminderWebhookURL = profile.ReminderWebhookURL
+		}
+		if user.ReminderWebhookURL != "" && user.ReminderWebhookSecret == "" {
+			secret, err := webhooks.NewSecret()
+			if err != nil {
+				return result, err
+			}
+			user.ReminderWebhookSecret = secret
+		}
+		user.DigestEnabled = profile.DigestEnabled
+		user.DigestHour = profile.DigestHour
+		user.UpdatedAt = profile.UpdatedAt
+		if err := r.Users.UpdateUser(ctx, user); err != nil {
+			return result, err
+		}
+	}
+
+	err := r.restoreTasks(ctx, user, account.Tasks, &result)
+	return result, err
+}
+
+// checkWebhookURL makes an exported reminder webhook pass the checks the
+// settings page makes, as the archive may have been edited
+func checkWebhookURL(ctx context.Context, raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("not an http(s) URL")
+	}
+	return webhooks.CheckURL(ctx, raw)
+}
+
+func (r *Restorer) restoreTasks(ctx context.Context, user models.User, tasks []Task, result *Result) error {
+	ctx = history.WithSource(ctx, history.SourceBackup)
+	for _, task := range tasks {
+		payload, err := task.Payload(user.UserID)
+		if err != nil {
+			result.Problems = append(result.Problems, err.Error())
+			continue
+		}
+
+		current, err := r.Tasks.GetTaskById(ctx, user.UserID, payload.TaskID)
+		switch {
+		case errors.Is(err, models.ErrTaskNotFound):
+		case err != nil:
+			return fmt.Errorf("task %s: %w", payload.TaskID, err)
+		case !current.UpdatedAt.Before(payload.UpdatedAt):
+			result.Kept++
+			continue
+		}
+
+		_, err = r.Tasks.CreateTask(ctx, payload, "")
+		if errors.Is(err, models.ErrTaskExists) {
+			// Task IDs are global, never overwrite someone else's task
+			result.Problems = append(result.Problems, fmt.Sprintf("task %s belongs to another account", payload.TaskID))
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("task %s: %w", payload.TaskID, err)
+		}
+		result.Restored++
+
+		if r.Reminders != nil {
+			if err := r.Reminders.Reschedule(ctx, models.Task(payload), user.Location()); err != nil {
+				result.Problems = append(result.Problems, fmt.Sprintf("task %s: reminders not scheduled: %v", payload.TaskID, err))
+			}
+		}
+	}
+	return nil
+}
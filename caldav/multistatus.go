@@ -0,0 +1,138 @@
+package caldav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// prefixes are the namespace prefixes used in responses
+var prefixes = map[string]string{
+	NSDAV:            "d",
+	NSCalDAV:         "c",
+	NSCalendarServer: "cs",
+	NSApple:          "ical",
+}
+
+// Prop is a property of a resource. Value is inner XML, built with Text,
+// Href or Element.
+type Prop struct {
+	Name  xml.Name
+	Value string
+}
+
+// Response describes one resource in a multistatus
+type Response struct {
+	Href string
+	// Status answers for the whole resource instead of per property, e.g.
+	// 404 for a missing multiget href or a deletion in a sync-collection
+	Status int
+	Props  []Prop
+	// Missing lists requested properties the resource does not have
+	Missing []xml.Name
+}
+
+// Multistatus renders a 207 Multi-Status body. syncToken is only written
+// for sync-collection reports.
+func Multistatus(responses []Response, syncToken string) []byte {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString("<d:multistatus")
+	writeNamespaces(&b)
+	b.WriteString(">")
+	for _, r := range responses {
+		b.WriteString("<d:response>")
+		b.WriteString(Href(r.Href))
+		if r.Status != 0 {
+			writeStatus(&b, r.Status)
+		}
+		if len(r.Props) > 0 {
+			b.WriteString("<d:propstat><d:prop>")
+			for _, p := range r.Props {
+				writeElement(&b, p.Name, p.Value)
+			}
+			b.WriteString("</d:prop>")
+			writeStatus(&b, http.StatusOK)
+			b.WriteString("</d:propstat>")
+		}
+		if len(r.Missing) > 0 {
+			b.WriteString("<d:propstat><d:prop>")
+			for _, name := range r.Missing {
+				writeElement(&b, name, "")
+			}
+			b.WriteString("</d:prop>")
+			writeStatus(&b, http.StatusNotFound)
+			b.WriteString("</d:propstat>")
+		}
+		b.WriteString("</d:response>")
+	}
+	if syncToken != "" {
+		b.WriteString("<d:sync-token>" + Text(syncToken) + "</d:sync-token>")
+	}
+	b.WriteString("</d:multistatus>")
+	return b.Bytes()
+}
+
+// Error renders a DAV:error body naming the failed precondition
+func Error(condition xml.Name) []byte {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString("<d:error")
+	writeNamespaces(&b)
+	b.WriteString(">")
+	writeElement(&b, condition, "")
+	b.WriteString("</d:error>")
+	return b.Bytes()
+}
+
+// Text escapes character data
+func Text(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// Href renders a DAV:href of a path
+func Href(path string) string {
+	u := url.URL{Path: path}
+	return "<d:href>" + Text(u.EscapedPath()) + "</d:href>"
+}
+
+// Element renders an empty element, e.g. the DAV:collection of a resourcetype
+func Element(space, local string) string {
+	var b bytes.Buffer
+	writeElement(&b, xml.Name{Space: space, Local: local}, "")
+	return b.String()
+}
+
+func writeNamespaces(b *bytes.Buffer) {
+	spaces := make([]string, 0, len(prefixes))
+	for space := range prefixes {
+		spaces = append(spaces, space)
+	}
+	sort.Strings(spaces)
+	for _, space := range spaces {
+		fmt.Fprintf(b, ` xmlns:%s="%s"`, prefixes[space], Text(space))
+	}
+}
+
+func writeElement(b *bytes.Buffer, name xml.Name, value string) {
+	tag, xmlns := name.Local, ""
+	if prefix, ok := prefixes[name.Space]; ok {
+		tag = prefix + ":" + name.Local
+	} else if name.Space != "" {
+		xmlns = ` xmlns="` + Text(name.Space) + `"`
+	}
+	if value == "" {
+		b.WriteString("<" + tag + xmlns + "/>")
+		return
+	}
+	b.WriteString("<" + tag + xmlns + ">" + value + "</" + tag + ">")
+}
+
+func writeStatus(b *bytes.Buffer, code int) {
+	fmt.Fprintf(b, "<d:status>HTTP/1.1 %d %s</d:status>", code, http.StatusText(code))
+}
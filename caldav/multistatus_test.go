@@ -0,0 +1,167 @@
+package caldav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const namespaces = ` xmlns:d="DAV:" xmlns:ical="http://apple.com/ns/ical/"` +
+	` xmlns:cs="http://calendarserver.org/ns/" xmlns:c="urn:ietf:params:xml:ns:caldav"`
+
+func TestMultistatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []Response
+		syncToken string
+		want      string
+	}{
+		{
+			name: "empty",
+			want: `<d:multistatus` + namespaces + `></d:multistatus>`,
+		},
+		{
+			name: "found and missing props",
+			responses: []Response{{
+				Href: "/caldav/tasks/",
+				Props: []Prop{
+					{Name: xml.Name{Space: NSDAV, Local: "resourcetype"}, Value: Element(NSDAV, "collection") + Element(NSCalDAV, "calendar")},
+					{Name: xml.Name{Space: NSDAV, Local: "displayname"}, Value: Text("Tasks & notes")},
+				},
+				Missing: []xml.Name{
+					{Space: NSApple, Local: "calendar-color"},
+					{Space: "urn:example", Local: "unknown"},
+				},
+			}},
+			want: `<d:multistatus` + namespaces + `>` +
+				`<d:response><d:href>/caldav/tasks/</d:href>` +
+				`<d:propstat><d:prop>` +
+				`<d:resourcetype><d:collection/><c:calendar/></d:resourcetype>` +
+				`<d:displayname>Tasks &amp; notes</d:displayname>` +
+				`</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>` +
+				`<d:propstat><d:prop>` +
+				`<ical:calendar-color/><unknown xmlns="urn:example"/>` +
+				`</d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat>` +
+				`</d:response></d:multistatus>`,
+		},
+		{
+			name: "resource status",
+			responses: []Response{
+				{Href: "/caldav/tasks/a b.ics", Status: http.StatusNotFound},
+				{
+					Href:  "/caldav/tasks/c.ics",
+					Props: []Prop{{Name: xml.Name{Space: NSDAV, Local: "getetag"}, Value: Text(`"1"`)}},
+				},
+			},
+			want: `<d:multistatus` + namespaces + `>` +
+				`<d:response><d:href>/caldav/tasks/a%20b.ics</d:href>` +
+				`<d:status>HTTP/1.1 404 Not Found</d:status></d:response>` +
+				`<d:response><d:href>/caldav/tasks/c.ics</d:href>` +
+				`<d:propstat><d:prop><d:getetag>&#34;1&#34;</d:getetag></d:prop>` +
+				`<d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>` +
+				`</d:multistatus>`,
+		},
+		{
+			name:      "sync token",
+			responses: []Response{{Href: "/caldav/tasks/gone.ics", Status: http.StatusNotFound}},
+			syncToken: "http://example.com/sync/7?a&b",
+			want: `<d:multistatus` + namespaces + `>` +
+				`<d:response><d:href>/caldav/tasks/gone.ics</d:href>` +
+				`<d:status>HTTP/1.1 404 Not Found</d:status></d:response>` +
+				`<d:sync-token>http://example.com/sync/7?a&amp;b</d:sync-token>` +
+				`</d:multistatus>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Multistatus(tt.responses, tt.syncToken)
+			if want := xml.Header + tt.want; string(got) != want {
+				t.Errorf("Multistatus()\n got %s\nwant %s", got, want)
+			}
+			root, err := parse(bytes.NewReader(got))
+			if err != nil {
+				t.Fatalf("Multistatus() is not well-formed: %v", err)
+			}
+			if root.Name != (xml.Name{Space: NSDAV, Local: "multistatus"}) {
+				t.Errorf("Multistatus() root = %v, want DAV: multistatus", root.Name)
+			}
+			if n := len(root.Children); tt.syncToken == "" && n != len(tt.responses) {
+				t.Errorf("Multistatus() has %d children, want %d responses", n, len(tt.responses))
+			}
+		})
+	}
+}
+
+func TestMultistatusHrefsRoundTrip(t *testing.T) {
+	paths := []string{"/caldav/tasks/a.ics", "/caldav/tasks/with space.ics", "/caldav/tasks/ünïcode&more.ics"}
+	var responses []Response
+	for _, path := range paths {
+		responses = append(responses, Response{Href: path, Status: http.StatusOK})
+	}
+
+	root, err := parse(bytes.NewReader(Multistatus(responses, "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, response := range root.Children {
+		href := response.child(NSDAV, "href")
+		if href == nil {
+			t.Fatalf("response %d has no href", i)
+		}
+		// a multiget sends the hrefs back, escaped as they were listed
+		report, err := ParseReport(strings.NewReader(
+			`<c:calendar-multiget xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">` +
+				Href(paths[i]) + `</c:calendar-multiget>`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Hrefs) != 1 || report.Hrefs[0] != href.Text {
+			t.Errorf("multiget of %q read %v, want [%s]", paths[i], report.Hrefs, href.Text)
+		}
+	}
+}
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		condition xml.Name
+		want      string
+	}{
+		{
+			condition: xml.Name{Space: NSDAV, Local: "valid-sync-token"},
+			want:      `<d:error` + namespaces + `><d:valid-sync-token/></d:error>`,
+		},
+		{
+			condition: xml.Name{Space: NSCalDAV, Local: "supported-report"},
+			want:      `<d:error` + namespaces + `><c:supported-report/></d:error>`,
+		},
+	}
+
+	for _, tt := range tests {
+		if got := string(Error(tt.condition)); got != xml.Header+tt.want {
+			t.Errorf("Error(%v)\n got %s\nwant %s", tt.condition, got, xml.Header+tt.want)
+		}
+	}
+}
+
+func TestElement(t *testing.T) {
+	tests := []struct {
+		space, local string
+		want         string
+	}{
+		{NSDAV, "collection", `<d:collection/>`},
+		{NSCalDAV, "calendar", `<c:calendar/>`},
+		{NSCalendarServer, "getctag", `<cs:getctag/>`},
+		{NSApple, "calendar-color", `<ical:calendar-color/>`},
+		{"urn:example", "thing", `<thing xmlns="urn:example"/>`},
+		{"", "bare", `<bare/>`},
+	}
+
+	for _, tt := range tests {
+		if got := Element(tt.space, tt.local); got != tt.want {
+			t.Errorf("Element(%q, %q) = %s, want %s", tt.space, tt.local, got, tt.want)
+		}
+	}
+}
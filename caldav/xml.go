@@ -0,0 +1,237 @@
+// Package caldav reads and writes the XML bodies of the WebDAV and CalDAV
+// methods the task calendar supports (RFC 4918, RFC 4791 and RFC 6578).
+package caldav
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// XML namespaces of the properties the server knows
+const (
+	NSDAV            = "DAV:"
+	NSCalDAV         = "urn:ietf:params:xml:ns:caldav"
+	NSCalendarServer = "http://calendarserver.org/ns/"
+	NSApple          = "http://apple.com/ns/ical/"
+)
+
+// Report kinds
+const (
+	CalendarQuery    = "calendar-query"
+	CalendarMultiget = "calendar-multiget"
+	SyncCollection   = "sync-collection"
+)
+
+// node is a parsed XML element
+type node struct {
+	Name     xml.Name
+	Attr     []xml.Attr
+	Children []*node
+	Text     string
+}
+
+func (n *node) child(space, local string) *node {
+	for _, c := range n.Children {
+		if c.Name.Space == space && c.Name.Local == local {
+			return c
+		}
+	}
+	return nil
+}
+
+func (n *node) attr(local string) string {
+	for _, a := range n.Attr {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// parse reads a whole XML document; an empty body returns nil
+func parse(r io.Reader) (*node, error) {
+	d := xml.NewDecoder(r)
+	var stack []*node
+	var root *node
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			if len(stack) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return root, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &node{Name: t.Name, Attr: t.Attr}
+			if len(stack) == 0 {
+				if root != nil {
+					return nil, errors.New("more than one root element")
+				}
+				root = n
+			} else {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, n)
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].Text += string(t)
+			}
+		}
+	}
+}
+
+// Props selects the properties a PROPFIND or REPORT asks for
+type Props struct {
+	// All is set for allprop and for empty PROPFIND bodies
+	All bool
+	// NamesOnly is set for propname
+	NamesOnly bool
+	Names     []xml.Name
+}
+
+// Wants reports whether the property was asked for by name
+func (p Props) Wants(space, local string) bool {
+	for _, name := range p.Names {
+		if name.Space == space && name.Local == local {
+			return true
+		}
+	}
+	return false
+}
+
+func readProps(n *node) Props {
+	switch {
+	case n.child(NSDAV, "allprop") != nil:
+		return Props{All: true}
+	case n.child(NSDAV, "propname") != nil:
+		return Props{NamesOnly: true}
+	}
+	var props Props
+	if prop := n.child(NSDAV, "prop"); prop != nil {
+		for _, c := range prop.Children {
+			props.Names = append(props.Names, c.Name)
+		}
+	}
+	return props
+}
+
+// ParsePropfind reads a PROPFIND body
+func ParsePropfind(r io.Reader) (Props, error) {
+	root, err := parse(r)
+	if err != nil {
+		return Props{}, err
+	}
+	if root == nil {
+		return Props{All: true}, nil
+	}
+	if root.Name.Space != NSDAV || root.Name.Local != "propfind" {
+		return Props{}, errors.New("expected a DAV:propfind body")
+	}
+	return readProps(root), nil
+}
+
+// Report is a parsed REPORT body
+type Report struct {
+	Kind  string
+	Props Props
+	// Hrefs lists the resources of a calendar-multiget
+	Hrefs []string
+	// Components lists the component names a calendar-query filters on,
+	// e.g. VCALENDAR and VTODO
+	Components []string
+	// Start and End bound the time-range filter of a calendar-query; they
+	// are zero when the filter is open on that side
+	Start, End time.Time
+	// SyncToken is the token of a sync-collection, empty for an initial sync
+	SyncToken string
+}
+
+// ParseReport reads the body of a REPORT the server supports
+func ParseReport(r io.Reader) (Report, error) {
+	root, err := parse(r)
+	if err != nil {
+		return Report{}, err
+	}
+	if root == nil {
+		return Report{}, errors.New("empty report")
+	}
+
+	report := Report{Kind: root.Name.Local, Props: readProps(root)}
+	switch {
+	case root.Name.Space == NSCalDAV && root.Name.Local == CalendarQuery:
+		if filter := root.child(NSCalDAV, "filter"); filter != nil {
+			if err := report.readFilter(filter); err != nil {
+				return Report{}, err
+			}
+		}
+	case root.Name.Space == NSCalDAV && root.Name.Local == CalendarMultiget:
+		for _, c := range root.Children {
+			if c.Name.Space == NSDAV && c.Name.Local == "href" {
+				report.Hrefs = append(report.Hrefs, strings.TrimSpace(c.Text))
+			}
+		}
+	case root.Name.Space == NSDAV && root.Name.Local == SyncCollection:
+		if token := root.child(NSDAV, "sync-token"); token != nil {
+			report.SyncToken = strings.TrimSpace(token.Text)
+		}
+	default:
+		return Report{}, &UnsupportedReportError{Name: root.Name}
+	}
+	return report, nil
+}
+
+// readFilter walks the nested comp-filters of a calendar-query
+func (r *Report) readFilter(n *node) error {
+	for _, c := range n.Children {
+		if c.Name.Space != NSCalDAV {
+			continue
+		}
+		switch c.Name.Local {
+		case "comp-filter":
+			r.Components = append(r.Components, strings.ToUpper(c.attr("name")))
+			if err := r.readFilter(c); err != nil {
+				return err
+			}
+		case "time-range":
+			var err error
+			if r.Start, err = parseUTC(c.attr("start")); err != nil {
+				return err
+			}
+			if r.End, err = parseUTC(c.attr("end")); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func parseUTC(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse("20060102T150405Z", value)
+	if err != nil {
+		return time.Time{}, errors.New("invalid time-range " + value)
+	}
+	return t, nil
+}
+
+// UnsupportedReportError is returned for REPORTs the server does not implement
+type UnsupportedReportError struct {
+	Name xml.Name
+}
+
+func (e *UnsupportedReportError) Error() string {
+	return "unsupported report " + e.Name.Space + " " + e.Name.Local
+}
@@ -0,0 +1,231 @@
+package caldav
+
+import (
+	"encoding/xml"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePropfind(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    Props
+		wantErr bool
+	}{
+		{name: "empty body", body: "", want: Props{All: true}},
+		{
+			name: "allprop",
+			body: `<d:propfind xmlns:d="DAV:"><d:allprop/></d:propfind>`,
+			want: Props{All: true},
+		},
+		{
+			name: "propname",
+			body: `<propfind xmlns="DAV:"><propname/></propfind>`,
+			want: Props{NamesOnly: true},
+		},
+		{
+			name: "named props",
+			body: `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav" xmlns:cs="http://calendarserver.org/ns/">
+  <d:prop>
+    <d:resourcetype/>
+    <c:calendar-home-set/>
+    <cs:getctag/>
+    <x:unknown xmlns:x="urn:example"/>
+  </d:prop>
+</d:propfind>`,
+			want: Props{Names: []xml.Name{
+				{Space: NSDAV, Local: "resourcetype"},
+				{Space: NSCalDAV, Local: "calendar-home-set"},
+				{Space: NSCalendarServer, Local: "getctag"},
+				{Space: "urn:example", Local: "unknown"},
+			}},
+		},
+		{name: "no prop", body: `<d:propfind xmlns:d="DAV:"/>`, want: Props{}},
+		{name: "wrong root", body: `<d:propertyupdate xmlns:d="DAV:"/>`, wantErr: true},
+		{name: "root without namespace", body: `<propfind><allprop/></propfind>`, wantErr: true},
+		{name: "unclosed", body: `<d:propfind xmlns:d="DAV:"><d:prop>`, wantErr: true},
+		{name: "two roots", body: `<d:propfind xmlns:d="DAV:"/><d:propfind xmlns:d="DAV:"/>`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePropfind(strings.NewReader(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePropfind() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParsePropfind()\n got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseReport(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    Report
+		wantErr bool
+	}{
+		{
+			name: "calendar-query with time-range",
+			body: `<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+  <d:prop><d:getetag/><c:calendar-data/></d:prop>
+  <c:filter>
+    <c:comp-filter name="VCALENDAR">
+      <c:comp-filter name="vtodo">
+        <c:time-range start="20240101T000000Z" end="20240201T120000Z"/>
+      </c:comp-filter>
+    </c:comp-filter>
+  </c:filter>
+</c:calendar-query>`,
+			want: Report{
+				Kind: CalendarQuery,
+				Props: Props{Names: []xml.Name{
+					{Space: NSDAV, Local: "getetag"},
+					{Space: NSCalDAV, Local: "calendar-data"},
+				}},
+				Components: []string{"VCALENDAR", "VTODO"},
+				Start:      time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:        time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "calendar-query open ended",
+			body: `<c:calendar-query xmlns:c="urn:ietf:params:xml:ns:caldav">
+  <c:filter><c:comp-filter name="VCALENDAR"><c:time-range start="20240101T000000Z"/></c:comp-filter></c:filter>
+</c:calendar-query>`,
+			want: Report{
+				Kind:       CalendarQuery,
+				Components: []string{"VCALENDAR"},
+				Start:      time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "calendar-query without filter",
+			body: `<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav"><d:allprop/></c:calendar-query>`,
+			want: Report{Kind: CalendarQuery, Props: Props{All: true}},
+		},
+		{
+			name: "calendar-query with invalid time-range",
+			body: `<c:calendar-query xmlns:c="urn:ietf:params:xml:ns:caldav">
+  <c:filter><c:comp-filter name="VCALENDAR"><c:time-range start="2024-01-01"/></c:comp-filter></c:filter>
+</c:calendar-query>`,
+			wantErr: true,
+		},
+		{
+			name: "calendar-multiget",
+			body: `<c:calendar-multiget xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+  <d:prop><d:getetag/></d:prop>
+  <d:href>
+    /caldav/tasks/a.ics
+  </d:href>
+  <d:href>/caldav/tasks/b%20c.ics</d:href>
+</c:calendar-multiget>`,
+			want: Report{
+				Kind:  CalendarMultiget,
+				Props: Props{Names: []xml.Name{{Space: NSDAV, Local: "getetag"}}},
+				Hrefs: []string{"/caldav/tasks/a.ics", "/caldav/tasks/b%20c.ics"},
+			},
+		},
+		{
+			name: "sync-collection",
+			body: `<d:sync-collection xmlns:d="DAV:">
+  <d:sync-token> http://example.com/sync/42 </d:sync-token>
+  <d:sync-level>1</d:sync-level>
+  <d:prop><d:getetag/></d:prop>
+</d:sync-collection>`,
+			want: Report{
+				Kind:      SyncCollection,
+				Props:     Props{Names: []xml.Name{{Space: NSDAV, Local: "getetag"}}},
+				SyncToken: "http://example.com/sync/42",
+			},
+		},
+		{
+			name: "initial sync-collection",
+			body: `<d:sync-collection xmlns:d="DAV:"><d:sync-token/><d:prop><d:getetag/></d:prop></d:sync-collection>`,
+			want: Report{
+				Kind:  SyncCollection,
+				Props: Props{Names: []xml.Name{{Space: NSDAV, Local: "getetag"}}},
+			},
+		},
+		{name: "empty body", body: "", wantErr: true},
+		{name: "malformed", body: `<c:calendar-query xmlns:c="urn:ietf:params:xml:ns:caldav">`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseReport(strings.NewReader(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseReport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseReport()\n got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseReportUnsupported(t *testing.T) {
+	tests := []struct {
+		body string
+		want xml.Name
+	}{
+		{
+			body: `<c:free-busy-query xmlns:c="urn:ietf:params:xml:ns:caldav"/>`,
+			want: xml.Name{Space: NSCalDAV, Local: "free-busy-query"},
+		},
+		{
+			body: `<d:expand-property xmlns:d="DAV:"/>`,
+			want: xml.Name{Space: NSDAV, Local: "expand-property"},
+		},
+		{
+			// calendar-query is only a CalDAV report
+			body: `<d:calendar-query xmlns:d="DAV:"/>`,
+			want: xml.Name{Space: NSDAV, Local: "calendar-query"},
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseReport(strings.NewReader(tt.body))
+		var unsupported *UnsupportedReportError
+		if !errors.As(err, &unsupported) {
+			t.Errorf("ParseReport(%q) error = %v, want an UnsupportedReportError", tt.body, err)
+			continue
+		}
+		if unsupported.Name != tt.want {
+			t.Errorf("ParseReport(%q) unsupported %v, want %v", tt.body, unsupported.Name, tt.want)
+		}
+	}
+}
+
+func TestPropsWants(t *testing.T) {
+	props := Props{Names: []xml.Name{
+		{Space: NSDAV, Local: "getetag"},
+		{Space: NSCalendarServer, Local: "getctag"},
+	}}
+	tests := []struct {
+		space, local string
+		want         bool
+	}{
+		{NSDAV, "getetag", true},
+		{NSCalendarServer, "getctag", true},
+		{NSCalDAV, "getetag", false},
+		{NSDAV, "getctag", false},
+		{NSDAV, "displayname", false},
+	}
+
+	for _, tt := range tests {
+		if got := props.Wants(tt.space, tt.local); got != tt.want {
+			t.Errorf("Wants(%q, %q) = %v, want %v", tt.space, tt.local, got, tt.want)
+		}
+	}
+	if (Props{All: true}).Wants(NSDAV, "getetag") {
+		t.Errorf("Wants on allprop = true, want false as no name was asked for")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Zenk41/go-gin-htmx/backup"
+	"github.com/Zenk41/go-gin-htmx/models"
+	"github.com/Zenk41/go-gin-htmx/search"
+)
+
+const commandUsage = `commands:
+  backup <file.zip>   write every user and task to file.zip, or to stdout for "-"
+  restore <file.zip>  restore the users and tasks of a backup; running it twice is safe
+  reindex             rebuild the search index and save it to search.index_file
+  backfill-updated-at set updated_at on tasks saved without it, so CalDAV clients list them`
+
+// runCommand runs an admin command given after the flags, e.g.
+//
+//	app -config app.yaml backup backup.zip
+//
+// Backup and restore only use the repository interfaces, so a backup of
+// one backend can be restored into another.
+func runCommand(ctx context.Context, args []string, users models.UserRepository, tasks models.TaskRepository, reminders backup.ReminderScheduler, index *search.Memory, indexFile string) error {
+	switch {
+	case args[0] == "reindex" && len(args) == 1:
+		return runReindex(ctx, users, tasks, index, indexFile)
+	case args[0] == "backfill-updated-at" && len(args) == 1:
+		return runBackfillUpdatedAt(ctx, tasks)
+	case args[0] == "backup" && len(args) == 2:
+		return runBackup(ctx, args[1], users, tasks)
+	case args[0] == "restore" && len(args) == 2:
+		return runRestore(ctx, args[1], users, tasks, reminders)
+	}
+	return fmt.Errorf("unknown command %q\n%s", args, commandUsage)
+}
+
+func runBackup(ctx context.Context, path string, users models.UserRepository, tasks models.TaskRepository) error {
+	if path == "-" {
+		manifest, err := backup.Backup(ctx, os.Stdout, users, tasks)
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "Backed up %d users and %d tasks\n", len(manifest.Accounts), manifest.Tasks)
+		}
+		return err
+	}
+
+	// Write next to the target and rename, so a failed run never leaves a
+	// truncated backup under the real name
+	tmp, err := os.CreateTemp(dirOf(path), ".backup-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	manifest, err := backup.Backup(ctx, tmp, users, tasks)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Backed up %d users and %d tasks to %s\n", len(manifest.Accounts), manifest.Tasks, path)
+	return nil
+}
+
+func runRestore(ctx context.Context, path string, users models.UserRepository, tasks models.TaskRepository, reminders backup.ReminderScheduler) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	manifest, accounts, err := backup.Read(f, info.Size())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Restoring %d users and %d tasks from a backup of %s\n",
+		len(accounts), manifest.Tasks, manifest.CreatedAt.Format("2006-01-02 15:04 MST"))
+
+	restorer := backup.Restorer{Users: users, Tasks: tasks, Reminders: reminders}
+	result, err := restorer.Restore(ctx, accounts)
+	printResult(os.Stderr, result)
+	return err
+}
+
+func runReindex(ctx context.Context, users models.UserRepository, tasks models.TaskRepository, index *search.Memory, indexFile string) error {
+	if indexFile == "" {
+		return errors.New("search.index_file is not set, the index is rebuilt on every start")
+	}
+	count, err := search.Rebuild(ctx, index, users, tasks)
+	if err != nil {
+		return err
+	}
+	if err := index.Save(indexFile); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Indexed %d tasks into %s\n", count, indexFile)
+	return nil
+}
+
+func runBackfillUpdatedAt(ctx context.Context, tasks models.TaskRepository) error {
+	count, err := tasks.BackfillUpdatedAt(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Set updated_at on %d tasks\n", count)
+	return nil
+}
+
+func printResult(w io.Writer, result backup.Result) {
+	fmt.Fprintf(w, "%d users created, %d tasks restored, %d already up to date\n", result.Users, result.Restored, result.Kept)
+	for _, problem := range result.Problems {
+		fmt.Fprintln(w, "  "+problem)
+	}
+}
+
+func dirOf(path string) string {
+	for i := len(path) - 1; i >= 0; i-- {
+		if os.IsPathSeparator(path[i]) {
+			return path[:i+1]
+		}
+	}
+	return "."
+}
@@ -0,0 +1,274 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Config holds every setting the application needs to start.
+//
+// Each field is addressed by its `config` key. The same key is used in the
+// config file, upper-cased with dots replaced by underscores for the
+// environment (server.read_timeout -> SERVER_READ_TIMEOUT) and with dots and underscores
+// replaced by dashes for flags (api_key -> -api-key).
+type Config struct {
+	Host               string `config:"host" usage:"interface the HTTP server listens on"`
+	Port               string `config:"port" usage:"port the HTTP server listens on"`
+	Domain             string `config:"domain" usage:"domain used for auth cookies"`
+	APIKey             string `config:"api_key" secret:"true" usage:"Firebase web API key"`
+	ServiceAccountFile string `config:"service_account_file" usage:"path to the Firebase service account JSON file"`
+	ProjectID          string `config:"project_id" usage:"Firebase project ID, read from the service account file when empty"`
+
+	Server    ServerConfig    `config:"server"`
+	Health    HealthConfig    `config:"health"`
+	Tracing   TracingConfig   `config:"tracing"`
+	Log       LogConfig       `config:"log"`
+	Scheduler SchedulerConfig `config:"scheduler"`
+	Mail      MailConfig      `config:"mail"`
+	Digest    DigestConfig    `config:"digest"`
+	Search    SearchConfig    `config:"search"`
+	Trash     TrashConfig     `config:"trash"`
+
+	// ConfigFile is the optional YAML or TOML file that was loaded.
+	ConfigFile string `config:"-"`
+	// PrintConfig asks main to print the effective config and exit.
+	PrintConfig bool `config:"-"`
+	// Args are the arguments after the flags: an admin command to run
+	// instead of the server, and its arguments.
+	Args []string `config:"-"`
+}
+
+// ServerConfig tunes the HTTP server.
+type ServerConfig struct {
+	ReadTimeout     time.Duration `config:"read_timeout" usage:"maximum duration for reading an entire request"`
+	WriteTimeout    time.Duration `config:"write_timeout" usage:"maximum duration before timing out writes of a response"`
+	IdleTimeout     time.Duration `config:"idle_timeout" usage:"maximum time to wait for the next request on a keep-alive connection"`
+	ShutdownTimeout time.Duration `config:"shutdown_timeout" usage:"how long in-flight requests may drain on shutdown"`
+}
+
+// HealthConfig tunes the readiness probe.
+type HealthConfig struct {
+	Timeout time.Duration `config:"timeout" usage:"per-dependency timeout for /readyz checks"`
+}
+
+// TracingConfig selects where OpenTelemetry spans are exported.
+type TracingConfig struct {
+	Exporter    string  `config:"exporter" usage:"span exporter: none, stdout or otlp"`
+	Endpoint    string  `config:"endpoint" usage:"OTLP/HTTP collector host:port"`
+	Insecure    bool    `config:"insecure" usage:"send OTLP spans over plain HTTP"`
+	ServiceName string  `config:"service_name" usage:"service.name resource attribute"`
+	SampleRatio float64 `config:"sample_ratio" usage:"fraction of new traces to sample, between 0 and 1"`
+}
+
+// LogConfig configures the shared logger and the request log.
+type LogConfig struct {
+	Level      string   `config:"level" usage:"minimum level: trace, debug, info, warn, error"`
+	Format     string   `config:"format" usage:"json or text"`
+	Output     string   `config:"output" usage:"stdout, stderr or a file path"`
+	SampleRate float64  `config:"sample_rate" usage:"fraction of successful requests to log, between 0 and 1"`
+	RedactKeys []string `config:"redact_keys" usage:"query and form keys whose values are masked in the request log"`
+	SkipPaths  []string `config:"skip_paths" usage:"request paths that are never logged"`
+}
+
+// SchedulerConfig tunes the background reminder scheduler.
+type SchedulerConfig struct {
+	Interval time.Duration `config:"interval" usage:"how often due reminders are looked up"`
+}
+
+// MailConfig configures outgoing email. Email is sent over SMTP when
+// SMTPHost is set, written to Dir when that is set instead, and disabled
+// otherwise.
+type MailConfig struct {
+	SMTPHost string `config:"smtp_host" usage:"SMTP server host"`
+	SMTPPort int    `config:"smtp_port" usage:"SMTP server port"`
+	Username string `config:"username" usage:"SMTP username"`
+	Password string `config:"password" secret:"true" usage:"SMTP password"`
+	From     string `config:"from" usage:"sender address of outgoing email"`
+	BaseURL  string `config:"base_url" usage:"public URL of the app used for links in email"`
+	Dir      string `config:"dir" usage:"write email as .eml files into this directory instead of sending it"`
+}
+
+// Enabled reports whether email can be delivered at all.
+func (m MailConfig) Enabled() bool {
+	return m.SMTPHost != "" || m.Dir != ""
+}
+
+// DigestConfig configures the daily digest email.
+type DigestConfig struct {
+	SigningKey  string `config:"signing_key" secret:"true" usage:"key that signs unsubscribe links"`
+	OverdueDays int    `config:"overdue_days" usage:"how many days back unfinished tasks are listed as overdue"`
+}
+
+// SearchConfig configures the embedded search index.
+type SearchConfig struct {
+	IndexFile    string        `config:"index_file" usage:"file the search index is saved to and loaded from; empty rebuilds it on every start"`
+	SaveInterval time.Duration `config:"save_interval" usage:"how often a changed search index is saved to index_file"`
+}
+
+// TrashConfig configures the trash deleted tasks are moved to.
+type TrashConfig struct {
+	Retention time.Duration `config:"retention" usage:"how long deleted tasks stay in the trash before they are purged"`
+}
+
+// Default returns the configuration used before any source is applied.
+func Default() Config {
+	return Config{
+		Host:   "0.0.0.0",
+		Port:   "8080",
+		Domain: "localhost",
+		Server: ServerConfig{
+			ReadTimeout:     15 * time.Second,
+			WriteTimeout:    30 * time.Second,
+			IdleTimeout:     60 * time.Second,
+			ShutdownTimeout: 20 * time.Second,
+		},
+		Health: HealthConfig{
+			Timeout: 2 * time.Second,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			Endpoint:    "localhost:4318",
+			ServiceName: "go-gin-htmx",
+			SampleRatio: 1,
+		},
+		Log: LogConfig{
+			Level:      "info",
+			Format:     "json",
+			Output:     "stdout",
+			SampleRate: 1,
+			RedactKeys: []string{"password", "token", "id_token", "refresh_token", "api_key", "key", "secret"},
+			SkipPaths:  []string{"/healthz", "/readyz", "/version", "/metrics"},
+		},
+		Scheduler: SchedulerConfig{
+			Interval: 30 * time.Second,
+		},
+		Mail: MailConfig{
+			SMTPPort: 587,
+			BaseURL:  "http://localhost:8080",
+		},
+		Digest: DigestConfig{
+			OverdueDays: 30,
+		},
+		Search: SearchConfig{
+			SaveInterval: time.Minute,
+		},
+		Trash: TrashConfig{
+			Retention: 30 * 24 * time.Hour,
+		},
+	}
+}
+
+// Addr returns the host:port pair the HTTP server listens on.
+func (c *Config) Addr() string {
+	return net.JoinHostPort(c.Host, strings.TrimPrefix(c.Port, ":"))
+}
+
+// Validate checks every setting and reports all problems at once.
+func (c *Config) Validate() error {
+	var errs []error
+
+	port, err := strconv.Atoi(strings.TrimPrefix(c.Port, ":"))
+	if err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("port: %q is not a valid TCP port", c.Port))
+	}
+	if c.APIKey == "" {
+		errs = append(errs, errors.New("api_key: must be set"))
+	}
+	if c.Domain == "" {
+		errs = append(errs, errors.New("domain: must be set"))
+	}
+	if c.ServiceAccountFile == "" {
+		errs = append(errs, errors.New("service_account_file: must be set"))
+	} else if _, err := os.Stat(c.ServiceAccountFile); err != nil {
+		errs = append(errs, fmt.Errorf("service_account_file: %v", err))
+	}
+	for _, timeout := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.shutdown_timeout", c.Server.ShutdownTimeout},
+		{"health.timeout", c.Health.Timeout},
+		{"scheduler.interval", c.Scheduler.Interval},
+		{"search.save_interval", c.Search.SaveInterval},
+		{"trash.retention", c.Trash.Retention},
+	} {
+		if timeout.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s: must be positive, got %s", timeout.key, timeout.value))
+		}
+	}
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		if c.Tracing.Endpoint == "" {
+			errs = append(errs, errors.New("tracing.endpoint: must be set for the otlp exporter"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter: %q is not one of none, stdout, otlp", c.Tracing.Exporter))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("tracing.sample_ratio: %v is not between 0 and 1", c.Tracing.SampleRatio))
+	}
+	if _, err := logrus.ParseLevel(c.Log.Level); err != nil {
+		errs = append(errs, fmt.Errorf("log.level: %v", err))
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		errs = append(errs, fmt.Errorf("log.format: %q is not one of json, text", c.Log.Format))
+	}
+	if c.Log.Output == "" {
+		errs = append(errs, errors.New("log.output: must be set"))
+	}
+	if c.Log.SampleRate < 0 || c.Log.SampleRate > 1 {
+		errs = append(errs, fmt.Errorf("log.sample_rate: %v is not between 0 and 1", c.Log.SampleRate))
+	}
+	if c.Mail.SMTPHost != "" && (c.Mail.SMTPPort < 1 || c.Mail.SMTPPort > 65535) {
+		errs = append(errs, fmt.Errorf("mail.smtp_port: %d is not a valid TCP port", c.Mail.SMTPPort))
+	}
+	if c.Mail.Enabled() {
+		if c.Mail.From == "" {
+			errs = append(errs, errors.New("mail.from: must be set when email is enabled"))
+		}
+		if c.Digest.SigningKey == "" {
+			errs = append(errs, errors.New("digest.signing_key: must be set when email is enabled"))
+		}
+	}
+	if c.Digest.OverdueDays < 0 {
+		errs = append(errs, fmt.Errorf("digest.overdue_days: must not be negative, got %d", c.Digest.OverdueDays))
+	}
+	if c.ProjectID == "" {
+		errs = append(errs, errors.New("project_id: must be set or present in the service account file"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// resolveProjectID fills ProjectID from the service account file when it
+// was not configured explicitly.
+func (c *Config) resolveProjectID() {
+	if c.ProjectID != "" || c.ServiceAccountFile == "" {
+		return
+	}
+
+	data, err := os.ReadFile(c.ServiceAccountFile)
+	if err != nil {
+		return
+	}
+
+	var serviceAccount struct {
+		ProjectID string `json:"project_id"`
+	}
+	if err := json.Unmarshal(data, &serviceAccount); err != nil {
+		return
+	}
+	c.ProjectID = serviceAccount.ProjectID
+}
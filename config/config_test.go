@@ -0,0 +1,128 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	serviceAccount := filepath.Join(t.TempDir(), "sa.json")
+	if err := os.WriteFile(serviceAccount, []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		change   func(c *Config)
+		wantErrs []string
+	}{
+		{name: "valid", change: func(c *Config) {}},
+		{name: "port with a colon", change: func(c *Config) { c.Port = ":8443" }},
+		{
+			name:     "port out of range",
+			change:   func(c *Config) { c.Port = "65536" },
+			wantErrs: []string{`port: "65536" is not a valid TCP port`},
+		},
+		{
+			name:     "port not a number",
+			change:   func(c *Config) { c.Port = "http" },
+			wantErrs: []string{`port: "http" is not a valid TCP port`},
+		},
+		{
+			name:     "missing required settings",
+			change:   func(c *Config) { c.APIKey, c.Domain, c.ServiceAccountFile, c.ProjectID = "", "", "", "" },
+			wantErrs: []string{"api_key: must be set", "domain: must be set", "service_account_file: must be set", "project_id: must be set"},
+		},
+		{
+			name:     "missing service account file",
+			change:   func(c *Config) { c.ServiceAccountFile = filepath.Join(filepath.Dir(serviceAccount), "missing.json") },
+			wantErrs: []string{"service_account_file: stat "},
+		},
+		{
+			name: "durations not positive",
+			change: func(c *Config) {
+				c.Server.ShutdownTimeout = 0
+				c.Trash.Retention = -time.Hour
+			},
+			wantErrs: []string{"server.shutdown_timeout: must be positive, got 0s", "trash.retention: must be positive, got -1h0m0s"},
+		},
+		{
+			name:     "otlp without endpoint",
+			change:   func(c *Config) { c.Tracing.Exporter, c.Tracing.Endpoint = "otlp", "" },
+			wantErrs: []string{"tracing.endpoint: must be set for the otlp exporter"},
+		},
+		{
+			name:     "unknown exporter",
+			change:   func(c *Config) { c.Tracing.Exporter = "jaeger" },
+			wantErrs: []string{`tracing.exporter: "jaeger" is not one of none, stdout, otlp`},
+		},
+		{
+			name: "ratios out of range",
+			change: func(c *Config) {
+				c.Tracing.SampleRatio = 1.5
+				c.Log.SampleRate = -0.1
+			},
+			wantErrs: []string{"tracing.sample_ratio: 1.5 is not between 0 and 1", "log.sample_rate: -0.1 is not between 0 and 1"},
+		},
+		{
+			name: "invalid log settings",
+			change: func(c *Config) {
+				c.Log.Level, c.Log.Format, c.Log.Output = "loud", "xml", ""
+			},
+			wantErrs: []string{"log.level:", `log.format: "xml" is not one of json, text`, "log.output: must be set"},
+		},
+		{
+			name:     "email without sender and signing key",
+			change:   func(c *Config) { c.Mail.Dir = "mail" },
+			wantErrs: []string{"mail.from: must be set when email is enabled", "digest.signing_key: must be set when email is enabled"},
+		},
+		{
+			name: "smtp port out of range",
+			change: func(c *Config) {
+				c.Mail.SMTPHost, c.Mail.SMTPPort = "smtp.example.com", 0
+				c.Mail.From, c.Digest.SigningKey = "app@example.com", "key"
+			},
+			wantErrs: []string{"mail.smtp_port: 0 is not a valid TCP port"},
+		},
+		{
+			name:     "smtp port unused without smtp host",
+			change:   func(c *Config) { c.Mail.SMTPPort = 0 },
+			wantErrs: nil,
+		},
+		{
+			name:     "negative overdue days",
+			change:   func(c *Config) { c.Digest.OverdueDays = -1 },
+			wantErrs: []string{"digest.overdue_days: must not be negative, got -1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			c.APIKey, c.ServiceAccountFile, c.ProjectID = "key", serviceAccount, "project"
+			tt.change(&c)
+
+			err := c.Validate()
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want none", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate() succeeded, want errors %q", tt.wantErrs)
+			}
+			if got := strings.Count(err.Error(), "\n") + 1; got != len(tt.wantErrs) {
+				t.Errorf("Validate() reported %d problems, want %d: %v", got, len(tt.wantErrs), err)
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate() error = %v, want it to mention %q", err, want)
+				}
+			}
+		})
+	}
+}
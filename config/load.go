@@ -0,0 +1,275 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// field is a single settable leaf of Config, addressed by its dotted key.
+type field struct {
+	key    string
+	usage  string
+	secret bool
+	value  reflect.Value
+}
+
+func (f field) envName() string {
+	return strings.ToUpper(strings.ReplaceAll(f.key, ".", "_"))
+}
+
+func (f field) flagName() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(f.key)
+}
+
+// fields lists the settable leaves of cfg in declaration order.
+func fields(cfg *Config) []field {
+	return collect(reflect.ValueOf(cfg).Elem(), "")
+}
+
+func collect(v reflect.Value, prefix string) []field {
+	var out []field
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key := sf.Tag.Get("config")
+		if key == "" || key == "-" {
+			continue
+		}
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		if sf.Type.Kind() == reflect.Struct && sf.Type != reflect.TypeOf(time.Time{}) {
+			out = append(out, collect(v.Field(i), key)...)
+			continue
+		}
+
+		out = append(out, field{
+			key:    key,
+			usage:  sf.Tag.Get("usage"),
+			secret: sf.Tag.Get("secret") == "true",
+			value:  v.Field(i),
+		})
+	}
+	return out
+}
+
+// set parses raw into the field according to its type.
+func (f field) set(raw string) error {
+	switch f.value.Interface().(type) {
+	case string:
+		f.value.SetString(raw)
+	case bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a boolean", f.key, raw)
+		}
+		f.value.SetBool(b)
+	case int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not an integer", f.key, raw)
+		}
+		f.value.SetInt(int64(n))
+	case float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a number", f.key, raw)
+		}
+		f.value.SetFloat(n)
+	case time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a duration", f.key, raw)
+		}
+		f.value.SetInt(int64(d))
+	case []string:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		f.value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("%s: unsupported type %s", f.key, f.value.Type())
+	}
+	return nil
+}
+
+// String formats the current value the same way set parses it.
+func (f field) String() string {
+	switch v := f.value.Interface().(type) {
+	case []string:
+		return strings.Join(v, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// flagValue adapts a field to flag.Value so flags are parsed with the same
+// rules as every other source.
+type flagValue struct{ f field }
+
+func (fv flagValue) String() string {
+	if !fv.f.value.IsValid() {
+		return ""
+	}
+	return fv.f.String()
+}
+func (fv flagValue) Set(raw string) error { return fv.f.set(raw) }
+func (fv flagValue) IsBoolFlag() bool     { return fv.f.value.Kind() == reflect.Bool }
+
+// Load builds the configuration from defaults, an optional config file,
+// the environment (including an optional .env file) and command line
+// flags, each source overriding the previous one. The result is validated
+// and every problem is reported in a single error. When args ask for help,
+// the usage is printed and flag.ErrHelp returned as it is, with no config.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+	var errs []error
+
+	// .env is loaded first so it can set CONFIG_FILE too. Variables already
+	// in the environment are not overridden.
+	if err := godotenv.Load(".env"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		errs = append(errs, fmt.Errorf(".env: %w", err))
+	}
+
+	fs := flag.NewFlagSet("app", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.StringVar(&cfg.ConfigFile, "config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	fs.BoolVar(&cfg.PrintConfig, "print-config", false, "print the effective config and exit")
+
+	// Flags are parsed into a scratch copy first so the config file path is
+	// known before any source is applied, then replayed on top at the end.
+	scratch := Default()
+	for _, f := range fields(&scratch) {
+		fs.Var(flagValue{f}, f.flagName(), f.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	cfg.Args = fs.Args()
+
+	if cfg.ConfigFile != "" {
+		if err := loadFile(&cfg, cfg.ConfigFile); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, f := range fields(&cfg) {
+		if raw, ok := os.LookupEnv(f.envName()); ok {
+			if err := f.set(strings.TrimSpace(raw)); err != nil {
+				errs = append(errs, fmt.Errorf("env %s: %w", f.envName(), err))
+			}
+		}
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+	for i, f := range fields(&cfg) {
+		if set[f.flagName()] {
+			f.value.Set(fields(&scratch)[i].value)
+		}
+	}
+
+	cfg.resolveProjectID()
+
+	if err := cfg.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return &cfg, err
+	}
+	return &cfg, nil
+}
+
+// loadFile applies a YAML or TOML file, chosen by extension.
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	raw := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return fmt.Errorf("config file: unsupported extension %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	values := map[string]string{}
+	flatten(raw, "", values)
+
+	var errs []error
+	known := map[string]bool{}
+	for _, f := range fields(cfg) {
+		known[f.key] = true
+		if v, ok := values[f.key]; ok {
+			if err := f.set(v); err != nil {
+				errs = append(errs, fmt.Errorf("config file: %w", err))
+			}
+		}
+	}
+	for key := range values {
+		if !known[key] {
+			errs = append(errs, fmt.Errorf("config file: unknown key %q", key))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// flatten turns nested maps into dotted keys with string values.
+func flatten(in map[string]interface{}, prefix string, out map[string]string) {
+	for k, v := range in {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		switch v := v.(type) {
+		case map[string]interface{}:
+			flatten(v, key, out)
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			out[key] = strings.Join(items, ",")
+		default:
+			out[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+// Redacted renders the effective configuration, one key per line, with
+// secrets masked.
+func (c *Config) Redacted() string {
+	var lines []string
+	for _, f := range fields(c) {
+		value := f.String()
+		if f.secret && value != "" {
+			value = "[REDACTED]"
+		}
+		lines = append(lines, f.key+" = "+strconv.Quote(value))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,280 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setup runs the test in an empty directory holding a service account file,
+// with none of the configuration variables set but API_KEY and
+// SERVICE_ACCOUNT_FILE, so every source is under the test's control
+func setup(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	var cfg Config
+	for _, f := range fields(&cfg) {
+		unsetenv(t, f.envName())
+	}
+	unsetenv(t, "CONFIG_FILE")
+
+	write(t, "sa.json", `{"project_id": "from-service-account"}`)
+	t.Setenv("API_KEY", "key")
+	t.Setenv("SERVICE_ACCOUNT_FILE", "sa.json")
+}
+
+// unsetenv unsets key for the test and restores it afterwards, also when
+// .env set it in between
+func unsetenv(t *testing.T, key string) {
+	t.Setenv(key, "")
+	os.Unsetenv(key)
+}
+
+func write(t *testing.T, name string, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(".", name), []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	const yamlFile = "port: \"9000\"\nlog:\n  level: debug\n  skip_paths: [/a, /b]\n"
+
+	tests := []struct {
+		name      string
+		files     map[string]string
+		env       map[string]string
+		args      []string
+		wantPort  string
+		wantLevel string
+		wantSkip  []string
+		wantFile  string
+	}{
+		{
+			name:      "defaults",
+			wantPort:  "8080",
+			wantLevel: "info",
+			wantSkip:  []string{"/healthz", "/readyz", "/version", "/metrics"},
+		},
+		{
+			name:      "file over defaults",
+			files:     map[string]string{"app.yaml": yamlFile},
+			args:      []string{"-config", "app.yaml"},
+			wantPort:  "9000",
+			wantLevel: "debug",
+			wantSkip:  []string{"/a", "/b"},
+			wantFile:  "app.yaml",
+		},
+		{
+			name:      "toml file",
+			files:     map[string]string{"app.toml": "port = \"9000\"\n[log]\nlevel = \"warn\"\nskip_paths = [\"/a\"]\n"},
+			args:      []string{"-config", "app.toml"},
+			wantPort:  "9000",
+			wantLevel: "warn",
+			wantSkip:  []string{"/a"},
+			wantFile:  "app.toml",
+		},
+		{
+			name:      "environment over file",
+			files:     map[string]string{"app.yaml": yamlFile},
+			env:       map[string]string{"PORT": "9100", "LOG_SKIP_PATHS": " /c , /d,"},
+			args:      []string{"-config", "app.yaml"},
+			wantPort:  "9100",
+			wantLevel: "debug",
+			wantSkip:  []string{"/c", "/d"},
+			wantFile:  "app.yaml",
+		},
+		{
+			name:      ".env over file",
+			files:     map[string]string{"app.yaml": yamlFile, ".env": "PORT=9200\n"},
+			args:      []string{"-config", "app.yaml"},
+			wantPort:  "9200",
+			wantLevel: "debug",
+			wantSkip:  []string{"/a", "/b"},
+			wantFile:  "app.yaml",
+		},
+		{
+			name:      "environment over .env",
+			files:     map[string]string{".env": "PORT=9200\nLOG_LEVEL=warn\n"},
+			env:       map[string]string{"PORT": "9100"},
+			wantPort:  "9100",
+			wantLevel: "warn",
+			wantSkip:  []string{"/healthz", "/readyz", "/version", "/metrics"},
+		},
+		{
+			name:      "flags over environment",
+			files:     map[string]string{"app.yaml": yamlFile, ".env": "LOG_LEVEL=warn\n"},
+			env:       map[string]string{"PORT": "9100"},
+			args:      []string{"-config", "app.yaml", "-port", "9300", "-log-level", "error", "backup", "out.zip"},
+			wantPort:  "9300",
+			wantLevel: "error",
+			wantSkip:  []string{"/a", "/b"},
+			wantFile:  "app.yaml",
+		},
+		{
+			name:      "config file from the environment",
+			files:     map[string]string{"app.yaml": yamlFile},
+			env:       map[string]string{"CONFIG_FILE": "app.yaml"},
+			wantPort:  "9000",
+			wantLevel: "debug",
+			wantSkip:  []string{"/a", "/b"},
+			wantFile:  "app.yaml",
+		},
+		{
+			name:      "config file from .env",
+			files:     map[string]string{"app.yaml": yamlFile, ".env": "CONFIG_FILE=app.yaml\n"},
+			wantPort:  "9000",
+			wantLevel: "debug",
+			wantSkip:  []string{"/a", "/b"},
+			wantFile:  "app.yaml",
+		},
+		{
+			name:      "config flag over CONFIG_FILE",
+			files:     map[string]string{"app.yaml": yamlFile},
+			env:       map[string]string{"CONFIG_FILE": "missing.yaml"},
+			args:      []string{"-config", "app.yaml"},
+			wantPort:  "9000",
+			wantLevel: "debug",
+			wantSkip:  []string{"/a", "/b"},
+			wantFile:  "app.yaml",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup(t)
+			for name, content := range tt.files {
+				write(t, name, content)
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			cfg, err := Load(tt.args)
+			if err != nil {
+				t.Fatalf("Load(%q) error = %v", tt.args, err)
+			}
+			if cfg.Port != tt.wantPort || cfg.Log.Level != tt.wantLevel || cfg.ConfigFile != tt.wantFile {
+				t.Errorf("port %q, log level %q and config file %q, want %q, %q and %q",
+					cfg.Port, cfg.Log.Level, cfg.ConfigFile, tt.wantPort, tt.wantLevel, tt.wantFile)
+			}
+			if !reflect.DeepEqual(cfg.Log.SkipPaths, tt.wantSkip) {
+				t.Errorf("log.skip_paths = %q, want %q", cfg.Log.SkipPaths, tt.wantSkip)
+			}
+			if cfg.ProjectID != "from-service-account" {
+				t.Errorf("project_id = %q, want it read from the service account file", cfg.ProjectID)
+			}
+		})
+	}
+}
+
+func TestLoadFlagArgs(t *testing.T) {
+	setup(t)
+	cfg, err := Load([]string{"-print-config", "-tracing-insecure", "restore", "backup.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.PrintConfig || !cfg.Tracing.Insecure {
+		t.Errorf("print-config %v and tracing.insecure %v, want both set", cfg.PrintConfig, cfg.Tracing.Insecure)
+	}
+	if want := []string{"restore", "backup.zip"}; !reflect.DeepEqual(cfg.Args, want) {
+		t.Errorf("Args = %q, want %q", cfg.Args, want)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		env      map[string]string
+		args     []string
+		wantErrs []string
+	}{
+		{
+			name:     "missing config file",
+			args:     []string{"-config", "missing.yaml"},
+			wantErrs: []string{"config file: open missing.yaml"},
+		},
+		{
+			name:     "unsupported extension",
+			files:    map[string]string{"app.json": "{}"},
+			args:     []string{"-config", "app.json"},
+			wantErrs: []string{`config file: unsupported extension ".json"`},
+		},
+		{
+			name:     "unknown and invalid keys in the file",
+			files:    map[string]string{"app.yaml": "bogus: 1\nserver:\n  read_timeout: soon\n"},
+			args:     []string{"-config", "app.yaml"},
+			wantErrs: []string{`config file: unknown key "bogus"`, `config file: server.read_timeout: "soon" is not a duration`},
+		},
+		{
+			name:     "invalid environment values",
+			env:      map[string]string{"TRACING_INSECURE": "maybe", "MAIL_SMTP_PORT": "smtp"},
+			wantErrs: []string{`env TRACING_INSECURE: tracing.insecure: "maybe" is not a boolean`, `env MAIL_SMTP_PORT: mail.smtp_port: "smtp" is not an integer`},
+		},
+		{
+			name:     "invalid .env values",
+			files:    map[string]string{".env": "TRACING_SAMPLE_RATIO=half\n"},
+			wantErrs: []string{`env TRACING_SAMPLE_RATIO: tracing.sample_ratio: "half" is not a number`},
+		},
+		{
+			name:     "validation after every source",
+			files:    map[string]string{"app.yaml": "log:\n  level: loud\n"},
+			env:      map[string]string{"LOG_FORMAT": "xml"},
+			args:     []string{"-config", "app.yaml", "-port", "0"},
+			wantErrs: []string{`port: "0" is not a valid TCP port`, "log.level:", `log.format: "xml" is not one of json, text`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup(t)
+			for name, content := range tt.files {
+				write(t, name, content)
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			_, err := Load(tt.args)
+			if err == nil {
+				t.Fatalf("Load(%q) succeeded, want errors %q", tt.args, tt.wantErrs)
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Load(%q) error = %v, want it to mention %q", tt.args, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadUnknownFlag(t *testing.T) {
+	setup(t)
+	cfg, err := Load([]string{"-no-such-flag"})
+	if err == nil || cfg != nil {
+		t.Errorf("Load() = %v, %v, want no config and an error", cfg, err)
+	}
+}
+
+func TestLoadHelp(t *testing.T) {
+	setup(t)
+	for _, arg := range []string{"-h", "-help", "--help"} {
+		cfg, err := Load([]string{arg})
+		if err != flag.ErrHelp || cfg != nil {
+			t.Errorf("Load(%q) = %v, %v, want no config and flag.ErrHelp", arg, cfg, err)
+		}
+	}
+}
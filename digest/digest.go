@@ -0,0 +1,181 @@
+package digest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/Zenk41/go-gin-htmx/mail"
+	"github.com/Zenk41/go-gin-htmx/models"
+	view_digest "github.com/Zenk41/go-gin-htmx/views/digest"
+	"github.com/sirupsen/logrus"
+)
+
+// Job sends the morning digest to every subscriber whose local digest hour
+// has come and who has not had today's digest yet. Register Run with the
+// scheduler.
+type Job struct {
+	Users       models.UserRepository
+	Tasks       models.TaskRepository
+	Mailer      mail.Mailer
+	BaseURL     string
+	SigningKey  []byte
+	OverdueDays int
+}
+
+// Run sends the digests that are due at now
+func (j *Job) Run(ctx context.Context, now time.Time) error {
+	users, err := j.Users.ListDigestSubscribers(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, user := range users {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		today, due := Due(user, now)
+		if !due {
+			continue
+		}
+		if err := j.send(ctx, user, today); err != nil {
+			logrus.WithError(err).WithField("user_id", user.UserID).Error("Failed to send digest")
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Due reports whether the user's digest should go out at now, and for which day
+func Due(user models.User, now time.Time) (civil.Date, bool) {
+	local := now.In(user.Location())
+	today := civil.DateOf(local)
+	return today, user.DigestEnabled && local.Hour() >= user.DigestHour && user.LastDigestOn != today.String()
+}
+
+// Skipped reports the day whose digest a user turning it on, or picking
+// another hour, at now should not get: today when the hour has already
+// passed. Marking it sent makes the first digest go out at the next
+// occurrence of the hour instead of right away.
+func Skipped(user models.User, now time.Time) (civil.Date, bool) {
+	local := now.In(user.Location())
+	return civil.DateOf(local), local.Hour() >= user.DigestHour
+}
+
+func (j *Job) send(ctx context.Context, user models.User, today civil.Date) error {
+	summary, err := j.Summarize(ctx, user, today)
+	if err != nil {
+		return err
+	}
+
+	// Nothing to report is not worth an email, but still counts as today's digest
+	if len(summary.Today) > 0 || len(summary.Overdue) > 0 || summary.TotalYesterday > 0 {
+		msg, err := j.Message(ctx, user, summary)
+		if err != nil {
+			return err
+		}
+		if err := j.Mailer.Send(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return j.Users.MarkDigestSent(ctx, user.UserID, today)
+}
+
+// Summarize collects today's tasks, the unfinished tasks of the previous
+// OverdueDays days and yesterday's completion with one range query
+func (j *Job) Summarize(ctx context.Context, user models.User, today civil.Date) (view_digest.Summary, error) {
+	from := today.AddDays(-j.OverdueDays)
+	if j.OverdueDays < 1 {
+		from = today.AddDays(-1) // yesterday is always needed for the completion rate
+	}
+	tasks, err := j.Tasks.GetTasksInRange(ctx, user.UserID, from, today)
+	if err != nil {
+		return view_digest.Summary{}, err
+	}
+
+	summary := view_digest.Summary{
+		Name:           user.Name,
+		Day:            today.String(),
+		AppURL:         j.BaseURL + "/",
+		UnsubscribeURL: j.UnsubscribeURL(user.UserID),
+	}
+	yesterday := today.AddDays(-1)
+	for _, task := range *tasks {
+		day := task.Day()
+		switch {
+		case day == today:
+			summary.Today = append(summary.Today, task)
+		case task.Status != "done" && j.OverdueDays > 0:
+			summary.Overdue = append(summary.Overdue, task)
+		}
+		if day == yesterday {
+			summary.TotalYesterday++
+			if task.Status == "done" {
+				summary.DoneYesterday++
+			}
+		}
+	}
+	return summary, nil
+}
+
+// UnsubscribeURL is the signed link that turns the digest off for userID
+func (j *Job) UnsubscribeURL(userID string) string {
+	query := url.Values{"uid": {userID}, "token": {UnsubscribeToken(j.SigningKey, userID)}}
+	return j.BaseURL + "/digest/unsubscribe?" + query.Encode()
+}
+
+// Message renders the digest as an email with HTML and plain text bodies
+func (j *Job) Message(ctx context.Context, user models.User, summary view_digest.Summary) (mail.Message, error) {
+	var html strings.Builder
+	if err := view_digest.Email(summary).Render(ctx, &html); err != nil {
+		return mail.Message{}, err
+	}
+
+	return mail.Message{
+		To:      user.Email,
+		Subject: fmt.Sprintf("Your tasks for %s", summary.Day),
+		Text:    Text(summary),
+		HTML:    html.String(),
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + summary.UnsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	}, nil
+}
+
+// Text renders the plain text alternative of the digest
+func Text(s view_digest.Summary) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Good morning, %s\n\n", s.Name)
+	if rate := s.CompletionRate(); rate != "" {
+		fmt.Fprintf(&b, "Yesterday you completed %s of your tasks.\n\n", rate)
+	}
+
+	fmt.Fprintf(&b, "Today, %s\n", s.Day)
+	if len(s.Today) == 0 {
+		b.WriteString("Nothing planned for today.\n")
+	}
+	for _, task := range s.Today {
+		b.WriteString("- " + task.Title)
+		if task.DueTime != "" {
+			b.WriteString(" at " + task.DueTime)
+		}
+		b.WriteString("\n")
+	}
+
+	if len(s.Overdue) > 0 {
+		b.WriteString("\nOverdue\n")
+		for _, task := range s.Overdue {
+			fmt.Fprintf(&b, "- %s (from %s)\n", task.Title, task.Day())
+		}
+	}
+
+	fmt.Fprintf(&b, "\nOpen your tasks: %s\n", s.AppURL)
+	fmt.Fprintf(&b, "\nYou receive this email because you turned on the daily digest.\nUnsubscribe: %s\n", s.UnsubscribeURL)
+	return b.String()
+}
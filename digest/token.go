@@ -0,0 +1,23 @@
+package digest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// UnsubscribeToken signs userID so an unsubscribe link cannot be forged for
+// another user
+func UnsubscribeToken(key []byte, userID string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("digest-unsubscribe:" + userID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyUnsubscribeToken reports whether token was issued for userID
+func VerifyUnsubscribeToken(key []byte, userID string, token string) bool {
+	if len(key) == 0 || userID == "" {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(UnsubscribeToken(key, userID)))
+}
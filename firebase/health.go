@@ -0,0 +1,32 @@
+package firebase
+
+import (
+	"context"
+
+	"cloud.google.com/go/firestore"
+	"firebase.google.com/go/auth"
+	"google.golang.org/api/iterator"
+)
+
+// FirestoreCheck returns a readiness check that reads at most one document.
+func FirestoreCheck(client *firestore.Client) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := client.Collection("users").Limit(1).Documents(ctx).Next()
+		if err == iterator.Done {
+			return nil
+		}
+		return err
+	}
+}
+
+// AuthCheck returns a readiness check that looks up a user that does not
+// exist; a not-found answer proves the identity provider is reachable.
+func AuthCheck(client *auth.Client) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := client.GetUser(ctx, "readyz-probe")
+		if err == nil || auth.IsUserNotFound(err) {
+			return nil
+		}
+		return err
+	}
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"firebase.google.com/go/auth"
+	"github.com/Zenk41/go-gin-htmx/logging"
+	"github.com/Zenk41/go-gin-htmx/models"
+	"github.com/Zenk41/go-gin-htmx/views/components"
+	view_settings "github.com/Zenk41/go-gin-htmx/views/settings"
+	"github.com/a-h/templ"
+	"github.com/gin-gonic/gin"
+)
+
+// maxAppPasswordName bounds the label of an app password
+const maxAppPasswordName = 100
+
+type AppPasswordHandler interface {
+	List(ctx *gin.Context)
+	Create(ctx *gin.Context)
+	Revoke(ctx *gin.Context)
+}
+
+type appPasswordHandler struct {
+	appPasswords models.AppPasswordRepository
+	userRepo     models.UserRepository
+	firebaseAuth *auth.Client
+	audit        models.AuditRepository
+}
+
+func NewAppPasswordHandler(appPasswords models.AppPasswordRepository,
+	userRepo models.UserRepository,
+	firebaseAuth *auth.Client,
+	audit models.AuditRepository) AppPasswordHandler {
+	return &appPasswordHandler{
+		appPasswords: appPasswords,
+		userRepo:     userRepo,
+		firebaseAuth: firebaseAuth,
+		audit:        audit,
+	}
+}
+
+// List renders the app password section of the settings page
+func (ah *appPasswordHandler) List(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, ah.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	ah.render(ctx, userId, "", nil)
+}
+
+// Create generates an app password and shows its secret once
+func (ah *appPasswordHandler) Create(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, ah.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	name := strings.TrimSpace(ctx.PostForm("name"))
+	if name == "" || len(name) > maxAppPasswordName {
+		ah.render(ctx, userId, "", components.Alert("error", "Give the app password a name of at most 100 characters"))
+		return
+	}
+
+	secret, hash, err := models.NewAppPassword()
+	if err != nil {
+		ah.render(ctx, userId, "", components.Alert("error", "Failed to generate app password"))
+		return
+	}
+	if _, err := ah.appPasswords.CreateAppPassword(ctx, models.AppPassword{
+		UserID:    userId,
+		Name:      name,
+		Hash:      hash,
+		CreatedAt: time.Now(),
+	}); err != nil {
+		logging.FromContext(ctx.Request.Context()).WithError(err).Error("Failed to save app password")
+		ah.render(ctx, userId, "", components.Alert("error", "Failed to save app password"))
+		return
+	}
+	recordAudit(ctx, ah.audit, userId, models.AuditAppPasswordCreated, name)
+	ah.render(ctx, userId, secret, nil)
+}
+
+// Revoke deletes an app password, signing out the apps that use it
+func (ah *appPasswordHandler) Revoke(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, ah.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	// The name goes into the audit log, the password itself is gone after
+	name := ""
+	if passwords, err := ah.appPasswords.ListAppPasswords(ctx, userId); err == nil {
+		for _, password := range passwords {
+			if password.ID == ctx.Param("id") {
+				name = password.Name
+			}
+		}
+	}
+	if err := ah.appPasswords.DeleteAppPassword(ctx, userId, ctx.Param("id")); err != nil {
+		logging.FromContext(ctx.Request.Context()).WithError(err).Error("Failed to revoke app password")
+		ah.render(ctx, userId, "", components.Alert("error", "Failed to revoke app password"))
+		return
+	}
+	recordAudit(ctx, ah.audit, userId, models.AuditAppPasswordRevoked, name)
+	ah.render(ctx, userId, "", components.Alert("success", "App password revoked"))
+}
+
+func (ah *appPasswordHandler) render(ctx *gin.Context, userId string, secret string, alert templ.Component) {
+	user, err := ah.userRepo.GetUser(ctx, userId)
+	if err != nil {
+		Render(ctx, view_settings.AppPasswords(baseURL(ctx), models.User{}, nil, "", components.Alert("error", "Failed to get user")))
+		return
+	}
+
+	passwords, err := ah.appPasswords.ListAppPasswords(ctx, userId)
+	if err != nil {
+		Render(ctx, view_settings.AppPasswords(baseURL(ctx), *user, nil, "", components.Alert("error", "Failed to get app passwords")))
+		return
+	}
+	Render(ctx, view_settings.AppPasswords(baseURL(ctx), *user, passwords, secret, alert))
+}
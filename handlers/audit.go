@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"firebase.google.com/go/auth"
+	"github.com/Zenk41/go-gin-htmx/logging"
+	"github.com/Zenk41/go-gin-htmx/models"
+	"github.com/Zenk41/go-gin-htmx/views/components"
+	view_settings "github.com/Zenk41/go-gin-htmx/views/settings"
+	"github.com/gin-gonic/gin"
+)
+
+// auditPageSize is how many events the activity page lists
+const auditPageSize = 100
+
+type AuditHandler interface {
+	Page(ctx *gin.Context)
+}
+
+type auditHandler struct {
+	audit        models.AuditRepository
+	userRepo     models.UserRepository
+	firebaseAuth *auth.Client
+}
+
+func NewAuditHandler(audit models.AuditRepository,
+	userRepo models.UserRepository,
+	firebaseAuth *auth.Client) AuditHandler {
+	return &auditHandler{
+		audit:        audit,
+		userRepo:     userRepo,
+		firebaseAuth: firebaseAuth,
+	}
+}
+
+// Page lists the latest security events of the account
+func (ah *auditHandler) Page(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, ah.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	user, err := ah.userRepo.GetUser(ctx, userId)
+	if err != nil {
+		Render(ctx, view_settings.Activity(models.User{}, nil, components.Alert("error", "Failed to get user")))
+		return
+	}
+
+	events, err := ah.audit.ListAuditEvents(ctx, userId, auditPageSize)
+	if err != nil {
+		logging.FromContext(ctx.Request.Context()).WithError(err).Error("Failed to list audit log")
+		Render(ctx, view_settings.Activity(*user, nil, components.Alert("error", "Failed to get account activity")))
+		return
+	}
+	Render(ctx, view_settings.Activity(*user, events, nil))
+}
+
+// recordAudit adds a security event of the request to the user's audit
+// log. A failure is logged and does not stop the request.
+func recordAudit(ctx *gin.Context, audit models.AuditRepository, userId string, action string, detail string) {
+	err := audit.AddAuditEvent(ctx, models.AuditEvent{
+		UserID:    userId,
+		Action:    action,
+		Detail:    detail,
+		IP:        ctx.ClientIP(),
+		UserAgent: ctx.Request.UserAgent(),
+		At:        time.Now(),
+	})
+	if err != nil {
+		logging.FromContext(ctx.Request.Context()).WithError(err).WithField("action", action).Error("Failed to add to audit log")
+	}
+}
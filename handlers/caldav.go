@@ -0,0 +1,817 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/Zenk41/go-gin-htmx/caldav"
+	"github.com/Zenk41/go-gin-htmx/history"
+	"github.com/Zenk41/go-gin-htmx/ical"
+	"github.com/Zenk41/go-gin-htmx/logging"
+	"github.com/Zenk41/go-gin-htmx/models"
+	"github.com/Zenk41/go-gin-htmx/pubsub"
+	"github.com/Zenk41/go-gin-htmx/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Paths of the CalDAV resources. Every user sees the same paths; the app
+// password decides whose tasks they hold.
+const (
+	davRoot       = "/dav/"
+	davPrincipal  = "/dav/principal/"
+	davHome       = "/dav/calendars/"
+	davCollection = "/dav/calendars/tasks/"
+)
+
+const (
+	// maxDAVBodySize bounds request bodies
+	maxDAVBodySize = 1 << 20
+	// appPasswordTouchInterval limits how often the last use of an app password is written
+	appPasswordTouchInterval = time.Hour
+	calendarContentType      = "text/calendar; charset=utf-8"
+)
+
+// taskNamePattern matches the resource names a client may PUT. Names are
+// the client's and only need to be unique within the account, see
+// davTaskID.
+var taskNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]{0,127}$`)
+
+type CalDAVHandler interface {
+	// WellKnown points clients at the CalDAV root (RFC 6764)
+	WellKnown(ctx *gin.Context)
+	Serve(ctx *gin.Context)
+}
+
+type caldavHandler struct {
+	taskRepo     models.TaskRepository
+	userRepo     models.UserRepository
+	appPasswords models.AppPasswordRepository
+	reminders    ReminderScheduler
+	events       TaskEventEmitter
+	broker       pubsub.Broker
+	domain       string
+}
+
+func NewCalDAVHandler(taskRepo models.TaskRepository,
+	userRepo models.UserRepository,
+	appPasswords models.AppPasswordRepository,
+	reminders ReminderScheduler,
+	events TaskEventEmitter,
+	broker pubsub.Broker,
+	domain string) CalDAVHandler {
+	return &caldavHandler{
+		taskRepo:     taskRepo,
+		userRepo:     userRepo,
+		appPasswords: appPasswords,
+		reminders:    reminders,
+		events:       events,
+		broker:       broker,
+		domain:       domain,
+	}
+}
+
+// davResource is a collection or a task served over CalDAV
+type davResource struct {
+	href string
+	task *models.Task
+}
+
+func (dh *caldavHandler) WellKnown(ctx *gin.Context) {
+	ctx.Redirect(http.StatusMovedPermanently, davRoot)
+}
+
+// Serve dispatches a request under /dav/ by path and method
+func (dh *caldavHandler) Serve(ctx *gin.Context) {
+	ctx.Header("DAV", "1, 3, calendar-access")
+	if ctx.Request.Method == http.MethodOptions {
+		ctx.Header("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT")
+		ctx.Status(http.StatusOK)
+		return
+	}
+
+	user, ok := dh.authenticate(ctx)
+	if !ok {
+		return
+	}
+	ctx.Request = ctx.Request.WithContext(history.WithSource(ctx.Request.Context(), history.SourceCalDAV))
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxDAVBodySize)
+
+	path := ctx.Request.URL.Path
+	if !strings.HasSuffix(path, "/") && !strings.HasSuffix(path, ".ics") {
+		path += "/"
+	}
+	method := ctx.Request.Method
+
+	switch {
+	case path == davRoot || path == davPrincipal || path == davHome:
+		if method != "PROPFIND" {
+			ctx.Status(http.StatusMethodNotAllowed)
+			return
+		}
+		dh.propfind(ctx, user, davResource{href: path})
+	case path == davCollection:
+		switch method {
+		case "PROPFIND":
+			dh.propfind(ctx, user, davResource{href: path})
+		case "REPORT":
+			dh.report(ctx, user)
+		case http.MethodGet, http.MethodHead:
+			dh.getCollection(ctx, user)
+		default:
+			ctx.Status(http.StatusMethodNotAllowed)
+		}
+	case strings.HasPrefix(path, davCollection):
+		name, ok := davTaskName(path)
+		if !ok {
+			ctx.Status(http.StatusNotFound)
+			return
+		}
+		switch method {
+		case http.MethodGet, http.MethodHead:
+			dh.get(ctx, user, name)
+		case http.MethodPut:
+			dh.put(ctx, user, name)
+		case http.MethodDelete:
+			dh.delete(ctx, user, name)
+		case "PROPFIND":
+			task, ok := dh.ownTask(ctx, user, name)
+			if !ok {
+				return
+			}
+			dh.propfind(ctx, user, davResource{href: path, task: task})
+		default:
+			ctx.Status(http.StatusMethodNotAllowed)
+		}
+	default:
+		ctx.Status(http.StatusNotFound)
+	}
+}
+
+// authenticate resolves the user of an app password, sent as the password
+// of Basic auth or as a Bearer token
+func (dh *caldavHandler) authenticate(ctx *gin.Context) (*models.User, bool) {
+	var secret string
+	if _, password, ok := ctx.Request.BasicAuth(); ok {
+		secret = password
+	} else if token, ok := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer "); ok {
+		secret = strings.TrimSpace(token)
+	}
+
+	if secret != "" {
+		password, err := dh.appPasswords.FindAppPassword(ctx, models.HashAppPassword(secret))
+		if err == nil {
+			user, err := dh.userRepo.GetUser(ctx, password.UserID)
+			if err == nil {
+				ctx.Set(logging.UserIDKey, user.UserID)
+				if time.Since(password.LastUsedAt) > appPasswordTouchInterval {
+					if err := dh.appPasswords.TouchAppPassword(ctx, password.ID, time.Now()); err != nil {
+						logging.FromContext(ctx.Request.Context()).WithError(err).Error("Failed to record app password use")
+					}
+				}
+				return user, true
+			}
+		}
+	}
+
+	ctx.Header("WWW-Authenticate", `Basic realm="Tasks", charset="UTF-8"`)
+	ctx.AbortWithStatus(http.StatusUnauthorized)
+	return nil, false
+}
+
+// davTaskName extracts the resource name from the path of a calendar object
+func davTaskName(path string) (string, bool) {
+	name, ok := strings.CutSuffix(strings.TrimPrefix(path, davCollection), ".ics")
+	if !ok || !taskNamePattern.MatchString(name) {
+		return "", false
+	}
+	return name, true
+}
+
+// davTaskID is the ID of the task a client creates under a resource name.
+// Task IDs are global, so the ID is derived from the name and the user:
+// accounts never share or see each other's names, and a retried PUT lands
+// on the same task.
+func davTaskID(userID string, name string) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(davCollection+userID+"/"+name)).String()
+}
+
+// davTaskHref is the path of a task: the name it was created under by a
+// client, or its ID for tasks created elsewhere
+func davTaskHref(taskID string, name string) string {
+	if name == "" {
+		name = taskID
+	}
+	return davCollection + name + ".ics"
+}
+
+// find loads the task of the user at a resource name
+func (dh *caldavHandler) find(ctx *gin.Context, user *models.User, name string) (*models.Task, error) {
+	task, err := dh.taskRepo.GetTaskById(ctx, user.UserID, davTaskID(user.UserID, name))
+	if errors.Is(err, models.ErrTaskNotFound) {
+		return dh.taskRepo.GetTaskById(ctx, user.UserID, name)
+	}
+	return task, err
+}
+
+// ownTask loads a task of the user, answering 404 when there is none
+func (dh *caldavHandler) ownTask(ctx *gin.Context, user *models.User, name string) (*models.Task, bool) {
+	task, err := dh.find(ctx, user, name)
+	if errors.Is(err, models.ErrTaskNotFound) {
+		ctx.Status(http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		dh.fail(ctx, err)
+		return nil, false
+	}
+	return task, true
+}
+
+// object renders a task the way it is served over CalDAV
+func (dh *caldavHandler) object(task models.Task, user *models.User) []byte {
+	return ical.Object(task, ical.FeedOptions{Domain: dh.domain, Location: user.Location()})
+}
+
+func (dh *caldavHandler) propfind(ctx *gin.Context, user *models.User, resource davResource) {
+	props, err := caldav.ParsePropfind(ctx.Request.Body)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resources := []davResource{resource}
+	if ctx.GetHeader("Depth") != "0" {
+		switch resource.href {
+		case davRoot:
+			resources = append(resources, davResource{href: davPrincipal}, davResource{href: davHome})
+		case davHome:
+			resources = append(resources, davResource{href: davCollection})
+		case davCollection:
+			tasks, err := dh.changedTasks(ctx, user.UserID, time.Time{})
+			if err != nil {
+				dh.fail(ctx, err)
+				return
+			}
+			for i := range tasks {
+				task := &tasks[i]
+				resources = append(resources, davResource{href: davTaskHref(task.TaskID, task.DavName), task: task})
+			}
+		}
+	}
+
+	var responses []caldav.Response
+	for _, r := range resources {
+		all, err := dh.properties(ctx, user, r, props)
+		if err != nil {
+			dh.fail(ctx, err)
+			return
+		}
+		responses = append(responses, selectProps(r.href, all, props))
+	}
+	ctx.Data(http.StatusMultiStatus, "application/xml; charset=utf-8", caldav.Multistatus(responses, ""))
+}
+
+// properties lists the properties of a resource. calendar-data is only
+// included when asked for by name, as allprop must not return it.
+func (dh *caldavHandler) properties(ctx *gin.Context, user *models.User, r davResource, req caldav.Props) ([]caldav.Prop, error) {
+	prop := func(space, local, value string) caldav.Prop {
+		return caldav.Prop{Name: xml.Name{Space: space, Local: local}, Value: value}
+	}
+	principal := caldav.Href(davPrincipal)
+	privileges := ""
+	for _, privilege := range []string{"read", "write", "write-content", "bind", "unbind"} {
+		privileges += "<d:privilege>" + caldav.Element(caldav.NSDAV, privilege) + "</d:privilege>"
+	}
+
+	props := []caldav.Prop{
+		prop(caldav.NSDAV, "current-user-principal", principal),
+		prop(caldav.NSDAV, "current-user-privilege-set", privileges),
+	}
+	collection := caldav.Element(caldav.NSDAV, "collection")
+
+	if r.task != nil {
+		body := dh.object(*r.task, user)
+		props = append(props,
+			prop(caldav.NSDAV, "resourcetype", ""),
+			prop(caldav.NSDAV, "owner", principal),
+			prop(caldav.NSDAV, "getetag", caldav.Text(contentETag(body))),
+			prop(caldav.NSDAV, "getcontenttype", caldav.Text(calendarContentType+"; component=VTODO")),
+			prop(caldav.NSDAV, "getcontentlength", strconv.Itoa(len(body))),
+			prop(caldav.NSDAV, "getlastmodified", caldav.Text(r.task.UpdatedAt.UTC().Format(http.TimeFormat))),
+		)
+		if req.Wants(caldav.NSCalDAV, "calendar-data") {
+			props = append(props, prop(caldav.NSCalDAV, "calendar-data", caldav.Text(string(body))))
+		}
+		return props, nil
+	}
+
+	switch r.href {
+	case davRoot:
+		props = append(props, prop(caldav.NSDAV, "resourcetype", collection))
+	case davPrincipal:
+		props = append(props,
+			prop(caldav.NSDAV, "resourcetype", collection+caldav.Element(caldav.NSDAV, "principal")),
+			prop(caldav.NSDAV, "displayname", caldav.Text(user.Name)),
+			prop(caldav.NSDAV, "principal-URL", principal),
+			prop(caldav.NSCalDAV, "calendar-home-set", caldav.Href(davHome)),
+			prop(caldav.NSCalDAV, "calendar-user-address-set", "<d:href>"+caldav.Text("mailto:"+user.Email)+"</d:href>"),
+		)
+	case davHome:
+		props = append(props,
+			prop(caldav.NSDAV, "resourcetype", collection),
+			prop(caldav.NSDAV, "owner", principal),
+		)
+	case davCollection:
+		last, err := dh.taskRepo.LastChangeAt(ctx, user.UserID)
+		if err != nil {
+			return nil, err
+		}
+		token := caldav.Text(dh.syncToken(last))
+		reports := ""
+		for _, report := range []xml.Name{
+			{Space: caldav.NSCalDAV, Local: caldav.CalendarQuery},
+			{Space: caldav.NSCalDAV, Local: caldav.CalendarMultiget},
+			{Space: caldav.NSDAV, Local: caldav.SyncCollection},
+		} {
+			reports += "<d:supported-report><d:report>" + caldav.Element(report.Space, report.Local) + "</d:report></d:supported-report>"
+		}
+		props = append(props,
+			prop(caldav.NSDAV, "resourcetype", collection+caldav.Element(caldav.NSCalDAV, "calendar")),
+			prop(caldav.NSDAV, "owner", principal),
+			prop(caldav.NSDAV, "displayname", "Tasks"),
+			prop(caldav.NSCalDAV, "calendar-description", caldav.Text(user.Name+"'s tasks")),
+			prop(caldav.NSCalDAV, "supported-calendar-component-set", `<c:comp name="VTODO"/>`),
+			prop(caldav.NSCalDAV, "supported-calendar-data", `<c:calendar-data content-type="text/calendar" version="2.0"/>`),
+			prop(caldav.NSCalDAV, "max-resource-size", strconv.Itoa(maxDAVBodySize)),
+			prop(caldav.NSDAV, "supported-report-set", reports),
+			prop(caldav.NSCalendarServer, "getctag", token),
+			prop(caldav.NSDAV, "sync-token", token),
+		)
+	}
+	return props, nil
+}
+
+// selectProps answers the requested properties of a resource
+func selectProps(href string, all []caldav.Prop, req caldav.Props) caldav.Response {
+	response := caldav.Response{Href: href}
+	switch {
+	case req.All:
+		response.Props = all
+	case req.NamesOnly:
+		for _, p := range all {
+			response.Props = append(response.Props, caldav.Prop{Name: p.Name})
+		}
+	default:
+		for _, name := range req.Names {
+			i := slices.IndexFunc(all, func(p caldav.Prop) bool { return p.Name == name })
+			if i < 0 {
+				response.Missing = append(response.Missing, name)
+				continue
+			}
+			response.Props = append(response.Props, all[i])
+		}
+	}
+	return response
+}
+
+// syncToken encodes the time of the last change the client has seen
+func (dh *caldavHandler) syncToken(last time.Time) string {
+	var micros int64
+	if !last.IsZero() {
+		micros = last.UnixMicro()
+	}
+	return fmt.Sprintf("http://%s/ns/sync/%d", dh.domain, micros)
+}
+
+func (dh *caldavHandler) parseSyncToken(token string) (time.Time, bool) {
+	value, ok := strings.CutPrefix(token, fmt.Sprintf("http://%s/ns/sync/", dh.domain))
+	if !ok {
+		return time.Time{}, false
+	}
+	micros, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || micros < 0 {
+		return time.Time{}, false
+	}
+	return time.UnixMicro(micros), true
+}
+
+func (dh *caldavHandler) report(ctx *gin.Context, user *models.User) {
+	report, err := caldav.ParseReport(ctx.Request.Body)
+	var unsupported *caldav.UnsupportedReportError
+	if errors.As(err, &unsupported) {
+		ctx.Data(http.StatusForbidden, "application/xml; charset=utf-8",
+			caldav.Error(xml.Name{Space: caldav.NSDAV, Local: "supported-report"}))
+		return
+	}
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var responses []caldav.Response
+	var token string
+	switch report.Kind {
+	case caldav.CalendarQuery:
+		responses, err = dh.calendarQuery(ctx, user, report)
+	case caldav.CalendarMultiget:
+		responses, err = dh.multiget(ctx, user, report)
+	case caldav.SyncCollection:
+		var valid bool
+		responses, token, valid, err = dh.syncCollection(ctx, user, report)
+		if err == nil && !valid {
+			ctx.Data(http.StatusForbidden, "application/xml; charset=utf-8",
+				caldav.Error(xml.Name{Space: caldav.NSDAV, Local: "valid-sync-token"}))
+			return
+		}
+	}
+	if err != nil {
+		dh.fail(ctx, err)
+		return
+	}
+	ctx.Data(http.StatusMultiStatus, "application/xml; charset=utf-8", caldav.Multistatus(responses, token))
+}
+
+// calendarQuery answers with the tasks matching the component and
+// time-range filters; property filters are not evaluated, which returns
+// a superset the client narrows down itself
+func (dh *caldavHandler) calendarQuery(ctx *gin.Context, user *models.User, report caldav.Report) ([]caldav.Response, error) {
+	if len(report.Components) > 1 && !slices.Contains(report.Components, "VTODO") {
+		return nil, nil // e.g. a query for VEVENTs, which this calendar has none of
+	}
+
+	tasks, err := dh.changedTasks(ctx, user.UserID, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	loc := user.Location()
+	var responses []caldav.Response
+	for i := range tasks {
+		task := &tasks[i]
+		if !inTimeRange(*task, loc, report.Start, report.End) {
+			continue
+		}
+		response, err := dh.taskResponse(ctx, user, task, report.Props)
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, response)
+	}
+	return responses, nil
+}
+
+// inTimeRange applies a time-range filter to the due time of a task, or to
+// its whole day when it has none
+func inTimeRange(task models.Task, loc *time.Location, start, end time.Time) bool {
+	from := task.Day().In(loc)
+	to := task.Day().AddDays(1).In(loc)
+	if due, ok := task.DueAt(loc); ok {
+		from, to = due, due.Add(time.Nanosecond)
+	}
+	return (start.IsZero() || to.After(start)) && (end.IsZero() || from.Before(end))
+}
+
+func (dh *caldavHandler) multiget(ctx *gin.Context, user *models.User, report caldav.Report) ([]caldav.Response, error) {
+	var responses []caldav.Response
+	for _, href := range report.Hrefs {
+		path := href
+		if u, err := url.Parse(href); err == nil {
+			path = u.Path
+		}
+		name, ok := davTaskName(path)
+		if !ok {
+			responses = append(responses, caldav.Response{Href: path, Status: http.StatusNotFound})
+			continue
+		}
+		task, err := dh.find(ctx, user, name)
+		if errors.Is(err, models.ErrTaskNotFound) {
+			responses = append(responses, caldav.Response{Href: path, Status: http.StatusNotFound})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		response, err := dh.taskResponse(ctx, user, task, report.Props)
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, response)
+	}
+	return responses, nil
+}
+
+// syncCollection reports the tasks changed and deleted since the client's
+// token. Changes at the exact time of the token are reported again, which
+// costs a refetch at worst but never misses a write.
+func (dh *caldavHandler) syncCollection(ctx *gin.Context, user *models.User, report caldav.Report) ([]caldav.Response, string, bool, error) {
+	var since time.Time
+	if report.SyncToken != "" {
+		var ok bool
+		if since, ok = dh.parseSyncToken(report.SyncToken); !ok {
+			return nil, "", false, nil
+		}
+	}
+
+	tasks, err := dh.changedTasks(ctx, user.UserID, since)
+	if err != nil {
+		return nil, "", true, err
+	}
+
+	last := since
+	changed := map[string]bool{}
+	var responses []caldav.Response
+	for i := range tasks {
+		task := &tasks[i]
+		changed[davTaskHref(task.TaskID, task.DavName)] = true
+		if task.UpdatedAt.After(last) {
+			last = task.UpdatedAt
+		}
+		response, err := dh.taskResponse(ctx, user, task, report.Props)
+		if err != nil {
+			return nil, "", true, err
+		}
+		responses = append(responses, response)
+	}
+
+	// An initial sync has nothing to delete
+	if report.SyncToken != "" {
+		tombstones, err := dh.taskRepo.GetTombstonesSince(ctx, user.UserID, since)
+		if err != nil {
+			return nil, "", true, err
+		}
+		for _, tombstone := range tombstones {
+			if tombstone.DeletedAt.After(last) {
+				last = tombstone.DeletedAt
+			}
+			href := davTaskHref(tombstone.TaskID, tombstone.DavName)
+			if changed[href] {
+				continue // deleted, then created again under the same name
+			}
+			responses = append(responses, caldav.Response{Href: href, Status: http.StatusNotFound})
+		}
+	} else {
+		// Cover deletions in the token as well, so the next sync starts
+		// after them
+		if latest, err := dh.taskRepo.LastChangeAt(ctx, user.UserID); err == nil && latest.After(last) {
+			last = latest
+		}
+	}
+	return responses, dh.syncToken(last), true, nil
+}
+
+// changedTasks reads the tasks of the user changed at or after since, a
+// page at a time
+func (dh *caldavHandler) changedTasks(ctx *gin.Context, userID string, since time.Time) ([]models.Task, error) {
+	var tasks []models.Task
+	page := models.PageRequest{Limit: models.MaxPageSize}
+	for {
+		result, err := dh.taskRepo.GetTasksChangedSince(ctx, userID, since, page)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, result.Tasks...)
+		if result.NextCursor == "" {
+			return tasks, nil
+		}
+		page.Cursor = result.NextCursor
+	}
+}
+
+func (dh *caldavHandler) taskResponse(ctx *gin.Context, user *models.User, task *models.Task, req caldav.Props) (caldav.Response, error) {
+	r := davResource{href: davTaskHref(task.TaskID, task.DavName), task: task}
+	all, err := dh.properties(ctx, user, r, req)
+	if err != nil {
+		return caldav.Response{}, err
+	}
+	return selectProps(r.href, all, req), nil
+}
+
+// getCollection serves every task as one calendar, for clients that
+// export the collection
+func (dh *caldavHandler) getCollection(ctx *gin.Context, user *models.User) {
+	tasks, err := dh.changedTasks(ctx, user.UserID, time.Time{})
+	if err != nil {
+		dh.fail(ctx, err)
+		return
+	}
+	ctx.Data(http.StatusOK, calendarContentType, ical.Feed(tasks, ical.FeedOptions{
+		Name:     "Tasks",
+		Domain:   dh.domain,
+		Location: user.Location(),
+	}))
+}
+
+func (dh *caldavHandler) get(ctx *gin.Context, user *models.User, name string) {
+	task, ok := dh.ownTask(ctx, user, name)
+	if !ok {
+		return
+	}
+
+	body := dh.object(*task, user)
+	etag := contentETag(body)
+	ctx.Header("ETag", etag)
+	ctx.Header("Last-Modified", task.UpdatedAt.UTC().Format(http.TimeFormat))
+	if etagMatches(ctx.GetHeader("If-None-Match"), etag) {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+	ctx.Data(http.StatusOK, calendarContentType, body)
+}
+
+// preconditionFailed evaluates If-Match and If-None-Match against the
+// current ETag of a resource, empty when it does not exist
+func preconditionFailed(ctx *gin.Context, current string) bool {
+	if match := ctx.GetHeader("If-Match"); match != "" && (current == "" || !etagMatches(match, current)) {
+		return true
+	}
+	noneMatch := ctx.GetHeader("If-None-Match")
+	return noneMatch != "" && current != "" && etagMatches(noneMatch, current)
+}
+
+// put creates or replaces a task from the VTODO in the body
+func (dh *caldavHandler) put(ctx *gin.Context, user *models.User, name string) {
+	existing, err := dh.find(ctx, user, name)
+	switch {
+	case errors.Is(err, models.ErrTaskNotFound):
+		existing = nil
+	case err != nil:
+		dh.fail(ctx, err)
+		return
+	}
+
+	current := ""
+	if existing != nil {
+		current = contentETag(dh.object(*existing, user))
+	}
+	if preconditionFailed(ctx, current) {
+		ctx.Status(http.StatusPreconditionFailed)
+		return
+	}
+
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		ctx.Status(http.StatusRequestEntityTooLarge)
+		return
+	}
+	entry, condition := dh.readTodo(body, user)
+	if condition != "" {
+		ctx.Data(http.StatusForbidden, "application/xml; charset=utf-8",
+			caldav.Error(xml.Name{Space: caldav.NSCalDAV, Local: condition}))
+		return
+	}
+
+	now := time.Now()
+	if existing == nil {
+		if taken, err := dh.taskRepo.FindICalUIDs(ctx, user.UserID, []string{entry.UID}); err != nil {
+			dh.fail(ctx, err)
+			return
+		} else if taken[entry.UID] {
+			ctx.Data(http.StatusForbidden, "application/xml; charset=utf-8",
+				caldav.Error(xml.Name{Space: caldav.NSCalDAV, Local: "no-uid-conflict"}))
+			return
+		}
+
+		payload := entry.Payload(user.UserID)
+		payload.TaskID = davTaskID(user.UserID, name)
+		payload.DavName = name
+		payload.CreatedAt = now
+		payload.UpdatedAt = now
+		// Two PUTs racing to create the name make one task
+		task, err := dh.taskRepo.CreateTask(ctx, payload, "caldav:"+name)
+		if errors.Is(err, models.ErrTaskAlreadyCreated) {
+			ctx.Status(http.StatusCreated)
+			return
+		} else if err != nil {
+			dh.fail(ctx, err)
+			return
+		}
+		dh.saved(ctx, user, models.EventTaskCreated, *task)
+		publishCreated(ctx, dh.broker, dh.taskRepo, user.UserID, map[civil.Date]bool{task.Day(): true})
+		ctx.Status(http.StatusCreated)
+		return
+	}
+
+	payload := models.TaskPayload(*existing)
+	payload.Title = entry.Title
+	payload.Description = entry.Description
+	payload.Date = models.StoredDate(entry.Date)
+	payload.DueTime = entry.DueTime
+	payload.Reminders = entry.Reminders
+	payload.Recurrence = entry.Recurrence
+	if entry.Tags != nil {
+		// Clients without category support send none, keep the tags then
+		payload.Tags = entry.Tags
+	}
+	if entry.UID != existing.TaskID+"@"+dh.domain {
+		payload.ICalUID = entry.UID
+	}
+	event := models.EventTaskUpdated
+	switch {
+	case entry.Done && existing.Status != "done":
+		payload.Status = "done"
+		event = models.EventTaskCompleted
+	case !entry.Done && existing.Status == "done":
+		payload.Status = ""
+	}
+	payload.UpdatedAt = now
+	if _, err := dh.taskRepo.EditTaskById(ctx, payload); errors.Is(err, models.ErrVersionConflict) {
+		// Changed between the If-Match check and the write
+		ctx.Status(http.StatusPreconditionFailed)
+		return
+	} else if err != nil {
+		dh.fail(ctx, err)
+		return
+	}
+	dh.saved(ctx, user, event, models.Task(payload))
+	if day := payload.Day(); day != existing.Day() {
+		// The card moves to another day's list
+		publishDays(ctx, dh.broker, dh.taskRepo, user.UserID, "task-deleted", map[civil.Date]bool{existing.Day(): true})
+		publishCreated(ctx, dh.broker, dh.taskRepo, user.UserID, map[civil.Date]bool{day: true})
+	} else if event == models.EventTaskCompleted {
+		publishTask(ctx, dh.broker, user.UserID, "task-completed", models.Task(payload))
+	} else {
+		publishTask(ctx, dh.broker, user.UserID, "task-updated", models.Task(payload))
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// readTodo reads the single VTODO of a calendar object, or names the
+// CalDAV precondition it violates
+func (dh *caldavHandler) readTodo(body []byte, user *models.User) (ical.Entry, string) {
+	root, err := ical.Parse(bytes.NewReader(body))
+	if err != nil {
+		return ical.Entry{}, "valid-calendar-data"
+	}
+	// Tasks need a day; undated to-dos land on today
+	loc := user.Location()
+	entries, errs := ical.EntriesOn(root, loc, utils.GetTodayDate(loc))
+	if len(errs) > 0 {
+		return ical.Entry{}, "valid-calendar-data"
+	}
+	// Overrides of single occurrences share the UID of the first entry,
+	// which is the one the task follows
+	if len(entries) == 0 || entries[0].Kind != "VTODO" {
+		return ical.Entry{}, "supported-calendar-component"
+	}
+	if entries[0].UID == "" {
+		return ical.Entry{}, "valid-calendar-object-resource"
+	}
+	return entries[0], ""
+}
+
+func (dh *caldavHandler) delete(ctx *gin.Context, user *models.User, name string) {
+	task, ok := dh.ownTask(ctx, user, name)
+	if !ok {
+		return
+	}
+	taskID := task.TaskID
+	if preconditionFailed(ctx, contentETag(dh.object(*task, user))) {
+		ctx.Status(http.StatusPreconditionFailed)
+		return
+	}
+
+	if _, err := dh.taskRepo.DeleteTaskById(ctx, user.UserID, taskID); errors.Is(err, models.ErrTaskNotFound) {
+		// Deleted since it was read
+		ctx.Status(http.StatusNotFound)
+		return
+	} else if err != nil {
+		dh.fail(ctx, err)
+		return
+	}
+	if err := dh.reminders.Cancel(ctx, taskID); err != nil {
+		logging.FromContext(ctx.Request.Context()).WithError(err).WithField("task_id", taskID).Error("Failed to cancel reminders")
+	}
+	if err := dh.events.EmitTaskEvent(ctx, user.UserID, models.EventTaskDeleted, *task); err != nil {
+		logging.FromContext(ctx.Request.Context()).WithError(err).WithField("task_id", taskID).Error("Failed to queue webhook event")
+	}
+	publishDays(ctx, dh.broker, dh.taskRepo, user.UserID, "task-deleted", map[civil.Date]bool{task.Day(): true})
+	ctx.Status(http.StatusNoContent)
+}
+
+// saved reschedules the reminders of a task written over CalDAV and tells
+// the user's webhooks; failures are logged as the task itself was saved
+func (dh *caldavHandler) saved(ctx *gin.Context, user *models.User, event string, task models.Task) {
+	if err := dh.reminders.Reschedule(ctx, task, user.Location()); err != nil {
+		logging.FromContext(ctx.Request.Context()).WithError(err).WithField("task_id", task.TaskID).Error("Failed to schedule reminders")
+	}
+	if err := dh.events.EmitTaskEvent(ctx, user.UserID, event, task); err != nil {
+		logging.FromContext(ctx.Request.Context()).WithError(err).WithField("task_id", task.TaskID).Error("Failed to queue webhook event")
+	}
+}
+
+func (dh *caldavHandler) fail(ctx *gin.Context, err error) {
+	logging.FromContext(ctx.Request.Context()).WithError(err).Error("CalDAV request failed")
+	ctx.Status(http.StatusInternalServerError)
+}
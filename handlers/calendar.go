@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"firebase.google.com/go/auth"
+	"github.com/Zenk41/go-gin-htmx/history"
+	"github.com/Zenk41/go-gin-htmx/ical"
+	"github.com/Zenk41/go-gin-htmx/logging"
+	"github.com/Zenk41/go-gin-htmx/models"
+	"github.com/Zenk41/go-gin-htmx/pubsub"
+	"github.com/Zenk41/go-gin-htmx/utils"
+	view_calendar "github.com/Zenk41/go-gin-htmx/views/calendar"
+	"github.com/Zenk41/go-gin-htmx/views/components"
+	"github.com/a-h/templ"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// The feed covers tasks from feedPastDays ago to feedFutureDays ahead
+	feedPastDays   = 90
+	feedFutureDays = 365
+	// maxImportSize bounds uploaded .ics files
+	maxImportSize = 2 << 20
+)
+
+type CalendarHandler interface {
+	Feed(ctx *gin.Context)
+	FeedSettings(ctx *gin.Context)
+	EnableFeed(ctx *gin.Context)
+	DisableFeed(ctx *gin.Context)
+	ImportPage(ctx *gin.Context)
+	ImportPreview(ctx *gin.Context)
+	Import(ctx *gin.Context)
+}
+
+type calendarHandler struct {
+	taskRepo     models.TaskRepository
+	userRepo     models.UserRepository
+	reminders    ReminderScheduler
+	events       TaskEventEmitter
+	broker       pubsub.Broker
+	firebaseAuth *auth.Client
+	audit        models.AuditRepository
+	domain       string
+}
+
+func NewCalendarHandler(taskRepo models.TaskRepository,
+	userRepo models.UserRepository,
+	reminders ReminderScheduler,
+	events TaskEventEmitter,
+	broker pubsub.Broker,
+	firebaseAuth *auth.Client,
+	audit models.AuditRepository,
+	domain string) CalendarHandler {
+	return &calendarHandler{
+		taskRepo:     taskRepo,
+		userRepo:     userRepo,
+		reminders:    reminders,
+		events:       events,
+		broker:       broker,
+		firebaseAuth: firebaseAuth,
+		audit:        audit,
+		domain:       domain,
+	}
+}
+
+// Feed serves the iCalendar feed behind a user's secret token. It needs no
+// login so calendar apps can poll it.
+func (ch *calendarHandler) Feed(ctx *gin.Context) {
+	token := strings.TrimSuffix(ctx.Param("token"), ".ics")
+	if token == "" {
+		ctx.Status(http.StatusNotFound)
+		return
+	}
+
+	user, err := ch.userRepo.GetUserByCalendarToken(ctx, token)
+	if err != nil {
+		ctx.Status(http.StatusNotFound)
+		return
+	}
+
+	loc := user.Location()
+	today := utils.GetTodayDate(loc)
+	tasks, err := ch.taskRepo.GetTasksInRange(ctx, user.UserID, today.AddDays(-feedPastDays), today.AddDays(feedFutureDays))
+	if err != nil {
+		logging.FromContext(ctx.Request.Context()).WithError(err).Error("Failed to get tasks for calendar feed")
+		ctx.Status(http.StatusInternalServerError)
+		return
+	}
+
+	body := ical.Feed(*tasks, ical.FeedOptions{
+		Name:     user.Name + "'s tasks",
+		Domain:   ch.domain,
+		Events:   ctx.Query("as") == "events",
+		Location: loc,
+	})
+
+	etag := contentETag(body)
+	ctx.Header("ETag", etag)
+	ctx.Header("Cache-Control", "private, max-age=300")
+	if etagMatches(ctx.GetHeader("If-None-Match"), etag) {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+	ctx.Header("Content-Disposition", `inline; filename="tasks.ics"`)
+	ctx.Data(http.StatusOK, "text/calendar; charset=utf-8", body)
+}
+
+// contentETag derives a strong ETag from a response body
+func contentETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches implements the If-Match and If-None-Match comparison
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// FeedSettings renders the calendar section of the settings page
+func (ch *calendarHandler) FeedSettings(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, ch.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	user, err := ch.userRepo.GetUser(ctx, userId)
+	if err != nil {
+		Render(ctx, view_calendar.FeedSettings("", "", components.Alert("error", "Failed to get user")))
+		return
+	}
+	ch.renderFeedSettings(ctx, user.CalendarToken, nil)
+}
+
+// EnableFeed turns the feed on, or replaces its token when it already is
+func (ch *calendarHandler) EnableFeed(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, ch.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		Render(ctx, view_calendar.FeedSettings("", "", components.Alert("error", "Failed to generate link")))
+		return
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	if err := ch.userRepo.SetCalendarToken(ctx, userId, token); err != nil {
+		Render(ctx, view_calendar.FeedSettings("", "", components.Alert("error", "Failed to save link")))
+		return
+	}
+	recordAudit(ctx, ch.audit, userId, models.AuditCalendarFeedEnabled, "")
+	ch.renderFeedSettings(ctx, token, components.Alert("success", "Calendar link created"))
+}
+
+// DisableFeed turns the feed off
+func (ch *calendarHandler) DisableFeed(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, ch.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	if err := ch.userRepo.SetCalendarToken(ctx, userId, ""); err != nil {
+		Render(ctx, view_calendar.FeedSettings("", "", components.Alert("error", "Failed to turn the feed off")))
+		return
+	}
+	recordAudit(ctx, ch.audit, userId, models.AuditCalendarFeedRevoked, "")
+	ch.renderFeedSettings(ctx, "", components.Alert("success", "Calendar feed turned off"))
+}
+
+func (ch *calendarHandler) renderFeedSettings(ctx *gin.Context, token string, alert templ.Component) {
+	if token == "" {
+		Render(ctx, view_calendar.FeedSettings("", "", alert))
+		return
+	}
+
+	// The links are built on the configured domain, never on a Host header
+	// the request could have set
+	path := "/calendar/" + token + ".ics"
+	feedURL := requestScheme(ctx) + "://" + ch.domain + path
+	webcalURL := "webcal://" + ch.domain + path
+	Render(ctx, view_calendar.FeedSettings(feedURL, webcalURL, alert))
+}
+
+// baseURL is the scheme and host the request reached the app on
+func baseURL(ctx *gin.Context) string {
+	return requestScheme(ctx) + "://" + ctx.Request.Host
+}
+
+// requestScheme is the scheme the request reached the app on
+func requestScheme(ctx *gin.Context) string {
+	if ctx.Request.TLS != nil || ctx.GetHeader("X-Forwarded-Proto") == "https" {
+		return "https"
+	}
+	return "http"
+}
+
+// ImportPage renders the .ics upload form
+func (ch *calendarHandler) ImportPage(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, ch.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	user, err := ch.userRepo.GetUser(ctx, userId)
+	if err != nil {
+		Render(ctx, view_calendar.Import(models.User{}, components.Alert("error", "Failed to get user")))
+		return
+	}
+	Render(ctx, view_calendar.Import(*user, nil))
+}
+
+// ImportPreview parses the uploaded file and shows what would be imported
+func (ch *calendarHandler) ImportPreview(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, ch.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	user, err := ch.userRepo.GetUser(ctx, userId)
+	if err != nil {
+		Render(ctx, view_calendar.Preview(nil, nil, components.Alert("error", "Failed to get user")))
+		return
+	}
+
+	header, err := ctx.FormFile("file")
+	if err != nil {
+		Render(ctx, view_calendar.Preview(nil, nil, components.Alert("error", "Choose a file to import")))
+		return
+	}
+	if header.Size > maxImportSize {
+		Render(ctx, view_calendar.Preview(nil, nil, components.Alert("error", "The file is larger than 2 MB")))
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		Render(ctx, view_calendar.Preview(nil, nil, components.Alert("error", "Failed to read the file")))
+		return
+	}
+	defer file.Close()
+
+	root, err := ical.Parse(io.LimitReader(file, maxImportSize))
+	if err != nil {
+		Render(ctx, view_calendar.Preview(nil, nil, components.Alert("error", "Not a valid calendar file: "+err.Error())))
+		return
+	}
+	entries, errs := ical.Entries(root, user.Location())
+
+	duplicates, err := ch.duplicates(ctx, userId, entries)
+	if err != nil {
+		Render(ctx, view_calendar.Preview(nil, nil, components.Alert("error", "Failed to check for duplicates")))
+		return
+	}
+
+	// Entries repeating a UID of the file, such as overridden occurrences,
+	// are duplicates of the first one
+	rows := make([]view_calendar.Row, len(entries))
+	for i, entry := range entries {
+		rows[i] = view_calendar.Row{Entry: entry, Duplicate: duplicates[entry.UID]}
+		if entry.UID != "" {
+			duplicates[entry.UID] = true
+		}
+	}
+	Render(ctx, view_calendar.Preview(rows, errs, nil))
+}
+
+// Import creates tasks from the previewed entries the user selected
+func (ch *calendarHandler) Import(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, ch.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	user, err := ch.userRepo.GetUser(ctx, userId)
+	if err != nil {
+		Render(ctx, view_calendar.Imported(0, nil, components.Alert("error", "Failed to get user")))
+		return
+	}
+	ctx.Request = ctx.Request.WithContext(history.WithSource(ctx.Request.Context(), history.SourceCalendar))
+
+	var entries []ical.Entry
+	if err := json.Unmarshal([]byte(ctx.PostForm("entries")), &entries); err != nil {
+		Render(ctx, view_calendar.Imported(0, nil, components.Alert("error", "Invalid import, preview the file again")))
+		return
+	}
+
+	// rows are the indexes of the selected entries, keying their creates
+	var selected []ical.Entry
+	var rows []string
+	var failed []string
+	seen := map[string]bool{}
+	for _, index := range ctx.PostFormArray("selected") {
+		i, err := strconv.Atoi(index)
+		if err != nil || i < 0 || i >= len(entries) {
+			continue
+		}
+		// The entries went through the browser, check them again
+		entry := entries[i]
+		if err := entry.Validate(); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", entry.Title, err))
+			continue
+		}
+		if entry.UID != "" {
+			if seen[entry.UID] {
+				continue
+			}
+			seen[entry.UID] = true
+		}
+		selected = append(selected, entry)
+		rows = append(rows, index)
+	}
+
+	// Check again, the same file may have been imported since the preview
+	duplicates, err := ch.duplicates(ctx, userId, selected)
+	if err != nil {
+		Render(ctx, view_calendar.Imported(0, nil, components.Alert("error", "Failed to check for duplicates")))
+		return
+	}
+
+	now := time.Now()
+	created := 0
+	days := map[civil.Date]bool{}
+	for i, entry := range selected {
+		if duplicates[entry.UID] {
+			continue
+		}
+
+		payload := entry.Payload(userId)
+		payload.CreatedAt = now
+		payload.UpdatedAt = now
+		task, err := ch.taskRepo.CreateTask(ctx, payload, importKey(ctx, rows[i]))
+		replayed := errors.Is(err, models.ErrTaskAlreadyCreated)
+		if err != nil && !replayed {
+			logging.FromContext(ctx.Request.Context()).WithError(err).Error("Failed to import task")
+			failed = append(failed, entry.Title)
+			continue
+		}
+		created++
+		if replayed {
+			continue
+		}
+		days[task.Day()] = true
+
+		if err := ch.reminders.Reschedule(ctx, *task, user.Location()); err != nil {
+			logging.FromContext(ctx.Request.Context()).WithError(err).WithField("task_id", task.TaskID).Error("Failed to schedule reminders")
+		}
+		if err := ch.events.EmitTaskEvent(ctx, userId, models.EventTaskCreated, *task); err != nil {
+			logging.FromContext(ctx.Request.Context()).WithError(err).WithField("task_id", task.TaskID).Error("Failed to queue webhook event")
+		}
+	}
+	publishCreated(ctx, ch.broker, ch.taskRepo, userId, days)
+	Render(ctx, view_calendar.Imported(created, failed, components.Alert("success", fmt.Sprintf("Imported %d task(s)", created))))
+}
+
+// duplicates reports which entry UIDs the user already has, either from an
+// earlier import or because the entry came from this app's own feed
+func (ch *calendarHandler) duplicates(ctx *gin.Context, userId string, entries []ical.Entry) (map[string]bool, error) {
+	var uids []string
+	found := map[string]bool{}
+	for _, entry := range entries {
+		if entry.UID == "" {
+			continue
+		}
+		if taskID, ok := strings.CutSuffix(entry.UID, "@"+ch.domain); ok {
+			if _, err := ch.taskRepo.GetTaskById(ctx, userId, taskID); err == nil {
+				found[entry.UID] = true
+				continue
+			}
+		}
+		uids = append(uids, entry.UID)
+	}
+
+	imported, err := ch.taskRepo.FindICalUIDs(ctx, userId, uids)
+	if err != nil {
+		return nil, err
+	}
+	for uid := range imported {
+		found[uid] = true
+	}
+	return found, nil
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"firebase.google.com/go/auth"
+	"github.com/Zenk41/go-gin-htmx/backup"
+	"github.com/Zenk41/go-gin-htmx/logging"
+	"github.com/Zenk41/go-gin-htmx/models"
+	"github.com/Zenk41/go-gin-htmx/views/components"
+	view_settings "github.com/Zenk41/go-gin-htmx/views/settings"
+	"github.com/gin-gonic/gin"
+)
+
+// maxArchiveSize bounds uploaded exports
+const maxArchiveSize = 32 << 20
+
+type DataHandler interface {
+	Export(ctx *gin.Context)
+	Import(ctx *gin.Context)
+}
+
+type dataHandler struct {
+	taskRepo     models.TaskRepository
+	userRepo     models.UserRepository
+	reminders    ReminderScheduler
+	firebaseAuth *auth.Client
+}
+
+func NewDataHandler(taskRepo models.TaskRepository,
+	userRepo models.UserRepository,
+	reminders ReminderScheduler,
+	firebaseAuth *auth.Client) DataHandler {
+	return &dataHandler{
+		taskRepo:     taskRepo,
+		userRepo:     userRepo,
+		reminders:    reminders,
+		firebaseAuth: firebaseAuth,
+	}
+}
+
+// Export streams the user's profile and tasks as a ZIP
+func (dh *dataHandler) Export(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, dh.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	user, err := dh.userRepo.GetUser(ctx, userId)
+	if err != nil {
+		ctx.Status(http.StatusInternalServerError)
+		return
+	}
+
+	ctx.Header("Content-Type", "application/zip")
+	ctx.Header("Content-Disposition", `attachment; filename="tasks-export-`+time.Now().Format("2006-01-02")+`.zip"`)
+	if err := backup.Export(ctx, ctx.Writer, *user, dh.taskRepo); err != nil {
+		// Headers are gone by now; the truncated download is the only signal
+		logging.FromContext(ctx.Request.Context()).WithError(err).Error("Failed to export data")
+	}
+}
+
+// Import restores the tasks and settings of an uploaded export
+func (dh *dataHandler) Import(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, dh.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	user, err := dh.userRepo.GetUser(ctx, userId)
+	if err != nil {
+		Render(ctx, view_settings.Imported(backup.Result{}, components.Alert("error", "Failed to get user")))
+		return
+	}
+
+	header, err := ctx.FormFile("file")
+	if err != nil {
+		Render(ctx, view_settings.Imported(backup.Result{}, components.Alert("error", "Choose an export to import")))
+		return
+	}
+	if header.Size > maxArchiveSize {
+		Render(ctx, view_settings.Imported(backup.Result{}, components.Alert("error", "The file is larger than 32 MB")))
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		Render(ctx, view_settings.Imported(backup.Result{}, components.Alert("error", "Failed to read the file")))
+		return
+	}
+	defer file.Close()
+
+	_, accounts, err := backup.Read(file, header.Size)
+	if err != nil {
+		Render(ctx, view_settings.Imported(backup.Result{}, components.Alert("error", "Not a valid export: "+err.Error())))
+		return
+	}
+	if len(accounts) != 1 {
+		Render(ctx, view_settings.Imported(backup.Result{}, components.Alert("error", "This is a full backup, not the export of one account")))
+		return
+	}
+
+	restorer := backup.Restorer{Users: dh.userRepo, Tasks: dh.taskRepo, Reminders: dh.reminders}
+	result, err := restorer.RestoreInto(ctx, *user, accounts[0])
+	if err != nil {
+		logging.FromContext(ctx.Request.Context()).WithError(err).Error("Failed to import data")
+		Render(ctx, view_settings.Imported(result, components.Alert("error", "The import stopped early, try again")))
+		return
+	}
+	Render(ctx, view_settings.Imported(result, components.Alert("success", "Import finished")))
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/Zenk41/go-gin-htmx/digest"
+	"github.com/Zenk41/go-gin-htmx/logging"
+	"github.com/Zenk41/go-gin-htmx/models"
+	view_digest "github.com/Zenk41/go-gin-htmx/views/digest"
+	"github.com/gin-gonic/gin"
+)
+
+type DigestHandler interface {
+	UnsubscribePage(ctx *gin.Context)
+	Unsubscribe(ctx *gin.Context)
+}
+
+type digestHandler struct {
+	userRepo   models.UserRepository
+	signingKey []byte
+}
+
+func NewDigestHandler(userRepo models.UserRepository, signingKey []byte) DigestHandler {
+	return &digestHandler{
+		userRepo:   userRepo,
+		signingKey: signingKey,
+	}
+}
+
+// UnsubscribePage is where the link in the digest email leads
+func (dh *digestHandler) UnsubscribePage(ctx *gin.Context) {
+	uid, token := ctx.Query("uid"), ctx.Query("token")
+	if !digest.VerifyUnsubscribeToken(dh.signingKey, uid, token) {
+		ctx.Status(http.StatusForbidden)
+		Render(ctx, view_digest.Unsubscribed(false))
+		return
+	}
+	Render(ctx, view_digest.Unsubscribe(uid, token))
+}
+
+// Unsubscribe turns the digest off. It also serves one-click unsubscribe
+// requests sent by mail clients (RFC 8058), which need no login.
+func (dh *digestHandler) Unsubscribe(ctx *gin.Context) {
+	uid := ctx.Query("uid")
+	if !digest.VerifyUnsubscribeToken(dh.signingKey, uid, ctx.Query("token")) {
+		ctx.Status(http.StatusForbidden)
+		Render(ctx, view_digest.Unsubscribed(false))
+		return
+	}
+
+	if err := dh.userRepo.UnsubscribeDigest(ctx, uid); err != nil {
+		logging.FromContext(ctx).WithError(err).WithField(logging.UserIDKey, uid).Error("Failed to unsubscribe from digest")
+		ctx.Status(http.StatusInternalServerError)
+		Render(ctx, view_digest.Unsubscribed(false))
+		return
+	}
+	Render(ctx, view_digest.Unsubscribed(true))
+}
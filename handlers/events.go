@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"firebase.google.com/go/auth"
+	"github.com/Zenk41/go-gin-htmx/logging"
+	"github.com/Zenk41/go-gin-htmx/pubsub"
+	"github.com/gin-gonic/gin"
+)
+
+type EventHandler interface {
+	Stream(ctx *gin.Context)
+}
+
+type eventHandler struct {
+	broker       pubsub.Broker
+	firebaseAuth *auth.Client
+	keepAlive    time.Duration
+}
+
+func NewEventHandler(broker pubsub.Broker, firebaseAuth *auth.Client, keepAlive time.Duration) EventHandler {
+	return &eventHandler{
+		broker:       broker,
+		firebaseAuth: firebaseAuth,
+		keepAlive:    keepAlive,
+	}
+}
+
+// Stream sends the signed in user's events as server-sent events until the
+// client goes away or the broker is closed on shutdown
+func (eh *eventHandler) Stream(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, eh.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	// The stream outlives the server's write timeout
+	if err := http.NewResponseController(ctx.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		logging.FromContext(ctx).WithError(err).Warn("Failed to clear write deadline for event stream")
+	}
+
+	events, unsubscribe := eh.broker.Subscribe(pubsub.UserTopic(userId))
+	defer unsubscribe()
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Header("X-Accel-Buffering", "no") // Stop proxies such as nginx from buffering the stream
+	ctx.Status(http.StatusOK)
+	ctx.Writer.Flush()
+
+	ticker := time.NewTicker(eh.keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Request.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			ctx.SSEvent(event.Name, event.Data)
+		case <-ticker.C:
+			// A comment line keeps idle connections from being dropped
+			if _, err := ctx.Writer.WriteString(": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		ctx.Writer.Flush()
+	}
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Zenk41/go-gin-htmx/buildinfo"
+	"github.com/gin-gonic/gin"
+)
+
+type HealthHandler interface {
+	Healthz(ctx *gin.Context)
+	Readyz(ctx *gin.Context)
+	Version(ctx *gin.Context)
+}
+
+// ReadinessCheck probes a single dependency
+type ReadinessCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type checkResult struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+	Error   string `json:"error,omitempty"`
+}
+
+type healthHandler struct {
+	timeout time.Duration
+	checks  []ReadinessCheck
+}
+
+func NewHealthHandler(timeout time.Duration, checks ...ReadinessCheck) HealthHandler {
+	return &healthHandler{
+		timeout: timeout,
+		checks:  checks,
+	}
+}
+
+// Healthz reports that the process is alive
+func (hh *healthHandler) Healthz(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readyz runs every dependency check in parallel, each bounded by the
+// handler timeout, and answers 503 when any of them fails
+func (hh *healthHandler) Readyz(ctx *gin.Context) {
+	results := make(map[string]checkResult, len(hh.checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, check := range hh.checks {
+		wg.Add(1)
+		go func(check ReadinessCheck) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx.Request.Context(), hh.timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check.Check(checkCtx)
+			result := checkResult{Status: "ok", Latency: time.Since(start).String()}
+			if err != nil {
+				result.Status = "error"
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			results[check.Name] = result
+			mu.Unlock()
+		}(check)
+	}
+	wg.Wait()
+
+	status, code := "ok", http.StatusOK
+	for _, result := range results {
+		if result.Status != "ok" {
+			status, code = "unavailable", http.StatusServiceUnavailable
+			break
+		}
+	}
+	ctx.JSON(code, gin.H{"status": status, "checks": results})
+}
+
+// Version reports the build the process is running
+func (hh *healthHandler) Version(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, buildinfo.Get())
+}
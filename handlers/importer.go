@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"firebase.google.com/go/auth"
+	"github.com/Zenk41/go-gin-htmx/history"
+	"github.com/Zenk41/go-gin-htmx/importer"
+	"github.com/Zenk41/go-gin-htmx/logging"
+	"github.com/Zenk41/go-gin-htmx/models"
+	"github.com/Zenk41/go-gin-htmx/pubsub"
+	"github.com/Zenk41/go-gin-htmx/utils"
+	"github.com/Zenk41/go-gin-htmx/views/components"
+	view_imports "github.com/Zenk41/go-gin-htmx/views/imports"
+	"github.com/gin-gonic/gin"
+)
+
+// maxListImportSize bounds uploaded or pasted exports; Trello boards carry
+// their whole history and get large
+const maxListImportSize = 10 << 20
+
+type ImportHandler interface {
+	Page(ctx *gin.Context)
+	Preview(ctx *gin.Context)
+	Import(ctx *gin.Context)
+}
+
+type importHandler struct {
+	taskRepo     models.TaskRepository
+	userRepo     models.UserRepository
+	reminders    ReminderScheduler
+	events       TaskEventEmitter
+	broker       pubsub.Broker
+	firebaseAuth *auth.Client
+}
+
+func NewImportHandler(taskRepo models.TaskRepository,
+	userRepo models.UserRepository,
+	reminders ReminderScheduler,
+	events TaskEventEmitter,
+	broker pubsub.Broker,
+	firebaseAuth *auth.Client) ImportHandler {
+	return &importHandler{
+		taskRepo:     taskRepo,
+		userRepo:     userRepo,
+		reminders:    reminders,
+		events:       events,
+		broker:       broker,
+		firebaseAuth: firebaseAuth,
+	}
+}
+
+// Page renders the form to upload or paste an export
+func (ih *importHandler) Page(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, ih.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	user, err := ih.userRepo.GetUser(ctx, userId)
+	if err != nil {
+		Render(ctx, view_imports.Index(models.User{}, importer.Parsers(), components.Alert("error", "Failed to get user")))
+		return
+	}
+	Render(ctx, view_imports.Index(*user, importer.Parsers(), nil))
+}
+
+// Preview parses the export without saving anything and shows which rows
+// would become tasks and why the others cannot
+func (ih *importHandler) Preview(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, ih.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	user, err := ih.userRepo.GetUser(ctx, userId)
+	if err != nil {
+		Render(ctx, view_imports.Preview(nil, components.Alert("error", "Failed to get user")))
+		return
+	}
+
+	parser := importer.Lookup(ctx.PostForm("source"))
+	if parser == nil {
+		Render(ctx, view_imports.Preview(nil, components.Alert("error", "Choose what to import from")))
+		return
+	}
+
+	var input io.Reader
+	if header, err := ctx.FormFile("file"); err == nil {
+		if header.Size > maxListImportSize {
+			Render(ctx, view_imports.Preview(nil, components.Alert("error", "The file is larger than 10 MB")))
+			return
+		}
+		file, err := header.Open()
+		if err != nil {
+			Render(ctx, view_imports.Preview(nil, components.Alert("error", "Failed to read the file")))
+			return
+		}
+		defer file.Close()
+		input = file
+	} else if text := ctx.PostForm("text"); strings.TrimSpace(text) != "" {
+		input = strings.NewReader(text)
+	} else {
+		Render(ctx, view_imports.Preview(nil, components.Alert("error", "Choose a file or paste your list")))
+		return
+	}
+
+	rows, err := parser.Parse(io.LimitReader(input, maxListImportSize), importer.Options{
+		Location: user.Location(),
+		Today:    utils.GetTodayDate(user.Location()),
+	})
+	if err != nil {
+		Render(ctx, view_imports.Preview(rows, components.Alert("error", err.Error())))
+		return
+	}
+	Render(ctx, view_imports.Preview(rows, nil))
+}
+
+// Import creates the previewed tasks the user selected
+func (ih *importHandler) Import(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, ih.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	user, err := ih.userRepo.GetUser(ctx, userId)
+	if err != nil {
+		Render(ctx, view_imports.Imported(0, nil, components.Alert("error", "Failed to get user")))
+		return
+	}
+	ctx.Request = ctx.Request.WithContext(history.WithSource(ctx.Request.Context(), history.SourceImport))
+
+	var tasks []models.TaskPayload
+	if err := json.Unmarshal([]byte(ctx.PostForm("tasks")), &tasks); err != nil {
+		Render(ctx, view_imports.Imported(0, nil, components.Alert("error", "Invalid import, preview the list again")))
+		return
+	}
+
+	now := time.Now()
+	created := 0
+	var failed []string
+	days := map[civil.Date]bool{}
+	for _, index := range ctx.PostFormArray("selected") {
+		i, err := strconv.Atoi(index)
+		if err != nil || i < 0 || i >= len(tasks) {
+			continue
+		}
+		// The tasks went through the browser, check them again
+		payload, err := importer.Clean(tasks[i])
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", tasks[i].Title, err))
+			continue
+		}
+
+		payload.UserID = userId
+		payload.CreatedAt = now
+		payload.UpdatedAt = now
+		task, err := ih.taskRepo.CreateTask(ctx, payload, importKey(ctx, index))
+		replayed := errors.Is(err, models.ErrTaskAlreadyCreated)
+		if err != nil && !replayed {
+			logging.FromContext(ctx.Request.Context()).WithError(err).Error("Failed to import task")
+			failed = append(failed, payload.Title+": failed to save")
+			continue
+		}
+		created++
+		if replayed {
+			continue
+		}
+		days[task.Day()] = true
+
+		if err := ih.reminders.Reschedule(ctx, *task, user.Location()); err != nil {
+			logging.FromContext(ctx.Request.Context()).WithError(err).WithField("task_id", task.TaskID).Error("Failed to schedule reminders")
+		}
+		if err := ih.events.EmitTaskEvent(ctx, userId, models.EventTaskCreated, *task); err != nil {
+			logging.FromContext(ctx.Request.Context()).WithError(err).WithField("task_id", task.TaskID).Error("Failed to queue webhook event")
+		}
+	}
+	publishCreated(ctx, ih.broker, ih.taskRepo, userId, days)
+	Render(ctx, view_imports.Imported(created, failed, components.Alert("success", fmt.Sprintf("Imported %d task(s)", created))))
+}
+
+// importKey is the idempotency key of one row of an import. The preview
+// form carries a key of its own, so submitting the same preview twice
+// creates each row once; empty without that key.
+func importKey(ctx *gin.Context, row string) string {
+	key := idempotencyKey(ctx)
+	if key == "" {
+		return ""
+	}
+	return "import:" + key + ":" + row
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"net/http"
+
+	"firebase.google.com/go/auth"
+	"github.com/Zenk41/go-gin-htmx/logging"
+	"github.com/Zenk41/go-gin-htmx/models"
+	"github.com/Zenk41/go-gin-htmx/pubsub"
+	"github.com/Zenk41/go-gin-htmx/views/components"
+	"github.com/gin-gonic/gin"
+)
+
+// bellSize is how many notifications the bell dropdown lists
+const bellSize = 10
+
+type NotificationHandler interface {
+	Bell(ctx *gin.Context)
+	MarkRead(ctx *gin.Context)
+	MarkAllRead(ctx *gin.Context)
+}
+
+type notificationHandler struct {
+	notificationRepo models.NotificationRepository
+	broker           pubsub.Broker
+	firebaseAuth     *auth.Client
+}
+
+func NewNotificationHandler(notificationRepo models.NotificationRepository,
+	broker pubsub.Broker,
+	firebaseAuth *auth.Client) NotificationHandler {
+	return &notificationHandler{
+		notificationRepo: notificationRepo,
+		broker:           broker,
+		firebaseAuth:     firebaseAuth,
+	}
+}
+
+// Bell renders the notification dropdown with the latest notifications
+func (nh *notificationHandler) Bell(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, nh.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	nh.renderBell(ctx, userId)
+}
+
+// MarkRead marks one notification as read and renders the updated dropdown
+func (nh *notificationHandler) MarkRead(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, nh.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	if err := nh.notificationRepo.MarkRead(ctx, userId, ctx.Param("id")); err != nil {
+		logging.FromContext(ctx).WithError(err).Error("Failed to mark notification as read")
+	} else {
+		nh.publishRead(userId)
+	}
+	nh.renderBell(ctx, userId)
+}
+
+// MarkAllRead marks every notification as read and renders the updated dropdown
+func (nh *notificationHandler) MarkAllRead(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, nh.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	if _, err := nh.notificationRepo.MarkAllRead(ctx, userId); err != nil {
+		logging.FromContext(ctx).WithError(err).Error("Failed to mark notifications as read")
+	} else {
+		nh.publishRead(userId)
+	}
+	nh.renderBell(ctx, userId)
+}
+
+// publishRead lets the user's other open pages refresh their unread count
+func (nh *notificationHandler) publishRead(userId string) {
+	nh.broker.Publish(pubsub.UserTopic(userId), pubsub.Event{Name: "notifications-read"})
+}
+
+func (nh *notificationHandler) renderBell(ctx *gin.Context, userId string) {
+	notifications, err := nh.notificationRepo.ListNotifications(ctx, userId, bellSize)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).Error("Failed to list notifications")
+		Render(ctx, components.NotificationBell(nil, 0))
+		return
+	}
+
+	unread, err := nh.notificationRepo.CountUnread(ctx, userId)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).Error("Failed to count unread notifications")
+	}
+	Render(ctx, components.NotificationBell(notifications, unread))
+}
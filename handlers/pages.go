@@ -5,6 +5,7 @@ import (
 
 	"time"
 
+	"cloud.google.com/go/civil"
 	"firebase.google.com/go/auth"
 	"github.com/Zenk41/go-gin-htmx/api"
 	"github.com/Zenk41/go-gin-htmx/models"
@@ -12,6 +13,7 @@ import (
 	view_auth "github.com/Zenk41/go-gin-htmx/views/auth"
 	"github.com/Zenk41/go-gin-htmx/views/components"
 	"github.com/Zenk41/go-gin-htmx/views/home"
+	view_settings "github.com/Zenk41/go-gin-htmx/views/settings"
 	"github.com/gin-gonic/gin"
 )
 
@@ -19,6 +21,7 @@ type PageHandler interface {
 	Home(ctx *gin.Context)
 	Login(ctx *gin.Context)
 	Register(ctx *gin.Context)
+	Settings(ctx *gin.Context)
 }
 
 type pageHandler struct {
@@ -41,35 +44,37 @@ func NewPageHandler(userRepo models.UserRepository,
 }
 
 func (ph *pageHandler) Home(ctx *gin.Context) {
-	formattedDate := utils.GetTodayDate()
+	formattedDate := utils.GetTodayDate(time.UTC).String()
 
 	userId, err := CookieAuth(ctx, ph.firebaseAuth)
-    if err != nil {
-        Render(ctx, home.Index(models.User{}, components.Alert("warning", err.Error()), formattedDate, components.Tasks([]models.Task{}, nil)))
-        return
-    }
-
-	user, err := ph.userRepo.GetUser(ctx, userId)
 	if err != nil {
-		Render(ctx, home.Index(models.User{}, components.Alert("error", "Failed to get user"), formattedDate, components.Tasks([]models.Task{}, nil)))
+		Render(ctx, home.Index(models.User{}, components.Alert("warning", err.Error()), formattedDate, components.Tasks("", []models.Task{}, nil)))
 		return
 	}
-	date, err := time.Parse("2006-01-02", formattedDate)
+
+	user, err := ph.userRepo.GetUser(ctx, userId)
 	if err != nil {
-		// Render the page with a logged-out state if user retrieval fails
-		Render(ctx, home.Index(models.User{}, components.Alert("error", err.Error()), formattedDate, components.Tasks([]models.Task{}, nil)))
+		Render(ctx, home.Index(models.User{}, components.Alert("error", "Failed to get user"), formattedDate, components.Tasks("", []models.Task{}, nil)))
 		return
 	}
+
+	// "Today" is the user's today, not the server's; links such as reminders may ask for another day
+	date := utils.GetTodayDate(user.Location())
+	if requested, err := civil.ParseDate(ctx.Query("date")); err == nil {
+		date = requested
+	}
+	formattedDate = date.String()
+
 	//Get task from repo
-	tasks, err := ph.taskRepo.GetTasksByDate(ctx, user.UserID, date)
+	page, err := ph.taskRepo.GetTaskPageByDate(ctx, user.UserID, date, models.PageRequest{})
 	if err != nil {
 		// Render the page with a logged-out state if user retrieval fails
-		Render(ctx, home.Index(models.User{}, components.Alert("error", err.Error()), formattedDate, components.Tasks([]models.Task{}, nil)))
+		Render(ctx, home.Index(models.User{}, components.Alert("error", err.Error()), formattedDate, components.Tasks("", []models.Task{}, nil)))
 		return
 	}
 
 	// Render the page with the logged-in state and the user data
-	Render(ctx, home.Index(*user, nil, formattedDate, components.Tasks(*tasks, nil)))
+	Render(ctx, home.Index(*user, nil, formattedDate, components.TasksPage(formattedDate, page, nil)))
 }
 
 func (ph *pageHandler) Login(ctx *gin.Context) {
@@ -88,3 +93,18 @@ func (ph *pageHandler) Register(ctx *gin.Context) {
 	}
 	Render(ctx, view_auth.Register(nil))
 }
+
+func (ph *pageHandler) Settings(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, ph.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	user, err := ph.userRepo.GetUser(ctx, userId)
+	if err != nil {
+		Render(ctx, view_settings.Index(models.User{}, components.Alert("error", "Failed to get user")))
+		return
+	}
+	Render(ctx, view_settings.Index(*user, nil))
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"firebase.google.com/go/auth"
+	"github.com/Zenk41/go-gin-htmx/logging"
+	"github.com/Zenk41/go-gin-htmx/models"
+	"github.com/Zenk41/go-gin-htmx/search"
+	"github.com/Zenk41/go-gin-htmx/views/components"
+	view_search "github.com/Zenk41/go-gin-htmx/views/search"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// liveResults is how many tasks the dropdown under the search box shows
+	liveResults = 8
+	// pageResults is how many tasks the results page shows
+	pageResults = 50
+)
+
+type SearchHandler interface {
+	Page(ctx *gin.Context)
+	Live(ctx *gin.Context)
+}
+
+type searchHandler struct {
+	taskRepo     models.TaskRepository
+	userRepo     models.UserRepository
+	index        search.Index
+	firebaseAuth *auth.Client
+}
+
+func NewSearchHandler(taskRepo models.TaskRepository,
+	userRepo models.UserRepository,
+	index search.Index,
+	firebaseAuth *auth.Client) SearchHandler {
+	return &searchHandler{
+		taskRepo:     taskRepo,
+		userRepo:     userRepo,
+		index:        index,
+		firebaseAuth: firebaseAuth,
+	}
+}
+
+// Page renders the results of ?q=
+func (sh *searchHandler) Page(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, sh.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	user, err := sh.userRepo.GetUser(ctx, userId)
+	if err != nil {
+		Render(ctx, view_search.Index(models.User{}, "", nil, components.Alert("error", "Failed to get user")))
+		return
+	}
+
+	query := strings.TrimSpace(ctx.Query("q"))
+	q, err := search.Parse(query)
+	if err != nil {
+		Render(ctx, view_search.Index(*user, query, nil, components.Alert("error", err.Error())))
+		return
+	}
+	tasks, err := sh.find(ctx, userId, q, pageResults)
+	if err != nil {
+		Render(ctx, view_search.Index(*user, query, nil, components.Alert("error", "Search failed")))
+		return
+	}
+	Render(ctx, view_search.Index(*user, query, tasks, nil))
+}
+
+// Live renders the dropdown under the search box as the user types. The
+// last word is matched as a prefix unless it has been finished with a space.
+func (sh *searchHandler) Live(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, sh.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	raw := ctx.Query("q")
+	query := strings.TrimSpace(raw)
+	if query == "" {
+		Render(ctx, view_search.Live("", nil, ""))
+		return
+	}
+	q, err := search.Parse(query)
+	if err != nil {
+		Render(ctx, view_search.Live(query, nil, err.Error()))
+		return
+	}
+	if !strings.HasSuffix(raw, " ") {
+		q.PrefixLast()
+	}
+	tasks, err := sh.find(ctx, userId, q, liveResults)
+	if err != nil {
+		Render(ctx, view_search.Live(query, nil, "Search failed"))
+		return
+	}
+	Render(ctx, view_search.Live(query, tasks, ""))
+}
+
+// find loads the tasks the index returns for q. Tasks that are gone are
+// dropped from the index, which only happens if a write was missed.
+func (sh *searchHandler) find(ctx *gin.Context, userId string, q search.Query, limit int) ([]models.Task, error) {
+	if q.Empty() {
+		return nil, nil
+	}
+	hits, err := sh.index.Search(ctx, userId, q, limit)
+	if err != nil {
+		logging.FromContext(ctx.Request.Context()).WithError(err).Error("Failed to search tasks")
+		return nil, err
+	}
+
+	tasks := make([]models.Task, 0, len(hits))
+	for _, hit := range hits {
+		task, err := sh.taskRepo.GetTaskById(ctx, userId, hit.TaskID)
+		switch {
+		case errors.Is(err, models.ErrTaskNotFound):
+			if err := sh.index.Delete(ctx, hit.TaskID); err != nil {
+				logging.FromContext(ctx.Request.Context()).WithError(err).WithField("task_id", hit.TaskID).Error("Failed to remove task from the search index")
+			}
+			continue
+		case err != nil:
+			logging.FromContext(ctx.Request.Context()).WithError(err).WithField("task_id", hit.TaskID).Error("Failed to get task")
+			return nil, err
+		}
+		tasks = append(tasks, *task)
+	}
+	return tasks, nil
+}
@@ -2,11 +2,17 @@ package handlers
 
 import (
 	"errors"
+	"strings"
 
 	"firebase.google.com/go/auth"
+	"github.com/Zenk41/go-gin-htmx/logging"
+	"github.com/Zenk41/go-gin-htmx/telemetry"
 	"github.com/a-h/templ"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // HTTPHandler is a type for handlers that return an error
@@ -16,14 +22,35 @@ type HTTPHandler func(c *gin.Context) error
 func Make(h HTTPHandler) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if err := h(c); err != nil {
-			logrus.Error("HTTP handle error", "err", err, "path", c.Request.URL.Path)
+			logging.FromContext(c.Request.Context()).WithFields(logrus.Fields{
+				"path":            c.Request.URL.Path,
+				logging.UserIDKey: c.GetString(logging.UserIDKey),
+			}).WithError(err).Error("HTTP handle error")
 		}
 	}
 }
 
 // Render renders a templ.Component using the gin.Context
 func Render(c *gin.Context, t templ.Component) error {
-	return t.Render(c.Request.Context(), c.Writer)
+	ctx, span := telemetry.Tracer().Start(c.Request.Context(), "templ.Render",
+		trace.WithAttributes(attribute.String("http.route", c.FullPath())))
+	defer span.End()
+
+	err := t.Render(ctx, c.Writer)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// RenderString renders a templ.Component to a string, e.g. to publish it as an event
+func RenderString(c *gin.Context, t templ.Component) (string, error) {
+	var buf strings.Builder
+	if err := t.Render(c.Request.Context(), &buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
 }
 
 // CookieAuth retrieves the UID from the cookie and verifies it with Firebase Auth
@@ -44,5 +71,6 @@ func CookieAuth(ctx *gin.Context, auth *auth.Client) (string, error) {
 		return "", errors.New("token verification failed: " + err.Error())
 	}
 
+	ctx.Set(logging.UserIDKey, token.UID)
 	return token.UID, nil
 }
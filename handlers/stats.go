@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+
+	"firebase.google.com/go/auth"
+	"github.com/Zenk41/go-gin-htmx/logging"
+	"github.com/Zenk41/go-gin-htmx/models"
+	"github.com/Zenk41/go-gin-htmx/stats"
+	"github.com/Zenk41/go-gin-htmx/utils"
+	"github.com/Zenk41/go-gin-htmx/views/components"
+	view_stats "github.com/Zenk41/go-gin-htmx/views/stats"
+	"github.com/gin-gonic/gin"
+)
+
+type StatsHandler interface {
+	Page(ctx *gin.Context)
+}
+
+type statsHandler struct {
+	taskRepo     models.TaskRepository
+	userRepo     models.UserRepository
+	firebaseAuth *auth.Client
+}
+
+func NewStatsHandler(taskRepo models.TaskRepository,
+	userRepo models.UserRepository,
+	firebaseAuth *auth.Client) StatsHandler {
+	return &statsHandler{
+		taskRepo:     taskRepo,
+		userRepo:     userRepo,
+		firebaseAuth: firebaseAuth,
+	}
+}
+
+// Page renders the statistics of the year up to the user's today
+func (sh *statsHandler) Page(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, sh.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	user, err := sh.userRepo.GetUser(ctx, userId)
+	if err != nil {
+		Render(ctx, view_stats.Index(models.User{}, stats.Summary{}, components.Alert("error", "Failed to get user")))
+		return
+	}
+
+	summary, err := stats.Load(ctx, sh.taskRepo, userId, utils.GetTodayDate(user.Location()))
+	if err != nil {
+		logging.FromContext(ctx.Request.Context()).WithError(err).Error("Failed to compute statistics")
+		Render(ctx, view_stats.Index(*user, stats.Summary{}, components.Alert("error", "Failed to compute statistics")))
+		return
+	}
+	Render(ctx, view_stats.Index(*user, summary, nil))
+}
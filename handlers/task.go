@@ -2,38 +2,192 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"cloud.google.com/go/civil"
 	"firebase.google.com/go/auth"
+	"github.com/Zenk41/go-gin-htmx/ical"
+	"github.com/Zenk41/go-gin-htmx/logging"
 	"github.com/Zenk41/go-gin-htmx/models"
+	"github.com/Zenk41/go-gin-htmx/pubsub"
+	"github.com/Zenk41/go-gin-htmx/quickadd"
+	"github.com/Zenk41/go-gin-htmx/utils"
 	"github.com/Zenk41/go-gin-htmx/views/components"
 	"github.com/Zenk41/go-gin-htmx/views/home"
+	view_task "github.com/Zenk41/go-gin-htmx/views/task"
 	"github.com/gin-gonic/gin"
 )
 
 type TaskHandler interface {
 	CreateNewTask(ctx *gin.Context)
 	GetTasksByDate(ctx *gin.Context)
+	MoreTasks(ctx *gin.Context)
+	ListTasks(ctx *gin.Context)
 	DeleteTaskById(ctx *gin.Context)
 	DoneTaskById(ctx *gin.Context)
 	DoneAllTaskDayByDate(ctx *gin.Context)
 	EditTaskById(ctx *gin.Context)
 	EditTaskModal(ctx *gin.Context)
 	DeleteTaskModal(ctx *gin.Context)
+	QuickAddPreview(ctx *gin.Context)
+	TaskPage(ctx *gin.Context)
+	TaskHistory(ctx *gin.Context)
+}
+
+// ReminderScheduler keeps pending reminders in step with task changes
+type ReminderScheduler interface {
+	Reschedule(ctx context.Context, task models.Task, loc *time.Location) error
+	Cancel(ctx context.Context, taskID string) error
+}
+
+// TaskEventEmitter forwards task changes to the user's webhooks
+type TaskEventEmitter interface {
+	EmitTaskEvent(ctx context.Context, userID string, event string, task models.Task) error
 }
 
 type taskHandler struct {
 	taskRepo     models.TaskRepository
 	userRepo     models.UserRepository
 	firebaseAuth *auth.Client
+	reminders    ReminderScheduler
+	broker       pubsub.Broker
+	events       TaskEventEmitter
+	historyRepo  models.HistoryRepository
 }
 
-func NewTaskHandler(taskRepo models.TaskRepository, userRepo models.UserRepository, firebaseAuth *auth.Client) TaskHandler {
+func NewTaskHandler(taskRepo models.TaskRepository,
+	userRepo models.UserRepository,
+	firebaseAuth *auth.Client,
+	reminders ReminderScheduler,
+	broker pubsub.Broker,
+	events TaskEventEmitter,
+	historyRepo models.HistoryRepository) TaskHandler {
 	return &taskHandler{
 		taskRepo:     taskRepo,
 		userRepo:     userRepo,
 		firebaseAuth: firebaseAuth,
+		reminders:    reminders,
+		broker:       broker,
+		events:       events,
+		historyRepo:  historyRepo,
+	}
+}
+
+// parseSchedule reads the due time and reminder fields shared by the create and edit forms
+func parseSchedule(ctx *gin.Context) (string, []models.Reminder, error) {
+	dueTime := ctx.PostForm("due-time")
+	if dueTime != "" {
+		if _, err := time.Parse("15:04", dueTime); err != nil {
+			return "", nil, errors.New("invalid due time")
+		}
+	}
+
+	var reminders []models.Reminder
+	for _, before := range ctx.PostFormArray("remind-before") {
+		minutes, err := strconv.Atoi(before)
+		if err != nil || minutes < 0 {
+			return "", nil, errors.New("invalid reminder")
+		}
+		if dueTime == "" {
+			return "", nil, errors.New("reminders before the due time need a due time")
+		}
+		reminders = append(reminders, models.Reminder{MinutesBefore: minutes})
+	}
+	if at := ctx.PostForm("remind-at"); at != "" {
+		if _, err := time.Parse("15:04", at); err != nil {
+			return "", nil, errors.New("invalid reminder time")
+		}
+		reminders = append(reminders, models.Reminder{At: at})
+	}
+	return dueTime, reminders, nil
+}
+
+// parseRecurrence reads the repeat field of the task forms, an RRULE value
+func parseRecurrence(ctx *gin.Context) (string, error) {
+	rule := strings.TrimSpace(ctx.PostForm("recurrence"))
+	if rule != "" && !ical.ValidRecurrence(rule) {
+		return "", errors.New("invalid repeat rule")
+	}
+	return rule, nil
+}
+
+// parseTags reads the comma separated tags field of the task forms
+func parseTags(ctx *gin.Context) []string {
+	return models.NormalizeTags(strings.Split(ctx.PostForm("tags"), ","))
+}
+
+// reschedule refreshes a task's reminders; failures are logged rather than
+// failing the request because the task itself was saved
+func (th *taskHandler) reschedule(ctx *gin.Context, task models.Task, user *models.User) {
+	if err := th.reminders.Reschedule(ctx, task, user.Location()); err != nil {
+		logging.FromContext(ctx.Request.Context()).WithError(err).WithField("task_id", task.TaskID).Error("Failed to schedule reminders")
+	}
+}
+
+// cancelReminders drops a task's pending reminders
+func (th *taskHandler) cancelReminders(ctx *gin.Context, taskID string) {
+	if err := th.reminders.Cancel(ctx, taskID); err != nil {
+		logging.FromContext(ctx.Request.Context()).WithError(err).WithField("task_id", taskID).Error("Failed to cancel reminders")
+	}
+}
+
+// emit forwards a task event to the user's webhooks; failures are logged
+// rather than failing the request because the task itself was saved
+func (th *taskHandler) emit(ctx *gin.Context, userId string, event string, task models.Task) {
+	if err := th.events.EmitTaskEvent(ctx, userId, event, task); err != nil {
+		logging.FromContext(ctx.Request.Context()).WithError(err).WithField("task_id", task.TaskID).Error("Failed to queue webhook event")
+	}
+}
+
+// publishTask sends the re-rendered card of a changed task to the user's
+// open pages, which swap it in place of the card with the same ID
+func (th *taskHandler) publishTask(ctx *gin.Context, userId string, kind string, task models.Task) {
+	publishTask(ctx, th.broker, userId, kind, task)
+}
+
+// publishDay sends the re-rendered task list of a day to the user's open
+// pages that show that day, for changes that add or remove cards
+func (th *taskHandler) publishDay(ctx *gin.Context, userId string, kind string, date civil.Date, page models.TaskPage) {
+	publishDay(ctx, th.broker, userId, kind, date, page)
+}
+
+func publishDay(ctx *gin.Context, broker pubsub.Broker, userId string, kind string, date civil.Date, page models.TaskPage) {
+	list, err := RenderString(ctx, components.TasksPage(date.String(), page, nil))
+	if err != nil {
+		logging.FromContext(ctx.Request.Context()).WithError(err).Error("Failed to render task event")
+		return
+	}
+	broker.Publish(pubsub.UserTopic(userId), pubsub.Event{Name: components.TaskEvent(kind, date.String()), Data: list})
+}
+
+func publishTask(ctx *gin.Context, broker pubsub.Broker, userId string, kind string, task models.Task) {
+	card, err := RenderString(ctx, components.Task(task, nil))
+	if err != nil {
+		logging.FromContext(ctx.Request.Context()).WithError(err).Error("Failed to render task event")
+		return
+	}
+	broker.Publish(pubsub.UserTopic(userId), pubsub.Event{Name: components.TaskEvent(kind, task.TaskID), Data: card})
+}
+
+// publishCreated sends the task lists of the days tasks were created on,
+// for handlers that create tasks away from the day's page
+func publishCreated(ctx *gin.Context, broker pubsub.Broker, taskRepo models.TaskRepository, userId string, days map[civil.Date]bool) {
+	publishDays(ctx, broker, taskRepo, userId, "task-created", days)
+}
+
+// publishDays reads and sends the task lists of days changed by kind
+func publishDays(ctx *gin.Context, broker pubsub.Broker, taskRepo models.TaskRepository, userId string, kind string, days map[civil.Date]bool) {
+	for date := range days {
+		page, err := taskRepo.GetTaskPageByDate(ctx, userId, date, models.PageRequest{})
+		if err != nil {
+			logging.FromContext(ctx.Request.Context()).WithError(err).Error("Failed to list tasks for the task event")
+			continue
+		}
+		publishDay(ctx, broker, userId, kind, date, page)
 	}
 }
 
@@ -54,107 +208,250 @@ func (th *taskHandler) CreateNewTask(ctx *gin.Context) {
 		return
 	}
 
-	date, err := time.Parse("2006-01-02", dateStr)
+	date, err := civil.ParseDate(dateStr)
 	if err != nil {
 		ctx.Redirect(http.StatusFound, "/")
 		return
 	}
-	task.Date = date
+	task.Date = models.StoredDate(date)
+	task.Tags = parseTags(ctx)
+
+	task.DueTime, task.Reminders, err = parseSchedule(ctx)
+	if err == nil {
+		task.Recurrence, err = parseRecurrence(ctx)
+	}
+	if quick := strings.TrimSpace(ctx.PostForm("quick")); quick != "" && err == nil {
+		err = th.applyQuickAdd(ctx, userId, quick, &task)
+		date = task.Day()
+		dateStr = date.String()
+	}
+	if err != nil {
+		Render(ctx, home.Index(models.User{}, components.Alert("error", err.Error()), dateStr, components.Tasks(dateStr, []models.Task{}, nil)))
+		return
+	}
 
-	task.TaskID = "task" + time.Now().Format("20060102150405")
 	task.CreatedAt = time.Now()
 	task.UpdatedAt = time.Now()
 
 	task.UserID = userId
 
-	if err := th.taskRepo.CreateTask(context.Background(), task); err != nil {
-		Render(ctx, home.Index(models.User{}, components.Alert("error", err.Error()), dateStr, components.Tasks([]models.Task{}, nil)))
+	// A double submit or a retry sends the same key and gets the first task
+	created, err := th.taskRepo.CreateTask(ctx, task, idempotencyKey(ctx))
+	replayed := errors.Is(err, models.ErrTaskAlreadyCreated)
+	if err != nil && !replayed {
+		Render(ctx, home.Index(models.User{}, components.Alert("error", err.Error()), dateStr, components.Tasks(dateStr, []models.Task{}, nil)))
 		return
 	}
+	date = created.Day()
+	dateStr = date.String()
 
-	user, err := th.userRepo.GetUser(context.Background(), userId)
+	user, err := th.userRepo.GetUser(ctx, userId)
 	if err != nil {
-		Render(ctx, home.Index(models.User{}, components.Alert("error", err.Error()), dateStr, components.Tasks([]models.Task{}, nil)))
+		Render(ctx, home.Index(models.User{}, components.Alert("error", err.Error()), dateStr, components.Tasks(dateStr, []models.Task{}, nil)))
 		return
 	}
+	message := "task has already been created"
+	if !replayed {
+		th.reschedule(ctx, *created, user)
+		th.emit(ctx, userId, models.EventTaskCreated, *created)
+		message = "new task has been created"
+	}
 
-	tasks, err := th.taskRepo.GetTasksByDate(ctx, userId, date)
+	page, err := th.taskRepo.GetTaskPageByDate(ctx, userId, date, models.PageRequest{})
 	if err != nil {
-		Render(ctx, home.Index(models.User{}, components.Alert("error", err.Error()), dateStr, components.Tasks([]models.Task{}, nil)))
+		Render(ctx, home.Index(models.User{}, components.Alert("error", err.Error()), dateStr, components.Tasks(dateStr, []models.Task{}, nil)))
 		return
 	}
+	if !replayed {
+		th.publishDay(ctx, userId, "task-created", date, page)
+	}
 
-	Render(ctx, home.Index(*user, components.Alert("success", "new task has been created"), dateStr, components.Tasks(*tasks, nil)))
+	Render(ctx, home.Index(*user, components.Alert("success", message), dateStr, components.TasksPage(dateStr, page, nil)))
 }
 
-// DeleteTaskById handles deleting a task by its ID
-func (th *taskHandler) DeleteTaskById(ctx *gin.Context) {
-	userId, errC := CookieAuth(ctx, th.firebaseAuth)
-	if errC != nil || userId == "" {
-		ctx.Redirect(http.StatusUnauthorized, "/login")
+// idempotencyKey returns the key a create request is sent with, from the
+// Idempotency-Key header of API clients or the field the create forms get
+// when they are rendered
+func idempotencyKey(ctx *gin.Context) string {
+	if key := strings.TrimSpace(ctx.GetHeader("Idempotency-Key")); key != "" {
+		return key
+	}
+	return ctx.PostForm("idempotency_key")
+}
+
+// applyQuickAdd fills a task from a quick-add line, read against the
+// user's today. The day picked on the page is kept when the line names none.
+func (th *taskHandler) applyQuickAdd(ctx *gin.Context, userId string, line string, task *models.TaskPayload) error {
+	user, err := th.userRepo.GetUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	quick := quickadd.Parse(line, utils.GetTodayDate(user.Location()))
+	if quick.Title == "" {
+		return errors.New("a quick-add line needs a title")
+	}
+	task.Title = quick.Title
+	if !quick.Date.IsZero() {
+		task.Date = models.StoredDate(quick.Date)
+	}
+	task.DueTime = quick.DueTime
+	task.Recurrence = quick.Recurrence
+	task.Tags = models.NormalizeTags(quick.AllTags())
+	return nil
+}
+
+// QuickAddPreview renders the fields a quick-add line describes as it is typed
+func (th *taskHandler) QuickAddPreview(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, th.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	line := strings.TrimSpace(ctx.PostForm("quick"))
+	if line == "" {
+		Render(ctx, components.QuickAddPreview(quickadd.Task{}, false))
+		return
+	}
+	user, err := th.userRepo.GetUser(ctx, userId)
+	if err != nil {
+		logging.FromContext(ctx.Request.Context()).WithError(err).Error("Failed to get user")
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	Render(ctx, components.QuickAddPreview(quickadd.Parse(line, utils.GetTodayDate(user.Location())), true))
+}
+
+// TaskPage shows a task with the history of its changes. Tasks in the
+// trash are shown too, so their history stays reachable until the purge.
+func (th *taskHandler) TaskPage(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, th.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	user, err := th.userRepo.GetUser(ctx, userId)
+	if err != nil {
+		Render(ctx, view_task.Index(models.User{}, nil, models.TaskChangePage{}, components.Alert("error", "Failed to get user")))
 		return
 	}
+
 	taskID := ctx.Param("id")
-	task, err := th.taskRepo.GetTaskById(ctx, taskID)
+	task, err := th.taskRepo.GetTaskById(ctx, userId, taskID)
+	if errors.Is(err, models.ErrTaskNotFound) {
+		task, err = th.taskRepo.GetTrashedTaskById(ctx, userId, taskID)
+	}
 	if err != nil {
-		ctx.Redirect(http.StatusFound, "/")
+		if !errors.Is(err, models.ErrTaskNotFound) {
+			logging.FromContext(ctx.Request.Context()).WithError(err).WithField("task_id", taskID).Error("Failed to get task")
+		}
+		ctx.Status(http.StatusNotFound)
+		Render(ctx, view_task.Index(*user, nil, models.TaskChangePage{}, components.Alert("error", "Task not found")))
+		return
+	}
+
+	changes, err := th.historyRepo.GetTaskHistory(ctx, userId, taskID, models.PageRequest{})
+	if err != nil {
+		logging.FromContext(ctx.Request.Context()).WithError(err).WithField("task_id", taskID).Error("Failed to get task history")
+		Render(ctx, view_task.Index(*user, task, models.TaskChangePage{}, components.Alert("error", "Failed to get the history of the task")))
 		return
 	}
+	Render(ctx, view_task.Index(*user, task, changes, nil))
+}
 
-	if userId != task.UserID {
-		Render(ctx, components.Task(*task, components.Alert("error", "error : You are not the owner of this task")))
+// TaskHistory renders the page of a task's history after the cursor, for
+// the placeholder at the end of the timeline
+func (th *taskHandler) TaskHistory(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, th.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
 
-	if err := th.taskRepo.DeleteTaskById(context.Background(), taskID); err != nil {
-		th.GetTasksByDate(ctx)
+	user, err := th.userRepo.GetUser(ctx, userId)
+	if err != nil {
+		Render(ctx, components.Alert("error", "Failed to get user"))
 		return
 	}
 
-	tasks, err := th.taskRepo.GetTasksByDate(ctx, userId, task.Date)
+	taskID := ctx.Param("id")
+	changes, err := th.historyRepo.GetTaskHistory(ctx, userId, taskID, models.PageRequest{Cursor: ctx.Query("cursor")})
 	if err != nil {
-		Render(ctx, components.Tasks([]models.Task{}, components.Alert("error", "error : Failed to get tasks")))
+		if !errors.Is(err, models.ErrInvalidCursor) {
+			logging.FromContext(ctx.Request.Context()).WithError(err).WithField("task_id", taskID).Error("Failed to get task history")
+		}
+		Render(ctx, components.Alert("error", "Failed to get more of the history"))
 		return
 	}
-	Render(ctx, components.Tasks(*tasks, components.Alert("success", "Task deleted successfully")))
+	Render(ctx, view_task.History(*user, taskID, changes, true))
 }
 
-func (th *taskHandler) DoneTaskById(ctx *gin.Context) {
+// DeleteTaskById handles deleting a task by its ID
+func (th *taskHandler) DeleteTaskById(ctx *gin.Context) {
 	userId, errC := CookieAuth(ctx, th.firebaseAuth)
 	if errC != nil || userId == "" {
 		ctx.Redirect(http.StatusUnauthorized, "/login")
 		return
 	}
+	taskID := ctx.Param("id")
+	task, err := th.taskRepo.DeleteTaskById(ctx, userId, taskID)
+	if errors.Is(err, models.ErrTaskNotFound) {
+		taskNotFound(ctx)
+		return
+	}
+	if err != nil {
+		th.GetTasksByDate(ctx)
+		return
+	}
+	th.cancelReminders(ctx, taskID)
+	th.emit(ctx, userId, models.EventTaskDeleted, *task)
 
-	task, err := th.taskRepo.GetTaskById(ctx, ctx.Param("id"))
+	page, err := th.taskRepo.GetTaskPageByDate(ctx, userId, task.Day(), models.PageRequest{})
 	if err != nil {
-		ctx.Redirect(http.StatusUnauthorized, "/login")
+		Render(ctx, components.Tasks(task.Day().String(), []models.Task{}, components.Alert("error", "error : Failed to get tasks")))
 		return
 	}
+	th.publishDay(ctx, userId, "task-deleted", task.Day(), page)
+	Render(ctx, components.TasksPage(task.Day().String(), page, components.UndoAlert("Task moved to the trash", "/trash/"+taskID+"/undo")))
+}
 
-	if userId != task.UserID {
-		th.GetTasksByDate(ctx)
+func (th *taskHandler) DoneTaskById(ctx *gin.Context) {
+	userId, errC := CookieAuth(ctx, th.firebaseAuth)
+	if errC != nil || userId == "" {
+		ctx.Redirect(http.StatusUnauthorized, "/login")
 		return
 	}
 
-	err = th.taskRepo.DoneTaskById(ctx, userId, task.TaskID)
+	task, changes, err := th.taskRepo.DoneTaskById(ctx, userId, ctx.Param("id"))
+	if errors.Is(err, models.ErrTaskNotFound) {
+		taskNotFound(ctx)
+		return
+	}
 	if err != nil {
 		th.GetTasksByDate(ctx)
 		return
 	}
-	date, err := time.Parse("2006-01-02",ctx.Query("date"))
+	// A task already done was left as it is and has nothing to announce
+	if len(changes) > 0 {
+		th.cancelReminders(ctx, task.TaskID)
+		th.publishTask(ctx, userId, "task-completed", *task)
+		th.emit(ctx, userId, models.EventTaskCompleted, *task)
+	}
+
+	date, err := civil.ParseDate(ctx.Query("date"))
 	if err != nil {
 		th.GetTasksByDate(ctx)
 		ctx.JSON(http.StatusBadRequest,err.Error())
 		return
 	}
 
-	tasks, err := th.taskRepo.GetTasksByDate(ctx, userId, date)
+	page, err := th.taskRepo.GetTaskPageByDate(ctx, userId, date, models.PageRequest{})
 	if err != nil {
 		th.GetTasksByDate(ctx)
 		return
 	}
-	Render(ctx, components.Tasks(*tasks, components.Alert("success", "task by date")))
+	Render(ctx, components.TasksPage(date.String(), page, components.Alert("success", "task by date")))
 }
 
 // DoneAllTaskDayByDate marks all tasks for a specific day as done
@@ -165,7 +462,7 @@ func (th *taskHandler) DoneAllTaskDayByDate(ctx *gin.Context) {
 		return
 	}
 	dateStr := ctx.PostForm("date-task")
-	date, err := time.Parse("2006-01-02", dateStr)
+	date, err := civil.ParseDate(dateStr)
 	if err != nil {
 		th.GetTasksByDate(ctx)
 		return
@@ -174,7 +471,7 @@ func (th *taskHandler) DoneAllTaskDayByDate(ctx *gin.Context) {
 	firebaseCookie, err := ctx.Cookie("firebase_token")
 	if err != nil || firebaseCookie == "" {
 		// If there's no cookie, we still render the page with a logged-out state
-		Render(ctx, components.Tasks([]models.Task{}, components.Alert("error", "error: "+err.Error())))
+		Render(ctx, components.Tasks("", []models.Task{}, components.Alert("error", "error: "+err.Error())))
 		return
 	}
 
@@ -182,22 +479,27 @@ func (th *taskHandler) DoneAllTaskDayByDate(ctx *gin.Context) {
 	token, err := th.firebaseAuth.VerifyIDToken(ctx, firebaseCookie)
 	if err != nil {
 		// Render the page with a logged-out state if the token verification fails
-		Render(ctx, components.Tasks([]models.Task{}, components.Alert("error", "error: "+err.Error())))
+		Render(ctx, components.Tasks("", []models.Task{}, components.Alert("error", "error: "+err.Error())))
 		return
 	}
 
-	if err := th.taskRepo.DoneAllTaskDayByDate(context.Background(), token.UID, date); err != nil {
-		Render(ctx, components.Tasks([]models.Task{}, components.Alert("error", "error: Failed to mark tasks as done")))
+	changed, err := th.taskRepo.DoneAllTaskDayByDate(ctx, token.UID, date)
+	if err != nil {
+		Render(ctx, components.Tasks(dateStr, []models.Task{}, components.Alert("error", "error: Failed to mark tasks as done")))
 		return
 	}
+	for _, c := range changed {
+		th.emit(ctx, token.UID, models.EventTaskCompleted, c.Task)
+	}
 
-	tasks, err := th.taskRepo.GetTasksByDate(ctx, token.UID, date)
+	page, err := th.taskRepo.GetTaskPageByDate(ctx, token.UID, date, models.PageRequest{})
 	if err != nil {
-		Render(ctx, components.Tasks([]models.Task{}, components.Alert("error", "error : Failed to get tasks")))
+		Render(ctx, components.Tasks(dateStr, []models.Task{}, components.Alert("error", "error : Failed to get tasks")))
 		return
 	}
 
-	Render(ctx, components.Tasks(*tasks, components.Alert("success", "success done all task")))
+	th.publishDay(ctx, token.UID, "task-completed-all", date, page)
+	Render(ctx, components.TasksPage(dateStr, page, components.Alert("success", "success done all task")))
 }
 
 // EditTaskById handles editing a task by its ID
@@ -208,53 +510,237 @@ func (th *taskHandler) EditTaskById(ctx *gin.Context) {
 		return
 	}
 
-	task, err := th.taskRepo.GetTaskById(ctx, ctx.Query("id-task"))
+	task, err := th.taskRepo.GetTaskById(ctx, userId, ctx.Query("id-task"))
+	if errors.Is(err, models.ErrTaskNotFound) {
+		taskNotFound(ctx)
+		return
+	}
 	if err != nil {
-		ctx.Redirect(http.StatusUnauthorized, "/login")
+		logging.FromContext(ctx.Request.Context()).WithError(err).Error("Failed to get task")
+		taskNotFound(ctx)
 		return
 	}
 
-	if userId != task.UserID {
-		Render(ctx, components.Task(*task, components.Alert("error", "error : You are not the owner of this task")))
+	version, ok := editVersion(ctx)
+	if !ok {
+		apiStatus(ctx, http.StatusPreconditionRequired)
+		Render(ctx, components.Task(*task, components.Alert("error", "error : Reload the page before editing the task")))
 		return
 	}
 
-	taskPayload := models.TaskPayload{
-		TaskID:      task.TaskID,
-		UserID:      task.UserID,
-		Title:       task.Title,
-		Description: task.Description,
-		Status:      task.Status,
-		Date:        task.Date,
-		CreatedAt:   task.CreatedAt,
-		UpdatedAt:   task.UpdatedAt,
-	}
+	taskPayload := models.TaskPayload(*task)
+	taskPayload.Version = version
 	taskPayload.Title = ctx.PostForm("title")
 	taskPayload.Description = ctx.PostForm("description")
+	taskPayload.Tags = parseTags(ctx)
+	taskPayload.DueTime, taskPayload.Reminders, err = parseSchedule(ctx)
+	if err == nil {
+		taskPayload.Recurrence, err = parseRecurrence(ctx)
+	}
+	if err != nil {
+		Render(ctx, components.Task(*task, components.Alert("error", "error : "+err.Error())))
+		return
+	}
 	taskPayload.UpdatedAt = time.Now()
 
-	if err := th.taskRepo.EditTaskById(ctx, taskPayload); err != nil {
+	_, err = th.taskRepo.EditTaskById(ctx, taskPayload)
+	if errors.Is(err, models.ErrVersionConflict) {
+		th.editConflict(ctx, models.Task(taskPayload))
+		return
+	}
+	if errors.Is(err, models.ErrTaskNotFound) {
+		// Deleted since it was read
+		taskNotFound(ctx)
+		return
+	}
+	if err != nil {
 		Render(ctx, components.Task(*task, components.Alert("error", "error : "+err.Error())))
 		return
 	}
-	Render(ctx, components.Task(*task, components.Alert("success", "success : Task edited successfully")))
+	taskPayload.Version++
+	ctx.Header("ETag", versionTag(taskPayload.Version))
+
+	if user, err := th.userRepo.GetUser(ctx, userId); err == nil {
+		th.reschedule(ctx, models.Task(taskPayload), user)
+	}
+	th.publishTask(ctx, userId, "task-updated", models.Task(taskPayload))
+	th.emit(ctx, userId, models.EventTaskUpdated, models.Task(taskPayload))
+	Render(ctx, components.Task(models.Task(taskPayload), components.Alert("success", "success : Task edited successfully")))
+}
+
+// editVersion reads the version of the task an edit is based on, from an
+// If-Match header for API clients or the version field of the edit form
+func editVersion(ctx *gin.Context) (int64, bool) {
+	raw := ctx.PostForm("version")
+	if match := ctx.GetHeader("If-Match"); match != "" {
+		raw = strings.Trim(strings.TrimPrefix(match, "W/"), `"`)
+	}
+	version, err := strconv.ParseInt(raw, 10, 64)
+	return version, err == nil && version >= 0
+}
+
+// taskNotFound answers a request for a task that does not exist or is not
+// the user's. htmx requests get the alert added to the page instead of
+// replacing their target.
+func taskNotFound(ctx *gin.Context) {
+	apiStatus(ctx, http.StatusNotFound)
+	ctx.Header("HX-Retarget", "body")
+	ctx.Header("HX-Reswap", "beforeend")
+	Render(ctx, components.Alert("error", "error : Task not found"))
+}
+
+// apiStatus sets the status of an error response for API clients. htmx does
+// not swap error responses, so its requests keep 200 to show the error.
+func apiStatus(ctx *gin.Context, code int) {
+	if ctx.GetHeader("HX-Request") == "" {
+		ctx.Status(code)
+	}
+}
+
+// versionTag formats a task version as an entity tag
+func versionTag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// editConflict answers an edit based on an outdated version with the saved
+// task in place of the card and a modal comparing it with the edit. API
+// clients get 409 Conflict.
+func (th *taskHandler) editConflict(ctx *gin.Context, mine models.Task) {
+	saved, err := th.taskRepo.GetTaskById(ctx, mine.UserID, mine.TaskID)
+	if err != nil {
+		logging.FromContext(ctx.Request.Context()).WithError(err).WithField("task_id", mine.TaskID).Error("Failed to get task")
+		Render(ctx, components.Task(mine, components.Alert("error", "error : The task was changed elsewhere, reload the page")))
+		return
+	}
+	ctx.Header("ETag", versionTag(saved.Version))
+	apiStatus(ctx, http.StatusConflict)
+	Render(ctx, components.Task(*saved, nil))
+	Render(ctx, components.ModalConflict(mine, *saved))
 }
 
 func (th *taskHandler) GetTasksByDate(ctx *gin.Context) {
 	dateStr := ctx.PostForm("date-task")
-	date, _ := time.Parse("2006-01-02", dateStr)
 	userId, errC := CookieAuth(ctx, th.firebaseAuth)
 	if errC != nil || userId == "" {
 		ctx.Redirect(http.StatusUnauthorized, "/login")
 		return
 	}
-	
-	tasks, err := th.taskRepo.GetTasksByDate(ctx, userId, date)
+
+	date, err := civil.ParseDate(dateStr)
+	if err != nil {
+		Render(ctx, components.Tasks("", []models.Task{}, components.Alert("error", "error : Invalid date")))
+		return
+	}
+
+	page, err := th.taskRepo.GetTaskPageByDate(ctx, userId, date, models.PageRequest{})
+	if err != nil {
+		Render(ctx, components.Tasks(dateStr, []models.Task{}, components.Alert("error", "error : Failed to get tasks")))
+		return
+	}
+	Render(ctx, components.TasksPage(dateStr, page, components.Alert("success", "task by date")))
+}
+
+// MoreTasks renders the next page of the tasks of a day, which the task
+// list asks for when it is scrolled to its end
+func (th *taskHandler) MoreTasks(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, th.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	date, err := civil.ParseDate(ctx.Query("date"))
+	if err != nil {
+		Render(ctx, components.Alert("error", "error : Invalid date"))
+		return
+	}
+	page, err := th.taskRepo.GetTaskPageByDate(ctx, userId, date, models.PageRequest{Cursor: ctx.Query("cursor")})
+	if err != nil {
+		if !errors.Is(err, models.ErrInvalidCursor) {
+			logging.FromContext(ctx.Request.Context()).WithError(err).Error("Failed to get tasks")
+		}
+		Render(ctx, components.Alert("error", "error : Failed to get more tasks"))
+		return
+	}
+	Render(ctx, components.MoreTasks(date.String(), page))
+}
+
+// apiTask is a task as the JSON API returns it
+type apiTask struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Status      string    `json:"status"`
+	Date        string    `json:"date"`
+	DueTime     string    `json:"due_time,omitempty"`
+	Recurrence  string    `json:"recurrence,omitempty"`
+	Tags        []string  `json:"tags"`
+	Version     int64     `json:"version"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func newAPITask(task models.Task) apiTask {
+	tags := task.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return apiTask{
+		ID:          task.TaskID,
+		Title:       task.Title,
+		Description: task.Description,
+		Status:      task.Status,
+		Date:        task.Day().String(),
+		DueTime:     task.DueTime,
+		Recurrence:  task.Recurrence,
+		Tags:        tags,
+		Version:     task.Version,
+		CreatedAt:   task.CreatedAt,
+		UpdatedAt:   task.UpdatedAt,
+	}
+}
+
+// ListTasks returns a page of the tasks of the day in the date query
+// parameter as JSON. The page holds up to limit tasks; next_cursor, empty
+// on the last page, is sent back as cursor to get the next one.
+func (th *taskHandler) ListTasks(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, th.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	date, err := civil.ParseDate(ctx.Query("date"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "date must be YYYY-MM-DD"})
+		return
+	}
+	request := models.PageRequest{Cursor: ctx.Query("cursor")}
+	if limit := ctx.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > models.MaxPageSize {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "limit must be from 1 to " + strconv.Itoa(models.MaxPageSize)})
+			return
+		}
+		request.Limit = n
+	}
+
+	page, err := th.taskRepo.GetTaskPageByDate(ctx, userId, date, request)
+	if errors.Is(err, models.ErrInvalidCursor) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
-		Render(ctx, components.Tasks([]models.Task{}, components.Alert("error", "error : Failed to get tasks")))
+		logging.FromContext(ctx.Request.Context()).WithError(err).Error("Failed to get tasks")
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get tasks"})
 		return
 	}
-	Render(ctx, components.Tasks(*tasks, components.Alert("success", "task by date")))
+
+	tasks := make([]apiTask, len(page.Tasks))
+	for i, task := range page.Tasks {
+		tasks[i] = newAPITask(task)
+	}
+	ctx.JSON(http.StatusOK, gin.H{"tasks": tasks, "next_cursor": page.NextCursor})
 }
 
 func (th *taskHandler) EditTaskModal(ctx *gin.Context) {
@@ -270,7 +756,12 @@ func (th *taskHandler) EditTaskModal(ctx *gin.Context) {
 		return
 	}
 
-	task, err := th.taskRepo.GetTaskById(ctx, idTask)
+	task, err := th.taskRepo.GetTaskById(ctx, userId, idTask)
+	if errors.Is(err, models.ErrTaskNotFound) {
+		apiStatus(ctx, http.StatusNotFound)
+		Render(ctx, components.ModalTaskError("error: task not found"))
+		return
+	}
 	if err != nil {
 		Render(ctx, components.ModalTaskError("error: "+err.Error()))
 		return
@@ -293,7 +784,12 @@ func (th *taskHandler) DeleteTaskModal(ctx *gin.Context) {
 		return
 	}
 
-	task, err := th.taskRepo.GetTaskById(ctx, idTask)
+	task, err := th.taskRepo.GetTaskById(ctx, userId, idTask)
+	if errors.Is(err, models.ErrTaskNotFound) {
+		apiStatus(ctx, http.StatusNotFound)
+		Render(ctx, components.ModalTaskError("error: task not found"))
+		return
+	}
 	if err != nil {
 		Render(ctx, components.ModalTaskError("error: "+err.Error()))
 		return
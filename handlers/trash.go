@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"firebase.google.com/go/auth"
+	"github.com/Zenk41/go-gin-htmx/logging"
+	"github.com/Zenk41/go-gin-htmx/models"
+	"github.com/Zenk41/go-gin-htmx/pubsub"
+	"github.com/Zenk41/go-gin-htmx/views/components"
+	view_trash "github.com/Zenk41/go-gin-htmx/views/trash"
+	"github.com/a-h/templ"
+	"github.com/gin-gonic/gin"
+)
+
+type TrashHandler interface {
+	Page(ctx *gin.Context)
+	Restore(ctx *gin.Context)
+	Undo(ctx *gin.Context)
+	Purge(ctx *gin.Context)
+	Empty(ctx *gin.Context)
+	More(ctx *gin.Context)
+}
+
+type trashHandler struct {
+	taskRepo     models.TaskRepository
+	userRepo     models.UserRepository
+	reminders    ReminderScheduler
+	broker       pubsub.Broker
+	events       TaskEventEmitter
+	firebaseAuth *auth.Client
+	retention    time.Duration
+}
+
+func NewTrashHandler(taskRepo models.TaskRepository,
+	userRepo models.UserRepository,
+	reminders ReminderScheduler,
+	broker pubsub.Broker,
+	events TaskEventEmitter,
+	firebaseAuth *auth.Client,
+	retention time.Duration) TrashHandler {
+	return &trashHandler{
+		taskRepo:     taskRepo,
+		userRepo:     userRepo,
+		reminders:    reminders,
+		broker:       broker,
+		events:       events,
+		firebaseAuth: firebaseAuth,
+		retention:    retention,
+	}
+}
+
+// errNotInTrash hides whether a task exists when it is not the user's
+var errNotInTrash = errors.New("This task is not in the trash")
+
+// Page lists the tasks in the trash
+func (th *trashHandler) Page(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, th.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	user, err := th.userRepo.GetUser(ctx, userId)
+	if err != nil {
+		Render(ctx, view_trash.Index(models.User{}, view_trash.List(models.TaskPage{}, th.retention, components.Alert("error", "Failed to get user"))))
+		return
+	}
+	Render(ctx, view_trash.Index(*user, th.list(ctx, userId, nil)))
+}
+
+// Restore moves a task back from the trash page
+func (th *trashHandler) Restore(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, th.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	if _, err := th.restore(ctx, userId, ctx.Param("id")); err != nil {
+		Render(ctx, th.list(ctx, userId, components.Alert("error", err.Error())))
+		return
+	}
+	Render(ctx, th.list(ctx, userId, components.Alert("success", "Task restored")))
+}
+
+// Undo restores a task right after it was deleted and answers with the
+// task list of its day
+func (th *trashHandler) Undo(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, th.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	task, err := th.restore(ctx, userId, ctx.Param("id"))
+	if err != nil {
+		// Show the alert without touching the task list
+		ctx.Header("HX-Retarget", "body")
+		ctx.Header("HX-Reswap", "beforeend")
+		Render(ctx, components.Alert("error", err.Error()))
+		return
+	}
+	page, err := th.taskRepo.GetTaskPageByDate(ctx, userId, task.Day(), models.PageRequest{})
+	if err != nil {
+		Render(ctx, components.Tasks(task.Day().String(), []models.Task{}, components.Alert("error", "error : Failed to get tasks")))
+		return
+	}
+	Render(ctx, components.TasksPage(task.Day().String(), page, components.Alert("success", "Task restored")))
+}
+
+// Purge deletes one task in the trash for good
+func (th *trashHandler) Purge(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, th.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	taskID := ctx.Param("id")
+	err = th.taskRepo.PurgeTaskById(ctx, userId, taskID)
+	if errors.Is(err, models.ErrTaskNotFound) {
+		Render(ctx, th.list(ctx, userId, components.Alert("error", errNotInTrash.Error())))
+		return
+	}
+	if err != nil {
+		logging.FromContext(ctx.Request.Context()).WithError(err).WithField("task_id", taskID).Error("Failed to purge task")
+		Render(ctx, th.list(ctx, userId, components.Alert("error", "Failed to delete the task")))
+		return
+	}
+	Render(ctx, th.list(ctx, userId, components.Alert("success", "Task deleted for good")))
+}
+
+// Empty deletes every task in the user's trash for good
+func (th *trashHandler) Empty(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, th.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	// Purging a page leaves the next one first, until the trash is empty
+	for {
+		page, err := th.taskRepo.GetTrashedTasks(ctx, userId, models.PageRequest{Limit: models.MaxPageSize})
+		if err != nil {
+			logging.FromContext(ctx.Request.Context()).WithError(err).Error("Failed to list the trash")
+			Render(ctx, th.list(ctx, userId, components.Alert("error", "Failed to empty the trash")))
+			return
+		}
+		for _, task := range page.Tasks {
+			if err := th.taskRepo.PurgeTaskById(ctx, userId, task.TaskID); err != nil {
+				logging.FromContext(ctx.Request.Context()).WithError(err).WithField("task_id", task.TaskID).Error("Failed to purge task")
+				Render(ctx, th.list(ctx, userId, components.Alert("error", "Failed to empty the trash")))
+				return
+			}
+		}
+		if page.NextCursor == "" {
+			break
+		}
+	}
+	Render(ctx, th.list(ctx, userId, components.Alert("success", "Trash emptied")))
+}
+
+// restore moves a task of the user back from the trash, then reschedules
+// its reminders and tells the user's webhooks and open pages. Errors are
+// fit to show.
+func (th *trashHandler) restore(ctx *gin.Context, userId string, taskID string) (*models.Task, error) {
+	log := logging.FromContext(ctx.Request.Context()).WithField("task_id", taskID)
+	task, err := th.taskRepo.RestoreTaskById(ctx, userId, taskID)
+	switch {
+	case errors.Is(err, models.ErrTaskExists):
+		return nil, errors.New("A task with the same ID exists, delete it before restoring this one")
+	case errors.Is(err, models.ErrTaskNotFound):
+		return nil, errNotInTrash
+	case err != nil:
+		log.WithError(err).Error("Failed to restore task")
+		return nil, errors.New("Failed to restore the task")
+	}
+
+	user, err := th.userRepo.GetUser(ctx, userId)
+	if err != nil {
+		log.WithError(err).Error("Failed to get user")
+	} else if err := th.reminders.Reschedule(ctx, *task, user.Location()); err != nil {
+		log.WithError(err).Error("Failed to schedule reminders")
+	}
+	if err := th.events.EmitTaskEvent(ctx, userId, models.EventTaskCreated, *task); err != nil {
+		log.WithError(err).Error("Failed to queue webhook event")
+	}
+
+	// Open pages showing the day get the list with the task back
+	publishCreated(ctx, th.broker, th.taskRepo, userId, map[civil.Date]bool{task.Day(): true})
+	return task, nil
+}
+
+// More renders the page of the trash after the cursor, for the placeholder
+// at the end of the list
+func (th *trashHandler) More(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, th.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	page, err := th.taskRepo.GetTrashedTasks(ctx, userId, models.PageRequest{Cursor: ctx.Query("cursor")})
+	if err != nil {
+		if !errors.Is(err, models.ErrInvalidCursor) {
+			logging.FromContext(ctx.Request.Context()).WithError(err).Error("Failed to list the trash")
+		}
+		Render(ctx, components.Alert("error", "Failed to list more of the trash"))
+		return
+	}
+	Render(ctx, view_trash.More(page, th.retention))
+}
+
+// list renders the first page of the trash of the user with an optional alert
+func (th *trashHandler) list(ctx *gin.Context, userId string, alert templ.Component) templ.Component {
+	page, err := th.taskRepo.GetTrashedTasks(ctx, userId, models.PageRequest{})
+	if err != nil {
+		logging.FromContext(ctx.Request.Context()).WithError(err).Error("Failed to list the trash")
+		return view_trash.List(models.TaskPage{}, th.retention, components.Alert("error", "Failed to list the trash"))
+	}
+	return view_trash.List(page, th.retention, alert)
+}
@@ -2,37 +2,63 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
+	"firebase.google.com/go/auth"
 	"github.com/Zenk41/go-gin-htmx/api"
+	"github.com/Zenk41/go-gin-htmx/digest"
+	"github.com/Zenk41/go-gin-htmx/logging"
 	"github.com/Zenk41/go-gin-htmx/models"
 	"github.com/Zenk41/go-gin-htmx/utils"
 	view_auth "github.com/Zenk41/go-gin-htmx/views/auth"
 	"github.com/Zenk41/go-gin-htmx/views/components"
+	view_settings "github.com/Zenk41/go-gin-htmx/views/settings"
 	"github.com/gin-gonic/gin"
 )
 
+// minPasswordLength is the shortest password Firebase accepts
+const minPasswordLength = 6
+
 type UserHandler interface {
 	Login(ctx *gin.Context)
 	Register(ctx *gin.Context)
 	Logout(ctx *gin.Context)
+	UpdateSettings(ctx *gin.Context)
+	ChangePassword(ctx *gin.Context)
 }
 
 type userHandler struct {
-	repo        models.UserRepository
-	apiKey      string
-	domain      string
-	firebaseApi api.FirebaseApi
+	repo         models.UserRepository
+	apiKey       string
+	domain       string
+	firebaseApi  api.FirebaseApi
+	firebaseAuth *auth.Client
+	audit        models.AuditRepository
+	taskRepo     models.TaskRepository
+	reminderRepo models.ReminderRepository
+	reminders    ReminderScheduler
+	newSecret    func() (string, error)
+	checkURL     func(ctx context.Context, raw string) error
 }
 
-func NewUserHandler(repo models.UserRepository, apiKey string, firebaseApi api.FirebaseApi, domain string) UserHandler {
+func NewUserHandler(repo models.UserRepository, apiKey string, firebaseApi api.FirebaseApi, domain string, firebaseAuth *auth.Client, audit models.AuditRepository, taskRepo models.TaskRepository, reminderRepo models.ReminderRepository, reminders ReminderScheduler, newSecret func() (string, error), checkURL func(ctx context.Context, raw string) error) UserHandler {
 	return &userHandler{
-		repo:        repo,
-		apiKey:      apiKey,
-		firebaseApi: firebaseApi,
-		domain:      domain,
+		repo:         repo,
+		apiKey:       apiKey,
+		firebaseApi:  firebaseApi,
+		domain:       domain,
+		firebaseAuth: firebaseAuth,
+		audit:        audit,
+		taskRepo:     taskRepo,
+		reminderRepo: reminderRepo,
+		reminders:    reminders,
+		newSecret:    newSecret,
+		checkURL:     checkURL,
 	}
 }
 
@@ -44,8 +70,13 @@ func (h *userHandler) Register(ctx *gin.Context) {
 	user.Name = ctx.PostForm("name")
 	user.Email = ctx.PostForm("email")
 	user.Password = ctx.PostForm("password")
+	// Detected by the browser on the register form
+	user.Timezone = ctx.PostForm("timezone")
+	if !utils.IsValidTimezone(user.Timezone) {
+		user.Timezone = "UTC"
+	}
 
-	registerResponse, err := h.firebaseApi.SignUpWithPassword(user.Name, user.Email, user.Password)
+	registerResponse, err := h.firebaseApi.SignUpWithPassword(ctx, user.Name, user.Email, user.Password)
 	if err != nil {
 		errorCode, _ := utils.ExtractErrorCodeFromText(err.Error())
 		Render(ctx, view_auth.Login(components.Alert("error", errorCode)))
@@ -61,11 +92,12 @@ func (h *userHandler) Register(ctx *gin.Context) {
 	user.CreatedAt = time.Now()
 	user.UpdatedAt = time.Now()
 
-	if err := h.repo.CreateUser(context.Background(), user); err != nil {
+	if err := h.repo.CreateUser(ctx, user); err != nil {
 		errorCode, _ := utils.ExtractErrorCodeFromText(err.Error())
 		Render(ctx, view_auth.Login(components.Alert("error", errorCode)))
 		return
 	}
+	recordAudit(ctx, h.audit, user.UserID, models.AuditRegistered, "")
 	expireIn, _ := strconv.Atoi(registerResponse["expiresIn"].(string))
 	ctx.SetCookie("firebase_token", registerResponse["idToken"].(string), expireIn, "/", "localhost", false, true)
 
@@ -80,25 +112,201 @@ func (h *userHandler) Login(ctx *gin.Context) {
 	user.Email = ctx.PostForm("email")
 	user.Password = ctx.PostForm("password")
 
-	loginResponse, err := h.firebaseApi.SignInWithPassword(user.Email, user.Password)
+	loginResponse, err := h.firebaseApi.SignInWithPassword(ctx, user.Email, user.Password)
 	if err != nil {
 		errorCode, _ := utils.ExtractErrorCodeFromText(err.Error())
 		Render(ctx, view_auth.Login(components.Alert("error", errorCode)))
 		return
 	}
 
-	expireIn, _ := strconv.Atoi(loginResponse["expiresIn"].(string))
-	ctx.SetCookie("firebase_token", loginResponse["idToken"].(string), expireIn, "/", h.domain, false, false)
-
-	ctx.SetCookie("refresh_token", loginResponse["refreshToken"].(string), 86400, "/", h.domain, false, false)
+	if userId, ok := loginResponse["localId"].(string); ok {
+		recordAudit(ctx, h.audit, userId, models.AuditLogin, "")
+	}
+	h.setSessionCookies(ctx, loginResponse)
 
 	ctx.Redirect(http.StatusFound, "/")
 }
 
+// setSessionCookies stores the tokens of a sign in response
+func (h *userHandler) setSessionCookies(ctx *gin.Context, signIn map[string]interface{}) {
+	expireIn, _ := strconv.Atoi(signIn["expiresIn"].(string))
+	ctx.SetCookie("firebase_token", signIn["idToken"].(string), expireIn, "/", h.domain, false, false)
+
+	ctx.SetCookie("refresh_token", signIn["refreshToken"].(string), 86400, "/", h.domain, false, false)
+}
+
 // Logout handles the user logout
 func (h *userHandler) Logout(ctx *gin.Context) {
+	if userId, err := CookieAuth(ctx, h.firebaseAuth); err == nil && userId != "" {
+		recordAudit(ctx, h.audit, userId, models.AuditLogout, "")
+	}
 	ctx.SetCookie("firebase_token", "", 0, "/", h.domain, false, true)
 
 	ctx.SetCookie("refresh_token", "", 0, "/", h.domain, false, true)
 	Render(ctx, view_auth.Login(components.Alert("success", "logout success ")))
 }
+
+// UpdateSettings saves the profile settings form
+func (h *userHandler) UpdateSettings(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, h.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	user, err := h.repo.GetUser(ctx, userId)
+	if err != nil {
+		Render(ctx, view_settings.Index(models.User{}, components.Alert("error", "Failed to get user")))
+		return
+	}
+
+	timezone := ctx.PostForm("timezone")
+	if !utils.IsValidTimezone(timezone) {
+		Render(ctx, view_settings.Index(*user, components.Alert("error", "Unknown timezone: "+timezone)))
+		return
+	}
+
+	webhookURL := strings.TrimSpace(ctx.PostForm("reminder_webhook_url"))
+	if webhookURL != "" && !isWebhookURL(webhookURL) {
+		Render(ctx, view_settings.Index(*user, components.Alert("error", "Reminder webhook must be an http(s) URL")))
+		return
+	}
+	if webhookURL != "" && webhookURL != user.ReminderWebhookURL {
+		if err := h.checkURL(ctx, webhookURL); err != nil {
+			Render(ctx, view_settings.Index(*user, components.Alert("error", "Reminder webhook must be a public address")))
+			return
+		}
+	}
+
+	if name := ctx.PostForm("name"); name != "" {
+		user.Name = name
+	}
+	oldTimezone := user.Timezone
+	user.Timezone = timezone
+	user.NotifyEmail = ctx.PostForm("notify_email") == "on"
+	user.ReminderWebhookURL = webhookURL
+	if webhookURL != "" && user.ReminderWebhookSecret == "" {
+		secret, err := h.newSecret()
+		if err != nil {
+			logging.FromContext(ctx.Request.Context()).WithError(err).Error("Failed to generate reminder webhook secret")
+			Render(ctx, view_settings.Index(*user, components.Alert("error", "Failed to save settings")))
+			return
+		}
+		user.ReminderWebhookSecret = secret
+	}
+	wasEnabled, oldHour := user.DigestEnabled, user.DigestHour
+	user.DigestEnabled = ctx.PostForm("digest_enabled") == "on"
+	if hour, err := strconv.Atoi(ctx.PostForm("digest_hour")); err == nil && hour >= 0 && hour < 24 {
+		user.DigestHour = hour
+	}
+	user.UpdatedAt = time.Now()
+
+	if err := h.repo.UpdateUser(ctx, *user); err != nil {
+		Render(ctx, view_settings.Index(*user, components.Alert("error", "Failed to save settings")))
+		return
+	}
+	if user.Timezone != oldTimezone {
+		h.rescheduleReminders(ctx, *user)
+	}
+	if user.DigestEnabled && (!wasEnabled || user.DigestHour != oldHour) {
+		if day, skipped := digest.Skipped(*user, user.UpdatedAt); skipped {
+			if err := h.repo.MarkDigestSent(ctx, userId, day); err != nil {
+				logging.FromContext(ctx.Request.Context()).WithError(err).Error("Failed to schedule the first digest")
+			}
+		}
+	}
+	Render(ctx, view_settings.Index(*user, components.Alert("success", "Settings saved")))
+}
+
+// rescheduleReminders plans the pending reminders of the user's tasks again
+// after a timezone change, as times of day are kept in the user's timezone.
+// Failures are logged as the settings themselves were saved.
+func (h *userHandler) rescheduleReminders(ctx *gin.Context, user models.User) {
+	log := logging.FromContext(ctx.Request.Context())
+	pending, err := h.reminderRepo.PendingForUser(ctx, user.UserID)
+	if err != nil {
+		log.WithError(err).Error("Failed to list reminders to reschedule")
+		return
+	}
+	done := map[string]bool{}
+	for _, reminder := range pending {
+		if done[reminder.TaskID] {
+			continue
+		}
+		done[reminder.TaskID] = true
+		task, err := h.taskRepo.GetTaskById(ctx, user.UserID, reminder.TaskID)
+		if errors.Is(err, models.ErrTaskNotFound) {
+			// Skipped by the scheduler when due
+			continue
+		} else if err != nil {
+			log.WithError(err).WithField("task_id", reminder.TaskID).Error("Failed to read task to reschedule")
+			continue
+		}
+		if err := h.reminders.Reschedule(ctx, *task, user.Location()); err != nil {
+			log.WithError(err).WithField("task_id", task.TaskID).Error("Failed to schedule reminders")
+		}
+	}
+}
+
+// ChangePassword sets a new account password after checking the current one
+func (h *userHandler) ChangePassword(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, h.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.repo.GetUser(ctx, userId)
+	if err != nil {
+		Render(ctx, view_settings.Password(components.Alert("error", "Failed to get user")))
+		return
+	}
+
+	current := ctx.PostForm("current_password")
+	password := ctx.PostForm("new_password")
+	switch {
+	case len(password) < minPasswordLength:
+		Render(ctx, view_settings.Password(components.Alert("error", "The new password must have at least 6 characters")))
+		return
+	case password != ctx.PostForm("confirm_password"):
+		Render(ctx, view_settings.Password(components.Alert("error", "The new passwords don't match")))
+		return
+	}
+	if _, err := h.firebaseApi.SignInWithPassword(ctx, user.Email, current); err != nil {
+		Render(ctx, view_settings.Password(components.Alert("error", "The current password is wrong")))
+		return
+	}
+
+	log := logging.FromContext(ctx.Request.Context())
+	if _, err := h.firebaseAuth.UpdateUser(ctx, userId, (&auth.UserToUpdate{}).Password(password)); err != nil {
+		log.WithError(err).Error("Failed to change password")
+		Render(ctx, view_settings.Password(components.Alert("error", "Failed to change the password")))
+		return
+	}
+	recordAudit(ctx, h.audit, userId, models.AuditPasswordChanged, "")
+
+	if err := user.EncryptPassword(password); err != nil {
+		log.WithError(err).Error("Failed to encrypt password")
+	} else {
+		user.UpdatedAt = time.Now()
+		if err := h.repo.UpdateUser(ctx, *user); err != nil {
+			log.WithError(err).Error("Failed to save password")
+		}
+	}
+
+	// The change revokes the refresh tokens of every session, sign this one in again
+	signIn, err := h.firebaseApi.SignInWithPassword(ctx, user.Email, password)
+	if err != nil {
+		log.WithError(err).Error("Failed to sign in with the new password")
+		ctx.Header("HX-Redirect", "/login")
+		return
+	}
+	h.setSessionCookies(ctx, signIn)
+	Render(ctx, view_settings.Password(components.Alert("success", "Password changed")))
+}
+
+// isWebhookURL reports whether raw is an absolute http(s) URL
+func isWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
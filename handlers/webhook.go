@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"firebase.google.com/go/auth"
+	"github.com/Zenk41/go-gin-htmx/logging"
+	"github.com/Zenk41/go-gin-htmx/models"
+	"github.com/Zenk41/go-gin-htmx/views/components"
+	view_webhooks "github.com/Zenk41/go-gin-htmx/views/webhooks"
+	"github.com/a-h/templ"
+	"github.com/gin-gonic/gin"
+)
+
+// deliveryLogSize is how many deliveries the delivery log shows
+const deliveryLogSize = 20
+
+// WebhookTester sends a test event to a webhook right away
+type WebhookTester interface {
+	SendTest(ctx context.Context, webhook models.Webhook) (models.WebhookDelivery, error)
+}
+
+type WebhookHandler interface {
+	Index(ctx *gin.Context)
+	Create(ctx *gin.Context)
+	Delete(ctx *gin.Context)
+	Test(ctx *gin.Context)
+	Deliveries(ctx *gin.Context)
+}
+
+type webhookHandler struct {
+	webhookRepo  models.WebhookRepository
+	userRepo     models.UserRepository
+	tester       WebhookTester
+	newSecret    func() (string, error)
+	checkURL     func(ctx context.Context, raw string) error
+	firebaseAuth *auth.Client
+}
+
+func NewWebhookHandler(webhookRepo models.WebhookRepository,
+	userRepo models.UserRepository,
+	tester WebhookTester,
+	newSecret func() (string, error),
+	checkURL func(ctx context.Context, raw string) error,
+	firebaseAuth *auth.Client) WebhookHandler {
+	return &webhookHandler{
+		webhookRepo:  webhookRepo,
+		userRepo:     userRepo,
+		tester:       tester,
+		newSecret:    newSecret,
+		checkURL:     checkURL,
+		firebaseAuth: firebaseAuth,
+	}
+}
+
+// Index renders the webhook settings page
+func (wh *webhookHandler) Index(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, wh.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+	wh.renderIndex(ctx, userId, nil)
+}
+
+// Create registers a new webhook for the selected events
+func (wh *webhookHandler) Create(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, wh.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	url := strings.TrimSpace(ctx.PostForm("url"))
+	if !isWebhookURL(url) {
+		wh.renderIndex(ctx, userId, components.Alert("error", "Webhook must be an http(s) URL"))
+		return
+	}
+	if err := wh.checkURL(ctx, url); err != nil {
+		wh.renderIndex(ctx, userId, components.Alert("error", "Webhook must be a public address"))
+		return
+	}
+
+	var events []string
+	for _, event := range ctx.PostFormArray("events") {
+		if slices.Contains(models.TaskEvents, event) && !slices.Contains(events, event) {
+			events = append(events, event)
+		}
+	}
+	if len(events) == 0 {
+		wh.renderIndex(ctx, userId, components.Alert("error", "Choose at least one event"))
+		return
+	}
+
+	secret, err := wh.newSecret()
+	if err != nil {
+		wh.renderIndex(ctx, userId, components.Alert("error", err.Error()))
+		return
+	}
+
+	if _, err := wh.webhookRepo.CreateWebhook(ctx, models.Webhook{
+		UserID:    userId,
+		URL:       url,
+		Secret:    secret,
+		Events:    events,
+		CreatedAt: time.Now(),
+	}); err != nil {
+		wh.renderIndex(ctx, userId, components.Alert("error", "Failed to save webhook"))
+		return
+	}
+	wh.renderIndex(ctx, userId, components.Alert("success", "Webhook added"))
+}
+
+// Delete removes a webhook and renders the remaining ones
+func (wh *webhookHandler) Delete(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, wh.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	if err := wh.webhookRepo.DeleteWebhook(ctx, userId, ctx.Param("id")); err != nil {
+		logging.FromContext(ctx).WithError(err).Error("Failed to delete webhook")
+	}
+
+	webhooks, err := wh.webhookRepo.ListWebhooks(ctx, userId)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).Error("Failed to list webhooks")
+	}
+	Render(ctx, view_webhooks.List(webhooks))
+}
+
+// Test sends a ping to the webhook and renders its delivery log
+func (wh *webhookHandler) Test(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, wh.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	webhook, err := wh.webhookRepo.GetWebhook(ctx, userId, ctx.Param("id"))
+	if err != nil {
+		Render(ctx, view_webhooks.Deliveries(nil, components.Alert("error", "Webhook not found")))
+		return
+	}
+
+	// Deliveries refuse such addresses too, this only explains why
+	if err := wh.checkURL(ctx, webhook.URL); err != nil {
+		wh.renderDeliveries(ctx, webhook.ID, components.Alert("error", "Webhook must be a public address"))
+		return
+	}
+
+	var alert = components.Alert("success", "Test event delivered")
+	delivery, err := wh.tester.SendTest(ctx, *webhook)
+	switch {
+	case err != nil:
+		alert = components.Alert("error", "Failed to queue test event")
+	case delivery.Status != models.DeliveryDelivered:
+		alert = components.Alert("warning", "Test event failed, it will be retried: "+delivery.LastError)
+	}
+	wh.renderDeliveries(ctx, webhook.ID, alert)
+}
+
+// Deliveries renders the delivery log of a webhook
+func (wh *webhookHandler) Deliveries(ctx *gin.Context) {
+	userId, err := CookieAuth(ctx, wh.firebaseAuth)
+	if err != nil || userId == "" {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	webhook, err := wh.webhookRepo.GetWebhook(ctx, userId, ctx.Param("id"))
+	if err != nil {
+		Render(ctx, view_webhooks.Deliveries(nil, components.Alert("error", "Webhook not found")))
+		return
+	}
+	wh.renderDeliveries(ctx, webhook.ID, nil)
+}
+
+func (wh *webhookHandler) renderDeliveries(ctx *gin.Context, webhookID string, alert templ.Component) {
+	deliveries, err := wh.webhookRepo.ListDeliveries(ctx, webhookID, deliveryLogSize)
+	if err != nil {
+		Render(ctx, view_webhooks.Deliveries(nil, components.Alert("error", "Failed to get deliveries")))
+		return
+	}
+	Render(ctx, view_webhooks.Deliveries(deliveries, alert))
+}
+
+func (wh *webhookHandler) renderIndex(ctx *gin.Context, userId string, alert templ.Component) {
+	user, err := wh.userRepo.GetUser(ctx, userId)
+	if err != nil {
+		Render(ctx, view_webhooks.Index(models.User{}, nil, components.Alert("error", "Failed to get user")))
+		return
+	}
+
+	webhooks, err := wh.webhookRepo.ListWebhooks(ctx, userId)
+	if err != nil {
+		Render(ctx, view_webhooks.Index(*user, nil, components.Alert("error", "Failed to get webhooks")))
+		return
+	}
+	Render(ctx, view_webhooks.Index(*user, webhooks, alert))
+}
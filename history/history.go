@@ -0,0 +1,37 @@
+// Package history keeps an append-only record of the changes made to each
+// task: who changed which fields, from what to what, and when.
+//
+// The record is kept by a TaskRepository decorator, so every handler and job
+// writing tasks is covered. The changed fields come from the repository,
+// which compares them with the stored task in the transaction of the write.
+// Handlers that don't take requests from the web pages say how the change
+// was made with WithSource.
+package history
+
+import "context"
+
+// Sources of a change
+const (
+	SourceWeb      = "web"
+	SourceCalDAV   = "caldav"
+	SourceImport   = "import"
+	SourceCalendar = "calendar import"
+	SourceBackup   = "backup"
+)
+
+type sourceKey struct{}
+
+// WithSource returns a context whose task changes are recorded as made
+// through source
+func WithSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+// Source returns how the changes made with ctx are made, SourceWeb unless
+// WithSource said otherwise
+func Source(ctx context.Context) string {
+	if source, ok := ctx.Value(sourceKey{}).(string); ok {
+		return source
+	}
+	return SourceWeb
+}
@@ -0,0 +1,132 @@
+package history
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/Zenk41/go-gin-htmx/logging"
+	"github.com/Zenk41/go-gin-htmx/models"
+)
+
+// recordedTaskRepository appends an entry to the history of every task it
+// changes. Reads pass through the embedded repository untouched.
+type recordedTaskRepository struct {
+	models.TaskRepository
+	history models.HistoryRepository
+}
+
+// RecordTasks wraps a TaskRepository so each change to a task is added to
+// its history. Edits and completions record the changes the repository
+// returns, so the old values are the ones the write replaced. History
+// failures are logged rather than returned: the task itself was saved.
+func RecordTasks(next models.TaskRepository, history models.HistoryRepository) models.TaskRepository {
+	return &recordedTaskRepository{TaskRepository: next, history: history}
+}
+
+func (r *recordedTaskRepository) CreateTask(ctx context.Context, task models.TaskPayload, idempotencyKey string) (*models.Task, error) {
+	created, err := r.TaskRepository.CreateTask(ctx, task, idempotencyKey)
+	if err != nil {
+		return created, err
+	}
+	r.add(ctx, r.change(ctx, created.UserID, created.TaskID, models.TaskCreated, nil))
+	return created, nil
+}
+
+func (r *recordedTaskRepository) EditTaskById(ctx context.Context, task models.TaskPayload) ([]models.FieldChange, error) {
+	changes, err := r.TaskRepository.EditTaskById(ctx, task)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) > 0 {
+		r.add(ctx, r.change(ctx, task.UserID, task.TaskID, models.TaskUpdated, changes))
+	}
+	return changes, nil
+}
+
+func (r *recordedTaskRepository) DoneTaskById(ctx context.Context, userID string, taskID string) (*models.Task, []models.FieldChange, error) {
+	task, changes, err := r.TaskRepository.DoneTaskById(ctx, userID, taskID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(changes) > 0 {
+		r.add(ctx, r.change(ctx, userID, taskID, models.TaskCompleted, changes))
+	}
+	return task, changes, nil
+}
+
+func (r *recordedTaskRepository) DoneAllTaskDayByDate(ctx context.Context, userID string, date civil.Date) ([]models.ChangedTask, error) {
+	changed, err := r.TaskRepository.DoneAllTaskDayByDate(ctx, userID, date)
+	if err != nil {
+		return nil, err
+	}
+	var changes []models.TaskChange
+	for _, c := range changed {
+		changes = append(changes, r.change(ctx, userID, c.Task.TaskID, models.TaskCompleted, c.Changes))
+	}
+	r.add(ctx, changes...)
+	return changed, nil
+}
+
+func (r *recordedTaskRepository) DeleteTaskById(ctx context.Context, userID string, taskID string) (*models.Task, error) {
+	task, err := r.TaskRepository.DeleteTaskById(ctx, userID, taskID)
+	if err != nil {
+		return nil, err
+	}
+	r.add(ctx, r.change(ctx, userID, taskID, models.TaskDeleted, nil))
+	return task, nil
+}
+
+func (r *recordedTaskRepository) RestoreTaskById(ctx context.Context, userID string, taskID string) (*models.Task, error) {
+	task, err := r.TaskRepository.RestoreTaskById(ctx, userID, taskID)
+	if err != nil {
+		return nil, err
+	}
+	r.add(ctx, r.change(ctx, userID, taskID, models.TaskRestored, nil))
+	return task, nil
+}
+
+func (r *recordedTaskRepository) PurgeTaskById(ctx context.Context, userID string, taskID string) error {
+	if err := r.TaskRepository.PurgeTaskById(ctx, userID, taskID); err != nil {
+		return err
+	}
+	r.add(ctx, r.change(ctx, userID, taskID, models.TaskPurged, nil))
+	return nil
+}
+
+func (r *recordedTaskRepository) PurgeTrash(ctx context.Context, before time.Time) ([]models.Task, error) {
+	tasks, err := r.TaskRepository.PurgeTrash(ctx, before)
+	if err != nil {
+		return nil, err
+	}
+	var changes []models.TaskChange
+	for _, task := range tasks {
+		changes = append(changes, r.change(ctx, task.UserID, task.TaskID, models.TaskPurged, nil))
+	}
+	r.add(ctx, changes...)
+	return tasks, nil
+}
+
+// change builds an entry made now by the user the request is authenticated
+// as, if any, through the source of ctx
+func (r *recordedTaskRepository) change(ctx context.Context, userID string, taskID string, action string, changes []models.FieldChange) models.TaskChange {
+	actor, _ := ctx.Value(logging.UserIDKey).(string)
+	return models.TaskChange{
+		TaskID:  taskID,
+		UserID:  userID,
+		ActorID: actor,
+		Source:  Source(ctx),
+		Action:  action,
+		Changes: changes,
+		At:      time.Now(),
+	}
+}
+
+func (r *recordedTaskRepository) add(ctx context.Context, changes ...models.TaskChange) {
+	if len(changes) == 0 {
+		return
+	}
+	if err := r.history.AddTaskChanges(ctx, changes); err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("task_id", changes[0].TaskID).Error("Failed to add to task history")
+	}
+}
@@ -0,0 +1,69 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Writer builds an RFC 5545 document. Lines end in CRLF and are folded at
+// 75 octets without splitting UTF-8 sequences.
+type Writer struct {
+	buf bytes.Buffer
+}
+
+// Begin opens a component such as VCALENDAR or VTODO
+func (w *Writer) Begin(component string) {
+	w.Line("BEGIN:" + component)
+}
+
+// End closes a component
+func (w *Writer) End(component string) {
+	w.Line("END:" + component)
+}
+
+// Prop writes a property whose value is already in iCalendar syntax
+func (w *Writer) Prop(name string, value string) {
+	w.Line(name + ":" + value)
+}
+
+// Text writes a TEXT property, escaping its value
+func (w *Writer) Text(name string, value string) {
+	w.Line(name + ":" + EscapeText(value))
+}
+
+// Line writes one content line, folding it when needed
+func (w *Writer) Line(line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		w.buf.WriteString(line[:cut])
+		w.buf.WriteString("\r\n ")
+		line = line[cut:]
+		limit = 74 // continuation lines start with a space
+	}
+	w.buf.WriteString(line)
+	w.buf.WriteString("\r\n")
+}
+
+// Bytes returns the document written so far
+func (w *Writer) Bytes() []byte {
+	return w.buf.Bytes()
+}
+
+// EscapeText escapes a TEXT value. Every line break, a lone CR included,
+// becomes \n: a raw CR would end the content line.
+func EscapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+var textEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\r", `\n`, "\n", `\n`)
+
+// UTC formats t as a UTC DATE-TIME value
+func UTC(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
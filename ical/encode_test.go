@@ -0,0 +1,128 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestEscapeText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "Buy milk", want: "Buy milk"},
+		{text: `a\b`, want: `a\\b`},
+		{text: "milk; eggs, bread", want: `milk\; eggs\, bread`},
+		{text: "one\ntwo", want: `one\ntwo`},
+		{text: "one\r\ntwo", want: `one\ntwo`},
+		{text: "one\rtwo", want: `one\ntwo`},
+		{text: "one\r\rtwo\r", want: `one\n\ntwo\n`},
+		{text: "one\n\r\ntwo", want: `one\n\ntwo`},
+		{text: `C:\tasks\n`, want: `C:\\tasks\\n`},
+	}
+	for _, tt := range tests {
+		got := EscapeText(tt.text)
+		if got != tt.want {
+			t.Errorf("EscapeText(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+		if strings.ContainsAny(got, "\r\n") {
+			t.Errorf("EscapeText(%q) = %q keeps a line break", tt.text, got)
+		}
+	}
+}
+
+func TestUnescapeText(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "Buy milk", want: "Buy milk"},
+		{value: `milk\; eggs\, bread`, want: "milk; eggs, bread"},
+		{value: `one\ntwo\Nthree`, want: "one\ntwo\nthree"},
+		{value: `a\\b`, want: `a\b`},
+		{value: `trailing\`, want: `trailing\`},
+		// Escapes RFC 5545 does not define keep the character
+		{value: `\"quoted\"`, want: `"quoted"`},
+	}
+	for _, tt := range tests {
+		if got := UnescapeText(tt.value); got != tt.want {
+			t.Errorf("UnescapeText(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestTextRoundTrip(t *testing.T) {
+	texts := []string{
+		"",
+		"Buy milk",
+		`C:\tasks\n; a, b`,
+		"first line\nsecond line\n\nafter a blank line",
+		strings.Repeat("long ", 40),
+		strings.Repeat("é", 100),
+		strings.Repeat("日本語", 30),
+		strings.Repeat("a", 73) + "🎉🎉🎉" + strings.Repeat("b", 80),
+	}
+	for _, text := range texts {
+		var w Writer
+		w.Begin("VTODO")
+		w.Text("SUMMARY", text)
+		w.End("VTODO")
+
+		root, err := Parse(bytes.NewReader(w.Bytes()))
+		if err != nil {
+			t.Errorf("%q: %v", text, err)
+			continue
+		}
+		if got := root.Children[0].Text("SUMMARY"); got != text {
+			t.Errorf("round trip of %q gave %q", text, got)
+		}
+	}
+}
+
+func TestLineFolding(t *testing.T) {
+	lines := []string{
+		"SUMMARY:short",
+		"SUMMARY:" + strings.Repeat("x", 67), // exactly 75 octets
+		"SUMMARY:" + strings.Repeat("x", 68),
+		"SUMMARY:" + strings.Repeat("x", 300),
+		// Multi-byte runes straddling the 75th and 149th octets
+		"SUMMARY:" + strings.Repeat("é", 100),
+		"SUMMARY:" + strings.Repeat("日本語", 30),
+		"SUMMARY:" + strings.Repeat("a", 66) + strings.Repeat("🎉", 20),
+	}
+	for _, line := range lines {
+		var w Writer
+		w.Line(line)
+		out := w.Bytes()
+		if !bytes.HasSuffix(out, []byte("\r\n")) {
+			t.Errorf("%q: output %q does not end in CRLF", line, out)
+			continue
+		}
+
+		physical := strings.Split(strings.TrimSuffix(string(out), "\r\n"), "\r\n")
+		unfolded := ""
+		for i, p := range physical {
+			if len(p) > 75 {
+				t.Errorf("%q: line %d is %d octets long", line, i+1, len(p))
+			}
+			if !utf8.ValidString(p) {
+				t.Errorf("%q: line %d %q splits a UTF-8 sequence", line, i+1, p)
+			}
+			if i > 0 {
+				if !strings.HasPrefix(p, " ") {
+					t.Errorf("%q: continuation line %d %q does not start with a space", line, i+1, p)
+				}
+				p = p[1:]
+			}
+			unfolded += p
+		}
+		if unfolded != line {
+			t.Errorf("%q unfolds to %q", line, unfolded)
+		}
+		if len(line) <= 75 && len(physical) != 1 {
+			t.Errorf("%q fits on a line but was folded", line)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Property is one content line of a component
+type Property struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// Component is a BEGIN/END block such as VCALENDAR, VTODO or VALARM
+type Component struct {
+	Name       string
+	Properties []Property
+	Children   []*Component
+}
+
+// Prop returns the first property called name, nil when there is none
+func (c *Component) Prop(name string) *Property {
+	for i := range c.Properties {
+		if c.Properties[i].Name == name {
+			return &c.Properties[i]
+		}
+	}
+	return nil
+}
+
+// Text returns the unescaped value of the TEXT property called name
+func (c *Component) Text(name string) string {
+	if p := c.Prop(name); p != nil {
+		return UnescapeText(p.Value)
+	}
+	return ""
+}
+
+// Parse reads an iCalendar stream. It returns a synthetic root component
+// whose children are the top-level components, usually one VCALENDAR.
+func Parse(r io.Reader) (*Component, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	root := &Component{}
+	stack := []*Component{root}
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		prop, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+
+		current := stack[len(stack)-1]
+		switch prop.Name {
+		case "BEGIN":
+			child := &Component{Name: strings.ToUpper(prop.Value)}
+			current.Children = append(current.Children, child)
+			stack = append(stack, child)
+		case "END":
+			if len(stack) == 1 || current.Name != strings.ToUpper(prop.Value) {
+				return nil, fmt.Errorf("line %d: END:%s does not close %s", i+1, prop.Value, current.Name)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) == 1 {
+				return nil, fmt.Errorf("line %d: property %s outside of a component", i+1, prop.Name)
+			}
+			current.Properties = append(current.Properties, prop)
+		}
+	}
+	if len(stack) > 1 {
+		return nil, fmt.Errorf("%s is never closed", stack[len(stack)-1].Name)
+	}
+	return root, nil
+}
+
+// unfold joins continuation lines, which start with a space or a tab
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// parseLine splits NAME;PARAM=value;PARAM="quoted:value":VALUE
+func parseLine(line string) (Property, error) {
+	prop := Property{Params: map[string]string{}}
+
+	end := strings.IndexAny(line, ";:")
+	if end <= 0 {
+		return prop, fmt.Errorf("malformed content line %q", line)
+	}
+	prop.Name = strings.ToUpper(line[:end])
+	rest := line[end:]
+
+	for strings.HasPrefix(rest, ";") {
+		rest = rest[1:]
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return prop, fmt.Errorf("malformed parameter in %q", line)
+		}
+		key := strings.ToUpper(rest[:eq])
+		rest = rest[eq+1:]
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			closing := strings.IndexByte(rest[1:], '"')
+			if closing < 0 {
+				return prop, fmt.Errorf("unterminated quoted parameter in %q", line)
+			}
+			value = rest[1 : closing+1]
+			rest = rest[closing+2:]
+		} else {
+			stop := strings.IndexAny(rest, ";:")
+			if stop < 0 {
+				return prop, fmt.Errorf("missing value in %q", line)
+			}
+			value = rest[:stop]
+			rest = rest[stop:]
+		}
+		prop.Params[key] = value
+	}
+
+	if !strings.HasPrefix(rest, ":") {
+		return prop, fmt.Errorf("missing value in %q", line)
+	}
+	prop.Value = rest[1:]
+	return prop, nil
+}
+
+// UnescapeText reverses EscapeText
+func UnescapeText(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
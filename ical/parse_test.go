@@ -0,0 +1,107 @@
+package ical
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	doc := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"",
+		"begin:vtodo",
+		"UID:1@example.com",
+		"SUMMARY:Write the quarterly ",
+		" report",
+		"DESCRIPTION:Folded with\t",
+		"\ta tab",
+		`DUE;TZID="Europe/Berlin";VALUE=DATE-TIME:20261021T090000`,
+		`X-LINK;LABEL="a:b;c":https://example.com/a;b`,
+		"BEGIN:VALARM",
+		"TRIGGER;RELATED=END:-PT15M",
+		"END:VALARM",
+		"END:VTODO",
+		"END:VCALENDAR",
+	}, "\r\n")
+
+	root, err := Parse(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Component{Children: []*Component{{
+		Name:       "VCALENDAR",
+		Properties: []Property{{Name: "VERSION", Params: map[string]string{}, Value: "2.0"}},
+		Children: []*Component{{
+			Name: "VTODO",
+			Properties: []Property{
+				{Name: "UID", Params: map[string]string{}, Value: "1@example.com"},
+				{Name: "SUMMARY", Params: map[string]string{}, Value: "Write the quarterly report"},
+				{Name: "DESCRIPTION", Params: map[string]string{}, Value: "Folded with\ta tab"},
+				{Name: "DUE", Params: map[string]string{"TZID": "Europe/Berlin", "VALUE": "DATE-TIME"}, Value: "20261021T090000"},
+				{Name: "X-LINK", Params: map[string]string{"LABEL": "a:b;c"}, Value: "https://example.com/a;b"},
+			},
+			Children: []*Component{{
+				Name:       "VALARM",
+				Properties: []Property{{Name: "TRIGGER", Params: map[string]string{"RELATED": "END"}, Value: "-PT15M"}},
+			}},
+		}},
+	}}}
+	if !reflect.DeepEqual(root, want) {
+		t.Errorf("Parse =\n%s\nwant\n%s", dump(root), dump(want))
+	}
+
+	todo := root.Children[0].Children[0]
+	if p := todo.Prop("SUMMARY"); p == nil || p.Value != "Write the quarterly report" {
+		t.Errorf("Prop(SUMMARY) = %+v", p)
+	}
+	if p := todo.Prop("LOCATION"); p != nil {
+		t.Errorf("Prop(LOCATION) = %+v, want nil", p)
+	}
+	if got := todo.Text("LOCATION"); got != "" {
+		t.Errorf("Text(LOCATION) = %q, want empty", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		doc     string
+		wantErr string
+	}{
+		{doc: "BEGIN:VCALENDAR\nBEGIN:VTODO\nEND:VCALENDAR\n", wantErr: "line 3: END:VCALENDAR does not close VTODO"},
+		{doc: "END:VCALENDAR\n", wantErr: "line 1: END:VCALENDAR does not close "},
+		{doc: "BEGIN:VCALENDAR\nBEGIN:VTODO\n", wantErr: "VTODO is never closed"},
+		{doc: "VERSION:2.0\n", wantErr: "line 1: property VERSION outside of a component"},
+		{doc: "BEGIN:VCALENDAR\nno colon here\n", wantErr: `line 2: malformed content line "no colon here"`},
+		{doc: "BEGIN:VCALENDAR\n:value\n", wantErr: `line 2: malformed content line ":value"`},
+		{doc: "BEGIN:VCALENDAR\nDUE;VALUE\n", wantErr: `line 2: malformed parameter in "DUE;VALUE"`},
+		{doc: "BEGIN:VCALENDAR\nDUE;VALUE=DATE\n", wantErr: `line 2: missing value in "DUE;VALUE=DATE"`},
+		{doc: "BEGIN:VCALENDAR\nX;P=\"open:1\n", wantErr: `line 2: unterminated quoted parameter in "X;P=\"open:1"`},
+		{doc: "BEGIN:VCALENDAR\nX;P=\"a\"b:1\n", wantErr: `line 2: missing value in "X;P=\"a\"b:1"`},
+	}
+	for _, tt := range tests {
+		_, err := Parse(strings.NewReader(tt.doc))
+		if err == nil || err.Error() != tt.wantErr {
+			t.Errorf("Parse(%q) error = %v, want %q", tt.doc, err, tt.wantErr)
+		}
+	}
+}
+
+// dump prints a component tree for failure messages
+func dump(c *Component) string {
+	var b strings.Builder
+	var walk func(c *Component, indent string)
+	walk = func(c *Component, indent string) {
+		fmt.Fprintf(&b, "%s%s\n", indent, c.Name)
+		for _, p := range c.Properties {
+			fmt.Fprintf(&b, "%s  %s %v %q\n", indent, p.Name, p.Params, p.Value)
+		}
+		for _, child := range c.Children {
+			walk(child, indent+"  ")
+		}
+	}
+	walk(c, "")
+	return b.String()
+}
@@ -0,0 +1,452 @@
+package ical
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/Zenk41/go-gin-htmx/models"
+)
+
+// FeedOptions describes the calendar a feed is rendered as
+type FeedOptions struct {
+	Name string
+	// Domain suffixes the UIDs of tasks that were not imported
+	Domain string
+	// Events renders tasks as VEVENTs for calendar apps without VTODO support
+	Events bool
+	// Location is the owner's timezone, in which due times are interpreted
+	Location *time.Location
+}
+
+// TaskUID is the UID of a task in feeds: the UID it was imported with, or
+// one derived from its ID
+func TaskUID(task models.Task, domain string) string {
+	if task.ICalUID != "" {
+		return task.ICalUID
+	}
+	return task.TaskID + "@" + domain
+}
+
+// Feed renders tasks as an iCalendar document
+func Feed(tasks []models.Task, opts FeedOptions) []byte {
+	var w Writer
+	beginCalendar(&w)
+	w.Prop("METHOD", "PUBLISH")
+	w.Text("X-WR-CALNAME", opts.Name)
+	w.Prop("REFRESH-INTERVAL;VALUE=DURATION", "PT1H")
+	w.Prop("X-PUBLISHED-TTL", "PT1H")
+
+	for _, task := range tasks {
+		if opts.Events {
+			writeEvent(&w, task, opts)
+		} else {
+			writeTodo(&w, task, opts)
+		}
+	}
+
+	w.End("VCALENDAR")
+	return w.Bytes()
+}
+
+// Object renders a single task as the iCalendar object of a CalDAV
+// resource, which unlike a feed must not carry a METHOD
+func Object(task models.Task, opts FeedOptions) []byte {
+	var w Writer
+	beginCalendar(&w)
+	if opts.Events {
+		writeEvent(&w, task, opts)
+	} else {
+		writeTodo(&w, task, opts)
+	}
+	w.End("VCALENDAR")
+	return w.Bytes()
+}
+
+func beginCalendar(w *Writer) {
+	w.Begin("VCALENDAR")
+	w.Prop("VERSION", "2.0")
+	w.Prop("PRODID", "-//go-gin-htmx//Task Manager//EN")
+	w.Prop("CALSCALE", "GREGORIAN")
+}
+
+func writeCommon(w *Writer, task models.Task, opts FeedOptions) {
+	w.Text("UID", TaskUID(task, opts.Domain))
+	stamp := task.UpdatedAt
+	if stamp.IsZero() {
+		stamp = task.CreatedAt
+	}
+	if stamp.IsZero() {
+		stamp = task.Day().In(time.UTC)
+	}
+	w.Prop("DTSTAMP", UTC(stamp))
+	if !task.CreatedAt.IsZero() {
+		w.Prop("CREATED", UTC(task.CreatedAt))
+	}
+	if !task.UpdatedAt.IsZero() {
+		w.Prop("LAST-MODIFIED", UTC(task.UpdatedAt))
+	}
+	if task.Description != "" {
+		w.Text("DESCRIPTION", task.Description)
+	}
+	if task.Recurrence != "" {
+		w.Prop("RRULE", task.Recurrence)
+	}
+	if len(task.Tags) > 0 {
+		categories := make([]string, len(task.Tags))
+		for i, tag := range task.Tags {
+			categories[i] = EscapeText(tag)
+		}
+		w.Prop("CATEGORIES", strings.Join(categories, ","))
+	}
+}
+
+func writeTodo(w *Writer, task models.Task, opts FeedOptions) {
+	w.Begin("VTODO")
+	writeCommon(w, task, opts)
+	w.Text("SUMMARY", task.Title)
+	if due, ok := task.DueAt(opts.Location); ok {
+		w.Prop("DUE", UTC(due))
+	} else {
+		w.Prop("DUE;VALUE=DATE", date(task.Day()))
+	}
+	if task.Status == "done" {
+		w.Prop("STATUS", "COMPLETED")
+		if !task.UpdatedAt.IsZero() {
+			w.Prop("COMPLETED", UTC(task.UpdatedAt))
+		}
+	} else {
+		w.Prop("STATUS", "NEEDS-ACTION")
+	}
+	// A VTODO has no start, so relative alarms hang off its due time
+	writeAlarms(w, task, opts, ";RELATED=END")
+	w.End("VTODO")
+}
+
+func writeEvent(w *Writer, task models.Task, opts FeedOptions) {
+	w.Begin("VEVENT")
+	writeCommon(w, task, opts)
+	summary := task.Title
+	if task.Status == "done" {
+		summary = "✔ " + summary
+	}
+	w.Text("SUMMARY", summary)
+	if due, ok := task.DueAt(opts.Location); ok {
+		w.Prop("DTSTART", UTC(due))
+	} else {
+		w.Prop("DTSTART;VALUE=DATE", date(task.Day()))
+		w.Prop("DTEND;VALUE=DATE", date(task.Day().AddDays(1)))
+	}
+	w.Prop("TRANSP", "TRANSPARENT")
+	writeAlarms(w, task, opts, "")
+	w.End("VEVENT")
+}
+
+func writeAlarms(w *Writer, task models.Task, opts FeedOptions, related string) {
+	for _, reminder := range task.Reminders {
+		var trigger string
+		switch {
+		case reminder.At != "":
+			fireAt, ok := reminder.FireAt(task, opts.Location)
+			if !ok {
+				continue
+			}
+			trigger = "TRIGGER;VALUE=DATE-TIME:" + UTC(fireAt)
+		case task.DueTime != "":
+			trigger = "TRIGGER" + related + ":" + beforeDuration(reminder.MinutesBefore)
+		default:
+			continue // relative reminders need a due time
+		}
+		w.Begin("VALARM")
+		w.Prop("ACTION", "DISPLAY")
+		w.Text("DESCRIPTION", task.Title)
+		w.Line(trigger)
+		w.End("VALARM")
+	}
+}
+
+func date(d civil.Date) string {
+	return fmt.Sprintf("%04d%02d%02d", d.Year, d.Month, d.Day)
+}
+
+// beforeDuration formats a negative DURATION of minutes
+func beforeDuration(minutes int) string {
+	switch {
+	case minutes == 0:
+		return "PT0S"
+	case minutes%(24*60) == 0:
+		return fmt.Sprintf("-P%dD", minutes/(24*60))
+	default:
+		return fmt.Sprintf("-PT%dM", minutes)
+	}
+}
+
+// Entry is a calendar entry read as a task
+type Entry struct {
+	UID         string
+	Kind        string // VTODO or VEVENT
+	Title       string
+	Description string
+	Date        civil.Date
+	DueTime     string
+	Done        bool
+	Recurrence  string
+	Reminders   []models.Reminder
+	Tags        []string // from CATEGORIES
+}
+
+// Payload converts the entry into a task of userID
+func (e Entry) Payload(userID string) models.TaskPayload {
+	task := models.TaskPayload{
+		UserID:      userID,
+		Title:       e.Title,
+		Description: e.Description,
+		Date:        models.StoredDate(e.Date),
+		DueTime:     e.DueTime,
+		Reminders:   e.Reminders,
+		Recurrence:  e.Recurrence,
+		ICalUID:     e.UID,
+		Tags:        e.Tags,
+	}
+	if e.Done {
+		task.Status = "done"
+	}
+	return task
+}
+
+// Validate checks an entry that went through the browser between preview
+// and import, holding it to what Entries produces
+func (e Entry) Validate() error {
+	switch {
+	case strings.TrimSpace(e.Title) == "":
+		return errors.New("has no title")
+	case !e.Date.IsValid():
+		return errors.New("has no date")
+	case e.Recurrence != "" && !ValidRecurrence(e.Recurrence):
+		return fmt.Errorf("unsupported recurrence %q", e.Recurrence)
+	}
+	if e.DueTime != "" {
+		if _, err := time.Parse("15:04", e.DueTime); err != nil {
+			return fmt.Errorf("invalid due time %q", e.DueTime)
+		}
+	}
+	for _, reminder := range e.Reminders {
+		switch {
+		case reminder.At != "":
+			if _, err := time.Parse("15:04", reminder.At); err != nil {
+				return fmt.Errorf("invalid reminder time %q", reminder.At)
+			}
+		case reminder.MinutesBefore < 0:
+			return errors.New("invalid reminder")
+		case e.DueTime == "":
+			return errors.New("reminders before the due time need a due time")
+		}
+	}
+	return nil
+}
+
+// EntryError explains why a calendar entry could not be read
+type EntryError struct {
+	UID     string
+	Summary string
+	Err     error
+}
+
+func (e EntryError) Error() string {
+	return fmt.Sprintf("%q: %v", e.Summary, e.Err)
+}
+
+// Entries reads the VTODOs and VEVENTs of a parsed calendar, interpreting
+// floating times in loc. Entries that cannot be read are returned as errors
+// next to the ones that can.
+func Entries(root *Component, loc *time.Location) ([]Entry, []EntryError) {
+	return EntriesOn(root, loc, civil.Date{})
+}
+
+// EntriesOn is Entries, dating entries without a date on day instead of
+// reporting them as errors; a zero day reports them
+func EntriesOn(root *Component, loc *time.Location, day civil.Date) ([]Entry, []EntryError) {
+	var entries []Entry
+	var errs []EntryError
+
+	var walk func(c *Component)
+	walk = func(c *Component) {
+		for _, child := range c.Children {
+			switch child.Name {
+			case "VTODO", "VEVENT":
+				entry, err := readEntry(child, loc, day)
+				if err != nil {
+					errs = append(errs, EntryError{UID: child.Text("UID"), Summary: child.Text("SUMMARY"), Err: err})
+					continue
+				}
+				entries = append(entries, entry)
+			case "VCALENDAR":
+				walk(child)
+			}
+		}
+	}
+	walk(root)
+	return entries, errs
+}
+
+func readEntry(c *Component, loc *time.Location, day civil.Date) (Entry, error) {
+	entry := Entry{
+		UID:         c.Text("UID"),
+		Kind:        c.Name,
+		Title:       strings.TrimSpace(c.Text("SUMMARY")),
+		Description: c.Text("DESCRIPTION"),
+	}
+	if entry.Title == "" {
+		entry.Title = "Untitled"
+	}
+	entry.Tags = models.NormalizeTags(categories(c))
+
+	when := c.Prop("DTSTART")
+	if c.Name == "VTODO" {
+		if due := c.Prop("DUE"); due != nil {
+			when = due
+		}
+		entry.Done = strings.EqualFold(c.Text("STATUS"), "COMPLETED") || c.Prop("COMPLETED") != nil
+	}
+	switch {
+	case when != nil:
+		var err error
+		entry.Date, entry.DueTime, err = dateTime(when, loc)
+		if err != nil {
+			return entry, err
+		}
+	case day.IsValid():
+		entry.Date = day
+	default:
+		return entry, errors.New("has no date")
+	}
+
+	if rrule := c.Prop("RRULE"); rrule != nil {
+		if !ValidRecurrence(rrule.Value) {
+			return entry, fmt.Errorf("unsupported recurrence %q", rrule.Value)
+		}
+		entry.Recurrence = rrule.Value
+	}
+
+	for _, alarm := range c.Children {
+		if alarm.Name != "VALARM" {
+			continue
+		}
+		if reminder, ok := readAlarm(alarm, entry, loc); ok {
+			entry.Reminders = append(entry.Reminders, reminder)
+		}
+	}
+	return entry, nil
+}
+
+// categories reads the values of every CATEGORIES property, which are
+// comma separated TEXT lists
+func categories(c *Component) []string {
+	var values []string
+	for _, p := range c.Properties {
+		if p.Name != "CATEGORIES" {
+			continue
+		}
+		start := 0
+		for i := 0; i <= len(p.Value); i++ {
+			switch {
+			case i+1 < len(p.Value) && p.Value[i] == '\\':
+				i++ // skip the escaped character, which may be a comma
+			case i == len(p.Value) || p.Value[i] == ',':
+				values = append(values, UnescapeText(p.Value[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return values
+}
+
+// readAlarm maps a VALARM to a reminder when the task model can express it:
+// a trigger before the due time, or an absolute time on the task's day
+func readAlarm(alarm *Component, entry Entry, loc *time.Location) (models.Reminder, bool) {
+	trigger := alarm.Prop("TRIGGER")
+	if trigger == nil {
+		return models.Reminder{}, false
+	}
+
+	if strings.EqualFold(trigger.Params["VALUE"], "DATE-TIME") {
+		day, at, err := dateTime(trigger, loc)
+		if err != nil || at == "" || day != entry.Date {
+			return models.Reminder{}, false
+		}
+		return models.Reminder{At: at}, true
+	}
+
+	d, err := parseDuration(trigger.Value)
+	if err != nil || d > 0 || entry.DueTime == "" {
+		return models.Reminder{}, false
+	}
+	return models.Reminder{MinutesBefore: int(-d / time.Minute)}, true
+}
+
+// dateTime reads a DATE or DATE-TIME value as a day and, for DATE-TIMEs, a
+// "15:04" time in loc
+func dateTime(p *Property, loc *time.Location) (civil.Date, string, error) {
+	value := p.Value
+	if len(value) == 8 || strings.EqualFold(p.Params["VALUE"], "DATE") {
+		t, err := time.Parse("20060102", value)
+		if err != nil {
+			return civil.Date{}, "", fmt.Errorf("invalid date %q", value)
+		}
+		return civil.DateOf(t), "", nil
+	}
+
+	var t time.Time
+	var err error
+	switch {
+	case strings.HasSuffix(value, "Z"):
+		t, err = time.Parse("20060102T150405Z", value)
+	default:
+		in := loc
+		if tzid := p.Params["TZID"]; tzid != "" {
+			if named, err := time.LoadLocation(tzid); err == nil {
+				in = named
+			}
+		}
+		t, err = time.ParseInLocation("20060102T150405", value, in)
+	}
+	if err != nil {
+		return civil.Date{}, "", fmt.Errorf("invalid date-time %q", value)
+	}
+	t = t.In(loc)
+	return civil.DateOf(t), t.Format("15:04"), nil
+}
+
+var durationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseDuration reads an RFC 5545 DURATION such as -PT15M or -P1DT2H
+func parseDuration(s string) (time.Duration, error) {
+	m := durationPattern.FindStringSubmatch(strings.ToUpper(s))
+	if m == nil || strings.Join(m[2:], "") == "" || strings.HasSuffix(strings.ToUpper(s), "T") {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+
+	var d time.Duration
+	for i, unit := range []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if m[i+2] == "" {
+			continue
+		}
+		n, _ := strconv.Atoi(m[i+2])
+		d += time.Duration(n) * unit
+	}
+	if m[1] == "-" {
+		d = -d
+	}
+	return d, nil
+}
+
+var recurrencePattern = regexp.MustCompile(`^[A-Z]+=[A-Z0-9,+\-]+(;[A-Z]+=[A-Z0-9,+\-]+)*$`)
+
+// ValidRecurrence reports whether rule looks like an RRULE value with a FREQ part
+func ValidRecurrence(rule string) bool {
+	return recurrencePattern.MatchString(rule) && strings.Contains(";"+rule, ";FREQ=")
+}
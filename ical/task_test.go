@@ -0,0 +1,311 @@
+package ical
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/Zenk41/go-gin-htmx/models"
+)
+
+// loc is the owner's timezone, seven hours ahead of UTC
+var loc = time.FixedZone("UTC+7", 7*60*60)
+
+func day(s string) civil.Date {
+	d, err := civil.ParseDate(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func TestDateTime(t *testing.T) {
+	tests := []struct {
+		prop    Property
+		date    string
+		dueTime string
+		wantErr string
+	}{
+		{prop: Property{Value: "20261021"}, date: "2026-10-21"},
+		{prop: Property{Params: map[string]string{"VALUE": "DATE"}, Value: "20261021"}, date: "2026-10-21"},
+		{prop: Property{Params: map[string]string{"VALUE": "date"}, Value: "2026-10-21"}, wantErr: `invalid date "2026-10-21"`},
+		{prop: Property{Value: "20261341"}, wantErr: `invalid date "20261341"`},
+		// UTC times are converted to loc, which may change the day
+		{prop: Property{Value: "20261021T020000Z"}, date: "2026-10-21", dueTime: "09:00"},
+		{prop: Property{Value: "20261021T200000Z"}, date: "2026-10-22", dueTime: "03:00"},
+		// Floating times are read in loc
+		{prop: Property{Value: "20261021T090000"}, date: "2026-10-21", dueTime: "09:00"},
+		{prop: Property{Params: map[string]string{"TZID": "Europe/Berlin"}, Value: "20261021T090000"}, date: "2026-10-21", dueTime: "14:00"},
+		// Unknown zones fall back to loc
+		{prop: Property{Params: map[string]string{"TZID": "Custom/Office"}, Value: "20261021T090000"}, date: "2026-10-21", dueTime: "09:00"},
+		{prop: Property{Value: "20261021T0900"}, wantErr: `invalid date-time "20261021T0900"`},
+		{prop: Property{Value: "2026-10-21T09:00:00Z"}, wantErr: `invalid date-time "2026-10-21T09:00:00Z"`},
+	}
+	for _, tt := range tests {
+		prop := tt.prop
+		if prop.Params == nil {
+			prop.Params = map[string]string{}
+		}
+		date, dueTime, err := dateTime(&prop, loc)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("dateTime(%+v) error = %v, want %q", tt.prop, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("dateTime(%+v) failed: %v", tt.prop, err)
+			continue
+		}
+		if date.String() != tt.date || dueTime != tt.dueTime {
+			t.Errorf("dateTime(%+v) = %v %q, want %s %q", tt.prop, date, dueTime, tt.date, tt.dueTime)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "PT0S", want: 0},
+		{value: "-PT15M", want: -15 * time.Minute},
+		{value: "-pt5m", want: -5 * time.Minute},
+		{value: "+PT1H30M", want: 90 * time.Minute},
+		{value: "PT45S", want: 45 * time.Second},
+		{value: "-P1D", want: -24 * time.Hour},
+		{value: "-P1DT2H", want: -26 * time.Hour},
+		{value: "P2W", want: 14 * 24 * time.Hour},
+		{value: "", wantErr: true},
+		{value: "P", wantErr: true},
+		{value: "-P", wantErr: true},
+		{value: "PT", wantErr: true},
+		{value: "-pt", wantErr: true},
+		{value: "P1DT", wantErr: true},
+		{value: "-P1H", wantErr: true},
+		{value: "15M", wantErr: true},
+		{value: "-PT1.5H", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseDuration(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseDuration(%q) = %v, want an error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseDuration(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestValidRecurrence(t *testing.T) {
+	tests := []struct {
+		rule string
+		want bool
+	}{
+		{rule: "FREQ=DAILY", want: true},
+		{rule: "FREQ=WEEKLY;BYDAY=MO,WE", want: true},
+		{rule: "INTERVAL=2;FREQ=MONTHLY", want: true},
+		{rule: "FREQ=MONTHLY;BYDAY=-1FR", want: true},
+		{rule: "FREQ=YEARLY;COUNT=3", want: true},
+		{rule: "", want: false},
+		{rule: "BYDAY=MO", want: false},
+		{rule: "XFREQ=DAILY", want: false},
+		{rule: "freq=daily", want: false},
+		{rule: "FREQ=", want: false},
+		{rule: "FREQ=DAILY;", want: false},
+		{rule: "FREQ=DAILY;UNTIL=20261231T000000Z", want: true},
+		{rule: "FREQ=DAILY\nBEGIN:VALARM", want: false},
+		{rule: "every week", want: false},
+	}
+	for _, tt := range tests {
+		if got := ValidRecurrence(tt.rule); got != tt.want {
+			t.Errorf("ValidRecurrence(%q) = %v, want %v", tt.rule, got, tt.want)
+		}
+	}
+}
+
+const calendar = `BEGIN:VCALENDAR
+VERSION:2.0
+BEGIN:VTODO
+UID:report@example.com
+SUMMARY:  Quarterly report
+DESCRIPTION:Line one\nLine two\, with a comma
+DTSTART:20261020T020000Z
+DUE:20261021T020000Z
+STATUS:COMPLETED
+RRULE:FREQ=WEEKLY;BYDAY=WE
+CATEGORIES:Work,Deep Work
+CATEGORIES:work,Team\, East
+BEGIN:VALARM
+TRIGGER;RELATED=END:-PT15M
+END:VALARM
+BEGIN:VALARM
+TRIGGER;VALUE=DATE-TIME:20261021T010000Z
+END:VALARM
+BEGIN:VALARM
+TRIGGER:PT5M
+END:VALARM
+BEGIN:VALARM
+TRIGGER;VALUE=DATE-TIME:20261022T010000Z
+END:VALARM
+BEGIN:VALARM
+ACTION:DISPLAY
+END:VALARM
+END:VTODO
+BEGIN:VTODO
+UID:untitled@example.com
+DTSTART;VALUE=DATE:20261022
+COMPLETED:20261022T100000Z
+BEGIN:VALARM
+TRIGGER:-PT10M
+END:VALARM
+END:VTODO
+BEGIN:VEVENT
+UID:standup@example.com
+SUMMARY:Standup
+DTSTART:20261023T091500
+STATUS:COMPLETED
+END:VEVENT
+BEGIN:VJOURNAL
+SUMMARY:Notes
+END:VJOURNAL
+BEGIN:VTODO
+UID:undated@example.com
+SUMMARY:Someday
+END:VTODO
+BEGIN:VTODO
+UID:rule@example.com
+SUMMARY:Bad rule
+DUE;VALUE=DATE:20261021
+RRULE:BYDAY=MO
+END:VTODO
+BEGIN:VTODO
+UID:date@example.com
+SUMMARY:Bad date
+DUE:2026-10-21
+END:VTODO
+END:VCALENDAR
+`
+
+func TestEntries(t *testing.T) {
+	root, err := Parse(strings.NewReader(calendar))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := Entry{
+		UID:         "report@example.com",
+		Kind:        "VTODO",
+		Title:       "Quarterly report",
+		Description: "Line one\nLine two, with a comma",
+		Date:        day("2026-10-21"),
+		DueTime:     "09:00",
+		Done:        true,
+		Recurrence:  "FREQ=WEEKLY;BYDAY=WE",
+		Reminders:   []models.Reminder{{MinutesBefore: 15}, {At: "08:00"}},
+		Tags:        []string{"work", "deep-work", "team,-east"},
+	}
+	untitled := Entry{UID: "untitled@example.com", Kind: "VTODO", Title: "Untitled", Date: day("2026-10-22"), Done: true}
+	standup := Entry{UID: "standup@example.com", Kind: "VEVENT", Title: "Standup", Date: day("2026-10-23"), DueTime: "09:15"}
+	undated := Entry{UID: "undated@example.com", Kind: "VTODO", Title: "Someday", Date: day("2026-10-19")}
+
+	tests := []struct {
+		name    string
+		day     civil.Date
+		entries []Entry
+		errs    []string
+	}{
+		{
+			name:    "undated entries fail",
+			entries: []Entry{report, untitled, standup},
+			errs: []string{
+				`"Someday": has no date`,
+				`"Bad rule": unsupported recurrence "BYDAY=MO"`,
+				`"Bad date": invalid date-time "2026-10-21"`,
+			},
+		},
+		{
+			name:    "undated entries on a day",
+			day:     day("2026-10-19"),
+			entries: []Entry{report, untitled, standup, undated},
+			errs: []string{
+				`"Bad rule": unsupported recurrence "BYDAY=MO"`,
+				`"Bad date": invalid date-time "2026-10-21"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		entries, errs := EntriesOn(root, loc, tt.day)
+		if !reflect.DeepEqual(entries, tt.entries) {
+			t.Errorf("%s: entries\n got %+v\nwant %+v", tt.name, entries, tt.entries)
+		}
+		var got []string
+		for _, err := range errs {
+			got = append(got, err.Error())
+		}
+		if !reflect.DeepEqual(got, tt.errs) {
+			t.Errorf("%s: errors\n got %q\nwant %q", tt.name, got, tt.errs)
+		}
+	}
+
+	_, errs := Entries(root, loc)
+	if len(errs) != 3 || errs[0].UID != "undated@example.com" || errs[0].Summary != "Someday" {
+		t.Errorf("Entries errors = %+v", errs)
+	}
+}
+
+func TestObjectRoundTrip(t *testing.T) {
+	task := models.Task{
+		TaskID:      "t1",
+		Title:       "Milk; eggs, bread",
+		Description: "Aisle 3\r\nthen the till\rthen home\n" + strings.Repeat("日本語のメモ ", 20),
+		Status:      "done",
+		Date:        models.StoredDate(day("2026-10-21")),
+		DueTime:     "09:30",
+		Reminders:   []models.Reminder{{MinutesBefore: 15}, {MinutesBefore: 24 * 60}, {At: "08:00"}},
+		Recurrence:  "FREQ=WEEKLY;BYDAY=WE",
+		Tags:        []string{"shopping", "home,errands"},
+		UpdatedAt:   time.Date(2026, 10, 20, 12, 0, 0, 0, time.UTC),
+	}
+	want := Entry{
+		UID:         "t1@example.com",
+		Kind:        "VTODO",
+		Title:       "Milk; eggs, bread",
+		Description: "Aisle 3\nthen the till\nthen home\n" + strings.Repeat("日本語のメモ ", 20),
+		Date:        day("2026-10-21"),
+		DueTime:     "09:30",
+		Done:        true,
+		Recurrence:  "FREQ=WEEKLY;BYDAY=WE",
+		Reminders:   task.Reminders,
+		Tags:        []string{"shopping", "home,errands"},
+	}
+
+	for _, events := range []bool{false, true} {
+		opts := FeedOptions{Domain: "example.com", Events: events, Location: loc}
+		object := Object(task, opts)
+		root, err := Parse(bytes.NewReader(object))
+		if err != nil {
+			t.Fatalf("events %v: %v\n%s", events, err, object)
+		}
+		entries, errs := Entries(root, loc)
+		if len(errs) > 0 || len(entries) != 1 {
+			t.Fatalf("events %v: %+v %+v", events, entries, errs)
+		}
+
+		expected := want
+		if events {
+			// Events have no status, so done tasks are marked in the summary
+			expected.Kind, expected.Title, expected.Done = "VEVENT", "✔ "+want.Title, false
+		}
+		if !reflect.DeepEqual(entries[0], expected) {
+			t.Errorf("events %v:\n got %+v\nwant %+v", events, entries[0], expected)
+		}
+	}
+}
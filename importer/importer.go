@@ -0,0 +1,170 @@
+// Package importer reads task lists exported from other apps into task
+// payloads. Each format is a Parser registered under a name, so adding one
+// needs no change to the handlers or views.
+//
+// Parsing is a dry run: it only returns rows, each either a task ready for
+// TaskRepository.CreateTask (minus its ID, owner and timestamps) or the
+// reason the item cannot be imported.
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/Zenk41/go-gin-htmx/ical"
+	"github.com/Zenk41/go-gin-htmx/models"
+)
+
+// Options tells parsers how to place items in time
+type Options struct {
+	// Location is the user's timezone. Times with an offset are converted
+	// to it and times without one are read in it.
+	Location *time.Location
+	// Today is the day undated items are put on
+	Today civil.Date
+}
+
+// Row is one item of an export
+type Row struct {
+	// Ref points at the item in the source, e.g. "line 12"
+	Ref  string
+	Task models.TaskPayload
+	// Err explains why the item cannot be imported; Task is then incomplete
+	Err error
+}
+
+// Parser reads one export format
+type Parser interface {
+	// Name identifies the format in forms
+	Name() string
+	// Label describes the format to users
+	Label() string
+	// Parse reads a whole export. It fails only when the input is not in
+	// the format at all; problems with single items are reported on their
+	// rows.
+	Parse(r io.Reader, opts Options) ([]Row, error)
+}
+
+var parsers []Parser
+
+func init() {
+	Register(Todoist{})
+	Register(Trello{})
+	Register(Markdown{})
+}
+
+// Register adds a parser. It panics when the name is taken.
+func Register(p Parser) {
+	if Lookup(p.Name()) != nil {
+		panic("importer: parser " + p.Name() + " registered twice")
+	}
+	parsers = append(parsers, p)
+}
+
+// Parsers lists the registered parsers in registration order
+func Parsers() []Parser {
+	return append([]Parser(nil), parsers...)
+}
+
+// Lookup returns the parser called name, nil when there is none
+func Lookup(name string) Parser {
+	for _, p := range parsers {
+		if p.Name() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// Clean keeps the fields of task an import may set and checks them, so
+// previewed tasks posted back by the browser are held to the same rules as
+// freshly parsed ones
+func Clean(task models.TaskPayload) (models.TaskPayload, error) {
+	clean := models.TaskPayload{
+		Title:       strings.TrimSpace(task.Title),
+		Description: strings.TrimSpace(task.Description),
+		Status:      task.Status,
+		Date:        task.Date,
+		DueTime:     task.DueTime,
+		Recurrence:  task.Recurrence,
+		Tags:        models.NormalizeTags(task.Tags),
+	}
+	switch {
+	case clean.Title == "":
+		return clean, errors.New("has no title")
+	case clean.Status != "" && clean.Status != "done":
+		return clean, fmt.Errorf("unknown status %q", clean.Status)
+	case !clean.Day().IsValid() || clean.Date.IsZero():
+		return clean, errors.New("has no date")
+	case clean.Recurrence != "" && !ical.ValidRecurrence(clean.Recurrence):
+		return clean, fmt.Errorf("unsupported repeat rule %q", clean.Recurrence)
+	}
+	if clean.DueTime != "" {
+		if _, err := time.Parse("15:04", clean.DueTime); err != nil {
+			return clean, fmt.Errorf("invalid due time %q", clean.DueTime)
+		}
+	}
+	clean.Date = models.StoredDate(clean.Day())
+	return clean, nil
+}
+
+// row builds the row of a parsed task, undated tasks going on today
+func row(ref string, task models.TaskPayload, opts Options) Row {
+	if task.Date.IsZero() {
+		task.Date = models.StoredDate(opts.Today)
+	}
+	clean, err := Clean(task)
+	return Row{Ref: ref, Task: clean, Err: err}
+}
+
+// failed builds the row of an item that cannot be imported
+func failed(ref string, title string, err error) Row {
+	return Row{Ref: ref, Task: models.TaskPayload{Title: title}, Err: err}
+}
+
+// parseWhen reads the dates and times exports use: a plain date, or a date
+// and time with or without an offset. It returns the day and the "15:04"
+// time in opts.Location, empty for plain dates.
+func parseWhen(value string, opts Options) (civil.Date, string, error) {
+	value = strings.TrimSpace(value)
+	if d, err := civil.ParseDate(value); err == nil {
+		return d, "", nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04Z07:00"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			t = t.In(opts.Location)
+			return civil.DateOf(t), t.Format("15:04"), nil
+		}
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, value, opts.Location); err == nil {
+			return civil.DateOf(t), t.Format("15:04"), nil
+		}
+	}
+	return civil.Date{}, "", fmt.Errorf("date %q not understood", value)
+}
+
+// recurrences maps the plain repeat phrases of other apps to RRULE values
+var recurrences = map[string]string{
+	"every day":   "FREQ=DAILY",
+	"daily":       "FREQ=DAILY",
+	"every week":  "FREQ=WEEKLY",
+	"weekly":      "FREQ=WEEKLY",
+	"every month": "FREQ=MONTHLY",
+	"monthly":     "FREQ=MONTHLY",
+	"every year":  "FREQ=YEARLY",
+	"yearly":      "FREQ=YEARLY",
+}
+
+// doneLists are the list names whose items count as done
+var doneLists = map[string]bool{
+	"done":      true,
+	"complete":  true,
+	"completed": true,
+	"finished":  true,
+	"archive":   true,
+}
@@ -0,0 +1,190 @@
+package importer
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/Zenk41/go-gin-htmx/models"
+)
+
+// today is a Monday, and users are seven hours ahead of UTC
+var (
+	today = civil.Date{Year: 2026, Month: 10, Day: 19}
+	opts  = Options{Location: time.FixedZone("UTC+7", 7*60*60), Today: today}
+)
+
+// stored is the Date of a task payload on the day s
+func stored(s string) time.Time {
+	d, err := civil.ParseDate(s)
+	if err != nil {
+		panic(err)
+	}
+	return models.StoredDate(d)
+}
+
+// result is a row with its error as text, so rows compare with DeepEqual
+type result struct {
+	Ref  string
+	Task models.TaskPayload
+	Err  string
+}
+
+func results(rows []Row) []result {
+	var out []result
+	for _, r := range rows {
+		res := result{Ref: r.Ref, Task: r.Task}
+		if r.Err != nil {
+			res.Err = r.Err.Error()
+		}
+		out = append(out, res)
+	}
+	return out
+}
+
+func checkRows(t *testing.T, rows []Row, want []result) {
+	t.Helper()
+	got := results(rows)
+	if len(got) != len(want) {
+		t.Fatalf("got %d rows, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("row %d:\n got %+v\nwant %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParseWhen(t *testing.T) {
+	tests := []struct {
+		value   string
+		day     string
+		dueTime string
+		wantErr bool
+	}{
+		{value: "2026-10-21", day: "2026-10-21"},
+		{value: " 2026-10-21 ", day: "2026-10-21"},
+		// Times with an offset are converted to the user's timezone
+		{value: "2026-10-21T08:30:00Z", day: "2026-10-21", dueTime: "15:30"},
+		{value: "2026-10-21T20:30:00.123Z", day: "2026-10-22", dueTime: "03:30"},
+		{value: "2026-10-21T08:30:00+02:00", day: "2026-10-21", dueTime: "13:30"},
+		{value: "2026-10-21T08:30+02:00", day: "2026-10-21", dueTime: "13:30"},
+		// Times without one are read in it
+		{value: "2026-10-21T08:30:00", day: "2026-10-21", dueTime: "08:30"},
+		{value: "2026-10-21T08:30:00.5", day: "2026-10-21", dueTime: "08:30"},
+		{value: "2026-10-21T08:30", day: "2026-10-21", dueTime: "08:30"},
+		{value: "2026-10-21 08:30:00", day: "2026-10-21", dueTime: "08:30"},
+		{value: "2026-10-21 08:30", day: "2026-10-21", dueTime: "08:30"},
+		{value: "", wantErr: true},
+		{value: "21/10/2026", wantErr: true},
+		{value: "2026-02-30", wantErr: true},
+		{value: "2026-10-21 25:00", wantErr: true},
+		{value: "tomorrow", wantErr: true},
+	}
+	for _, tt := range tests {
+		day, dueTime, err := parseWhen(tt.value, opts)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseWhen(%q) = %v %q, want an error", tt.value, day, dueTime)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseWhen(%q) failed: %v", tt.value, err)
+			continue
+		}
+		if day.String() != tt.day || dueTime != tt.dueTime {
+			t.Errorf("parseWhen(%q) = %v %q, want %s %q", tt.value, day, dueTime, tt.day, tt.dueTime)
+		}
+	}
+}
+
+func TestClean(t *testing.T) {
+	tests := []struct {
+		name    string
+		task    models.TaskPayload
+		want    models.TaskPayload
+		wantErr string
+	}{
+		{
+			name: "trims and normalizes",
+			task: models.TaskPayload{
+				TaskID:      "kept out",
+				Title:       "  Plan trip ",
+				Description: " Book trains\n",
+				Status:      "done",
+				Date:        stored("2026-10-21"),
+				DueTime:     "09:00",
+				Recurrence:  "FREQ=WEEKLY;BYDAY=MO",
+				Tags:        []string{"#Travel", "travel", "Long Weekend", ""},
+			},
+			want: models.TaskPayload{
+				Title:       "Plan trip",
+				Description: "Book trains",
+				Status:      "done",
+				Date:        stored("2026-10-21"),
+				DueTime:     "09:00",
+				Recurrence:  "FREQ=WEEKLY;BYDAY=MO",
+				Tags:        []string{"travel", "long-weekend"},
+			},
+		},
+		{
+			name:    "no title",
+			task:    models.TaskPayload{Title: "  ", Date: stored("2026-10-21")},
+			wantErr: "has no title",
+		},
+		{
+			name:    "unknown status",
+			task:    models.TaskPayload{Title: "Plan trip", Status: "started", Date: stored("2026-10-21")},
+			wantErr: `unknown status "started"`,
+		},
+		{
+			name:    "no date",
+			task:    models.TaskPayload{Title: "Plan trip"},
+			wantErr: "has no date",
+		},
+		{
+			name:    "repeat rule without FREQ",
+			task:    models.TaskPayload{Title: "Plan trip", Date: stored("2026-10-21"), Recurrence: "BYDAY=MO"},
+			wantErr: `unsupported repeat rule "BYDAY=MO"`,
+		},
+		{
+			name:    "repeat phrase",
+			task:    models.TaskPayload{Title: "Plan trip", Date: stored("2026-10-21"), Recurrence: "every week"},
+			wantErr: `unsupported repeat rule "every week"`,
+		},
+		{
+			name:    "due time",
+			task:    models.TaskPayload{Title: "Plan trip", Date: stored("2026-10-21"), DueTime: "9am"},
+			wantErr: `invalid due time "9am"`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := Clean(tt.task)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for _, name := range []string{"todoist", "trello", "markdown"} {
+		if p := Lookup(name); p == nil || p.Name() != name {
+			t.Errorf("Lookup(%q) = %v", name, p)
+		}
+	}
+	if p := Lookup("asana"); p != nil {
+		t.Errorf("Lookup(\"asana\") = %v, want nil", p)
+	}
+}
@@ -0,0 +1,87 @@
+package importer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Zenk41/go-gin-htmx/models"
+)
+
+// Markdown reads task list items, "- [ ] open" and "- [x] done", from any
+// Markdown document. An item may carry a date, optionally with a time
+// ("2024-05-01", "due:2024-05-01 14:30" or "📅 2024-05-01") and #tags.
+// Items are also tagged with the heading they are under. Other lines are
+// ignored.
+type Markdown struct{}
+
+func (Markdown) Name() string  { return "markdown" }
+func (Markdown) Label() string { return "Markdown checklist" }
+
+var (
+	itemPattern    = regexp.MustCompile(`^\s*(?:[-*+]|\d+[.)])\s+\[(.)\]\s*(.*)$`)
+	headingPattern = regexp.MustCompile(`^#{1,6}\s+(.*?)\s*#*$`)
+	// Completion dates, as written by Obsidian, are not due dates
+	doneDatePattern = regexp.MustCompile(`✅\s*\d{4}-\d{2}-\d{2}`)
+	datePattern     = regexp.MustCompile(`(?:^|\s)(?:📅\s*|due:\s*|@)?(\d{4}-\d{2}-\d{2})(?:[ T](\d{1,2}:\d{2}))?(?:\s|$)`)
+	tagPattern      = regexp.MustCompile(`(?:^|\s)#([\p{L}\p{N}_/-]+)`)
+)
+
+func (Markdown) Parse(r io.Reader, opts Options) ([]Row, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+
+	var rows []Row
+	heading := ""
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if m := headingPattern.FindStringSubmatch(text); m != nil {
+			heading = m[1]
+			continue
+		}
+		m := itemPattern.FindStringSubmatch(text)
+		if m == nil {
+			continue
+		}
+
+		ref := "line " + strconv.Itoa(line)
+		task := models.TaskPayload{Tags: []string{heading}}
+		switch m[1] {
+		case " ":
+		case "x", "X":
+			task.Status = "done"
+		default:
+			rows = append(rows, failed(ref, strings.TrimSpace(m[2]), fmt.Errorf("unknown checkbox [%s]", m[1])))
+			continue
+		}
+
+		item := doneDatePattern.ReplaceAllString(m[2], " ")
+		if d := datePattern.FindStringSubmatchIndex(item); d != nil {
+			when := item[d[2]:d[3]]
+			if d[4] >= 0 {
+				when += " " + item[d[4]:d[5]]
+			}
+			item = item[:d[0]] + " " + item[d[1]:]
+
+			day, dueTime, err := parseWhen(when, opts)
+			if err != nil {
+				rows = append(rows, failed(ref, strings.TrimSpace(item), err))
+				continue
+			}
+			task.Date = models.StoredDate(day)
+			task.DueTime = dueTime
+		}
+		for _, tag := range tagPattern.FindAllStringSubmatch(item, -1) {
+			task.Tags = append(task.Tags, tag[1])
+		}
+		task.Title = strings.Join(strings.Fields(tagPattern.ReplaceAllString(item, " ")), " ")
+		rows = append(rows, row(ref, task, opts))
+	}
+	if err := scanner.Err(); err != nil {
+		return rows, err
+	}
+	return rows, nil
+}
@@ -0,0 +1,52 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Zenk41/go-gin-htmx/models"
+)
+
+func TestMarkdown(t *testing.T) {
+	export := strings.Join([]string{
+		"# Work",
+		"- [ ] Write report 2026-10-21 #q3",
+		"- [x] Send invoice due:2026-10-20 14:30",
+		"* [ ] Call Bob 📅 2026-10-22",
+		"1. [ ] Review @2026-10-23T09:15 #team/eng",
+		"- [X] Water plants ✅ 2026-10-18",
+		"- [?] Maybe",
+		"- [ ] Pay rent 2026-02-30",
+		"- [ ] Fix bug 2026-10-21 25:00",
+		"A paragraph with 2026-10-21 in it",
+		"- a plain list item",
+		"## Home ##",
+		"+ [ ] Fix sink",
+		"- [ ] #later",
+	}, "\n")
+
+	rows, err := Markdown{}.Parse(strings.NewReader(export), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRows(t, rows, []result{
+		{Ref: "line 2", Task: models.TaskPayload{Title: "Write report", Date: stored("2026-10-21"), Tags: []string{"work", "q3"}}},
+		{Ref: "line 3", Task: models.TaskPayload{Title: "Send invoice", Status: "done", Date: stored("2026-10-20"), DueTime: "14:30", Tags: []string{"work"}}},
+		{Ref: "line 4", Task: models.TaskPayload{Title: "Call Bob", Date: stored("2026-10-22"), Tags: []string{"work"}}},
+		{Ref: "line 5", Task: models.TaskPayload{Title: "Review", Date: stored("2026-10-23"), DueTime: "09:15", Tags: []string{"work", "team/eng"}}},
+		// The completion date is not a due date
+		{Ref: "line 6", Task: models.TaskPayload{Title: "Water plants", Status: "done", Date: stored("2026-10-19"), Tags: []string{"work"}}},
+		{Ref: "line 7", Task: models.TaskPayload{Title: "Maybe"}, Err: "unknown checkbox [?]"},
+		{Ref: "line 8", Task: models.TaskPayload{Title: "Pay rent"}, Err: `date "2026-02-30" not understood`},
+		{Ref: "line 9", Task: models.TaskPayload{Title: "Fix bug"}, Err: `date "2026-10-21 25:00" not understood`},
+		{Ref: "line 13", Task: models.TaskPayload{Title: "Fix sink", Date: stored("2026-10-19"), Tags: []string{"home"}}},
+		{Ref: "line 14", Task: models.TaskPayload{Date: stored("2026-10-19"), Tags: []string{"home", "later"}}, Err: "has no title"},
+	})
+}
+
+func TestMarkdownWithoutItems(t *testing.T) {
+	rows, err := Markdown{}.Parse(strings.NewReader("# Notes\n\nNothing to do.\n"), opts)
+	if err != nil || len(rows) != 0 {
+		t.Errorf("Parse = %+v, %v, want no rows", rows, err)
+	}
+}
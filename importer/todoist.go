@@ -0,0 +1,254 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/Zenk41/go-gin-htmx/models"
+)
+
+// Todoist reads the CSV export of a Todoist project and the task JSON of
+// its APIs. Labels become tags, sections tag the tasks below them and
+// priorities p1 to p3 become the tags "p1" to "p3".
+type Todoist struct{}
+
+func (Todoist) Name() string  { return "todoist" }
+func (Todoist) Label() string { return "Todoist (CSV or JSON)" }
+
+func (t Todoist) Parse(r io.Reader, opts Options) ([]Row, error) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return nil, errors.New("the file is empty")
+		}
+		switch {
+		case b[0] == '[' || b[0] == '{':
+			return t.parseJSON(br, opts)
+		case strings.IndexByte(" \t\r\n", b[0]) >= 0 || b[0] == 0xEF:
+			// Skip leading white space and the byte order mark Todoist writes
+			if _, _, err := br.ReadRune(); err != nil {
+				return nil, err
+			}
+		default:
+			return t.parseCSV(br, opts)
+		}
+	}
+}
+
+func (Todoist) parseCSV(r io.Reader, opts Options) ([]Row, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("not a Todoist CSV export: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToUpper(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["TYPE"]; !ok {
+		return nil, errors.New("not a Todoist CSV export: no TYPE column")
+	}
+	if _, ok := columns["CONTENT"]; !ok {
+		return nil, errors.New("not a Todoist CSV export: no CONTENT column")
+	}
+
+	var rows []Row
+	section := ""
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return rows, fmt.Errorf("row %d: %w", line, err)
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		ref := "row " + strconv.Itoa(line)
+		switch strings.ToLower(field("TYPE")) {
+		case "":
+			continue // Todoist separates sections with blank rows
+		case "section":
+			section = field("CONTENT")
+			continue
+		case "note":
+			// Comments belong to the task above them
+			if n := len(rows); n > 0 && rows[n-1].Err == nil {
+				task := &rows[n-1].Task
+				task.Description = strings.TrimSpace(task.Description + "\n\n" + field("CONTENT"))
+			}
+			continue
+		case "task":
+		default:
+			rows = append(rows, failed(ref, field("CONTENT"), fmt.Errorf("unknown row type %q", field("TYPE"))))
+			continue
+		}
+
+		title, labels := splitLabels(field("CONTENT"))
+		task := models.TaskPayload{
+			Title:       title,
+			Description: field("DESCRIPTION"),
+			Tags:        append(labels, section),
+		}
+		if priority, err := strconv.Atoi(field("PRIORITY")); err == nil && priority >= 1 && priority <= 3 {
+			// The CSV counts priorities like the app, 1 being p1
+			task.Tags = append(task.Tags, "p"+strconv.Itoa(priority))
+		}
+		if err := readTodoistDate(&task, field("DATE"), opts); err != nil {
+			rows = append(rows, failed(ref, title, err))
+			continue
+		}
+		rows = append(rows, row(ref, task, opts))
+	}
+}
+
+// splitLabels takes the @labels Todoist writes into CSV task names out of
+// the title
+func splitLabels(content string) (string, []string) {
+	var words, labels []string
+	for _, word := range strings.Fields(content) {
+		if len(word) > 1 && word[0] == '@' {
+			labels = append(labels, word[1:])
+			continue
+		}
+		words = append(words, word)
+	}
+	return strings.Join(words, " "), labels
+}
+
+// readTodoistDate reads the DATE column, which holds what the user typed:
+// a date, "today", "tomorrow" or a repeat such as "every week"
+func readTodoistDate(task *models.TaskPayload, value string, opts Options) error {
+	lower := strings.ToLower(strings.TrimSpace(value))
+	switch {
+	case lower == "":
+		return nil
+	case lower == "today":
+		task.Date = models.StoredDate(opts.Today)
+		return nil
+	case lower == "tomorrow":
+		task.Date = models.StoredDate(opts.Today.AddDays(1))
+		return nil
+	case recurrences[lower] != "":
+		task.Date = models.StoredDate(opts.Today)
+		task.Recurrence = recurrences[lower]
+		return nil
+	}
+
+	if day, dueTime, err := parseWhen(value, opts); err == nil {
+		task.Date = models.StoredDate(day)
+		task.DueTime = dueTime
+		return nil
+	}
+	for _, layout := range []string{"Jan 2 2006", "2 Jan 2006", "January 2 2006", "2 January 2006"} {
+		if t, err := time.Parse(layout, strings.ReplaceAll(value, ",", "")); err == nil {
+			task.Date = models.StoredDate(civil.DateOf(t))
+			return nil
+		}
+	}
+	for _, layout := range []string{"Jan 2", "2 Jan", "January 2", "2 January"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			task.Date = models.StoredDate(civil.Date{Year: opts.Today.Year, Month: t.Month(), Day: t.Day()})
+			return nil
+		}
+	}
+	return fmt.Errorf("date %q not understood, use YYYY-MM-DD", value)
+}
+
+// todoistTask is a task of the REST API, or an item of the Sync API
+type todoistTask struct {
+	Content     string   `json:"content"`
+	Description string   `json:"description"`
+	Labels      []string `json:"labels"`
+	Priority    int      `json:"priority"`
+	IsCompleted bool     `json:"is_completed"`
+	Checked     bool     `json:"checked"`
+	IsDeleted   bool     `json:"is_deleted"`
+	Due         *struct {
+		Date        string `json:"date"`
+		Datetime    string `json:"datetime"`
+		String      string `json:"string"`
+		IsRecurring bool   `json:"is_recurring"`
+	} `json:"due"`
+}
+
+func (Todoist) parseJSON(r io.Reader, opts Options) ([]Row, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Accept a bare list of tasks as well as the objects wrapping them
+	var tasks []todoistTask
+	if err := json.Unmarshal(data, &tasks); err != nil {
+		var wrapped struct {
+			Items   []todoistTask `json:"items"`
+			Tasks   []todoistTask `json:"tasks"`
+			Results []todoistTask `json:"results"`
+		}
+		if err := json.Unmarshal(data, &wrapped); err != nil {
+			return nil, fmt.Errorf("not a Todoist JSON export: %w", err)
+		}
+		tasks = append(append(wrapped.Items, wrapped.Tasks...), wrapped.Results...)
+		if tasks == nil {
+			return nil, errors.New("not a Todoist JSON export: no tasks found")
+		}
+	}
+
+	var rows []Row
+	for i, item := range tasks {
+		ref := "task " + strconv.Itoa(i+1)
+		if item.IsDeleted {
+			rows = append(rows, failed(ref, item.Content, errors.New("deleted in Todoist")))
+			continue
+		}
+
+		task := models.TaskPayload{
+			Title:       item.Content,
+			Description: item.Description,
+			Tags:        item.Labels,
+		}
+		if item.IsCompleted || item.Checked {
+			task.Status = "done"
+		}
+		if item.Priority >= 2 && item.Priority <= 4 {
+			// The API counts the other way round, 4 being p1
+			task.Tags = append(task.Tags, "p"+strconv.Itoa(5-item.Priority))
+		}
+		if item.Due != nil {
+			when := item.Due.Datetime
+			if when == "" {
+				when = item.Due.Date
+			}
+			day, dueTime, err := parseWhen(when, opts)
+			if err != nil {
+				rows = append(rows, failed(ref, item.Content, err))
+				continue
+			}
+			task.Date = models.StoredDate(day)
+			task.DueTime = dueTime
+			if item.Due.IsRecurring {
+				task.Recurrence = recurrences[strings.ToLower(strings.TrimSpace(item.Due.String))]
+			}
+		}
+		rows = append(rows, row(ref, task, opts))
+	}
+	return rows, nil
+}
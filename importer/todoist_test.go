@@ -0,0 +1,161 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Zenk41/go-gin-htmx/models"
+)
+
+func TestReadTodoistDate(t *testing.T) {
+	tests := []struct {
+		value      string
+		date       string
+		dueTime    string
+		recurrence string
+		wantErr    bool
+	}{
+		{value: ""},
+		{value: "today", date: "2026-10-19"},
+		{value: "Tomorrow", date: "2026-10-20"},
+		// Repeats start today
+		{value: "every day", date: "2026-10-19", recurrence: "FREQ=DAILY"},
+		{value: "Daily", date: "2026-10-19", recurrence: "FREQ=DAILY"},
+		{value: " every week ", date: "2026-10-19", recurrence: "FREQ=WEEKLY"},
+		{value: "weekly", date: "2026-10-19", recurrence: "FREQ=WEEKLY"},
+		{value: "every month", date: "2026-10-19", recurrence: "FREQ=MONTHLY"},
+		{value: "monthly", date: "2026-10-19", recurrence: "FREQ=MONTHLY"},
+		{value: "every year", date: "2026-10-19", recurrence: "FREQ=YEARLY"},
+		{value: "yearly", date: "2026-10-19", recurrence: "FREQ=YEARLY"},
+		{value: "2026-10-22", date: "2026-10-22"},
+		{value: "2026-10-22 15:00", date: "2026-10-22", dueTime: "15:00"},
+		{value: "Nov 5 2026", date: "2026-11-05"},
+		{value: "Nov 5, 2026", date: "2026-11-05"},
+		{value: "5 Nov 2026", date: "2026-11-05"},
+		{value: "November 5, 2026", date: "2026-11-05"},
+		{value: "5 November 2026", date: "2026-11-05"},
+		// Dates without a year are in this year
+		{value: "Dec 24", date: "2026-12-24"},
+		{value: "24 Dec", date: "2026-12-24"},
+		{value: "December 24", date: "2026-12-24"},
+		{value: "24 December", date: "2026-12-24"},
+		{value: "every other tuesday", wantErr: true},
+		{value: "next blue moon", wantErr: true},
+		{value: "24/12/2026", wantErr: true},
+	}
+	for _, tt := range tests {
+		var task models.TaskPayload
+		err := readTodoistDate(&task, tt.value, opts)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("readTodoistDate(%q) = %+v, want an error", tt.value, task)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("readTodoistDate(%q) failed: %v", tt.value, err)
+			continue
+		}
+		date := ""
+		if !task.Date.IsZero() {
+			date = task.Day().String()
+		}
+		if date != tt.date || task.DueTime != tt.dueTime || task.Recurrence != tt.recurrence {
+			t.Errorf("readTodoistDate(%q) = %s %q %q, want %s %q %q", tt.value,
+				date, task.DueTime, task.Recurrence, tt.date, tt.dueTime, tt.recurrence)
+		}
+	}
+}
+
+func TestTodoistCSV(t *testing.T) {
+	// The byte order mark and blank rows are what Todoist writes
+	export := "\ufeffTYPE,CONTENT,DESCRIPTION,PRIORITY,INDENT,AUTHOR,RESPONSIBLE,DATE,DATE_LANG,TIMEZONE\n" +
+		"section,Errands,,,,,,,,\n" +
+		"task,Buy milk @shopping,,1,1,,,today,en,\n" +
+		"note,Semi-skimmed,,,,,,,,\n" +
+		"task,Pay rent,,4,1,,,every month,en,\n" +
+		"task,Dentist,Bring card,2,1,,,2026-10-22 15:00,en,\n" +
+		"task,Passport,,3,1,,,\"Nov 5, 2026\",en,\n" +
+		"task,Someday,,4,1,,,next blue moon,en,\n" +
+		",,,,,,,,,\n" +
+		"project,Inbox,,,,,,,,\n" +
+		"task,@waiting,,4,1,,,,en,\n"
+
+	rows, err := Todoist{}.Parse(strings.NewReader(export), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRows(t, rows, []result{
+		{Ref: "row 3", Task: models.TaskPayload{Title: "Buy milk", Description: "Semi-skimmed", Date: stored("2026-10-19"), Tags: []string{"shopping", "errands", "p1"}}},
+		{Ref: "row 5", Task: models.TaskPayload{Title: "Pay rent", Date: stored("2026-10-19"), Recurrence: "FREQ=MONTHLY", Tags: []string{"errands"}}},
+		{Ref: "row 6", Task: models.TaskPayload{Title: "Dentist", Description: "Bring card", Date: stored("2026-10-22"), DueTime: "15:00", Tags: []string{"errands", "p2"}}},
+		{Ref: "row 7", Task: models.TaskPayload{Title: "Passport", Date: stored("2026-11-05"), Tags: []string{"errands", "p3"}}},
+		{Ref: "row 8", Task: models.TaskPayload{Title: "Someday"}, Err: `date "next blue moon" not understood, use YYYY-MM-DD`},
+		{Ref: "row 10", Task: models.TaskPayload{Title: "Inbox"}, Err: `unknown row type "project"`},
+		{Ref: "row 11", Task: models.TaskPayload{Date: stored("2026-10-19"), Tags: []string{"waiting", "errands"}}, Err: "has no title"},
+	})
+}
+
+func TestTodoistJSON(t *testing.T) {
+	export := `[
+		{"content": "Write report", "description": "Q3", "labels": ["Work"], "priority": 4, "due": {"date": "2026-10-21"}},
+		{"content": "Standup", "priority": 1, "due": {"date": "2026-10-21", "datetime": "2026-10-21T02:00:00Z", "string": "every week", "is_recurring": true}},
+		{"content": "Stretch", "due": {"date": "2026-10-21", "string": "every other day", "is_recurring": true}},
+		{"content": "Old", "is_completed": true},
+		{"content": "Older", "checked": true, "priority": 3},
+		{"content": "Gone", "is_deleted": true},
+		{"content": "Whenever", "due": {"date": "someday"}}
+	]`
+
+	rows, err := Todoist{}.Parse(strings.NewReader(export), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRows(t, rows, []result{
+		{Ref: "task 1", Task: models.TaskPayload{Title: "Write report", Description: "Q3", Date: stored("2026-10-21"), Tags: []string{"work", "p1"}}},
+		{Ref: "task 2", Task: models.TaskPayload{Title: "Standup", Date: stored("2026-10-21"), DueTime: "09:00", Recurrence: "FREQ=WEEKLY"}},
+		// Repeats without an RRULE equivalent are imported once
+		{Ref: "task 3", Task: models.TaskPayload{Title: "Stretch", Date: stored("2026-10-21")}},
+		{Ref: "task 4", Task: models.TaskPayload{Title: "Old", Status: "done", Date: stored("2026-10-19")}},
+		{Ref: "task 5", Task: models.TaskPayload{Title: "Older", Status: "done", Date: stored("2026-10-19"), Tags: []string{"p2"}}},
+		{Ref: "task 6", Task: models.TaskPayload{Title: "Gone"}, Err: "deleted in Todoist"},
+		{Ref: "task 7", Task: models.TaskPayload{Title: "Whenever"}, Err: `date "someday" not understood`},
+	})
+}
+
+func TestTodoistJSONWrapped(t *testing.T) {
+	for _, export := range []string{
+		`{"items": [{"content": "Call mum"}]}`,
+		`{"tasks": [{"content": "Call mum"}]}`,
+		`{"results": [{"content": "Call mum"}], "next_cursor": null}`,
+	} {
+		rows, err := Todoist{}.Parse(strings.NewReader(export), opts)
+		if err != nil {
+			t.Errorf("%s: %v", export, err)
+			continue
+		}
+		checkRows(t, rows, []result{
+			{Ref: "task 1", Task: models.TaskPayload{Title: "Call mum", Date: stored("2026-10-19")}},
+		})
+	}
+}
+
+func TestTodoistNotAnExport(t *testing.T) {
+	tests := []struct {
+		export  string
+		wantErr string
+	}{
+		{export: "", wantErr: "the file is empty"},
+		{export: " \n", wantErr: "the file is empty"},
+		{export: `{"projects": []}`, wantErr: "not a Todoist JSON export: no tasks found"},
+		{export: `[{"content": 1}]`, wantErr: "not a Todoist JSON export: "},
+		{export: "TITLE,DATE\nCall mum,today\n", wantErr: "not a Todoist CSV export: no TYPE column"},
+		{export: "TYPE,TITLE\ntask,Call mum\n", wantErr: "not a Todoist CSV export: no CONTENT column"},
+	}
+	for _, tt := range tests {
+		rows, err := Todoist{}.Parse(strings.NewReader(tt.export), opts)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+			t.Errorf("Parse(%q) = %v, %v, want error %q", tt.export, rows, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Zenk41/go-gin-htmx/models"
+)
+
+// Trello reads the JSON export of a Trello board. Cards become tasks tagged
+// with their labels and list; cards marked complete or sitting in a list
+// such as "Done" are imported as done. Checklists are appended to the
+// description as Markdown.
+type Trello struct{}
+
+func (Trello) Name() string  { return "trello" }
+func (Trello) Label() string { return "Trello board (JSON)" }
+
+type trelloBoard struct {
+	Lists []struct {
+		ID     string `json:"id"`
+		Name   string `json:"name"`
+		Closed bool   `json:"closed"`
+	} `json:"lists"`
+	Cards []struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		Desc        string `json:"desc"`
+		Closed      bool   `json:"closed"`
+		IDList      string `json:"idList"`
+		Due         string `json:"due"`
+		DueComplete bool   `json:"dueComplete"`
+		Labels      []struct {
+			Name  string `json:"name"`
+			Color string `json:"color"`
+		} `json:"labels"`
+	} `json:"cards"`
+	Checklists []struct {
+		IDCard     string `json:"idCard"`
+		Name       string `json:"name"`
+		CheckItems []struct {
+			Name  string `json:"name"`
+			State string `json:"state"`
+		} `json:"checkItems"`
+	} `json:"checklists"`
+}
+
+func (Trello) Parse(r io.Reader, opts Options) ([]Row, error) {
+	var board trelloBoard
+	if err := json.NewDecoder(r).Decode(&board); err != nil {
+		return nil, fmt.Errorf("not a Trello board export: %w", err)
+	}
+	if board.Lists == nil && board.Cards == nil {
+		return nil, errors.New("not a Trello board export: no lists or cards found")
+	}
+
+	type list struct {
+		name   string
+		closed bool
+	}
+	lists := map[string]list{}
+	for _, l := range board.Lists {
+		lists[l.ID] = list{name: l.Name, closed: l.Closed}
+	}
+	checklists := map[string][]string{}
+	for _, c := range board.Checklists {
+		lines := []string{"**" + c.Name + "**"}
+		for _, item := range c.CheckItems {
+			box := "[ ]"
+			if item.State == "complete" {
+				box = "[x]"
+			}
+			lines = append(lines, "- "+box+" "+item.Name)
+		}
+		checklists[c.IDCard] = append(checklists[c.IDCard], strings.Join(lines, "\n"))
+	}
+
+	var rows []Row
+	for _, card := range board.Cards {
+		ref := fmt.Sprintf("card %q", card.Name)
+		in := lists[card.IDList]
+		if card.Closed || in.closed {
+			rows = append(rows, failed(ref, card.Name, errors.New("archived in Trello")))
+			continue
+		}
+
+		task := models.TaskPayload{
+			Title:       card.Name,
+			Description: strings.Join(append([]string{card.Desc}, checklists[card.ID]...), "\n\n"),
+			Tags:        []string{in.name},
+		}
+		for _, label := range card.Labels {
+			if label.Name != "" {
+				task.Tags = append(task.Tags, label.Name)
+			} else {
+				task.Tags = append(task.Tags, label.Color)
+			}
+		}
+		if card.DueComplete || doneLists[strings.ToLower(strings.TrimSpace(in.name))] {
+			task.Status = "done"
+		}
+		if card.Due != "" {
+			day, dueTime, err := parseWhen(card.Due, opts)
+			if err != nil {
+				rows = append(rows, failed(ref, card.Name, err))
+				continue
+			}
+			task.Date = models.StoredDate(day)
+			task.DueTime = dueTime
+		}
+		rows = append(rows, row(ref, task, opts))
+	}
+	return rows, nil
+}
@@ -0,0 +1,99 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Zenk41/go-gin-htmx/models"
+)
+
+func TestTrello(t *testing.T) {
+	export := `{
+		"lists": [
+			{"id": "l1", "name": "To do"},
+			{"id": "l2", "name": "Done"},
+			{"id": "l3", "name": "Ideas", "closed": true}
+		],
+		"cards": [
+			{"id": "c1", "name": "Plan launch", "desc": "Agenda", "idList": "l1", "due": "2026-10-21T08:00:00.000Z",
+				"labels": [{"name": "Work", "color": "blue"}, {"name": "", "color": "green"}]},
+			{"id": "c2", "name": "Ship beta", "idList": "l2"},
+			{"id": "c3", "name": "Review", "idList": "l1", "dueComplete": true},
+			{"id": "c4", "name": "Old card", "idList": "l1", "closed": true},
+			{"id": "c5", "name": "Idea", "idList": "l3"},
+			{"id": "c6", "name": "Call", "idList": "l1", "due": "tomorrow"},
+			{"id": "c7", "name": " ", "idList": "l1"}
+		],
+		"checklists": [
+			{"idCard": "c1", "name": "Steps", "checkItems": [
+				{"name": "Book room", "state": "complete"},
+				{"name": "Send invites", "state": "incomplete"}
+			]}
+		]
+	}`
+
+	rows, err := Trello{}.Parse(strings.NewReader(export), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRows(t, rows, []result{
+		{Ref: `card "Plan launch"`, Task: models.TaskPayload{
+			Title:       "Plan launch",
+			Description: "Agenda\n\n**Steps**\n- [x] Book room\n- [ ] Send invites",
+			Date:        stored("2026-10-21"),
+			DueTime:     "15:00",
+			Tags:        []string{"to-do", "work", "green"},
+		}},
+		{Ref: `card "Ship beta"`, Task: models.TaskPayload{Title: "Ship beta", Status: "done", Date: stored("2026-10-19"), Tags: []string{"done"}}},
+		{Ref: `card "Review"`, Task: models.TaskPayload{Title: "Review", Status: "done", Date: stored("2026-10-19"), Tags: []string{"to-do"}}},
+		{Ref: `card "Old card"`, Task: models.TaskPayload{Title: "Old card"}, Err: "archived in Trello"},
+		{Ref: `card "Idea"`, Task: models.TaskPayload{Title: "Idea"}, Err: "archived in Trello"},
+		{Ref: `card "Call"`, Task: models.TaskPayload{Title: "Call"}, Err: `date "tomorrow" not understood`},
+		{Ref: `card " "`, Task: models.TaskPayload{Date: stored("2026-10-19"), Tags: []string{"to-do"}}, Err: "has no title"},
+	})
+}
+
+func TestTrelloDoneLists(t *testing.T) {
+	tests := []struct {
+		list string
+		done bool
+	}{
+		{list: "Done", done: true},
+		{list: "done", done: true},
+		{list: " Complete ", done: true},
+		{list: "Completed", done: true},
+		{list: "Finished", done: true},
+		{list: "ARCHIVE", done: true},
+		{list: "Doing", done: false},
+		{list: "Done soon", done: false},
+		{list: "", done: false},
+	}
+	for _, tt := range tests {
+		export := `{"lists": [{"id": "l1", "name": "` + tt.list + `"}], "cards": [{"name": "Card", "idList": "l1"}]}`
+		rows, err := Trello{}.Parse(strings.NewReader(export), opts)
+		if err != nil || len(rows) != 1 || rows[0].Err != nil {
+			t.Errorf("list %q: %+v, %v", tt.list, rows, err)
+			continue
+		}
+		if done := rows[0].Task.Status == "done"; done != tt.done {
+			t.Errorf("list %q: done = %v, want %v", tt.list, done, tt.done)
+		}
+	}
+}
+
+func TestTrelloNotAnExport(t *testing.T) {
+	tests := []struct {
+		export  string
+		wantErr string
+	}{
+		{export: "", wantErr: "not a Trello board export: EOF"},
+		{export: "- [ ] Call mum", wantErr: "not a Trello board export: "},
+		{export: `{"name": "Board"}`, wantErr: "not a Trello board export: no lists or cards found"},
+	}
+	for _, tt := range tests {
+		rows, err := Trello{}.Parse(strings.NewReader(tt.export), opts)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+			t.Errorf("Parse(%q) = %v, %v, want error %q", tt.export, rows, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Zenk41/go-gin-htmx/config"
+	"github.com/Zenk41/go-gin-htmx/telemetry"
+	"github.com/sirupsen/logrus"
+)
+
+// Keys under which request scoped values are stored in the gin context.
+const (
+	RequestIDKey = "request_id"
+	UserIDKey    = "user_id"
+)
+
+type contextKey struct{}
+
+// Setup configures the shared logrus standard logger from conf and returns
+// it, so the request logger and every package-level logrus call write the
+// same way to the same place.
+func Setup(conf config.LogConfig) (*logrus.Logger, error) {
+	logger := logrus.StandardLogger()
+
+	level, err := logrus.ParseLevel(conf.Level)
+	if err != nil {
+		return nil, err
+	}
+	logger.SetLevel(level)
+
+	switch conf.Format {
+	case "json":
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	case "text":
+		logger.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	default:
+		return nil, fmt.Errorf("unknown log format %q", conf.Format)
+	}
+
+	var out io.Writer
+	switch conf.Output {
+	case "stdout":
+		out = os.Stdout
+	case "stderr":
+		out = os.Stderr
+	default:
+		f, err := os.OpenFile(conf.Output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		out = f
+	}
+	logger.SetOutput(out)
+
+	return logger, nil
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, requestID)
+}
+
+// RequestID returns the request ID carried by ctx, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// FromContext returns an entry of the shared logger annotated with the
+// request and trace IDs carried by ctx.
+func FromContext(ctx context.Context) *logrus.Entry {
+	fields := logrus.Fields{}
+	if id := RequestID(ctx); id != "" {
+		fields[RequestIDKey] = id
+	}
+	if id := telemetry.TraceID(ctx); id != "" {
+		fields["trace_id"] = id
+	}
+	return logrus.WithContext(ctx).WithFields(fields)
+}
@@ -0,0 +1,66 @@
+package logging
+
+import (
+	"net/url"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// Redactor masks the values of sensitive keys before they are logged.
+type Redactor struct {
+	keys map[string]bool
+}
+
+// NewRedactor builds a Redactor matching keys case-insensitively.
+func NewRedactor(keys []string) *Redactor {
+	r := &Redactor{keys: make(map[string]bool, len(keys))}
+	for _, key := range keys {
+		r.keys[strings.ToLower(key)] = true
+	}
+	return r
+}
+
+// Values returns a copy of values with every sensitive key masked.
+func (r *Redactor) Values(values url.Values) url.Values {
+	out := make(url.Values, len(values))
+	for key, vals := range values {
+		if r.keys[strings.ToLower(key)] {
+			out[key] = []string{redacted}
+			continue
+		}
+		out[key] = vals
+	}
+	return out
+}
+
+// Query masks sensitive keys in a raw query string. A query that cannot be
+// parsed is dropped entirely rather than risk leaking a secret.
+func (r *Redactor) Query(rawQuery string) string {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return redacted
+	}
+	return r.Values(values).Encode()
+}
+
+// Route fills in the ":name" and "*name" parameters of a route with the
+// values param returns, masking the sensitive ones, and reports whether any
+// was masked.
+func (r *Redactor) Route(route string, param func(name string) string) (string, bool) {
+	segments := strings.Split(route, "/")
+	masked := false
+	for i, segment := range segments {
+		switch {
+		case strings.HasPrefix(segment, ":") && r.keys[strings.ToLower(segment[1:])]:
+			segments[i] = redacted
+			masked = true
+		case strings.HasPrefix(segment, ":"):
+			segments[i] = param(segment[1:])
+		case strings.HasPrefix(segment, "*"):
+			// Catch-all values start with the slash before them
+			segments[i] = strings.TrimPrefix(param(segment[1:]), "/")
+		}
+	}
+	return strings.Join(segments, "/"), masked
+}
@@ -0,0 +1,31 @@
+package mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DirMailer writes every message as an .eml file into Dir instead of
+// sending it, for local development
+type DirMailer struct {
+	Dir  string
+	From string
+}
+
+// Send writes msg to a new file named after the time and recipient
+func (m *DirMailer) Send(ctx context.Context, msg Message) error {
+	body, err := Build(m.From, msg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+
+	recipient := strings.NewReplacer("@", "_at_", "/", "_", "\\", "_").Replace(msg.To)
+	name := time.Now().UTC().Format("20060102T150405.000000000") + "-" + recipient + ".eml"
+	return os.WriteFile(filepath.Join(m.Dir, name), body, 0o644)
+}
@@ -0,0 +1,152 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Message is an email with a plain text body and an optional HTML alternative
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	// Headers are extra headers such as List-Unsubscribe
+	Headers map[string]string
+}
+
+// Mailer delivers email messages
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTPMailer sends mail through an SMTP relay using STARTTLS when offered
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// Send delivers msg through the relay
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	body, err := Build(m.From, msg)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	// net/smtp has no context support, so honour cancellation by running the
+	// send in the background and giving up on it when ctx ends
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(net.JoinHostPort(m.Host, fmt.Sprint(m.Port)), auth, m.From, []string{msg.To}, body)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Build renders msg as an RFC 5322 message, multipart/alternative when it
+// has an HTML body.
+func Build(from string, msg Message) ([]byte, error) {
+	var buf bytes.Buffer
+	header := textproto.MIMEHeader{}
+	header.Set("From", from)
+	header.Set("To", msg.To)
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header.Set("Date", time.Now().Format(time.RFC1123Z))
+	header.Set("Message-ID", "<"+randomID()+"@"+domainOf(from)+">")
+	header.Set("MIME-Version", "1.0")
+	for k, v := range msg.Headers {
+		header.Set(k, v)
+	}
+
+	if msg.HTML == "" {
+		header.Set("Content-Type", "text/plain; charset=utf-8")
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		writeHeader(&buf, header)
+		if err := writeQuotedPrintable(&buf, msg.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&buf)
+	header.Set("Content-Type", "multipart/alternative; boundary="+mw.Boundary())
+	// the header must precede the parts, so render it into a separate buffer
+	var out bytes.Buffer
+	writeHeader(&out, header)
+
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	out.Write(buf.Bytes())
+	return out.Bytes(), nil
+}
+
+func writeHeader(buf *bytes.Buffer, header textproto.MIMEHeader) {
+	for k, vs := range header {
+		for _, v := range vs {
+			buf.WriteString(k + ": " + v + "\r\n")
+		}
+	}
+	buf.WriteString("\r\n")
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(s)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func domainOf(address string) string {
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		return strings.Trim(address[at+1:], "> ")
+	}
+	return "localhost"
+}
@@ -1,136 +1,441 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/Zenk41/go-gin-htmx/api"
+	"github.com/Zenk41/go-gin-htmx/config"
+	"github.com/Zenk41/go-gin-htmx/digest"
 	"github.com/Zenk41/go-gin-htmx/firebase"
 	"github.com/Zenk41/go-gin-htmx/handlers"
+	"github.com/Zenk41/go-gin-htmx/history"
+	"github.com/Zenk41/go-gin-htmx/logging"
+	"github.com/Zenk41/go-gin-htmx/mail"
+	"github.com/Zenk41/go-gin-htmx/metrics"
 	"github.com/Zenk41/go-gin-htmx/middlewares"
 	"github.com/Zenk41/go-gin-htmx/models"
+	"github.com/Zenk41/go-gin-htmx/notify"
+	"github.com/Zenk41/go-gin-htmx/pubsub"
+	"github.com/Zenk41/go-gin-htmx/scheduler"
+	"github.com/Zenk41/go-gin-htmx/search"
+	"github.com/Zenk41/go-gin-htmx/telemetry"
+	"github.com/Zenk41/go-gin-htmx/webhooks"
 
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
-type FirebaseConfig struct {
-	ProjectID string `json:"project_id"`
-}
-
 func main() {
-	err := godotenv.Load(".env")
-	if err != nil {
-		log.Fatal("Error loading .env file")
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		// The usage was printed by the flag parser
+		return
 	}
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT environment variable not set")
+	if cfg != nil && cfg.PrintConfig {
+		fmt.Print(cfg.Redacted())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
 	}
-
-	apiKey := os.Getenv("API_KEY")
-	if apiKey == "" {
-		log.Fatal("apiKey environment variable not set")
-	}
-
-	domain := os.Getenv("DOMAIN")
-	if apiKey == "" {
-		log.Fatal("DOMAIN environment variable not set")
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
 	}
 
-	firebaseServiceAccount := os.Getenv("SERVICE_ACCOUNT_FILE")
-	if firebaseServiceAccount == "" {
-		log.Fatal("Firebase Project ID variable not set")
+	logger, err := logging.Setup(cfg.Log)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
 	}
 
-	httpAddr := flag.String("addr", "0.0.0.0"+port, "Listen address")
-	flag.Parse()
-
 	gin.SetMode(gin.DebugMode) // Ensure gin is in debug mode
 	gin.DefaultWriter = os.Stdout
 
-	// Path to your JSON configuration file
-	filePath := firebaseServiceAccount
+	shutdownTracing, err := telemetry.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		logger.Fatalf("Failed to set up tracing: %v", err)
+	}
 
-	// Read the JSON file
-	data, err := os.ReadFile(filePath)
+	fireStoreClient, err := firebase.Firestore(cfg.ServiceAccountFile, cfg.ProjectID)
 	if err != nil {
-		log.Fatalf("Failed to read file: %v", err)
+		logger.Fatalf("Failed to create Firestore client: %v", err)
 	}
 
-	// Unmarshal the JSON data into the struct
-	var config FirebaseConfig
-	if err := json.Unmarshal(data, &config); err != nil {
-		log.Fatalf("Failed to unmarshal JSON: %v", err)
+	firebaseApi := metrics.InstrumentFirebaseApi(api.NewFirebaseApi(cfg.APIKey))
+
+	// The search index follows every task write made through taskRepo
+	searchIndex, indexLoaded := search.NewMemory(), false
+	if cfg.Search.IndexFile != "" {
+		if searchIndex, indexLoaded, err = search.OpenMemory(cfg.Search.IndexFile); err != nil {
+			logger.Fatalf("Failed to load search index: %v", err)
+		}
 	}
 
-	fireStoreClient, err := firebase.Firestore(firebaseServiceAccount, config.ProjectID)
-	if err != nil {
-		log.Fatalf("Failed to create Firestore client: %v", err)
+	userRepo := metrics.InstrumentUserRepository(telemetry.TraceUserRepository(models.NewUserRepository(fireStoreClient)))
+	historyRepo := models.NewHistoryRepository(fireStoreClient)
+	auditRepo := models.NewAuditRepository(fireStoreClient)
+	taskRepo := history.RecordTasks(search.IndexTasks(metrics.InstrumentTaskRepository(telemetry.TraceTaskRepository(models.NewTaskRepository(fireStoreClient))), searchIndex), historyRepo)
+	if indexLoaded {
+		stale, err := search.Stale(context.Background(), cfg.Search.IndexFile, taskRepo)
+		if err != nil {
+			logger.Fatalf("Failed to check the search index: %v", err)
+		}
+		if stale {
+			// Rebuilt below, as if there were no file
+			logger.Warnf("Search index %s is older than the last task write, rebuilding it", cfg.Search.IndexFile)
+			indexLoaded = false
+		}
+	}
+	reminderRepo := models.NewReminderRepository(fireStoreClient)
+	notificationRepo := models.NewNotificationRepository(fireStoreClient)
+	webhookRepo := models.NewWebhookRepository(fireStoreClient)
+	appPasswordRepo := models.NewAppPasswordRepository(fireStoreClient)
+
+	broker := pubsub.NewMemory(16)
+
+	notifiers := notify.Multi{
+		&notify.InApp{Repo: notificationRepo, Broker: broker},
+		&notify.Webhook{Client: webhooks.NewClient(10 * time.Second)},
+	}
+	var mailer mail.Mailer
+	switch {
+	case cfg.Mail.SMTPHost != "":
+		mailer = &mail.SMTPMailer{
+			Host:     cfg.Mail.SMTPHost,
+			Port:     cfg.Mail.SMTPPort,
+			Username: cfg.Mail.Username,
+			Password: cfg.Mail.Password,
+			From:     cfg.Mail.From,
+		}
+	case cfg.Mail.Dir != "":
+		mailer = &mail.DirMailer{Dir: cfg.Mail.Dir, From: cfg.Mail.From}
+	}
+	if mailer != nil {
+		notifiers = append(notifiers, &notify.Email{Mailer: mailer, BaseURL: cfg.Mail.BaseURL})
+	}
+	sched := scheduler.New(reminderRepo, taskRepo, userRepo, notifiers, cfg.Scheduler.Interval)
+	dispatcher := &webhooks.Dispatcher{Repo: webhookRepo, Client: webhooks.NewClient(10 * time.Second)}
+	sched.Every("webhooks", cfg.Scheduler.Interval, dispatcher.Deliver)
+	if mailer != nil {
+		digestJob := &digest.Job{
+			Users:       userRepo,
+			Tasks:       taskRepo,
+			Mailer:      mailer,
+			BaseURL:     cfg.Mail.BaseURL,
+			SigningKey:  []byte(cfg.Digest.SigningKey),
+			OverdueDays: cfg.Digest.OverdueDays,
+		}
+		sched.Every("digest", 5*time.Minute, digestJob.Run)
+	}
+	sched.Every("trash", time.Hour, func(ctx context.Context, now time.Time) error {
+		_, err := taskRepo.PurgeTrash(ctx, now.Add(-cfg.Trash.Retention))
+		return err
+	})
+	if cfg.Search.IndexFile != "" {
+		sched.Every("search-index", cfg.Search.SaveInterval, func(ctx context.Context, now time.Time) error {
+			return searchIndex.Save(cfg.Search.IndexFile)
+		})
 	}
-	defer fireStoreClient.Close()
 
-	firebaseApi := api.NewFirebaseApi(apiKey)
+	// Admin commands work on the repositories and exit instead of serving
+	if len(cfg.Args) > 0 {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err := runCommand(ctx, cfg.Args, userRepo, taskRepo, sched, searchIndex, cfg.Search.IndexFile)
+		stop()
+		// Without a saved index to start from, the one built up by the
+		// command is incomplete and must not replace the file
+		if indexLoaded {
+			if err := searchIndex.Save(cfg.Search.IndexFile); err != nil {
+				logger.Errorf("Failed to save search index: %v", err)
+			}
+		}
+		if err := taskRepo.Close(); err != nil {
+			logger.Errorf("Failed to flush task writes: %v", err)
+		}
+		if err := fireStoreClient.Close(); err != nil {
+			logger.Errorf("Failed to close Firestore client: %v", err)
+		}
+		if err != nil {
+			logger.Fatalf("%s: %v", cfg.Args[0], err)
+		}
+		return
+	}
 
-	userRepo := models.NewUserRepository(fireStoreClient)
-	taskRepo := models.NewTaskRepository(fireStoreClient)
+	if !indexLoaded {
+		start := time.Now()
+		count, err := search.Rebuild(context.Background(), searchIndex, userRepo, taskRepo)
+		if err != nil {
+			logger.Fatalf("Failed to build search index: %v", err)
+		}
+		logger.Infof("Indexed %d tasks for search in %s", count, time.Since(start).Round(time.Millisecond))
+	}
 
-	firebaseAuth, err := firebase.Auth(firebaseServiceAccount)
+	firebaseAuth, err := firebase.Auth(cfg.ServiceAccountFile)
 	if err != nil {
-		log.Fatalf("Failed to create Firestore client: %v", err)
+		logger.Fatalf("Failed to create Firebase Auth client: %v", err)
 	}
-	userHandler := handlers.NewUserHandler(userRepo, apiKey, firebaseApi, domain)
-	taskHandler := handlers.NewTaskHandler(taskRepo, userRepo, firebaseAuth)
+	userHandler := handlers.NewUserHandler(userRepo, cfg.APIKey, firebaseApi, cfg.Domain, firebaseAuth, auditRepo, taskRepo, reminderRepo, sched, webhooks.NewSecret, webhooks.CheckURL)
+	taskHandler := handlers.NewTaskHandler(taskRepo, userRepo, firebaseAuth, sched, broker, dispatcher, historyRepo)
 	pageHandler := handlers.NewPageHandler(userRepo, taskRepo, firebaseApi, firebaseAuth)
+	notificationHandler := handlers.NewNotificationHandler(notificationRepo, broker, firebaseAuth)
+	webhookHandler := handlers.NewWebhookHandler(webhookRepo, userRepo, dispatcher, webhooks.NewSecret, webhooks.CheckURL, firebaseAuth)
+	digestHandler := handlers.NewDigestHandler(userRepo, []byte(cfg.Digest.SigningKey))
+	calendarHandler := handlers.NewCalendarHandler(taskRepo, userRepo, sched, dispatcher, broker, firebaseAuth, auditRepo, cfg.Domain)
+	caldavHandler := handlers.NewCalDAVHandler(taskRepo, userRepo, appPasswordRepo, sched, dispatcher, broker, cfg.Domain)
+	appPasswordHandler := handlers.NewAppPasswordHandler(appPasswordRepo, userRepo, firebaseAuth, auditRepo)
+	dataHandler := handlers.NewDataHandler(taskRepo, userRepo, sched, firebaseAuth)
+	importHandler := handlers.NewImportHandler(taskRepo, userRepo, sched, dispatcher, broker, firebaseAuth)
+	searchHandler := handlers.NewSearchHandler(taskRepo, userRepo, searchIndex, firebaseAuth)
+	statsHandler := handlers.NewStatsHandler(taskRepo, userRepo, firebaseAuth)
+	trashHandler := handlers.NewTrashHandler(taskRepo, userRepo, sched, broker, dispatcher, firebaseAuth, cfg.Trash.Retention)
+	auditHandler := handlers.NewAuditHandler(auditRepo, userRepo, firebaseAuth)
+	// Comment lines every 25s keep idle event streams open through proxies
+	eventHandler := handlers.NewEventHandler(broker, firebaseAuth, 25*time.Second)
+	healthHandler := handlers.NewHealthHandler(cfg.Health.Timeout,
+		handlers.ReadinessCheck{Name: "firestore", Check: firebase.FirestoreCheck(fireStoreClient)},
+		handlers.ReadinessCheck{Name: "firebase_auth", Check: firebase.AuthCheck(firebaseAuth)},
+	)
 
 	routesInit := handlerList{
-		userHandler: userHandler,
-		taskHandler: taskHandler,
-		pageHandler: pageHandler,
+		userHandler:         userHandler,
+		taskHandler:         taskHandler,
+		pageHandler:         pageHandler,
+		healthHandler:       healthHandler,
+		notificationHandler: notificationHandler,
+		eventHandler:        eventHandler,
+		digestHandler:       digestHandler,
+		webhookHandler:      webhookHandler,
+		calendarHandler:     calendarHandler,
+		caldavHandler:       caldavHandler,
+		appPasswordHandler:  appPasswordHandler,
+		dataHandler:         dataHandler,
+		importHandler:       importHandler,
+		searchHandler:       searchHandler,
+		statsHandler:        statsHandler,
+		trashHandler:        trashHandler,
+		auditHandler:        auditHandler,
+		serviceName:         cfg.Tracing.ServiceName,
+		loggerConfig: middlewares.LoggerConfig{
+			Logger:     logger,
+			SkipPaths:  cfg.Log.SkipPaths,
+			RedactKeys: cfg.Log.RedactKeys,
+			SampleRate: cfg.Log.SampleRate,
+		},
 	}
 
 	e := gin.New()
+	// Let *gin.Context be passed as a context.Context that carries the request's span and deadline
+	e.ContextWithFallback = true
 
 	routesInit.RoutesRegister(e)
 
 	e.SetTrustedProxies(nil)
 
-	if err := e.Run(*httpAddr); err != nil {
-		log.Fatalf("Failed to run server: %v", err)
+	srv := &http.Server{
+		Addr:         cfg.Addr(),
+		Handler:      e,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+	}
+	// Event streams never finish on their own, end them so Shutdown can drain
+	srv.RegisterOnShutdown(broker.Close)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var background sync.WaitGroup
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		sched.Run(ctx)
+	}()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Infof("Listening on %s", srv.Addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("Failed to run server: %v", err)
+		}
+	case <-ctx.Done():
+		logger.Infof("Shutting down, draining connections for up to %s", cfg.Server.ShutdownTimeout)
+	}
+	stop()
+
+	// Stop accepting connections and wait for in-flight requests
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Errorf("Failed to drain connections: %v", err)
+	}
+	// The scheduler stops on its next tick once ctx is cancelled
+	background.Wait()
+
+	// Commit pending bulk writes before the client goes away
+	if err := taskRepo.Close(); err != nil {
+		logger.Errorf("Failed to flush task writes: %v", err)
+	}
+	if cfg.Search.IndexFile != "" {
+		if err := searchIndex.Save(cfg.Search.IndexFile); err != nil {
+			logger.Errorf("Failed to save search index: %v", err)
+		}
+	}
+	if err := fireStoreClient.Close(); err != nil {
+		logger.Errorf("Failed to close Firestore client: %v", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Errorf("Failed to flush spans: %v", err)
 	}
 }
 
 type handlerList struct {
-	userHandler handlers.UserHandler
-	taskHandler handlers.TaskHandler
-	pageHandler handlers.PageHandler
+	userHandler         handlers.UserHandler
+	taskHandler         handlers.TaskHandler
+	pageHandler         handlers.PageHandler
+	healthHandler       handlers.HealthHandler
+	notificationHandler handlers.NotificationHandler
+	eventHandler        handlers.EventHandler
+	digestHandler       handlers.DigestHandler
+	webhookHandler      handlers.WebhookHandler
+	calendarHandler     handlers.CalendarHandler
+	caldavHandler       handlers.CalDAVHandler
+	appPasswordHandler  handlers.AppPasswordHandler
+	dataHandler         handlers.DataHandler
+	importHandler       handlers.ImportHandler
+	searchHandler       handlers.SearchHandler
+	statsHandler        handlers.StatsHandler
+	trashHandler        handlers.TrashHandler
+	auditHandler        handlers.AuditHandler
+	serviceName         string
+	loggerConfig        middlewares.LoggerConfig
 }
 
 func (hl *handlerList) RoutesRegister(e *gin.Engine) {
-	e.Use(gin.Recovery())                 // Add recovery middleware for panic recovery
-	e.Use(middlewares.StructuredLogger()) // Apply logging middleware
+	e.Use(gin.Recovery())                      // Add recovery middleware for panic recovery
+	e.Use(middlewares.MaskPathParams("token")) // Keep secret path segments out of traces and logs
+	e.Use(otelgin.Middleware(hl.serviceName, otelgin.WithFilter(func(r *http.Request) bool {
+		return !slices.Contains(hl.loggerConfig.SkipPaths, r.URL.Path)
+	}))) // Start a span per request before anything logs
+	e.Use(middlewares.RequestID())                                 // Assign or propagate X-Request-ID
+	e.Use(middlewares.StructuredLoggerWithConfig(hl.loggerConfig)) // Apply logging middleware
+	e.Use(metrics.Middleware())                                    // Record request metrics
 
 	e.Static("/public", "./public")
 
+	// probes
+	e.GET("/healthz", hl.healthHandler.Healthz)
+	e.GET("/readyz", hl.healthHandler.Readyz)
+	e.GET("/version", hl.healthHandler.Version)
+	e.GET("/metrics", gin.WrapH(metrics.Handler()))
+
 	// Pages
 	// auth page
 	e.GET("/login", hl.pageHandler.Login)
 	e.GET("/register", hl.pageHandler.Register)
 	// home page
 	e.GET("/", hl.pageHandler.Home)
+	// settings page
+	e.GET("/settings", hl.pageHandler.Settings)
+	e.POST("/settings", hl.userHandler.UpdateSettings)
+	e.GET("/settings/export", hl.dataHandler.Export)
+	e.POST("/settings/import", hl.dataHandler.Import)
+	e.POST("/settings/password", hl.userHandler.ChangePassword)
+	e.GET("/settings/activity", hl.auditHandler.Page)
+
+	// live updates
+	e.GET("/events", hl.eventHandler.Stream)
+
+	// notifications
+	notifications := e.Group("/notifications")
+	notifications.GET("/bell", hl.notificationHandler.Bell)
+	notifications.POST("/:id/read", hl.notificationHandler.MarkRead)
+	notifications.POST("/read-all", hl.notificationHandler.MarkAllRead)
+
+	// webhooks
+	hooks := e.Group("/webhooks")
+	hooks.GET("", hl.webhookHandler.Index)
+	hooks.POST("", hl.webhookHandler.Create)
+	hooks.DELETE("/:id", hl.webhookHandler.Delete)
+	hooks.POST("/:id/test", hl.webhookHandler.Test)
+	hooks.GET("/:id/deliveries", hl.webhookHandler.Deliveries)
+
+	// digest email
+	e.GET("/digest/unsubscribe", hl.digestHandler.UnsubscribePage)
+	e.POST("/digest/unsubscribe", hl.digestHandler.Unsubscribe)
+
+	// calendar feed and import
+	e.GET("/calendar/:token", hl.calendarHandler.Feed)
+	e.GET("/settings/calendar", hl.calendarHandler.FeedSettings)
+	e.POST("/settings/calendar", hl.calendarHandler.EnableFeed)
+	e.DELETE("/settings/calendar", hl.calendarHandler.DisableFeed)
+	e.GET("/import/ics", hl.calendarHandler.ImportPage)
+	e.POST("/import/ics/preview", hl.calendarHandler.ImportPreview)
+	e.POST("/import/ics", hl.calendarHandler.Import)
+
+	// task list importers
+	e.GET("/import", hl.importHandler.Page)
+	e.POST("/import/preview", hl.importHandler.Preview)
+	e.POST("/import", hl.importHandler.Import)
+
+	// search
+	e.GET("/search", hl.searchHandler.Page)
+	e.GET("/search/live", hl.searchHandler.Live)
+
+	// statistics
+	e.GET("/stats", hl.statsHandler.Page)
+
+	// trash
+	e.GET("/trash", hl.trashHandler.Page)
+	e.DELETE("/trash", hl.trashHandler.Empty)
+	e.GET("/trash/more", hl.trashHandler.More)
+	e.POST("/trash/:id/restore", hl.trashHandler.Restore)
+	e.POST("/trash/:id/undo", hl.trashHandler.Undo)
+	e.DELETE("/trash/:id", hl.trashHandler.Purge)
+
+	// CalDAV, authenticated with app passwords
+	e.GET("/settings/app-passwords", hl.appPasswordHandler.List)
+	e.POST("/settings/app-passwords", hl.appPasswordHandler.Create)
+	e.DELETE("/settings/app-passwords/:id", hl.appPasswordHandler.Revoke)
+	for _, method := range []string{"GET", "PROPFIND"} {
+		e.Handle(method, "/.well-known/caldav", hl.caldavHandler.WellKnown)
+	}
+	for _, method := range []string{"OPTIONS", "PROPFIND", "REPORT", "GET", "HEAD", "PUT", "DELETE"} {
+		e.Handle(method, "/dav/*path", hl.caldavHandler.Serve)
+	}
 
 	// task
 	task := e.Group("/task")
 	task.POST("", hl.taskHandler.CreateNewTask)
+	task.GET("/:id", hl.taskHandler.TaskPage)
+	task.GET("/:id/history", hl.taskHandler.TaskHistory)
 	task.PUT("", hl.taskHandler.EditTaskById)
 	task.PUT("/:id/done", hl.taskHandler.DoneTaskById)
 	task.DELETE("/:id", hl.taskHandler.DeleteTaskById)
 	task.POST("/update", hl.taskHandler.GetTasksByDate)
+	task.GET("/more", hl.taskHandler.MoreTasks)
+	task.POST("/quick-preview", hl.taskHandler.QuickAddPreview)
 	task.PUT("/done-all", hl.taskHandler.DoneAllTaskDayByDate)
 
+	// api
+	apiGroup := e.Group("/api")
+	apiGroup.GET("/tasks", hl.taskHandler.ListTasks)
+
 	// component
 	comp := e.Group("/component")
 	comp.POST("/task-edit", hl.taskHandler.EditTaskModal)
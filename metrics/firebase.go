@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Zenk41/go-gin-htmx/api"
+	"github.com/Zenk41/go-gin-htmx/utils"
+)
+
+type instrumentedFirebaseApi struct {
+	next api.FirebaseApi
+}
+
+// InstrumentFirebaseApi wraps a FirebaseApi so every call to the Firebase
+// Auth REST API is counted by endpoint and outcome. Failed calls are
+// labelled with the Firebase error code, e.g. INVALID_PASSWORD.
+func InstrumentFirebaseApi(next api.FirebaseApi) api.FirebaseApi {
+	return &instrumentedFirebaseApi{next: next}
+}
+
+func countAuthCall(endpoint string, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+		// Some codes carry a human readable suffix ("WEAK_PASSWORD : ...")
+		if code, codeErr := utils.ExtractErrorCodeFromText(err.Error()); codeErr == nil {
+			outcome = strings.Fields(code)[0]
+		}
+	}
+	authCalls.WithLabelValues(endpoint, outcome).Inc()
+}
+
+func (fa *instrumentedFirebaseApi) SignInWithPassword(ctx context.Context, email, password string) (map[string]interface{}, error) {
+	res, err := fa.next.SignInWithPassword(ctx, email, password)
+	countAuthCall("signInWithPassword", err)
+	return res, err
+}
+
+func (fa *instrumentedFirebaseApi) SignUpWithPassword(ctx context.Context, name, email, password string) (map[string]interface{}, error) {
+	res, err := fa.next.SignUpWithPassword(ctx, name, email, password)
+	countAuthCall("signUp", err)
+	return res, err
+}
+
+func (fa *instrumentedFirebaseApi) ExchangeRefreshTokenForIDToken(ctx context.Context, refreshToken string) (map[string]interface{}, error) {
+	res, err := fa.next.ExchangeRefreshTokenForIDToken(ctx, refreshToken)
+	countAuthCall("token", err)
+	return res, err
+}
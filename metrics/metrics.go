@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "taskmanager"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route template.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	httpInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests currently being served.",
+	})
+
+	repositoryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "repository_call_duration_seconds",
+		Help:      "Repository call latency by repository and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"repository", "method"})
+
+	repositoryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "repository_call_errors_total",
+		Help:      "Repository calls that returned an error, by repository and method.",
+	}, []string{"repository", "method"})
+
+	authCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "firebase_auth_calls_total",
+		Help:      "Firebase Auth API calls by endpoint and outcome.",
+	}, []string{"endpoint", "outcome"})
+
+	tasksCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_created_total",
+		Help:      "Tasks created.",
+	})
+
+	tasksCompleted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_completed_total",
+		Help:      "Tasks marked as done.",
+	})
+)
+
+// Handler serves the registered metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
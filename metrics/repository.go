@@ -0,0 +1,222 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/Zenk41/go-gin-htmx/models"
+)
+
+// observe records the latency and outcome of a single repository call.
+func observe(repository, method string, start time.Time, err error) {
+	repositoryDuration.WithLabelValues(repository, method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		repositoryErrors.WithLabelValues(repository, method).Inc()
+	}
+}
+
+type instrumentedTaskRepository struct {
+	next models.TaskRepository
+}
+
+// InstrumentTaskRepository wraps a TaskRepository so every call is timed and
+// counted, and task creation and completion feed the business counters.
+func InstrumentTaskRepository(next models.TaskRepository) models.TaskRepository {
+	return &instrumentedTaskRepository{next: next}
+}
+
+func (r *instrumentedTaskRepository) GetTasksByDate(ctx context.Context, userID string, date civil.Date) (tasks *[]models.Task, err error) {
+	defer func(start time.Time) { observe("task", "GetTasksByDate", start, err) }(time.Now())
+	return r.next.GetTasksByDate(ctx, userID, date)
+}
+
+func (r *instrumentedTaskRepository) GetTaskPageByDate(ctx context.Context, userID string, date civil.Date, page models.PageRequest) (tasks models.TaskPage, err error) {
+	defer func(start time.Time) { observe("task", "GetTaskPageByDate", start, err) }(time.Now())
+	return r.next.GetTaskPageByDate(ctx, userID, date, page)
+}
+
+func (r *instrumentedTaskRepository) GetTodayTasks(ctx context.Context, userID string, loc *time.Location) (tasks *[]models.Task, err error) {
+	defer func(start time.Time) { observe("task", "GetTodayTasks", start, err) }(time.Now())
+	return r.next.GetTodayTasks(ctx, userID, loc)
+}
+
+func (r *instrumentedTaskRepository) GetTasksInRange(ctx context.Context, userID string, from civil.Date, to civil.Date) (tasks *[]models.Task, err error) {
+	defer func(start time.Time) { observe("task", "GetTasksInRange", start, err) }(time.Now())
+	return r.next.GetTasksInRange(ctx, userID, from, to)
+}
+
+func (r *instrumentedTaskRepository) GetAllTasks(ctx context.Context, userID string) (tasks *[]models.Task, err error) {
+	defer func(start time.Time) { observe("task", "GetAllTasks", start, err) }(time.Now())
+	return r.next.GetAllTasks(ctx, userID)
+}
+
+func (r *instrumentedTaskRepository) FindICalUIDs(ctx context.Context, userID string, uids []string) (found map[string]bool, err error) {
+	defer func(start time.Time) { observe("task", "FindICalUIDs", start, err) }(time.Now())
+	return r.next.FindICalUIDs(ctx, userID, uids)
+}
+
+func (r *instrumentedTaskRepository) GetTasksChangedSince(ctx context.Context, userID string, since time.Time, page models.PageRequest) (tasks models.TaskPage, err error) {
+	defer func(start time.Time) { observe("task", "GetTasksChangedSince", start, err) }(time.Now())
+	return r.next.GetTasksChangedSince(ctx, userID, since, page)
+}
+
+func (r *instrumentedTaskRepository) BackfillUpdatedAt(ctx context.Context) (count int, err error) {
+	defer func(start time.Time) { observe("task", "BackfillUpdatedAt", start, err) }(time.Now())
+	return r.next.BackfillUpdatedAt(ctx)
+}
+
+func (r *instrumentedTaskRepository) GetTombstonesSince(ctx context.Context, userID string, since time.Time) (tombstones []models.TaskTombstone, err error) {
+	defer func(start time.Time) { observe("task", "GetTombstonesSince", start, err) }(time.Now())
+	return r.next.GetTombstonesSince(ctx, userID, since)
+}
+
+func (r *instrumentedTaskRepository) LastChangeAt(ctx context.Context, userID string) (last time.Time, err error) {
+	defer func(start time.Time) { observe("task", "LastChangeAt", start, err) }(time.Now())
+	return r.next.LastChangeAt(ctx, userID)
+}
+
+func (r *instrumentedTaskRepository) LastWriteAt(ctx context.Context) (last time.Time, err error) {
+	defer func(start time.Time) { observe("task", "LastWriteAt", start, err) }(time.Now())
+	return r.next.LastWriteAt(ctx)
+}
+
+func (r *instrumentedTaskRepository) GetTaskStatsInRange(ctx context.Context, userID string, from civil.Date, to civil.Date) (stats []models.TaskStat, err error) {
+	defer func(start time.Time) { observe("task", "GetTaskStatsInRange", start, err) }(time.Now())
+	return r.next.GetTaskStatsInRange(ctx, userID, from, to)
+}
+
+func (r *instrumentedTaskRepository) CountTasks(ctx context.Context, userID string) (counts models.TaskCounts, err error) {
+	defer func(start time.Time) { observe("task", "CountTasks", start, err) }(time.Now())
+	return r.next.CountTasks(ctx, userID)
+}
+
+func (r *instrumentedTaskRepository) CreateTask(ctx context.Context, task models.TaskPayload, idempotencyKey string) (created *models.Task, err error) {
+	defer func(start time.Time) {
+		if errors.Is(err, models.ErrTaskAlreadyCreated) {
+			// A repeated create is answered, not failed
+			observe("task", "CreateTask", start, nil)
+			return
+		}
+		observe("task", "CreateTask", start, err)
+	}(time.Now())
+	if created, err = r.next.CreateTask(ctx, task, idempotencyKey); err == nil {
+		tasksCreated.Inc()
+	}
+	return created, err
+}
+
+func (r *instrumentedTaskRepository) GetTaskById(ctx context.Context, userID string, taskID string) (task *models.Task, err error) {
+	defer func(start time.Time) { observe("task", "GetTaskById", start, err) }(time.Now())
+	return r.next.GetTaskById(ctx, userID, taskID)
+}
+
+func (r *instrumentedTaskRepository) DeleteTaskById(ctx context.Context, userID string, taskID string) (task *models.Task, err error) {
+	defer func(start time.Time) { observe("task", "DeleteTaskById", start, err) }(time.Now())
+	return r.next.DeleteTaskById(ctx, userID, taskID)
+}
+
+func (r *instrumentedTaskRepository) GetTrashedTasks(ctx context.Context, userID string, page models.PageRequest) (tasks models.TaskPage, err error) {
+	defer func(start time.Time) { observe("task", "GetTrashedTasks", start, err) }(time.Now())
+	return r.next.GetTrashedTasks(ctx, userID, page)
+}
+
+func (r *instrumentedTaskRepository) GetTrashedTaskById(ctx context.Context, userID string, taskID string) (task *models.Task, err error) {
+	defer func(start time.Time) { observe("task", "GetTrashedTaskById", start, err) }(time.Now())
+	return r.next.GetTrashedTaskById(ctx, userID, taskID)
+}
+
+func (r *instrumentedTaskRepository) RestoreTaskById(ctx context.Context, userID string, taskID string) (task *models.Task, err error) {
+	defer func(start time.Time) { observe("task", "RestoreTaskById", start, err) }(time.Now())
+	return r.next.RestoreTaskById(ctx, userID, taskID)
+}
+
+func (r *instrumentedTaskRepository) PurgeTaskById(ctx context.Context, userID string, taskID string) (err error) {
+	defer func(start time.Time) { observe("task", "PurgeTaskById", start, err) }(time.Now())
+	return r.next.PurgeTaskById(ctx, userID, taskID)
+}
+
+func (r *instrumentedTaskRepository) PurgeTrash(ctx context.Context, before time.Time) (tasks []models.Task, err error) {
+	defer func(start time.Time) { observe("task", "PurgeTrash", start, err) }(time.Now())
+	return r.next.PurgeTrash(ctx, before)
+}
+
+func (r *instrumentedTaskRepository) DoneAllTaskDayByDate(ctx context.Context, userID string, date civil.Date) (changed []models.ChangedTask, err error) {
+	defer func(start time.Time) { observe("task", "DoneAllTaskDayByDate", start, err) }(time.Now())
+	changed, err = r.next.DoneAllTaskDayByDate(ctx, userID, date)
+	tasksCompleted.Add(float64(len(changed)))
+	return changed, err
+}
+
+func (r *instrumentedTaskRepository) DoneTaskById(ctx context.Context, userID string, taskID string) (task *models.Task, changes []models.FieldChange, err error) {
+	defer func(start time.Time) { observe("task", "DoneTaskById", start, err) }(time.Now())
+	// Completing a task already done changes nothing and is not counted
+	if task, changes, err = r.next.DoneTaskById(ctx, userID, taskID); err == nil && len(changes) > 0 {
+		tasksCompleted.Inc()
+	}
+	return task, changes, err
+}
+
+func (r *instrumentedTaskRepository) EditTaskById(ctx context.Context, task models.TaskPayload) (changes []models.FieldChange, err error) {
+	defer func(start time.Time) { observe("task", "EditTaskById", start, err) }(time.Now())
+	return r.next.EditTaskById(ctx, task)
+}
+
+func (r *instrumentedTaskRepository) Close() error {
+	return r.next.Close()
+}
+
+type instrumentedUserRepository struct {
+	next models.UserRepository
+}
+
+// InstrumentUserRepository wraps a UserRepository so every call is timed and counted.
+func InstrumentUserRepository(next models.UserRepository) models.UserRepository {
+	return &instrumentedUserRepository{next: next}
+}
+
+func (r *instrumentedUserRepository) CreateUser(ctx context.Context, user models.User) (err error) {
+	defer func(start time.Time) { observe("user", "CreateUser", start, err) }(time.Now())
+	return r.next.CreateUser(ctx, user)
+}
+
+func (r *instrumentedUserRepository) UpdateUser(ctx context.Context, user models.User) (err error) {
+	defer func(start time.Time) { observe("user", "UpdateUser", start, err) }(time.Now())
+	return r.next.UpdateUser(ctx, user)
+}
+
+func (r *instrumentedUserRepository) GetUser(ctx context.Context, userID string) (user *models.User, err error) {
+	defer func(start time.Time) { observe("user", "GetUser", start, err) }(time.Now())
+	return r.next.GetUser(ctx, userID)
+}
+
+func (r *instrumentedUserRepository) ListUsers(ctx context.Context) (users []models.User, err error) {
+	defer func(start time.Time) { observe("user", "ListUsers", start, err) }(time.Now())
+	return r.next.ListUsers(ctx)
+}
+
+func (r *instrumentedUserRepository) ListDigestSubscribers(ctx context.Context) (users []models.User, err error) {
+	defer func(start time.Time) { observe("user", "ListDigestSubscribers", start, err) }(time.Now())
+	return r.next.ListDigestSubscribers(ctx)
+}
+
+func (r *instrumentedUserRepository) MarkDigestSent(ctx context.Context, userID string, day civil.Date) (err error) {
+	defer func(start time.Time) { observe("user", "MarkDigestSent", start, err) }(time.Now())
+	return r.next.MarkDigestSent(ctx, userID, day)
+}
+
+func (r *instrumentedUserRepository) UnsubscribeDigest(ctx context.Context, userID string) (err error) {
+	defer func(start time.Time) { observe("user", "UnsubscribeDigest", start, err) }(time.Now())
+	return r.next.UnsubscribeDigest(ctx, userID)
+}
+
+func (r *instrumentedUserRepository) GetUserByCalendarToken(ctx context.Context, token string) (user *models.User, err error) {
+	defer func(start time.Time) { observe("user", "GetUserByCalendarToken", start, err) }(time.Now())
+	return r.next.GetUserByCalendarToken(ctx, token)
+}
+
+func (r *instrumentedUserRepository) SetCalendarToken(ctx context.Context, userID string, token string) (err error) {
+	defer func(start time.Time) { observe("user", "SetCalendarToken", start, err) }(time.Now())
+	return r.next.SetCalendarToken(ctx, userID, token)
+}
@@ -1,16 +1,47 @@
 package middlewares
 
 import (
+	"math/rand"
 	"time"
 
+	"github.com/Zenk41/go-gin-htmx/logging"
+	"github.com/Zenk41/go-gin-htmx/telemetry"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
 
-// StructuredLogger logs a gin HTTP request in JSON format using logrus.
+// LoggerConfig configures StructuredLoggerWithConfig.
+type LoggerConfig struct {
+	// Logger receives the entries, the logrus standard logger when nil.
+	Logger *logrus.Logger
+	// SkipPaths are request paths that are never logged.
+	SkipPaths []string
+	// RedactKeys are query and form keys whose values are masked.
+	RedactKeys []string
+	// SampleRate is the fraction of successful requests that are logged.
+	// Failed requests are always logged.
+	SampleRate float64
+}
+
+// StructuredLogger logs every gin HTTP request using the shared logrus
+// logger, masking and skipping nothing. main passes the log settings to
+// StructuredLoggerWithConfig instead.
 func StructuredLogger() gin.HandlerFunc {
-	logger := logrus.New()
-	logger.SetFormatter(&logrus.JSONFormatter{})
+	return StructuredLoggerWithConfig(LoggerConfig{SampleRate: 1})
+}
+
+// StructuredLoggerWithConfig is StructuredLogger with explicit settings.
+func StructuredLoggerWithConfig(conf LoggerConfig) gin.HandlerFunc {
+	logger := conf.Logger
+	if logger == nil {
+		logger = logrus.StandardLogger()
+	}
+	redactor := logging.NewRedactor(conf.RedactKeys)
+
+	skip := make(map[string]bool, len(conf.SkipPaths))
+	for _, path := range conf.SkipPaths {
+		skip[path] = true
+	}
 
 	return func(ctx *gin.Context) {
 		start := time.Now() // Start timer
@@ -20,27 +51,59 @@ func StructuredLogger() gin.HandlerFunc {
 		// Process request
 		ctx.Next()
 
+		if skip[path] {
+			return
+		}
+
+		status := ctx.Writer.Status()
+		failed := len(ctx.Errors) > 0 || status >= 500
+		if !failed && conf.SampleRate < 1 && rand.Float64() >= conf.SampleRate {
+			return
+		}
+
 		// Create log entry fields
 		fields := logrus.Fields{
-			"time":        time.Now().Format(time.RFC3339),
 			"client_ip":   ctx.ClientIP(),
 			"method":      ctx.Request.Method,
 			"path":        path,
+			"route":       ctx.FullPath(),
 			"proto":       ctx.Request.Proto,
-			"status_code": ctx.Writer.Status(),
+			"status_code": status,
 			"latency":     time.Since(start).String(),
 			"body_size":   ctx.Writer.Size(),
 		}
 
+		if id := ctx.GetString(logging.RequestIDKey); id != "" {
+			fields[logging.RequestIDKey] = id
+		}
+		if id := ctx.GetString(logging.UserIDKey); id != "" {
+			fields[logging.UserIDKey] = id
+		}
+
+		if traceID := telemetry.TraceID(ctx.Request.Context()); traceID != "" {
+			fields["trace_id"] = traceID
+			fields["span_id"] = telemetry.SpanID(ctx.Request.Context())
+		}
+
 		if raw != "" {
-			fields["path"] = path + "?" + raw
+			fields["path"] = path + "?" + redactor.Query(raw)
+		}
+
+		// Form values are only useful when debugging and may carry personal data
+		if logger.IsLevelEnabled(logrus.DebugLevel) && len(ctx.Request.PostForm) > 0 {
+			fields["form"] = redactor.Values(ctx.Request.PostForm).Encode()
 		}
 
-		if len(ctx.Errors) > 0 {
-			fields["error_message"] = ctx.Errors.String()
-			logger.WithFields(fields).Error("Request failed")
-		} else {
-			logger.WithFields(fields).Info("Request succeeded")
+		entry := logger.WithFields(fields)
+		switch {
+		case len(ctx.Errors) > 0:
+			entry.WithField("error_message", ctx.Errors.String()).Error("Request failed")
+		case status >= 500:
+			entry.Error("Request failed")
+		case status >= 400:
+			entry.Warn("Request rejected")
+		default:
+			entry.Info("Request succeeded")
 		}
 	}
 }
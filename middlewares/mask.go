@@ -0,0 +1,25 @@
+package middlewares
+
+import (
+	"github.com/Zenk41/go-gin-htmx/logging"
+	"github.com/gin-gonic/gin"
+)
+
+// MaskPathParams masks the values of the named route parameters, such as
+// the secret token of a calendar feed URL, in the request URL. Handlers
+// still read them with ctx.Param, while the tracing and logging middlewares
+// registered after it only see the masked path.
+func MaskPathParams(names ...string) gin.HandlerFunc {
+	redactor := logging.NewRedactor(names)
+	return func(ctx *gin.Context) {
+		if path, masked := redactor.Route(ctx.FullPath(), ctx.Param); masked {
+			u := *ctx.Request.URL
+			u.Path, u.RawPath = path, ""
+			r := ctx.Request.Clone(ctx.Request.Context())
+			r.URL = &u
+			r.RequestURI = u.RequestURI()
+			ctx.Request = r
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,42 @@
+package middlewares
+
+import (
+	"github.com/Zenk41/go-gin-htmx/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the request ID in both directions.
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID propagates the caller's X-Request-ID, or generates one, and
+// echoes it on the response. The ID is stored in the gin context and the
+// request context so every log line for the request can carry it.
+func RequestID() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id := ctx.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+
+		ctx.Set(logging.RequestIDKey, id)
+		ctx.Request = ctx.Request.WithContext(logging.WithRequestID(ctx.Request.Context(), id))
+		ctx.Header(RequestIDHeader, id)
+
+		ctx.Next()
+	}
+}
+
+// validRequestID accepts short printable IDs so a client cannot inject
+// arbitrary content into the logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// appPasswordPrefix marks app passwords so secret scanners can spot them
+const appPasswordPrefix = "tm_"
+
+// AppPassword lets a client such as a CalDAV app sign in without the
+// account password. Only a hash of the secret is stored.
+type AppPassword struct {
+	ID         string    `firestore:"id"`
+	UserID     string    `firestore:"user_id"`
+	Name       string    `firestore:"name"`
+	Hash       string    `firestore:"hash"`
+	CreatedAt  time.Time `firestore:"created_at"`
+	LastUsedAt time.Time `firestore:"last_used_at"`
+}
+
+// NewAppPassword generates a secret and returns it with its hash
+func NewAppPassword() (secret string, hash string, err error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	secret = appPasswordPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return secret, HashAppPassword(secret), nil
+}
+
+// HashAppPassword hashes a secret for lookup. The secrets are random, so a
+// plain SHA-256 is enough and keeps the lookup a single query.
+func HashAppPassword(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+type appPasswordRepository struct {
+	client *firestore.Client
+}
+
+type AppPasswordRepository interface {
+	CreateAppPassword(ctx context.Context, password AppPassword) (*AppPassword, error)
+	ListAppPasswords(ctx context.Context, userID string) ([]AppPassword, error)
+	DeleteAppPassword(ctx context.Context, userID string, id string) error
+	// FindAppPassword returns the app password with the given hash
+	FindAppPassword(ctx context.Context, hash string) (*AppPassword, error)
+	// TouchAppPassword records when an app password was last used
+	TouchAppPassword(ctx context.Context, id string, at time.Time) error
+}
+
+func NewAppPasswordRepository(client *firestore.Client) AppPasswordRepository {
+	return &appPasswordRepository{
+		client: client,
+	}
+}
+
+// CreateAppPassword stores a new app password and returns it with its ID
+func (ar *appPasswordRepository) CreateAppPassword(ctx context.Context, password AppPassword) (*AppPassword, error) {
+	ref := ar.client.Collection("app_passwords").NewDoc()
+	password.ID = ref.ID
+	if _, err := ref.Set(ctx, password); err != nil {
+		return nil, err
+	}
+	return &password, nil
+}
+
+// ListAppPasswords retrieves the app passwords of the user, newest first
+func (ar *appPasswordRepository) ListAppPasswords(ctx context.Context, userID string) ([]AppPassword, error) {
+	iter := ar.client.Collection("app_passwords").
+		Where("user_id", "==", userID).
+		OrderBy("created_at", firestore.Desc).
+		Documents(ctx)
+
+	var passwords []AppPassword
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var password AppPassword
+		if err := doc.DataTo(&password); err != nil {
+			return nil, err
+		}
+		passwords = append(passwords, password)
+	}
+	return passwords, nil
+}
+
+// DeleteAppPassword revokes an app password of the user
+func (ar *appPasswordRepository) DeleteAppPassword(ctx context.Context, userID string, id string) error {
+	ref := ar.client.Collection("app_passwords").Doc(id)
+	doc, err := ref.Get(ctx)
+	if err != nil {
+		return err
+	}
+	if doc.Data()["user_id"] != userID {
+		return fmt.Errorf("app password does not belong to the user")
+	}
+	_, err = ref.Delete(ctx)
+	return err
+}
+
+// FindAppPassword looks an app password up by the hash of its secret
+func (ar *appPasswordRepository) FindAppPassword(ctx context.Context, hash string) (*AppPassword, error) {
+	docs, err := ar.client.Collection("app_passwords").
+		Where("hash", "==", hash).
+		Limit(1).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("app password not found")
+	}
+	var password AppPassword
+	if err := docs[0].DataTo(&password); err != nil {
+		return nil, err
+	}
+	return &password, nil
+}
+
+// TouchAppPassword updates the last use of an app password
+func (ar *appPasswordRepository) TouchAppPassword(ctx context.Context, id string, at time.Time) error {
+	_, err := ar.client.Collection("app_passwords").Doc(id).Update(ctx, []firestore.Update{
+		{Path: "last_used_at", Value: at},
+	})
+	return err
+}
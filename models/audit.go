@@ -0,0 +1,83 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// Security events recorded in the audit log of an account
+const (
+	AuditRegistered          = "registered"
+	AuditLogin               = "login"
+	AuditLogout              = "logout"
+	AuditPasswordChanged     = "password_changed"
+	AuditAppPasswordCreated  = "app_password_created"
+	AuditAppPasswordRevoked  = "app_password_revoked"
+	AuditCalendarFeedEnabled = "calendar_feed_enabled"
+	AuditCalendarFeedRevoked = "calendar_feed_revoked"
+)
+
+// AuditEvent is a security event of an account, kept so the user can spot
+// activity they don't recognize
+type AuditEvent struct {
+	ID        string    `firestore:"id"`
+	UserID    string    `firestore:"user_id"`
+	Action    string    `firestore:"action"`
+	Detail    string    `firestore:"detail"` // e.g. the name of an app password
+	IP        string    `firestore:"ip"`
+	UserAgent string    `firestore:"user_agent"`
+	At        time.Time `firestore:"at"`
+}
+
+type auditRepository struct {
+	client *firestore.Client
+}
+
+type AuditRepository interface {
+	AddAuditEvent(ctx context.Context, event AuditEvent) error
+	// ListAuditEvents returns up to limit events of the user, newest first
+	ListAuditEvents(ctx context.Context, userID string, limit int) ([]AuditEvent, error)
+}
+
+func NewAuditRepository(client *firestore.Client) AuditRepository {
+	return &auditRepository{
+		client: client,
+	}
+}
+
+// AddAuditEvent stores an event under a new ID
+func (ar *auditRepository) AddAuditEvent(ctx context.Context, event AuditEvent) error {
+	ref := ar.client.Collection("audit_log").NewDoc()
+	event.ID = ref.ID
+	_, err := ref.Create(ctx, event)
+	return err
+}
+
+// ListAuditEvents retrieves the latest events of the user
+func (ar *auditRepository) ListAuditEvents(ctx context.Context, userID string, limit int) ([]AuditEvent, error) {
+	iter := ar.client.Collection("audit_log").
+		Where("user_id", "==", userID).
+		OrderBy("at", firestore.Desc).
+		Limit(limit).
+		Documents(ctx)
+
+	var events []AuditEvent
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var event AuditEvent
+		if err := doc.DataTo(&event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
@@ -0,0 +1,138 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Actions recorded in the history of a task
+const (
+	TaskCreated   = "created"
+	TaskUpdated   = "updated"
+	TaskCompleted = "completed"
+	TaskDeleted   = "deleted"
+	TaskRestored  = "restored"
+	TaskPurged    = "purged"
+)
+
+// FieldChange is one field of a task going from one value to another, both
+// formatted for display
+type FieldChange struct {
+	Field string `firestore:"field"`
+	From  string `firestore:"from"`
+	To    string `firestore:"to"`
+}
+
+// ChangedTask is a task as a write saved it, with the fields the write
+// changed
+type ChangedTask struct {
+	Task    Task
+	Changes []FieldChange
+}
+
+// TaskChange is an entry in the history of a task. Entries are only ever
+// added, so the history keeps the values a later edit overwrites.
+type TaskChange struct {
+	ID      string        `firestore:"id"`
+	TaskID  string        `firestore:"task_id"`
+	UserID  string        `firestore:"user_id"`  // owner of the task
+	ActorID string        `firestore:"actor_id"` // who made the change
+	Source  string        `firestore:"source"`   // how the change was made, e.g. "web" or "caldav"
+	Action  string        `firestore:"action"`
+	Changes []FieldChange `firestore:"changes"`
+	At      time.Time     `firestore:"at"`
+}
+
+// DiffTasks lists the fields that differ between two versions of a task,
+// in the order the edit form shows them
+func DiffTasks(old Task, new Task) []FieldChange {
+	var changes []FieldChange
+	add := func(field string, from string, to string) {
+		if from != to {
+			changes = append(changes, FieldChange{Field: field, From: from, To: to})
+		}
+	}
+	add("title", old.Title, new.Title)
+	add("description", old.Description, new.Description)
+	add("status", StatusLabel(old.Status), StatusLabel(new.Status))
+	add("date", old.Day().String(), new.Day().String())
+	add("due time", old.DueTime, new.DueTime)
+	add("reminders", reminderLabels(old.Reminders), reminderLabels(new.Reminders))
+	add("repeat", old.Recurrence, new.Recurrence)
+	add("tags", strings.Join(old.Tags, ", "), strings.Join(new.Tags, ", "))
+	return changes
+}
+
+// StatusLabel names a task status for display, "open" for a task not done
+func StatusLabel(status string) string {
+	if status == "" {
+		return "open"
+	}
+	return status
+}
+
+func reminderLabels(list []Reminder) string {
+	labels := make([]string, len(list))
+	for i, r := range list {
+		labels[i] = r.Label()
+	}
+	return strings.Join(labels, ", ")
+}
+
+// TaskChangePage is a page of the history of a task
+type TaskChangePage struct {
+	Changes    []TaskChange
+	NextCursor string // empty on the last page
+}
+
+type historyRepository struct {
+	client *firestore.Client
+}
+
+type HistoryRepository interface {
+	// AddTaskChanges appends entries to the history of their tasks
+	AddTaskChanges(ctx context.Context, changes []TaskChange) error
+	// GetTaskHistory returns a page of the history of a task of the user, oldest first
+	GetTaskHistory(ctx context.Context, userID string, taskID string, page PageRequest) (TaskChangePage, error)
+}
+
+func NewHistoryRepository(client *firestore.Client) HistoryRepository {
+	return &historyRepository{
+		client: client,
+	}
+}
+
+// AddTaskChanges stores each entry under a new ID
+func (hr *historyRepository) AddTaskChanges(ctx context.Context, changes []TaskChange) error {
+	for _, change := range changes {
+		ref := hr.client.Collection("task_history").NewDoc()
+		change.ID = ref.ID
+		if _, err := ref.Create(ctx, change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTaskHistory retrieves a page of the entries of a task, oldest first
+func (hr *historyRepository) GetTaskHistory(ctx context.Context, userID string, taskID string, page PageRequest) (TaskChangePage, error) {
+	docs, next, err := queryTimePage(ctx, hr.client.Collection("task_history").
+		Where("user_id", "==", userID).
+		Where("task_id", "==", taskID), "at", firestore.Asc, page)
+	if err != nil {
+		return TaskChangePage{}, err
+	}
+
+	changes := make([]TaskChange, 0, len(docs))
+	for _, doc := range docs {
+		var change TaskChange
+		if err := doc.DataTo(&change); err != nil {
+			return TaskChangePage{}, err
+		}
+		changes = append(changes, change)
+	}
+	return TaskChangePage{Changes: changes, NextCursor: next}, nil
+}
@@ -0,0 +1,154 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/api/iterator"
+)
+
+// Notification is an entry in a user's in-app notification list
+type Notification struct {
+	ID        string    `firestore:"id"`
+	UserID    string    `firestore:"user_id"`
+	Kind      string    `firestore:"kind"` // "reminder" or "overdue"
+	Title     string    `firestore:"title"`
+	Body      string    `firestore:"body"`
+	Link      string    `firestore:"link"`
+	TaskID    string    `firestore:"task_id"`
+	Read      bool      `firestore:"read"`
+	CreatedAt time.Time `firestore:"created_at"`
+}
+
+type notificationRepository struct {
+	client *firestore.Client
+}
+
+type NotificationRepository interface {
+	CreateNotification(ctx context.Context, notification Notification) (*Notification, error)
+	// ListNotifications returns the newest notifications of a user first
+	ListNotifications(ctx context.Context, userID string, limit int) ([]Notification, error)
+	CountUnread(ctx context.Context, userID string) (int, error)
+	MarkRead(ctx context.Context, userID string, notificationID string) error
+	// MarkAllRead marks every unread notification of a user as read and returns how many changed
+	MarkAllRead(ctx context.Context, userID string) (int, error)
+}
+
+func NewNotificationRepository(client *firestore.Client) NotificationRepository {
+	return &notificationRepository{
+		client: client,
+	}
+}
+
+// CreateNotification stores a new notification and returns it with its ID
+func (nr *notificationRepository) CreateNotification(ctx context.Context, notification Notification) (*Notification, error) {
+	ref := nr.client.Collection("notifications").NewDoc()
+	notification.ID = ref.ID
+	if _, err := ref.Set(ctx, notification); err != nil {
+		return nil, err
+	}
+	return &notification, nil
+}
+
+// ListNotifications retrieves the latest notifications of a user
+func (nr *notificationRepository) ListNotifications(ctx context.Context, userID string, limit int) ([]Notification, error) {
+	iter := nr.client.Collection("notifications").
+		Where("user_id", "==", userID).
+		OrderBy("created_at", firestore.Desc).
+		Limit(limit).
+		Documents(ctx)
+
+	var notifications []Notification
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var notification Notification
+		if err := doc.DataTo(&notification); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications, nil
+}
+
+// CountUnread counts the unread notifications of a user without reading them
+func (nr *notificationRepository) CountUnread(ctx context.Context, userID string) (int, error) {
+	query := nr.client.Collection("notifications").
+		Where("user_id", "==", userID).
+		Where("read", "==", false)
+	result, err := query.NewAggregationQuery().
+		WithCount("unread").
+		Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count, ok := result["unread"].(*firestorepb.Value)
+	if !ok {
+		return 0, fmt.Errorf("unexpected count result %T", result["unread"])
+	}
+	return int(count.GetIntegerValue()), nil
+}
+
+// MarkRead marks one notification of the user as read
+func (nr *notificationRepository) MarkRead(ctx context.Context, userID string, notificationID string) error {
+	doc := nr.client.Collection("notifications").Doc(notificationID)
+
+	snap, err := doc.Get(ctx)
+	if err != nil {
+		return err
+	}
+	if snap.Data()["user_id"] != userID {
+		return fmt.Errorf("notification does not belong to the user")
+	}
+
+	_, err = doc.Update(ctx, []firestore.Update{
+		{Path: "read", Value: true},
+	})
+	return err
+}
+
+// MarkAllRead marks every unread notification of the user as read
+func (nr *notificationRepository) MarkAllRead(ctx context.Context, userID string) (int, error) {
+	iter := nr.client.Collection("notifications").
+		Where("user_id", "==", userID).
+		Where("read", "==", false).
+		Documents(ctx)
+
+	bulk := nr.client.BulkWriter(ctx)
+	var jobs []*firestore.BulkWriterJob
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			bulk.End()
+			return 0, err
+		}
+		job, err := bulk.Update(doc.Ref, []firestore.Update{
+			{Path: "read", Value: true},
+		})
+		if err != nil {
+			bulk.End()
+			return 0, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	bulk.End() // Blocking call that commits everything queued
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			return 0, err
+		}
+	}
+	return len(jobs), nil
+}
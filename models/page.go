@@ -0,0 +1,127 @@
+package models
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// Page sizes of listings
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// ErrInvalidCursor is returned for a cursor no listing handed out
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// PageRequest asks for the page of a listing that starts after Cursor, the
+// first page when it is empty
+type PageRequest struct {
+	Cursor string
+	Limit  int // DefaultPageSize when zero, at most MaxPageSize
+}
+
+func (p PageRequest) size() int {
+	switch {
+	case p.Limit <= 0:
+		return DefaultPageSize
+	case p.Limit > MaxPageSize:
+		return MaxPageSize
+	}
+	return p.Limit
+}
+
+// TaskPage is a page of a task listing
+type TaskPage struct {
+	Tasks      []Task
+	NextCursor string // empty on the last page
+}
+
+// queryPage runs a page of q. Pages follow the document ID, which is unique
+// and never changes, so a task is listed once even when tasks are added or
+// edited between pages; task IDs being UUIDv7, that is creation order for
+// new tasks. The cursor is the encoded ID of the last document of the
+// previous page.
+func queryPage(ctx context.Context, q firestore.Query, page PageRequest) ([]*firestore.DocumentSnapshot, string, error) {
+	q = q.OrderBy(firestore.DocumentID, firestore.Asc)
+	if page.Cursor != "" {
+		after, err := base64.RawURLEncoding.DecodeString(page.Cursor)
+		if err != nil || len(after) == 0 {
+			return nil, "", ErrInvalidCursor
+		}
+		q = q.StartAfter(string(after))
+	}
+
+	docs, last, err := fetchPage(ctx, q, page.size())
+	if err != nil || last == nil {
+		return docs, "", err
+	}
+	return docs, base64.RawURLEncoding.EncodeToString([]byte(last.Ref.ID)), nil
+}
+
+// queryTimePage runs a page of q ordered by the time in field, the document
+// ID breaking ties, for listings shown in time order such as the trash. The
+// cursor is the encoded time and ID of the last document of the previous
+// page, so it stays valid when that document is removed.
+func queryTimePage(ctx context.Context, q firestore.Query, field string, dir firestore.Direction, page PageRequest) ([]*firestore.DocumentSnapshot, string, error) {
+	q = q.OrderBy(field, dir).OrderBy(firestore.DocumentID, dir)
+	if page.Cursor != "" {
+		after, err := base64.RawURLEncoding.DecodeString(page.Cursor)
+		if err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+		nanos, id, ok := strings.Cut(string(after), ":")
+		unix, err := strconv.ParseInt(nanos, 10, 64)
+		if !ok || err != nil || id == "" {
+			return nil, "", ErrInvalidCursor
+		}
+		q = q.StartAfter(time.Unix(0, unix), id)
+	}
+
+	docs, last, err := fetchPage(ctx, q, page.size())
+	if err != nil || last == nil {
+		return docs, "", err
+	}
+	at, err := last.DataAt(field)
+	if err != nil {
+		return nil, "", err
+	}
+	t, ok := at.(time.Time)
+	if !ok {
+		return nil, "", fmt.Errorf("%s of %s is %T, not a time", field, last.Ref.ID, at)
+	}
+	cursor := strconv.FormatInt(t.UnixNano(), 10) + ":" + last.Ref.ID
+	return docs, base64.RawURLEncoding.EncodeToString([]byte(cursor)), nil
+}
+
+// fetchPage reads up to size documents of q and returns the last one when
+// there is a next page
+func fetchPage(ctx context.Context, q firestore.Query, size int) ([]*firestore.DocumentSnapshot, *firestore.DocumentSnapshot, error) {
+	// One more document tells whether there is a next page
+	iter := q.Limit(size + 1).Documents(ctx)
+	defer iter.Stop()
+	var docs []*firestore.DocumentSnapshot
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		docs = append(docs, doc)
+	}
+	if len(docs) <= size {
+		return docs, nil, nil
+	}
+	docs = docs[:size]
+	return docs, docs[size-1], nil
+}
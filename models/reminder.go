@@ -0,0 +1,147 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// Statuses of a ScheduledReminder
+const (
+	ReminderPending = "pending"
+	ReminderSent    = "sent"
+	ReminderSkipped = "skipped"
+	ReminderFailed  = "failed"
+)
+
+// ReminderOverdue is the kind of the occurrence telling the user a task is
+// overdue; the reminders a task asks for have no kind
+const ReminderOverdue = "overdue"
+
+// ScheduledReminder is a reminder occurrence waiting to be delivered. They
+// are persisted so pending reminders survive restarts.
+type ScheduledReminder struct {
+	ID        string    `firestore:"id"`
+	TaskID    string    `firestore:"task_id"`
+	UserID    string    `firestore:"user_id"`
+	Kind      string    `firestore:"kind,omitempty"`
+	FireAt    time.Time `firestore:"fire_at"`
+	Status    string    `firestore:"status"`
+	Attempts  int       `firestore:"attempts"`
+	Delivered []string  `firestore:"delivered"` // names of the channels done with it
+	LastError string    `firestore:"last_error"`
+	CreatedAt time.Time `firestore:"created_at"`
+	UpdatedAt time.Time `firestore:"updated_at"`
+}
+
+type reminderRepository struct {
+	client *firestore.Client
+}
+
+type ReminderRepository interface {
+	// ReplaceForTask drops the task's pending reminders and schedules the given ones instead
+	ReplaceForTask(ctx context.Context, taskID string, reminders []ScheduledReminder) error
+	// Due returns up to limit pending reminders whose fire time is not after now, oldest first
+	Due(ctx context.Context, now time.Time, limit int) ([]ScheduledReminder, error)
+	// PendingForUser returns every pending reminder of the user
+	PendingForUser(ctx context.Context, userID string) ([]ScheduledReminder, error)
+	// Finish records the final status of a reminder
+	Finish(ctx context.Context, id string, status string, lastError string) error
+	// Retry pushes a failed reminder back to fireAt, recording the channels it was delivered over
+	Retry(ctx context.Context, id string, fireAt time.Time, attempts int, delivered []string, lastError string) error
+}
+
+func NewReminderRepository(client *firestore.Client) ReminderRepository {
+	return &reminderRepository{
+		client: client,
+	}
+}
+
+// ReplaceForTask swaps the pending reminders of a task in one transaction
+func (rr *reminderRepository) ReplaceForTask(ctx context.Context, taskID string, reminders []ScheduledReminder) error {
+	col := rr.client.Collection("reminders")
+	return rr.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		pending, err := tx.Documents(col.
+			Where("task_id", "==", taskID).
+			Where("status", "==", ReminderPending)).GetAll()
+		if err != nil {
+			return err
+		}
+
+		for _, doc := range pending {
+			if err := tx.Delete(doc.Ref); err != nil {
+				return err
+			}
+		}
+		for _, reminder := range reminders {
+			ref := col.NewDoc()
+			reminder.ID = ref.ID
+			if err := tx.Create(ref, reminder); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Due lists pending reminders that should have fired by now
+func (rr *reminderRepository) Due(ctx context.Context, now time.Time, limit int) ([]ScheduledReminder, error) {
+	return rr.reminders(rr.client.Collection("reminders").
+		Where("status", "==", ReminderPending).
+		Where("fire_at", "<=", now).
+		OrderBy("fire_at", firestore.Asc).
+		Limit(limit).
+		Documents(ctx))
+}
+
+// PendingForUser retrieves the pending reminders of a user
+func (rr *reminderRepository) PendingForUser(ctx context.Context, userID string) ([]ScheduledReminder, error) {
+	return rr.reminders(rr.client.Collection("reminders").
+		Where("user_id", "==", userID).
+		Where("status", "==", ReminderPending).
+		Documents(ctx))
+}
+
+func (rr *reminderRepository) reminders(iter *firestore.DocumentIterator) ([]ScheduledReminder, error) {
+	defer iter.Stop()
+	var reminders []ScheduledReminder
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var reminder ScheduledReminder
+		if err := doc.DataTo(&reminder); err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, reminder)
+	}
+	return reminders, nil
+}
+
+// Finish records the final status of a reminder
+func (rr *reminderRepository) Finish(ctx context.Context, id string, status string, lastError string) error {
+	_, err := rr.client.Collection("reminders").Doc(id).Update(ctx, []firestore.Update{
+		{Path: "status", Value: status},
+		{Path: "last_error", Value: lastError},
+		{Path: "updated_at", Value: time.Now()},
+	})
+	return err
+}
+
+// Retry pushes a failed reminder back to fireAt
+func (rr *reminderRepository) Retry(ctx context.Context, id string, fireAt time.Time, attempts int, delivered []string, lastError string) error {
+	_, err := rr.client.Collection("reminders").Doc(id).Update(ctx, []firestore.Update{
+		{Path: "fire_at", Value: fireAt},
+		{Path: "attempts", Value: attempts},
+		{Path: "delivered", Value: delivered},
+		{Path: "last_error", Value: lastError},
+		{Path: "updated_at", Value: time.Now()},
+	})
+	return err
+}
@@ -2,69 +2,413 @@ package models
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
+	"unicode"
 
+	"cloud.google.com/go/civil"
 	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/google/uuid"
 	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-// Task represents a task in the application
+// Task represents a task in the application.
+//
+// Date is the civil day the task belongs to, stored as midnight UTC of that
+// day so it compares equal regardless of the owner's timezone. Use Day to
+// read it and StoredDate to write it.
+//
+// Deleted tasks are moved to a separate trash collection with DeletedAt set,
+// so every query on tasks leaves them out, until they are restored or
+// purged.
 type Task struct {
-	TaskID      string    `firestore:"task_id"`
-	UserID      string    `firestore:"user_id"`
-	Title       string    `firestore:"title"`
-	Description string    `firestore:"description"`
-	Status      string    `firestore:"status"`
-	Date        time.Time `firestore:"date"`
-	CreatedAt   time.Time `firestore:"created_at"`
-	UpdatedAt   time.Time `firestore:"updated_at"`
+	TaskID      string     `firestore:"task_id"`
+	UserID      string     `firestore:"user_id"`
+	Title       string     `firestore:"title"`
+	Description string     `firestore:"description"`
+	Status      string     `firestore:"status"`
+	Date        time.Time  `firestore:"date"`
+	DueTime     string     `firestore:"due_time"` // "15:04" in the owner's timezone, empty when the task has no due time
+	Reminders   []Reminder `firestore:"reminders"`
+	Recurrence  string     `firestore:"recurrence"`         // RFC 5545 RRULE value, e.g. FREQ=WEEKLY;BYDAY=MO
+	ICalUID     string     `firestore:"ical_uid"`           // UID of the calendar entry the task was imported from
+	DavName     string     `firestore:"dav_name,omitempty"` // resource name a CalDAV client created the task under
+	Tags        []string   `firestore:"tags"`               // normalized with NormalizeTags
+	CreatedAt   time.Time  `firestore:"created_at"`
+	UpdatedAt   time.Time  `firestore:"updated_at"`
+	DeletedAt   time.Time  `firestore:"deleted_at,omitempty"` // when the task was moved to the trash, zero otherwise
+	Version     int64      `firestore:"version"`              // incremented by every write, see EditTaskById
 }
 
 type TaskPayload struct {
-	TaskID      string    `firestore:"task_id"`
-	UserID      string    `firestore:"user_id"`
-	Title       string    `firestore:"title"`
-	Description string    `firestore:"description"`
-	Status      string    `firestore:"status"`
-	Date        time.Time `firestore:"date"`
-	CreatedAt   time.Time `firestore:"created_at"`
-	UpdatedAt   time.Time `firestore:"updated_at"`
+	TaskID      string     `firestore:"task_id"`
+	UserID      string     `firestore:"user_id"`
+	Title       string     `firestore:"title"`
+	Description string     `firestore:"description"`
+	Status      string     `firestore:"status"`
+	Date        time.Time  `firestore:"date"`
+	DueTime     string     `firestore:"due_time"` // "15:04" in the owner's timezone, empty when the task has no due time
+	Reminders   []Reminder `firestore:"reminders"`
+	Recurrence  string     `firestore:"recurrence"`         // RFC 5545 RRULE value, e.g. FREQ=WEEKLY;BYDAY=MO
+	ICalUID     string     `firestore:"ical_uid"`           // UID of the calendar entry the task was imported from
+	DavName     string     `firestore:"dav_name,omitempty"` // resource name a CalDAV client created the task under
+	Tags        []string   `firestore:"tags"`               // normalized with NormalizeTags
+	CreatedAt   time.Time  `firestore:"created_at"`
+	UpdatedAt   time.Time  `firestore:"updated_at"`
+	DeletedAt   time.Time  `firestore:"deleted_at,omitempty"` // when the task was moved to the trash, zero otherwise
+	Version     int64      `firestore:"version"`              // incremented by every write, see EditTaskById
+}
+
+// Day returns the civil day the task belongs to
+func (t Task) Day() civil.Date {
+	return civil.DateOf(t.Date.In(time.UTC))
+}
+
+// Day returns the civil day the task belongs to
+func (t TaskPayload) Day() civil.Date {
+	return civil.DateOf(t.Date.In(time.UTC))
+}
+
+// TaskTombstone records a deleted task so calendar clients can sync the deletion
+type TaskTombstone struct {
+	TaskID    string    `firestore:"task_id"`
+	UserID    string    `firestore:"user_id"`
+	DavName   string    `firestore:"dav_name,omitempty"`
+	DeletedAt time.Time `firestore:"deleted_at"`
+}
+
+// ErrTaskExists is returned when restoring a task whose ID is in use again,
+// or creating a task under the ID of another user's task
+var ErrTaskExists = errors.New("a task with the same ID exists")
+
+// ErrTaskNotFound is returned for a task that does not exist or belongs to
+// another user, so callers cannot tell the two apart
+var ErrTaskNotFound = errors.New("task not found")
+
+// ErrTaskAlreadyCreated is returned along with the task an earlier create
+// with the same idempotency key saved
+var ErrTaskAlreadyCreated = errors.New("the task was already created")
+
+// idempotencyWindow is how long an idempotency key keeps pointing at the
+// task it created
+const idempotencyWindow = 24 * time.Hour
+
+// taskCreation maps an idempotency key of a user to the task created with
+// it. The key is hashed into the document ID, so clients can use any string.
+type taskCreation struct {
+	UserID    string    `firestore:"user_id"`
+	TaskID    string    `firestore:"task_id"`
+	ExpiresAt time.Time `firestore:"expires_at"`
+}
+
+// ErrVersionConflict is returned when editing a task that was changed since
+// the version the edit is based on
+var ErrVersionConflict = errors.New("the task was changed since it was read")
+
+// TaskStat is the part of a task statistics read, loaded without the rest
+// of the document
+type TaskStat struct {
+	Date   time.Time `firestore:"date"`
+	Status string    `firestore:"status"`
+	Tags   []string  `firestore:"tags"`
+}
+
+// Day returns the civil day the task belongs to
+func (s TaskStat) Day() civil.Date {
+	return civil.DateOf(s.Date.In(time.UTC))
+}
+
+// TaskCounts are the user's task totals, counted by the database
+type TaskCounts struct {
+	Total int
+	Done  int
+}
+
+// DueAt returns the instant the task is due in loc, false when it has no due time
+func (t Task) DueAt(loc *time.Location) (time.Time, bool) {
+	if t.DueTime == "" {
+		return time.Time{}, false
+	}
+	due, err := civil.ParseTime(t.DueTime + ":00")
+	if err != nil {
+		return time.Time{}, false
+	}
+	return civil.DateTime{Date: t.Day(), Time: due}.In(loc), true
+}
+
+// OverdueAt returns the instant the task becomes overdue in loc: its due
+// time, or the end of its day when it has none
+func (t Task) OverdueAt(loc *time.Location) time.Time {
+	if due, ok := t.DueAt(loc); ok {
+		return due
+	}
+	return t.Day().AddDays(1).In(loc)
+}
+
+// Reminder asks for a notification either a number of minutes before the
+// task's due time or at a fixed time of day on the task's date.
+type Reminder struct {
+	MinutesBefore int    `firestore:"minutes_before"`
+	At            string `firestore:"at"` // "15:04"; when set MinutesBefore is ignored
+}
+
+// FireAt returns when the reminder should go off for task in loc, false
+// when it cannot be placed (a relative reminder on a task without due time).
+func (r Reminder) FireAt(task Task, loc *time.Location) (time.Time, bool) {
+	if r.At != "" {
+		at, err := civil.ParseTime(r.At + ":00")
+		if err != nil {
+			return time.Time{}, false
+		}
+		return civil.DateTime{Date: task.Day(), Time: at}.In(loc), true
+	}
+
+	due, ok := task.DueAt(loc)
+	if !ok {
+		return time.Time{}, false
+	}
+	return due.Add(-time.Duration(r.MinutesBefore) * time.Minute), true
+}
+
+// Label describes the reminder for display
+func (r Reminder) Label() string {
+	switch {
+	case r.At != "":
+		return "at " + r.At
+	case r.MinutesBefore == 0:
+		return "at due time"
+	case r.MinutesBefore%(24*60) == 0:
+		return fmt.Sprintf("%d day(s) before", r.MinutesBefore/(24*60))
+	case r.MinutesBefore%60 == 0:
+		return fmt.Sprintf("%d hour(s) before", r.MinutesBefore/60)
+	default:
+		return fmt.Sprintf("%d minutes before", r.MinutesBefore)
+	}
+}
+
+// StoredDate converts a civil day into the instant stored in the date field
+func StoredDate(d civil.Date) time.Time {
+	return d.In(time.UTC)
+}
+
+// NormalizeTags cleans up tags typed by users or read from imports: they are
+// lowercased, lose a leading # or @, use dashes instead of spaces and are
+// deduplicated. Empty tags are dropped.
+func NormalizeTags(tags []string) []string {
+	var normalized []string
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.TrimLeft(strings.TrimSpace(tag), "#@")
+		tag = strings.ToLower(strings.Join(strings.FieldsFunc(tag, unicode.IsSpace), "-"))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
 }
 
 type taskRepository struct {
 	client *firestore.Client
+	bulk   *firestore.BulkWriter
 }
 
 type TaskRepository interface {
-	GetTasksByDate(ctx context.Context, userID string, date time.Time) (*[]Task, error)
-	GetTodayTasks(ctx context.Context, userID string) (*[]Task, error)
-	CreateTask(ctx context.Context, task TaskPayload) error
-	GetTaskById(ctx context.Context, taskID string) (*Task, error)
-	DeleteTaskById(ctx context.Context, taskID string) error
-	DoneAllTaskDayByDate(ctx context.Context, userID string, date time.Time) error
-	DoneTaskById(ctx context.Context, userID string, taskID string) error
-	EditTaskById(ctx context.Context, task TaskPayload) error
+	GetTasksByDate(ctx context.Context, userID string, date civil.Date) (*[]Task, error)
+	// GetTaskPageByDate returns a page of the tasks of a day, see PageRequest
+	GetTaskPageByDate(ctx context.Context, userID string, date civil.Date, page PageRequest) (TaskPage, error)
+	GetTodayTasks(ctx context.Context, userID string, loc *time.Location) (*[]Task, error)
+	// FindICalUIDs returns which of uids belong to tasks of the user imported from a calendar
+	FindICalUIDs(ctx context.Context, userID string, uids []string) (map[string]bool, error)
+	// GetAllTasks returns every task of the user, e.g. for an export
+	GetAllTasks(ctx context.Context, userID string) (*[]Task, error)
+	// GetTasksInRange returns the tasks dated from from through to, both inclusive, oldest first
+	GetTasksInRange(ctx context.Context, userID string, from civil.Date, to civil.Date) (*[]Task, error)
+	// GetTasksChangedSince returns a page of the user's tasks updated at or
+	// after since, oldest change first. Tasks without updated_at are not
+	// listed until BackfillUpdatedAt gives them one.
+	GetTasksChangedSince(ctx context.Context, userID string, since time.Time, page PageRequest) (TaskPage, error)
+	// BackfillUpdatedAt sets updated_at to now on every task saved without
+	// one, e.g. by other tools, and returns how many
+	BackfillUpdatedAt(ctx context.Context) (int, error)
+	// GetTombstonesSince returns the user's tasks deleted at or after since
+	GetTombstonesSince(ctx context.Context, userID string, since time.Time) ([]TaskTombstone, error)
+	// LastChangeAt returns when a task of the user was last updated or deleted, zero when never
+	LastChangeAt(ctx context.Context, userID string) (time.Time, error)
+	// LastWriteAt returns when any task was last updated or deleted, zero when never
+	LastWriteAt(ctx context.Context) (time.Time, error)
+	// GetTaskStatsInRange returns the date, status and tags of the tasks dated from from through to, both inclusive
+	GetTaskStatsInRange(ctx context.Context, userID string, from civil.Date, to civil.Date) ([]TaskStat, error)
+	// CountTasks counts all the user's tasks and the done ones
+	CountTasks(ctx context.Context, userID string) (TaskCounts, error)
+	// CreateTask saves a new task, or replaces a task of the same user at the
+	// version after the stored one, and returns it as saved. A task without an ID gets a new one. It fails
+	// with ErrTaskExists when the ID is another user's. A create repeating
+	// the non-empty idempotencyKey of an earlier one saves nothing and
+	// returns the task that one saved with ErrTaskAlreadyCreated, unless
+	// that task was deleted since.
+	CreateTask(ctx context.Context, task TaskPayload, idempotencyKey string) (*Task, error)
+	// The methods below taking a task ID only see the tasks of userID, and
+	// fail with ErrTaskNotFound for the others
+	GetTaskById(ctx context.Context, userID string, taskID string) (*Task, error)
+	// DeleteTaskById moves a task to the trash and leaves a tombstone for
+	// sync clients. It returns the task as it was moved.
+	DeleteTaskById(ctx context.Context, userID string, taskID string) (*Task, error)
+	// GetTrashedTasks returns a page of the user's tasks in the trash, last deleted first
+	GetTrashedTasks(ctx context.Context, userID string, page PageRequest) (TaskPage, error)
+	GetTrashedTaskById(ctx context.Context, userID string, taskID string) (*Task, error)
+	// RestoreTaskById moves a task back from the trash and returns it. It
+	// fails with ErrTaskExists when a task with the same ID was created
+	// meanwhile.
+	RestoreTaskById(ctx context.Context, userID string, taskID string) (*Task, error)
+	// PurgeTaskById deletes a task in the trash for good
+	PurgeTaskById(ctx context.Context, userID string, taskID string) error
+	// PurgeTrash deletes every task moved to the trash before before and
+	// returns the tasks it deleted
+	PurgeTrash(ctx context.Context, before time.Time) ([]Task, error)
+	// DoneAllTaskDayByDate marks the open tasks of a day done in one
+	// transaction and returns each as saved with the change of its status.
+	// Tasks already done are left out.
+	DoneAllTaskDayByDate(ctx context.Context, userID string, date civil.Date) ([]ChangedTask, error)
+	// DoneTaskById marks a task done and returns it as saved with the change
+	// of its status, compared with the task read in the transaction of the
+	// write. A task already done is left as it is and returned without a
+	// change.
+	DoneTaskById(ctx context.Context, userID string, taskID string) (*Task, []FieldChange, error)
+	// EditTaskById saves task if the stored task is still at task.Version,
+	// and fails with ErrVersionConflict otherwise. The saved task is at the
+	// next version. The task must belong to task.UserID. It returns the
+	// fields that differ from the stored task it replaced.
+	EditTaskById(ctx context.Context, task TaskPayload) ([]FieldChange, error)
+	// Close flushes any pending bulk writes. The repository must not be used afterwards.
+	Close() error
 }
 
 func NewTaskRepository(client *firestore.Client) TaskRepository {
 	return &taskRepository{
 		client: client,
+		bulk:   client.BulkWriter(context.Background()),
 	}
 }
 
-// CreateTask creates a new task in Firestore
-func (tr *taskRepository) CreateTask(ctx context.Context, task TaskPayload) error {
-	_, err := tr.client.Collection("tasks").Doc(task.TaskID).Set(ctx, task)
-	return err
+// CreateTask creates a new task in Firestore at its first version, or
+// replaces a task of the same user at the version after the stored one, so
+// versions never go back. New IDs are UUIDv7, so they sort by creation time
+// and never collide. The idempotency key and the task are saved in one
+// transaction.
+func (tr *taskRepository) CreateTask(ctx context.Context, task TaskPayload, idempotencyKey string) (*Task, error) {
+	if task.TaskID == "" {
+		id, err := uuid.NewV7()
+		if err != nil {
+			return nil, err
+		}
+		task.TaskID = id.String()
+	}
+	ref := tr.client.Collection("tasks").Doc(task.TaskID)
+	var keyRef *firestore.DocumentRef
+	if idempotencyKey != "" {
+		keyRef = tr.client.Collection("task_creations").Doc(creationID(task.UserID, idempotencyKey))
+	}
+
+	var saved *Task
+	err := tr.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if keyRef != nil {
+			doc, err := tx.Get(keyRef)
+			switch {
+			case status.Code(err) == codes.NotFound:
+			case err != nil:
+				return err
+			default:
+				var creation taskCreation
+				if err := doc.DataTo(&creation); err != nil {
+					return err
+				}
+				if creation.ExpiresAt.After(time.Now()) {
+					doc, err := tx.Get(tr.client.Collection("tasks").Doc(creation.TaskID))
+					saved, err = ownTask(doc, err, task.UserID)
+					switch {
+					case err == nil:
+						return ErrTaskAlreadyCreated
+					case !errors.Is(err, ErrTaskNotFound):
+						return err
+					}
+					// The task was trashed or purged since, create it
+					// again and point the key at the new one
+				}
+			}
+		}
+
+		doc, err := tx.Get(ref)
+		task.Version = 1
+		switch {
+		case status.Code(err) == codes.NotFound:
+		case err != nil:
+			return err
+		case doc.Data()["user_id"] != task.UserID:
+			return ErrTaskExists
+		default:
+			var current Task
+			if err := doc.DataTo(&current); err != nil {
+				return err
+			}
+			task.Version = current.Version + 1
+		}
+		if keyRef != nil {
+			creation := taskCreation{UserID: task.UserID, TaskID: task.TaskID, ExpiresAt: time.Now().Add(idempotencyWindow)}
+			if err := tx.Set(keyRef, creation); err != nil {
+				return err
+			}
+		}
+		return tx.Set(ref, task)
+	})
+	if errors.Is(err, ErrTaskAlreadyCreated) {
+		return saved, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	created := Task(task)
+	return &created, nil
+}
+
+// creationID is the document ID of an idempotency key of a user
+func creationID(userID string, key string) string {
+	sum := sha256.Sum256([]byte(userID + "\x00" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ownTask decodes a task read with err and checks it belongs to the user
+func ownTask(doc *firestore.DocumentSnapshot, err error, userID string) (*Task, error) {
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var task Task
+	if err := doc.DataTo(&task); err != nil {
+		return nil, err
+	}
+	if task.UserID != userID {
+		return nil, ErrTaskNotFound
+	}
+	return &task, nil
 }
 
 // GetTasksByDate retrieves tasks by specific date
-func (tr *taskRepository) GetTasksByDate(ctx context.Context, userID string, date time.Time) (*[]Task, error) {
+func (tr *taskRepository) GetTasksByDate(ctx context.Context, userID string, date civil.Date) (*[]Task, error) {
 	var tasks []Task
 	iter := tr.client.Collection("tasks").
 		Where("user_id", "==", userID).
-		Where("date", "==", date).
+		Where("date", "==", StoredDate(date)).
 		Documents(ctx)
 
 	for {
@@ -84,82 +428,530 @@ func (tr *taskRepository) GetTasksByDate(ctx context.Context, userID string, dat
 	return &tasks, nil
 }
 
-func (tr *taskRepository) DoneTaskById(ctx context.Context, userID string, taskID string) error {
-	doc := tr.client.Collection("tasks").Doc(taskID)
-
-	// Get the task to verify the userID
-	taskSnap, err := doc.Get(ctx)
+// GetTaskPageByDate retrieves a page of the tasks of a specific date
+func (tr *taskRepository) GetTaskPageByDate(ctx context.Context, userID string, date civil.Date, page PageRequest) (TaskPage, error) {
+	docs, next, err := queryPage(ctx, tr.client.Collection("tasks").
+		Where("user_id", "==", userID).
+		Where("date", "==", StoredDate(date)), page)
 	if err != nil {
-		return err
+		return TaskPage{}, err
 	}
 
-	taskData := taskSnap.Data()
-	if taskData["user_id"] != userID {
-		return fmt.Errorf("task does not belong to the user")
+	tasks := make([]Task, 0, len(docs))
+	for _, doc := range docs {
+		var task Task
+		if err := doc.DataTo(&task); err != nil {
+			return TaskPage{}, err
+		}
+		tasks = append(tasks, task)
 	}
+	return TaskPage{Tasks: tasks, NextCursor: next}, nil
+}
 
-	// Update the status to done
-	_, err = doc.Update(ctx, []firestore.Update{
-		{Path: "status", Value: "done"},
-	})
+// GetAllTasks retrieves all tasks of a user, oldest day first
+func (tr *taskRepository) GetAllTasks(ctx context.Context, userID string) (*[]Task, error) {
+	var tasks []Task
+	iter := tr.client.Collection("tasks").
+		Where("user_id", "==", userID).
+		OrderBy("date", firestore.Asc).
+		Documents(ctx)
 
-	return err
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var task Task
+		if err := doc.DataTo(&task); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return &tasks, nil
+}
+
+// GetTasksInRange retrieves the tasks of a user between two days, both inclusive
+func (tr *taskRepository) GetTasksInRange(ctx context.Context, userID string, from civil.Date, to civil.Date) (*[]Task, error) {
+	var tasks []Task
+	iter := tr.client.Collection("tasks").
+		Where("user_id", "==", userID).
+		Where("date", ">=", StoredDate(from)).
+		Where("date", "<=", StoredDate(to)).
+		OrderBy("date", firestore.Asc).
+		Documents(ctx)
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var task Task
+		if err := doc.DataTo(&task); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return &tasks, nil
 }
 
-// GetTodayTasks retrieves tasks for the current date
-func (tr *taskRepository) GetTodayTasks(ctx context.Context, userID string) (*[]Task, error) {
-	today := time.Now().Truncate(24 * time.Hour)
-	return tr.GetTasksByDate(ctx, userID, today)
+// FindICalUIDs looks the UIDs up in batches of 30, the most an "in" filter takes
+func (tr *taskRepository) FindICalUIDs(ctx context.Context, userID string, uids []string) (map[string]bool, error) {
+	found := map[string]bool{}
+	for start := 0; start < len(uids); start += 30 {
+		end := min(start+30, len(uids))
+		iter := tr.client.Collection("tasks").
+			Where("user_id", "==", userID).
+			Where("ical_uid", "in", uids[start:end]).
+			Select("ical_uid").
+			Documents(ctx)
+
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if uid, ok := doc.Data()["ical_uid"].(string); ok {
+				found[uid] = true
+			}
+		}
+	}
+	return found, nil
 }
 
-// GetTaskById retrieves a task by its ID
-func (tr *taskRepository) GetTaskById(ctx context.Context, taskID string) (*Task, error) {
-	doc, err := tr.client.Collection("tasks").Doc(taskID).Get(ctx)
+// GetTasksChangedSince retrieves a page of the tasks of a user by last
+// update. A zero since lists every task with updated_at.
+func (tr *taskRepository) GetTasksChangedSince(ctx context.Context, userID string, since time.Time, page PageRequest) (TaskPage, error) {
+	docs, next, err := queryTimePage(ctx, tr.client.Collection("tasks").
+		Where("user_id", "==", userID).
+		Where("updated_at", ">=", since), "updated_at", firestore.Asc, page)
 	if err != nil {
-		return &Task{}, err
+		return TaskPage{}, err
 	}
-	var task Task
-	if err := doc.DataTo(&task); err != nil {
-		return &Task{}, err
+
+	tasks := make([]Task, 0, len(docs))
+	for _, doc := range docs {
+		var task Task
+		if err := doc.DataTo(&task); err != nil {
+			return TaskPage{}, err
+		}
+		tasks = append(tasks, task)
 	}
-	return &task, nil
+	return TaskPage{Tasks: tasks, NextCursor: next}, nil
 }
 
-// DeleteTaskById deletes a task by its ID
-func (tr *taskRepository) DeleteTaskById(ctx context.Context, taskID string) error {
-	_, err := tr.client.Collection("tasks").Doc(taskID).Delete(ctx)
-	return err
+// BackfillUpdatedAt reads every task a page at a time, as the tasks
+// without updated_at cannot be queried for, and sets it on those lacking it
+func (tr *taskRepository) BackfillUpdatedAt(ctx context.Context) (int, error) {
+	now := time.Now()
+	count := 0
+	page := PageRequest{Limit: MaxPageSize}
+	for {
+		docs, next, err := queryPage(ctx, tr.client.Collection("tasks").Query, page)
+		if err != nil {
+			return count, err
+		}
+		var missing []*firestore.DocumentRef
+		for _, doc := range docs {
+			at, _ := doc.DataAt("updated_at")
+			if t, ok := at.(time.Time); !ok || t.IsZero() {
+				missing = append(missing, doc.Ref)
+			}
+		}
+		if err := tr.setUpdatedAt(ctx, missing, now); err != nil {
+			return count, err
+		}
+		count += len(missing)
+		if next == "" {
+			return count, nil
+		}
+		page.Cursor = next
+	}
 }
 
-// DoneAllTaskDayByDate marks all tasks for a specific user on a specific date as done
-func (tr *taskRepository) DoneAllTaskDayByDate(ctx context.Context, userID string, date time.Time) error {
-	iter := tr.client.Collection("tasks").
+// setUpdatedAt sets updated_at of the given tasks to at
+func (tr *taskRepository) setUpdatedAt(ctx context.Context, refs []*firestore.DocumentRef, at time.Time) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	bulk := tr.client.BulkWriter(ctx)
+	jobs := make([]*firestore.BulkWriterJob, 0, len(refs))
+	for _, ref := range refs {
+		job, err := bulk.Update(ref, []firestore.Update{{Path: "updated_at", Value: at}})
+		if err != nil {
+			bulk.End()
+			return err
+		}
+		jobs = append(jobs, job)
+	}
+	bulk.End() // Blocking call that commits everything queued
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTombstonesSince retrieves the deleted tasks of a user by deletion time
+func (tr *taskRepository) GetTombstonesSince(ctx context.Context, userID string, since time.Time) ([]TaskTombstone, error) {
+	var tombstones []TaskTombstone
+	iter := tr.client.Collection("task_tombstones").
 		Where("user_id", "==", userID).
-		Where("date", "==", date).
+		Where("deleted_at", ">=", since).
 		Documents(ctx)
 
-	batch := tr.client.BulkWriter(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var tombstone TaskTombstone
+		if err := doc.DataTo(&tombstone); err != nil {
+			return nil, err
+		}
+		tombstones = append(tombstones, tombstone)
+	}
+	return tombstones, nil
+}
+
+// LastChangeAt reads the newest update and the newest deletion of the user's tasks
+func (tr *taskRepository) LastChangeAt(ctx context.Context, userID string) (time.Time, error) {
+	return tr.lastWrite(ctx, userID)
+}
+
+// LastWriteAt finds the latest update or deletion of any task
+func (tr *taskRepository) LastWriteAt(ctx context.Context) (time.Time, error) {
+	return tr.lastWrite(ctx, "")
+}
+
+// lastWrite finds the latest update or deletion of the tasks of userID, of
+// every user's tasks when it is empty
+func (tr *taskRepository) lastWrite(ctx context.Context, userID string) (time.Time, error) {
+	var last time.Time
+	for _, q := range []struct{ collection, field string }{
+		{"tasks", "updated_at"},
+		{"task_tombstones", "deleted_at"},
+	} {
+		query := tr.client.Collection(q.collection).Query
+		if userID != "" {
+			query = query.Where("user_id", "==", userID)
+		}
+		docs, err := query.
+			OrderBy(q.field, firestore.Desc).
+			Select(q.field).
+			Limit(1).
+			Documents(ctx).GetAll()
+		if err != nil {
+			return time.Time{}, err
+		}
+		if len(docs) == 0 {
+			continue
+		}
+		if at, ok := docs[0].Data()[q.field].(time.Time); ok && at.After(last) {
+			last = at
+		}
+	}
+	return last, nil
+}
+
+// GetTaskStatsInRange reads only the fields statistics need of the tasks between two days
+func (tr *taskRepository) GetTaskStatsInRange(ctx context.Context, userID string, from civil.Date, to civil.Date) ([]TaskStat, error) {
+	var stats []TaskStat
+	iter := tr.client.Collection("tasks").
+		Where("user_id", "==", userID).
+		Where("date", ">=", StoredDate(from)).
+		Where("date", "<=", StoredDate(to)).
+		Select("date", "status", "tags").
+		Documents(ctx)
 
 	for {
 		doc, err := iter.Next()
 		if err == iterator.Done {
 			break
 		}
+		if err != nil {
+			return nil, err
+		}
+		var stat TaskStat
+		if err := doc.DataTo(&stat); err != nil {
+			return nil, err
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+// CountTasks runs two count aggregations, so no task is read
+func (tr *taskRepository) CountTasks(ctx context.Context, userID string) (TaskCounts, error) {
+	var counts TaskCounts
+	for _, q := range []struct {
+		query firestore.Query
+		count *int
+	}{
+		{tr.client.Collection("tasks").Where("user_id", "==", userID), &counts.Total},
+		{tr.client.Collection("tasks").Where("user_id", "==", userID).Where("status", "==", "done"), &counts.Done},
+	} {
+		result, err := q.query.NewAggregationQuery().WithCount("count").Get(ctx)
+		if err != nil {
+			return TaskCounts{}, err
+		}
+		count, ok := result["count"].(*firestorepb.Value)
+		if !ok {
+			return TaskCounts{}, fmt.Errorf("unexpected count result %T", result["count"])
+		}
+		*q.count = int(count.GetIntegerValue())
+	}
+	return counts, nil
+}
+
+func (tr *taskRepository) DoneTaskById(ctx context.Context, userID string, taskID string) (*Task, []FieldChange, error) {
+	ref := tr.client.Collection("tasks").Doc(taskID)
+	var task *Task
+	var changes []FieldChange
+	err := tr.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		// Verify the userID in the transaction that updates the status
+		doc, err := tx.Get(ref)
+		current, err := ownTask(doc, err, userID)
 		if err != nil {
 			return err
 		}
+		task, changes = current, nil
+		if current.Status == "done" {
+			// Already done, nothing to write nor a new version
+			return nil
+		}
+		done := *current
+		done.Status = "done"
+		done.UpdatedAt = time.Now()
+		done.Version++
+		task, changes = &done, DiffTasks(*current, done)
+		return tx.Update(ref, []firestore.Update{
+			{Path: "status", Value: done.Status},
+			{Path: "updated_at", Value: done.UpdatedAt},
+			{Path: "version", Value: firestore.Increment(1)},
+		})
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return task, changes, nil
+}
+
+// GetTodayTasks retrieves tasks for the current date in the given timezone
+func (tr *taskRepository) GetTodayTasks(ctx context.Context, userID string, loc *time.Location) (*[]Task, error) {
+	return tr.GetTasksByDate(ctx, userID, civil.DateOf(time.Now().In(loc)))
+}
 
-		batch.Update(doc.Ref, []firestore.Update{
-			{Path: "status", Value: "done"},
+// GetTaskById retrieves a task by its ID
+func (tr *taskRepository) GetTaskById(ctx context.Context, userID string, taskID string) (*Task, error) {
+	doc, err := tr.client.Collection("tasks").Doc(taskID).Get(ctx)
+	return ownTask(doc, err, userID)
+}
+
+// DeleteTaskById moves a task to the trash and leaves a tombstone in its place
+func (tr *taskRepository) DeleteTaskById(ctx context.Context, userID string, taskID string) (*Task, error) {
+	ref := tr.client.Collection("tasks").Doc(taskID)
+	var task *Task
+	err := tr.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		task, err = ownTask(doc, err, userID)
+		if err != nil {
+			return err
+		}
+
+		task.DeletedAt = time.Now()
+		if err := tx.Set(tr.client.Collection("task_trash").Doc(taskID), task); err != nil {
+			return err
+		}
+		if err := tx.Delete(ref); err != nil {
+			return err
+		}
+		return tx.Set(tr.client.Collection("task_tombstones").Doc(taskID), TaskTombstone{
+			TaskID:    taskID,
+			UserID:    task.UserID,
+			DavName:   task.DavName,
+			DeletedAt: task.DeletedAt,
 		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// GetTrashedTasks retrieves a page of the tasks of a user in the trash
+func (tr *taskRepository) GetTrashedTasks(ctx context.Context, userID string, page PageRequest) (TaskPage, error) {
+	docs, next, err := queryTimePage(ctx, tr.client.Collection("task_trash").
+		Where("user_id", "==", userID), "deleted_at", firestore.Desc, page)
+	if err != nil {
+		return TaskPage{}, err
 	}
 
-	batch.Flush() // Blocking call to ensure all writes are committed
+	tasks := make([]Task, 0, len(docs))
+	for _, doc := range docs {
+		var task Task
+		if err := doc.DataTo(&task); err != nil {
+			return TaskPage{}, err
+		}
+		tasks = append(tasks, task)
+	}
+	return TaskPage{Tasks: tasks, NextCursor: next}, nil
+}
+
+// GetTrashedTaskById retrieves a task in the trash by its ID
+func (tr *taskRepository) GetTrashedTaskById(ctx context.Context, userID string, taskID string) (*Task, error) {
+	doc, err := tr.client.Collection("task_trash").Doc(taskID).Get(ctx)
+	return ownTask(doc, err, userID)
+}
+
+// RestoreTaskById moves a task back from the trash. The task counts as
+// changed now, so sync clients that saw the deletion pick it up again.
+func (tr *taskRepository) RestoreTaskById(ctx context.Context, userID string, taskID string) (*Task, error) {
+	trashRef := tr.client.Collection("task_trash").Doc(taskID)
+	ref := tr.client.Collection("tasks").Doc(taskID)
+	var task *Task
+	err := tr.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(trashRef)
+		task, err = ownTask(doc, err, userID)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Get(ref); err == nil {
+			return ErrTaskExists
+		} else if status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		task.DeletedAt = time.Time{}
+		task.UpdatedAt = time.Now()
+		task.Version++
+		if err := tx.Set(ref, task); err != nil {
+			return err
+		}
+		if err := tx.Delete(trashRef); err != nil {
+			return err
+		}
+		return tx.Delete(tr.client.Collection("task_tombstones").Doc(taskID))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// PurgeTaskById deletes a task in the trash; its tombstone stays for sync clients
+func (tr *taskRepository) PurgeTaskById(ctx context.Context, userID string, taskID string) error {
+	ref := tr.client.Collection("task_trash").Doc(taskID)
+	return tr.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if _, err := ownTask(doc, err, userID); err != nil {
+			return err
+		}
+		return tx.Delete(ref)
+	})
+}
+
+// PurgeTrash deletes the tasks of every user trashed before a time
+func (tr *taskRepository) PurgeTrash(ctx context.Context, before time.Time) ([]Task, error) {
+	iter := tr.client.Collection("task_trash").
+		Where("deleted_at", "<", before).
+		Documents(ctx)
+
+	var tasks []Task
+	var jobs []*firestore.BulkWriterJob
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var task Task
+		if err := doc.DataTo(&task); err != nil {
+			return nil, err
+		}
+		job, err := tr.bulk.Delete(doc.Ref)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+		jobs = append(jobs, job)
+	}
+
+	tr.bulk.Flush()
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			return nil, err
+		}
+	}
+	return tasks, nil
+}
+
+// DoneAllTaskDayByDate marks all tasks for a specific user on a specific date as done
+// and returns the ones that were not done before
+func (tr *taskRepository) DoneAllTaskDayByDate(ctx context.Context, userID string, date civil.Date) ([]ChangedTask, error) {
+	query := tr.client.Collection("tasks").
+		Where("user_id", "==", userID).
+		Where("date", "==", StoredDate(date))
+
+	var changed []ChangedTask
+	err := tr.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docs, err := tx.Documents(query).GetAll()
+		if err != nil {
+			return err
+		}
+		changed = nil
+		now := time.Now()
+		for _, doc := range docs {
+			var current Task
+			if err := doc.DataTo(&current); err != nil {
+				return err
+			}
+			if current.Status == "done" {
+				continue
+			}
+			done := current
+			done.Status = "done"
+			done.UpdatedAt = now
+			done.Version++
+			if err := tx.Update(doc.Ref, []firestore.Update{
+				{Path: "status", Value: done.Status},
+				{Path: "updated_at", Value: done.UpdatedAt},
+				{Path: "version", Value: firestore.Increment(1)},
+			}); err != nil {
+				return err
+			}
+			changed = append(changed, ChangedTask{Task: done, Changes: DiffTasks(current, done)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+// Close ends the shared bulk writer, committing everything still queued
+func (tr *taskRepository) Close() error {
+	tr.bulk.End()
 	return nil
 }
 
 // EditTaskById edits a task by its ID
-func (tr *taskRepository) EditTaskById(ctx context.Context, task TaskPayload) error {
+func (tr *taskRepository) EditTaskById(ctx context.Context, task TaskPayload) ([]FieldChange, error) {
 
 	taskMap := map[string]interface{}{
 		"task_id":     task.TaskID,
@@ -168,10 +960,35 @@ func (tr *taskRepository) EditTaskById(ctx context.Context, task TaskPayload) er
 		"description": task.Description,
 		"status":      task.Status,
 		"date":        task.Date,
+		"due_time":    task.DueTime,
+		"reminders":   task.Reminders,
+		"recurrence":  task.Recurrence,
+		"ical_uid":    task.ICalUID,
+		"tags":        task.Tags,
 		"created_at":  task.CreatedAt,
 		"updated_at":  task.UpdatedAt,
+		"version":     task.Version + 1,
 	}
 
-	_, err := tr.client.Collection("tasks").Doc(task.TaskID).Set(ctx, taskMap, firestore.MergeAll)
-	return err
+	// Check the owner and version and write in one transaction, so two
+	// edits of the same version cannot both succeed
+	ref := tr.client.Collection("tasks").Doc(task.TaskID)
+	var changes []FieldChange
+	err := tr.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		current, err := ownTask(doc, err, task.UserID)
+		if err != nil {
+			return err
+		}
+		// Tasks saved before versions were introduced have none, read as 0
+		if current.Version != task.Version {
+			return ErrVersionConflict
+		}
+		changes = DiffTasks(*current, Task(task))
+		return tx.Set(ref, taskMap, firestore.MergeAll)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
 }
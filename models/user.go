@@ -2,10 +2,13 @@ package models
 
 import (
 	"context"
+	"fmt"
 	"time"
 
+	"cloud.google.com/go/civil"
 	"cloud.google.com/go/firestore"
 	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/api/iterator"
 )
 
 // User represents a user in the application
@@ -14,10 +17,38 @@ type User struct {
 	Email     string    `firestore:"email"`
 	Password  string    `firestore:"password"` // Ensure this is hashed before storing
 	Name      string    `firestore:"name"`
+	Timezone  string    `firestore:"timezone"` // IANA name, e.g. Asia/Jakarta
+
+	// Reminder delivery channels besides the in-app list
+	NotifyEmail        bool   `firestore:"notify_email"`
+	ReminderWebhookURL string `firestore:"reminder_webhook_url"`
+	// ReminderWebhookSecret signs the requests to ReminderWebhookURL
+	ReminderWebhookSecret string `firestore:"reminder_webhook_secret"`
+
+	// Morning digest email, sent at DigestHour in the user's timezone
+	DigestEnabled bool   `firestore:"digest_enabled"`
+	DigestHour    int    `firestore:"digest_hour"`
+	LastDigestOn  string `firestore:"last_digest_on"` // civil date of the last digest sent, in the user's timezone
+
+	// CalendarToken is the secret in the user's calendar feed URL, empty until the feed is turned on
+	CalendarToken string `firestore:"calendar_token"`
+
 	CreatedAt time.Time `firestore:"created_at"`
 	UpdatedAt time.Time `firestore:"updated_at"`
 }
 
+// Location returns the user's timezone, UTC when unset or unknown
+func (u User) Location() *time.Location {
+	if u.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(u.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
 func (u *User) EncryptPassword (password string) error {
 	pass, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -63,6 +94,19 @@ type userRepository struct {
 type UserRepository interface {
 	CreateUser(ctx context.Context, user User) error
 	GetUser(ctx context.Context, userID string) (*User, error)
+	UpdateUser(ctx context.Context, user User) error
+	// ListUsers returns every user, e.g. for a backup
+	ListUsers(ctx context.Context) ([]User, error)
+	// ListDigestSubscribers returns every user who opted in to the digest email
+	ListDigestSubscribers(ctx context.Context) ([]User, error)
+	// MarkDigestSent records the day of the user's last digest so it is sent once a day
+	MarkDigestSent(ctx context.Context, userID string, day civil.Date) error
+	// UnsubscribeDigest turns the digest email off
+	UnsubscribeDigest(ctx context.Context, userID string) error
+	// GetUserByCalendarToken finds the owner of a calendar feed
+	GetUserByCalendarToken(ctx context.Context, token string) (*User, error)
+	// SetCalendarToken replaces the secret of the user's calendar feed, an empty token turns it off
+	SetCalendarToken(ctx context.Context, userID string, token string) error
 }
 
 func NewUserRepository(client *firestore.Client) UserRepository {
@@ -89,3 +133,89 @@ func (ur *userRepository) GetUser(ctx context.Context, userID string) (*User, er
 	}
 	return &user, nil
 }
+
+// UpdateUser saves the user's editable profile fields
+func (ur *userRepository) UpdateUser(ctx context.Context, user User) error {
+	_, err := ur.client.Collection("users").Doc(user.UserID).Set(ctx, map[string]interface{}{
+		"name":                    user.Name,
+		"timezone":                user.Timezone,
+		"notify_email":            user.NotifyEmail,
+		"reminder_webhook_url":    user.ReminderWebhookURL,
+		"reminder_webhook_secret": user.ReminderWebhookSecret,
+		"digest_enabled":          user.DigestEnabled,
+		"digest_hour":             user.DigestHour,
+		"updated_at":              user.UpdatedAt,
+	}, firestore.MergeAll)
+	return err
+}
+
+// ListUsers retrieves all users
+func (ur *userRepository) ListUsers(ctx context.Context) ([]User, error) {
+	return ur.users(ur.client.Collection("users").Documents(ctx))
+}
+
+// ListDigestSubscribers retrieves the users with the digest email turned on
+func (ur *userRepository) ListDigestSubscribers(ctx context.Context) ([]User, error) {
+	return ur.users(ur.client.Collection("users").Where("digest_enabled", "==", true).Documents(ctx))
+}
+
+func (ur *userRepository) users(iter *firestore.DocumentIterator) ([]User, error) {
+	var users []User
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var user User
+		if err := doc.DataTo(&user); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// MarkDigestSent stores the day the user's digest was sent
+func (ur *userRepository) MarkDigestSent(ctx context.Context, userID string, day civil.Date) error {
+	_, err := ur.client.Collection("users").Doc(userID).Update(ctx, []firestore.Update{
+		{Path: "last_digest_on", Value: day.String()},
+	})
+	return err
+}
+
+// UnsubscribeDigest turns the digest email off for the user
+func (ur *userRepository) UnsubscribeDigest(ctx context.Context, userID string) error {
+	_, err := ur.client.Collection("users").Doc(userID).Update(ctx, []firestore.Update{
+		{Path: "digest_enabled", Value: false},
+		{Path: "updated_at", Value: time.Now()},
+	})
+	return err
+}
+
+// GetUserByCalendarToken retrieves the user whose calendar feed uses token
+func (ur *userRepository) GetUserByCalendarToken(ctx context.Context, token string) (*User, error) {
+	docs, err := ur.client.Collection("users").Where("calendar_token", "==", token).Limit(1).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("no calendar feed for this token")
+	}
+	var user User
+	if err := docs[0].DataTo(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SetCalendarToken stores the secret of the user's calendar feed
+func (ur *userRepository) SetCalendarToken(ctx context.Context, userID string, token string) error {
+	_, err := ur.client.Collection("users").Doc(userID).Update(ctx, []firestore.Update{
+		{Path: "calendar_token", Value: token},
+		{Path: "updated_at", Value: time.Now()},
+	})
+	return err
+}
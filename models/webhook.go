@@ -0,0 +1,238 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// Task events a webhook can subscribe to
+const (
+	EventTaskCreated   = "task.created"
+	EventTaskUpdated   = "task.updated"
+	EventTaskCompleted = "task.completed"
+	EventTaskDeleted   = "task.deleted"
+	// EventPing is only sent by the "send test event" button
+	EventPing = "ping"
+)
+
+// TaskEvents lists the events users can choose from
+var TaskEvents = []string{EventTaskCreated, EventTaskUpdated, EventTaskCompleted, EventTaskDeleted}
+
+// Statuses of a WebhookDelivery
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a URL a user registered to receive task events
+type Webhook struct {
+	ID        string    `firestore:"id"`
+	UserID    string    `firestore:"user_id"`
+	URL       string    `firestore:"url"`
+	Secret    string    `firestore:"secret"` // HMAC key for the signature header
+	Events    []string  `firestore:"events"`
+	CreatedAt time.Time `firestore:"created_at"`
+}
+
+// WebhookDelivery is one event on its way to one webhook. Pending
+// deliveries form the outgoing queue; finished ones are the delivery log.
+type WebhookDelivery struct {
+	ID             string    `firestore:"id"`
+	WebhookID      string    `firestore:"webhook_id"`
+	UserID         string    `firestore:"user_id"`
+	URL            string    `firestore:"url"`
+	Event          string    `firestore:"event"`
+	Payload        string    `firestore:"payload"` // JSON body, signed as is
+	Status         string    `firestore:"status"`
+	Attempts       int       `firestore:"attempts"`
+	NextAttemptAt  time.Time `firestore:"next_attempt_at"`
+	ResponseStatus int       `firestore:"response_status"`
+	LastError      string    `firestore:"last_error"`
+	CreatedAt      time.Time `firestore:"created_at"`
+	UpdatedAt      time.Time `firestore:"updated_at"`
+}
+
+type webhookRepository struct {
+	client *firestore.Client
+}
+
+type WebhookRepository interface {
+	CreateWebhook(ctx context.Context, webhook Webhook) (*Webhook, error)
+	GetWebhook(ctx context.Context, userID string, webhookID string) (*Webhook, error)
+	ListWebhooks(ctx context.Context, userID string) ([]Webhook, error)
+	DeleteWebhook(ctx context.Context, userID string, webhookID string) error
+	// ListSubscribed returns the user's webhooks that subscribed to event
+	ListSubscribed(ctx context.Context, userID string, event string) ([]Webhook, error)
+
+	// Enqueue stores deliveries as pending
+	Enqueue(ctx context.Context, deliveries []WebhookDelivery) ([]WebhookDelivery, error)
+	// DueDeliveries returns up to limit pending deliveries whose next attempt is not after now, oldest first
+	DueDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error)
+	// ListDeliveries returns the newest deliveries of a webhook first
+	ListDeliveries(ctx context.Context, webhookID string, limit int) ([]WebhookDelivery, error)
+	// FinishDelivery records the final outcome of a delivery
+	FinishDelivery(ctx context.Context, id string, status string, attempts int, responseStatus int, lastError string) error
+	// RetryDelivery pushes a failed delivery back to next
+	RetryDelivery(ctx context.Context, id string, next time.Time, attempts int, responseStatus int, lastError string) error
+}
+
+func NewWebhookRepository(client *firestore.Client) WebhookRepository {
+	return &webhookRepository{
+		client: client,
+	}
+}
+
+// CreateWebhook stores a new webhook and returns it with its ID
+func (wr *webhookRepository) CreateWebhook(ctx context.Context, webhook Webhook) (*Webhook, error) {
+	ref := wr.client.Collection("webhooks").NewDoc()
+	webhook.ID = ref.ID
+	if _, err := ref.Set(ctx, webhook); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// GetWebhook retrieves a webhook of the user by its ID
+func (wr *webhookRepository) GetWebhook(ctx context.Context, userID string, webhookID string) (*Webhook, error) {
+	doc, err := wr.client.Collection("webhooks").Doc(webhookID).Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var webhook Webhook
+	if err := doc.DataTo(&webhook); err != nil {
+		return nil, err
+	}
+	if webhook.UserID != userID {
+		return nil, fmt.Errorf("webhook does not belong to the user")
+	}
+	return &webhook, nil
+}
+
+// ListWebhooks retrieves every webhook of the user
+func (wr *webhookRepository) ListWebhooks(ctx context.Context, userID string) ([]Webhook, error) {
+	return wr.webhooks(wr.client.Collection("webhooks").
+		Where("user_id", "==", userID).
+		Documents(ctx))
+}
+
+// DeleteWebhook deletes a webhook of the user; its delivery log is kept
+func (wr *webhookRepository) DeleteWebhook(ctx context.Context, userID string, webhookID string) error {
+	if _, err := wr.GetWebhook(ctx, userID, webhookID); err != nil {
+		return err
+	}
+	_, err := wr.client.Collection("webhooks").Doc(webhookID).Delete(ctx)
+	return err
+}
+
+// ListSubscribed retrieves the user's webhooks subscribed to event
+func (wr *webhookRepository) ListSubscribed(ctx context.Context, userID string, event string) ([]Webhook, error) {
+	return wr.webhooks(wr.client.Collection("webhooks").
+		Where("user_id", "==", userID).
+		Where("events", "array-contains", event).
+		Documents(ctx))
+}
+
+func (wr *webhookRepository) webhooks(iter *firestore.DocumentIterator) ([]Webhook, error) {
+	var webhooks []Webhook
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var webhook Webhook
+		if err := doc.DataTo(&webhook); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+// Enqueue stores the deliveries in one batch and returns them with their IDs
+func (wr *webhookRepository) Enqueue(ctx context.Context, deliveries []WebhookDelivery) ([]WebhookDelivery, error) {
+	if len(deliveries) == 0 {
+		return nil, nil
+	}
+
+	col := wr.client.Collection("webhook_deliveries")
+	batch := wr.client.Batch()
+	for i := range deliveries {
+		ref := col.NewDoc()
+		deliveries[i].ID = ref.ID
+		batch.Create(ref, deliveries[i])
+	}
+	if _, err := batch.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// DueDeliveries lists pending deliveries that should be attempted by now
+func (wr *webhookRepository) DueDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error) {
+	return wr.deliveries(wr.client.Collection("webhook_deliveries").
+		Where("status", "==", DeliveryPending).
+		Where("next_attempt_at", "<=", now).
+		OrderBy("next_attempt_at", firestore.Asc).
+		Limit(limit).
+		Documents(ctx))
+}
+
+// ListDeliveries retrieves the latest deliveries of a webhook
+func (wr *webhookRepository) ListDeliveries(ctx context.Context, webhookID string, limit int) ([]WebhookDelivery, error) {
+	return wr.deliveries(wr.client.Collection("webhook_deliveries").
+		Where("webhook_id", "==", webhookID).
+		OrderBy("created_at", firestore.Desc).
+		Limit(limit).
+		Documents(ctx))
+}
+
+func (wr *webhookRepository) deliveries(iter *firestore.DocumentIterator) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var delivery WebhookDelivery
+		if err := doc.DataTo(&delivery); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// FinishDelivery records the final outcome of a delivery
+func (wr *webhookRepository) FinishDelivery(ctx context.Context, id string, status string, attempts int, responseStatus int, lastError string) error {
+	_, err := wr.client.Collection("webhook_deliveries").Doc(id).Update(ctx, []firestore.Update{
+		{Path: "status", Value: status},
+		{Path: "attempts", Value: attempts},
+		{Path: "response_status", Value: responseStatus},
+		{Path: "last_error", Value: lastError},
+		{Path: "updated_at", Value: time.Now()},
+	})
+	return err
+}
+
+// RetryDelivery pushes a failed delivery back to next
+func (wr *webhookRepository) RetryDelivery(ctx context.Context, id string, next time.Time, attempts int, responseStatus int, lastError string) error {
+	_, err := wr.client.Collection("webhook_deliveries").Doc(id).Update(ctx, []firestore.Update{
+		{Path: "next_attempt_at", Value: next},
+		{Path: "attempts", Value: attempts},
+		{Path: "response_status", Value: responseStatus},
+		{Path: "last_error", Value: lastError},
+		{Path: "updated_at", Value: time.Now()},
+	})
+	return err
+}
@@ -0,0 +1,32 @@
+package notify
+
+import (
+	"context"
+
+	"github.com/Zenk41/go-gin-htmx/mail"
+	"github.com/Zenk41/go-gin-htmx/models"
+)
+
+// Email mails the message to users who enabled email notifications
+type Email struct {
+	Mailer  mail.Mailer
+	BaseURL string
+}
+
+func (n *Email) Name() string { return "email" }
+
+func (n *Email) Notify(ctx context.Context, user models.User, msg Message) error {
+	if !user.NotifyEmail || user.Email == "" {
+		return nil
+	}
+
+	text := msg.Body
+	if msg.Link != "" {
+		text += "\n\n" + n.BaseURL + msg.Link
+	}
+	return n.Mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: msg.Title,
+		Text:    text,
+	})
+}
@@ -0,0 +1,37 @@
+package notify
+
+import (
+	"context"
+
+	"github.com/Zenk41/go-gin-htmx/models"
+	"github.com/Zenk41/go-gin-htmx/pubsub"
+)
+
+// InApp stores the message in the user's notification list and publishes
+// it to the user's open pages when a Broker is set
+type InApp struct {
+	Repo   models.NotificationRepository
+	Broker pubsub.Broker
+}
+
+func (n *InApp) Name() string { return "in_app" }
+
+func (n *InApp) Notify(ctx context.Context, user models.User, msg Message) error {
+	notification, err := n.Repo.CreateNotification(ctx, models.Notification{
+		UserID:    user.UserID,
+		Kind:      msg.Kind,
+		Title:     msg.Title,
+		Body:      msg.Body,
+		Link:      msg.Link,
+		TaskID:    msg.TaskID,
+		CreatedAt: msg.SentAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	if n.Broker != nil {
+		n.Broker.Publish(pubsub.UserTopic(user.UserID), pubsub.Event{Name: "notification", Data: notification})
+	}
+	return nil
+}
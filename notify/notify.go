@@ -0,0 +1,63 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/Zenk41/go-gin-htmx/models"
+)
+
+// Message is a notification about a task addressed to one user
+type Message struct {
+	Kind   string    `json:"kind"` // "reminder" or "overdue"
+	Title  string    `json:"title"`
+	Body   string    `json:"body"`
+	Link   string    `json:"link"`
+	TaskID string    `json:"task_id"`
+	SentAt time.Time `json:"sent_at"`
+}
+
+// Notifier delivers a message to a user over one channel. Implementations
+// decide from the user's settings whether the channel applies to them and
+// return nil without sending when it does not.
+type Notifier interface {
+	Notify(ctx context.Context, user models.User, msg Message) error
+}
+
+// Channel is a notifier with a name, under which Multi tracks what a
+// message reached
+type Channel interface {
+	Notifier
+	Name() string
+}
+
+// Multi fans a message out to every channel and reports all failures
+type Multi []Channel
+
+func (m Multi) Notify(ctx context.Context, user models.User, msg Message) error {
+	_, err := m.NotifyPending(ctx, user, msg, nil)
+	return err
+}
+
+// NotifyPending sends msg over the channels not named in done, those that
+// delivered it on an earlier attempt, and returns done with the channels
+// that succeeded this time added. Retrying with the result sends msg only
+// over the channels that failed.
+func (m Multi) NotifyPending(ctx context.Context, user models.User, msg Message, done []string) ([]string, error) {
+	done = slices.Clip(done)
+	var errs []error
+	for _, n := range m {
+		if slices.Contains(done, n.Name()) {
+			continue
+		}
+		if err := n.Notify(ctx, user, msg); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", n.Name(), err))
+			continue
+		}
+		done = append(done, n.Name())
+	}
+	return done, errors.Join(errs...)
+}
@@ -0,0 +1,61 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Zenk41/go-gin-htmx/models"
+	"github.com/Zenk41/go-gin-htmx/webhooks"
+)
+
+// Webhook posts the message as JSON to the user's reminder webhook URL,
+// signed like task event webhooks with the user's reminder webhook secret
+type Webhook struct {
+	Client *http.Client // from webhooks.NewClient, so URLs inside the network are refused
+}
+
+// EventReminder is the X-Webhook-Event of reminder webhook requests
+const EventReminder = "reminder"
+
+type webhookPayload struct {
+	UserID string `json:"user_id"`
+	Message
+}
+
+func (n *Webhook) Name() string { return "webhook" }
+
+func (n *Webhook) Notify(ctx context.Context, user models.User, msg Message) error {
+	if user.ReminderWebhookURL == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(webhookPayload{UserID: user.UserID, Message: msg})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, user.ReminderWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhooks.EventHeader, EventReminder)
+	if user.ReminderWebhookSecret != "" {
+		req.Header.Set(webhooks.SignatureHeader, webhooks.Sign(user.ReminderWebhookSecret, time.Now(), body))
+	}
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s answered %s", user.ReminderWebhookURL, resp.Status)
+	}
+	return nil
+}
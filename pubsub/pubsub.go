@@ -0,0 +1,105 @@
+package pubsub
+
+import (
+	"sync"
+)
+
+// Event is a message published on a topic. Name becomes the SSE event name.
+// Data is sent as is when it is a string, such as the HTML fragments HTMX
+// swaps in, and JSON-encoded otherwise.
+type Event struct {
+	Name string `json:"name"`
+	Data any    `json:"data"`
+}
+
+// Broker fans events out to the subscribers of a topic. Delivery is best
+// effort: a subscriber that falls behind misses events rather than
+// blocking the publisher.
+type Broker interface {
+	Publish(topic string, event Event)
+	// Subscribe returns a channel of events on topic and a function that
+	// ends the subscription. The channel is closed when the subscription
+	// ends or the broker is closed.
+	Subscribe(topic string) (<-chan Event, func())
+	// Close ends every subscription
+	Close()
+}
+
+// UserTopic is the topic carrying the events of one user
+func UserTopic(userID string) string {
+	return "user:" + userID
+}
+
+// Memory is an in-process Broker. It only reaches subscribers in the same
+// process, so running several replicas needs a shared Broker instead.
+type Memory struct {
+	buffer int
+
+	mu     sync.Mutex
+	subs   map[string]map[chan Event]struct{}
+	closed bool
+}
+
+// NewMemory returns a broker whose subscriber channels hold buffer events
+func NewMemory(buffer int) *Memory {
+	return &Memory{
+		buffer: buffer,
+		subs:   map[string]map[chan Event]struct{}{},
+	}
+}
+
+func (m *Memory) Publish(topic string, event Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for ch := range m.subs[topic] {
+		select {
+		case ch <- event:
+		default: // subscriber is not keeping up, drop the event
+		}
+	}
+}
+
+func (m *Memory) Subscribe(topic string) (<-chan Event, func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ch := make(chan Event, m.buffer)
+	if m.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if m.subs[topic] == nil {
+		m.subs[topic] = map[chan Event]struct{}{}
+	}
+	m.subs[topic][ch] = struct{}{}
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if _, ok := m.subs[topic][ch]; !ok {
+				return // already closed by Close
+			}
+			delete(m.subs[topic], ch)
+			if len(m.subs[topic]) == 0 {
+				delete(m.subs, topic)
+			}
+			close(ch)
+		})
+	}
+}
+
+func (m *Memory) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	for topic, subs := range m.subs {
+		for ch := range subs {
+			close(ch)
+		}
+		delete(m.subs, topic)
+	}
+}
@@ -0,0 +1,539 @@
+// Package quickadd reads a task from a single line of text such as
+//
+//	Call dentist tomorrow 3pm #health !high every monday
+//
+// The words describing the day, the time, tags, priority and repetition are
+// taken out of the line and whatever is left becomes the title. Days are
+// read relative to the date passed to Parse, which callers compute in the
+// user's timezone. Words in double quotes are always kept in the title.
+//
+// The package has no dependencies on the rest of the application.
+package quickadd
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+)
+
+// Task is what a quick-add line describes
+type Task struct {
+	Title string
+	// Date is zero when the line names no day
+	Date civil.Date
+	// DueTime is "15:04", or empty when the line names no time
+	DueTime string
+	// Tags are lowercased and without the leading #
+	Tags []string
+	// Priority is 1 for the highest, 3 for the lowest and 0 when none is given
+	Priority int
+	// Recurrence is an RRULE value such as FREQ=WEEKLY;BYDAY=MO, or empty
+	Recurrence string
+}
+
+// PriorityTag is the tag recording a priority, p1 to p3, which is how
+// imported tasks record it too; empty for no priority
+func PriorityTag(priority int) string {
+	if priority < 1 || priority > 3 {
+		return ""
+	}
+	return fmt.Sprintf("p%d", priority)
+}
+
+// AllTags returns the tags of the task with its priority tag, if any
+func (t Task) AllTags() []string {
+	tags := append([]string(nil), t.Tags...)
+	if tag := PriorityTag(t.Priority); tag != "" {
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// Parse reads a quick-add line. Only the first day, time, priority and
+// repetition in the line count; later ones stay in the title.
+func Parse(line string, today civil.Date) Task {
+	p := parser{words: strings.Fields(line), today: today}
+	for i := 0; i < len(p.words); {
+		if n := p.quoted(i); n > 0 {
+			i += n
+			continue
+		}
+		if n := p.match(i); n > 0 {
+			i += n
+			continue
+		}
+		p.title = append(p.title, p.words[i])
+		i++
+	}
+
+	// A day of the week to repeat on is also the first day when none is given
+	if p.task.Date.IsZero() && len(p.days) > 0 {
+		p.task.Date = nextWeekday(today, p.days, true)
+	}
+	p.task.Title = strings.Join(p.title, " ")
+	return p.task
+}
+
+type parser struct {
+	words []string
+	today civil.Date
+	task  Task
+	title []string
+	// days are the weekdays the task repeats on
+	days []time.Weekday
+}
+
+// word returns the i-th word lowercased and without trailing punctuation,
+// or "" past the end of the line
+func (p *parser) word(i int) string {
+	if i < 0 || i >= len(p.words) {
+		return ""
+	}
+	return strings.TrimRight(strings.ToLower(p.words[i]), ",.;:?")
+}
+
+// match tries every kind of field at word i and returns how many words
+// the one that matched took, or 0
+func (p *parser) match(i int) int {
+	for _, m := range []func(int) int{p.tag, p.priority, p.recurrence, p.date, p.time} {
+		if n := m(i); n > 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// quoted keeps the words between double quotes in the title as written
+func (p *parser) quoted(i int) int {
+	if !strings.HasPrefix(p.words[i], `"`) {
+		return 0
+	}
+	for j := i; j < len(p.words); j++ {
+		w := p.words[j]
+		if j == i {
+			w = w[1:]
+		}
+		if strings.HasSuffix(w, `"`) {
+			quoted := strings.Join(p.words[i:j+1], " ")
+			p.title = append(p.title, strings.Trim(quoted, `"`))
+			return j - i + 1
+		}
+	}
+	// An unclosed quote is just part of the title
+	return 0
+}
+
+func (p *parser) tag(i int) int {
+	w := p.word(i)
+	if !strings.HasPrefix(w, "#") {
+		return 0
+	}
+	tag := strings.TrimLeft(w, "#")
+	if _, err := strconv.Atoi(tag); tag == "" || err == nil {
+		// "#1" reads as a number, not a tag
+		return 0
+	}
+	for _, t := range p.task.Tags {
+		if t == tag {
+			return 1
+		}
+	}
+	p.task.Tags = append(p.task.Tags, tag)
+	return 1
+}
+
+var priorities = map[string]int{
+	"high": 1, "h": 1, "1": 1, "p1": 1, "!!": 1,
+	"medium": 2, "med": 2, "m": 2, "2": 2, "p2": 2, "!": 2,
+	"low": 3, "l": 3, "3": 3, "p3": 3,
+}
+
+func (p *parser) priority(i int) int {
+	w := p.word(i)
+	if p.task.Priority != 0 || !strings.HasPrefix(w, "!") {
+		return 0
+	}
+	priority, ok := priorities[w[1:]]
+	if !ok {
+		return 0
+	}
+	p.task.Priority = priority
+	return 1
+}
+
+var frequencies = map[string]string{
+	"day": "DAILY", "days": "DAILY",
+	"week": "WEEKLY", "weeks": "WEEKLY",
+	"month": "MONTHLY", "months": "MONTHLY",
+	"year": "YEARLY", "years": "YEARLY",
+}
+
+var adverbs = map[string]string{
+	"daily":    "FREQ=DAILY",
+	"weekly":   "FREQ=WEEKLY",
+	"monthly":  "FREQ=MONTHLY",
+	"yearly":   "FREQ=YEARLY",
+	"annually": "FREQ=YEARLY",
+}
+
+// byday are the RRULE names of the weekdays
+var byday = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// recurrence reads "daily", "every day", "every 2 weeks", "every other
+// month", "every weekday", "every weekend", "every monday and thursday"
+// and "every other friday"
+func (p *parser) recurrence(i int) int {
+	if p.task.Recurrence != "" {
+		return 0
+	}
+	w := p.word(i)
+	if rule, ok := adverbs[w]; ok {
+		p.task.Recurrence = rule
+		return 1
+	}
+	if w != "every" && w != "each" {
+		return 0
+	}
+
+	j := i + 1
+	interval := 1
+	if p.word(j) == "other" {
+		interval = 2
+		j++
+	} else if n, ok := number(p.word(j)); ok {
+		interval = n
+		j++
+	}
+
+	var days []time.Weekday
+	switch unit := p.word(j); {
+	case frequencies[unit] != "":
+		p.task.Recurrence = "FREQ=" + frequencies[unit]
+		j++
+	case unit == "weekday" || unit == "weekdays":
+		days = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+		j++
+	case unit == "weekend" || unit == "weekends":
+		days = []time.Weekday{time.Saturday, time.Sunday}
+		j++
+	default:
+		for {
+			day, ok := weekday(p.word(j))
+			if !ok {
+				break
+			}
+			days = append(days, day)
+			j++
+			// Lists are joined by commas, which word drops, or "and"
+			if next := p.word(j); next == "and" || next == "&" {
+				if _, ok := weekday(p.word(j + 1)); ok {
+					j++
+				}
+			}
+		}
+		if len(days) == 0 {
+			return 0
+		}
+	}
+
+	if len(days) > 0 {
+		names := make([]string, len(days))
+		for k, day := range days {
+			names[k] = byday[day]
+		}
+		p.task.Recurrence = "FREQ=WEEKLY;BYDAY=" + strings.Join(names, ",")
+		p.days = days
+	}
+	if interval > 1 {
+		p.task.Recurrence += fmt.Sprintf(";INTERVAL=%d", interval)
+	}
+	return j - i
+}
+
+// date reads a day and the "on", "by" or "due" before it
+func (p *parser) date(i int) int {
+	if !p.task.Date.IsZero() {
+		return 0
+	}
+	switch p.word(i) {
+	case "on", "by", "due":
+		if d, n := p.day(i + 1); n > 0 {
+			p.task.Date = d
+			return n + 1
+		}
+		return 0
+	}
+	d, n := p.day(i)
+	if n > 0 {
+		p.task.Date = d
+	}
+	return n
+}
+
+// day reads "today", "tomorrow", "monday", "this friday", "next week",
+// "in 3 days", "2024-05-01", "may 5", "5th may" and "may 5 2027"
+func (p *parser) day(i int) (civil.Date, int) {
+	w := p.word(i)
+	switch w {
+	case "today", "tonight":
+		return p.today, 1
+	case "tomorrow", "tmr", "tmrw":
+		return p.today.AddDays(1), 1
+	case "next":
+		switch next := p.word(i + 1); next {
+		case "week":
+			return p.today.AddDays(7), 2
+		case "month":
+			return addDate(p.today, 0, 1), 2
+		case "year":
+			return addDate(p.today, 1, 0), 2
+		default:
+			if day, ok := weekday(next); ok {
+				return nextWeekday(p.today, []time.Weekday{day}, false), 2
+			}
+		}
+		return civil.Date{}, 0
+	case "this":
+		if day, ok := weekday(p.word(i + 1)); ok {
+			return nextWeekday(p.today, []time.Weekday{day}, true), 2
+		}
+		return civil.Date{}, 0
+	case "in":
+		n, ok := number(p.word(i + 1))
+		if !ok {
+			return civil.Date{}, 0
+		}
+		switch frequencies[p.word(i+2)] {
+		case "DAILY":
+			return p.today.AddDays(n), 3
+		case "WEEKLY":
+			return p.today.AddDays(7 * n), 3
+		case "MONTHLY":
+			return addDate(p.today, 0, n), 3
+		case "YEARLY":
+			return addDate(p.today, n, 0), 3
+		}
+		return civil.Date{}, 0
+	}
+
+	// Alone, only full names are days: "sun" and "sat" are words too
+	if day, ok := weekday(w); ok && strings.HasSuffix(w, "day") {
+		return nextWeekday(p.today, []time.Weekday{day}, false), 1
+	}
+	if d, err := civil.ParseDate(w); err == nil {
+		return d, 1
+	}
+
+	// A month and a day in either order, then maybe a year
+	var month time.Month
+	var dom int
+	if m, ok := monthOf(w); ok {
+		if d, ok := dayOfMonth(p.word(i + 1)); ok {
+			month, dom = m, d
+		}
+	} else if d, ok := dayOfMonth(w); ok {
+		if m, ok := monthOf(p.word(i + 1)); ok {
+			month, dom = m, d
+		}
+	}
+	if month == 0 {
+		return civil.Date{}, 0
+	}
+	if year, ok := yearOf(p.word(i + 2)); ok {
+		d := civil.Date{Year: year, Month: month, Day: dom}
+		if !d.IsValid() {
+			return civil.Date{}, 0
+		}
+		return d, 3
+	}
+	// Without a year the next such day is meant
+	d := civil.Date{Year: p.today.Year, Month: month, Day: dom}
+	if d.IsValid() && d.Before(p.today) {
+		d.Year++
+	}
+	// February 29th waits for the next leap year
+	for y := 0; y < 4 && !d.IsValid(); y++ {
+		d.Year++
+	}
+	if !d.IsValid() {
+		return civil.Date{}, 0
+	}
+	return d, 2
+}
+
+var clock = regexp.MustCompile(`^(\d{1,2})(?:[:.](\d{2}))?(am|pm|a|p)?$`)
+
+// time reads "3pm", "3:30pm", "15:00", "3 pm", "noon", "midnight" and an
+// "at" before any of them. After "at" a bare hour is taken as written, so
+// "at 9" is 09:00.
+func (p *parser) time(i int) int {
+	if p.task.DueTime != "" {
+		return 0
+	}
+	if p.word(i) == "at" {
+		if t, n := p.clock(i+1, true); n > 0 {
+			p.task.DueTime = t
+			return n + 1
+		}
+		return 0
+	}
+	t, n := p.clock(i, false)
+	if n > 0 {
+		p.task.DueTime = t
+	}
+	return n
+}
+
+func (p *parser) clock(i int, bare bool) (string, int) {
+	switch w := p.word(i); w {
+	case "noon", "midday":
+		return "12:00", 1
+	case "midnight":
+		return "00:00", 1
+	}
+
+	m := clock.FindStringSubmatch(p.word(i))
+	if m == nil {
+		return "", 0
+	}
+	n := 1
+	hour, _ := strconv.Atoi(m[1])
+	minute := 0
+	if m[2] != "" {
+		minute, _ = strconv.Atoi(m[2])
+	}
+	suffix := m[3]
+	if suffix == "" && m[2] == "" {
+		// "3 pm" is two words
+		switch p.word(i + 1) {
+		case "am", "a.m", "pm", "p.m":
+			suffix = p.word(i + 1)
+			n = 2
+		}
+	}
+	if suffix == "" && m[2] == "" && !bare {
+		// A bare number is part of the title, as in "buy 3 apples"
+		return "", 0
+	}
+
+	if suffix != "" {
+		if hour < 1 || hour > 12 {
+			return "", 0
+		}
+		hour %= 12
+		if strings.HasPrefix(suffix, "p") {
+			hour += 12
+		}
+	}
+	if hour > 23 || minute > 59 {
+		return "", 0
+	}
+	return fmt.Sprintf("%02d:%02d", hour, minute), n
+}
+
+// nextWeekday returns the first of days after from, or on it when
+// inclusive is set
+func nextWeekday(from civil.Date, days []time.Weekday, inclusive bool) civil.Date {
+	start := 1
+	if inclusive {
+		start = 0
+	}
+	for n := start; n < start+7; n++ {
+		d := from.AddDays(n)
+		for _, day := range days {
+			if d.In(time.UTC).Weekday() == day {
+				return d
+			}
+		}
+	}
+	return from
+}
+
+// addDate moves d by years and months, clamping to the end of shorter
+// months so January 31st plus a month is the last day of February
+func addDate(d civil.Date, years int, months int) civil.Date {
+	first := civil.Date{Year: d.Year, Month: d.Month, Day: 1}
+	moved := civil.DateOf(first.In(time.UTC).AddDate(years, months, 0))
+	last := civil.DateOf(moved.In(time.UTC).AddDate(0, 1, -1)).Day
+	if d.Day < last {
+		moved.Day = d.Day
+	} else {
+		moved.Day = last
+	}
+	return moved
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday, "tues": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday, "thur": time.Thursday, "thurs": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
+
+func weekday(w string) (time.Weekday, bool) {
+	day, ok := weekdays[strings.TrimSuffix(w, "s")]
+	if !ok {
+		day, ok = weekdays[w]
+	}
+	return day, ok
+}
+
+var months = map[string]time.Month{
+	"january": time.January, "jan": time.January,
+	"february": time.February, "feb": time.February,
+	"march": time.March, "mar": time.March,
+	"april": time.April, "apr": time.April,
+	"may":  time.May,
+	"june": time.June, "jun": time.June,
+	"july": time.July, "jul": time.July,
+	"august": time.August, "aug": time.August,
+	"september": time.September, "sep": time.September, "sept": time.September,
+	"october": time.October, "oct": time.October,
+	"november": time.November, "nov": time.November,
+	"december": time.December, "dec": time.December,
+}
+
+func monthOf(w string) (time.Month, bool) {
+	m, ok := months[w]
+	return m, ok
+}
+
+var ordinal = regexp.MustCompile(`^(\d{1,2})(st|nd|rd|th)?$`)
+
+func dayOfMonth(w string) (int, bool) {
+	m := ordinal.FindStringSubmatch(w)
+	if m == nil {
+		return 0, false
+	}
+	d, _ := strconv.Atoi(m[1])
+	return d, d >= 1 && d <= 31
+}
+
+func yearOf(w string) (int, bool) {
+	if len(w) != 4 {
+		return 0, false
+	}
+	y, err := strconv.Atoi(w)
+	return y, err == nil && y >= 2000 && y < 2100
+}
+
+var numbers = map[string]int{
+	"a": 1, "an": 1, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5,
+	"six": 6, "seven": 7, "eight": 8, "nine": 9, "ten": 10,
+}
+
+// number reads a count written in digits or as a small word
+func number(w string) (int, bool) {
+	if n, ok := numbers[w]; ok {
+		return n, true
+	}
+	n, err := strconv.Atoi(w)
+	return n, err == nil && n > 0
+}
@@ -0,0 +1,279 @@
+package quickadd
+
+import (
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/civil"
+)
+
+// today is a Monday
+var today = civil.Date{Year: 2026, Month: 10, Day: 19}
+
+func date(s string) civil.Date {
+	d, err := civil.ParseDate(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		line string
+		want Task
+	}{
+		{
+			line: "Call dentist tomorrow 3pm #health !high every monday",
+			want: Task{Title: "Call dentist", Date: date("2026-10-20"), DueTime: "15:00", Tags: []string{"health"}, Priority: 1, Recurrence: "FREQ=WEEKLY;BYDAY=MO"},
+		},
+		{
+			line: "Buy milk",
+			want: Task{Title: "Buy milk"},
+		},
+		{
+			line: "Buy 3 apples",
+			want: Task{Title: "Buy 3 apples"},
+		},
+		{
+			line: "Report today",
+			want: Task{Title: "Report", Date: today},
+		},
+		{
+			line: "Water plants tmrw",
+			want: Task{Title: "Water plants", Date: date("2026-10-20")},
+		},
+		// Days of the week are the next one, never today
+		{
+			line: "Standup monday",
+			want: Task{Title: "Standup", Date: date("2026-10-26")},
+		},
+		{
+			line: "Pay rent on Friday",
+			want: Task{Title: "Pay rent", Date: date("2026-10-23")},
+		},
+		{
+			line: "Review this monday",
+			want: Task{Title: "Review", Date: today},
+		},
+		{
+			line: "Review next wed",
+			want: Task{Title: "Review", Date: date("2026-10-21")},
+		},
+		{
+			line: "Plan trip next month",
+			want: Task{Title: "Plan trip", Date: date("2026-11-19")},
+		},
+		{
+			line: "Renew passport next year",
+			want: Task{Title: "Renew passport", Date: date("2027-10-19")},
+		},
+		{
+			line: "Follow up in 3 days",
+			want: Task{Title: "Follow up", Date: date("2026-10-22")},
+		},
+		{
+			line: "Follow up in two weeks",
+			want: Task{Title: "Follow up", Date: date("2026-11-02")},
+		},
+		{
+			line: "Check in a month",
+			want: Task{Title: "Check", Date: date("2026-11-19")},
+		},
+		{
+			line: "Submit 2026-12-01",
+			want: Task{Title: "Submit", Date: date("2026-12-01")},
+		},
+		{
+			line: "Party dec 24th",
+			want: Task{Title: "Party", Date: date("2026-12-24")},
+		},
+		{
+			line: "Party 24 December",
+			want: Task{Title: "Party", Date: date("2026-12-24")},
+		},
+		// A day that has passed this year is next year's
+		{
+			line: "Taxes by april 15",
+			want: Task{Title: "Taxes", Date: date("2027-04-15")},
+		},
+		{
+			line: "Launch may 5 2028",
+			want: Task{Title: "Launch", Date: date("2028-05-05")},
+		},
+		{
+			line: "Leap day feb 29",
+			want: Task{Title: "Leap day", Date: date("2028-02-29")},
+		},
+		{
+			line: "Nothing feb 30",
+			want: Task{Title: "Nothing feb 30"},
+		},
+		{
+			line: "Lunch at noon",
+			want: Task{Title: "Lunch", DueTime: "12:00"},
+		},
+		{
+			line: "Call 9:30am",
+			want: Task{Title: "Call", DueTime: "09:30"},
+		},
+		{
+			line: "Call 12am",
+			want: Task{Title: "Call", DueTime: "00:00"},
+		},
+		{
+			line: "Call 12pm",
+			want: Task{Title: "Call", DueTime: "12:00"},
+		},
+		{
+			line: "Call 7 pm",
+			want: Task{Title: "Call", DueTime: "19:00"},
+		},
+		{
+			line: "Deploy 18:45",
+			want: Task{Title: "Deploy", DueTime: "18:45"},
+		},
+		{
+			line: "Deploy at 9",
+			want: Task{Title: "Deploy", DueTime: "09:00"},
+		},
+		{
+			line: "Meet at the cafe",
+			want: Task{Title: "Meet at the cafe"},
+		},
+		{
+			line: "Bad time 25:00",
+			want: Task{Title: "Bad time 25:00"},
+		},
+		{
+			line: "Tags #Work #home, #work",
+			want: Task{Title: "Tags", Tags: []string{"work", "home"}},
+		},
+		{
+			line: "Issue #42",
+			want: Task{Title: "Issue #42"},
+		},
+		{
+			line: "Fix bug !!!",
+			want: Task{Title: "Fix bug", Priority: 1},
+		},
+		{
+			line: "Fix bug !med",
+			want: Task{Title: "Fix bug", Priority: 2},
+		},
+		{
+			line: "Fix bug !p3 !high",
+			want: Task{Title: "Fix bug !high", Priority: 3},
+		},
+		{
+			line: "Wow!",
+			want: Task{Title: "Wow!"},
+		},
+		{
+			line: "Stretch daily",
+			want: Task{Title: "Stretch", Recurrence: "FREQ=DAILY"},
+		},
+		{
+			line: "Backup every 2 weeks",
+			want: Task{Title: "Backup", Recurrence: "FREQ=WEEKLY;INTERVAL=2"},
+		},
+		{
+			line: "Water plants every other day",
+			want: Task{Title: "Water plants", Recurrence: "FREQ=DAILY;INTERVAL=2"},
+		},
+		{
+			line: "Invoice each month",
+			want: Task{Title: "Invoice", Recurrence: "FREQ=MONTHLY"},
+		},
+		// Repeating on days of the week starts on the first of them
+		{
+			line: "Gym every tue, thu and sat",
+			want: Task{Title: "Gym", Date: date("2026-10-20"), Recurrence: "FREQ=WEEKLY;BYDAY=TU,TH,SA"},
+		},
+		{
+			line: "Commute every weekday 8am",
+			want: Task{Title: "Commute", Date: today, DueTime: "08:00", Recurrence: "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR"},
+		},
+		{
+			line: "Hike every weekend",
+			want: Task{Title: "Hike", Date: date("2026-10-24"), Recurrence: "FREQ=WEEKLY;BYDAY=SA,SU"},
+		},
+		{
+			line: "1:1 every other friday",
+			want: Task{Title: "1:1", Date: date("2026-10-23"), Recurrence: "FREQ=WEEKLY;BYDAY=FR;INTERVAL=2"},
+		},
+		{
+			line: "Every one of them",
+			want: Task{Title: "Every one of them"},
+		},
+		// Only the first day counts
+		{
+			line: "Move friday meeting to monday",
+			want: Task{Title: "Move meeting to monday", Date: date("2026-10-23")},
+		},
+		{
+			line: `"Monday notes" tomorrow`,
+			want: Task{Title: "Monday notes", Date: date("2026-10-20")},
+		},
+		{
+			line: `Read "The Sun Also Rises" sunday`,
+			want: Task{Title: "Read The Sun Also Rises", Date: date("2026-10-25")},
+		},
+		{
+			line: `Say "hi`,
+			want: Task{Title: `Say "hi`},
+		},
+		{
+			line: "Enjoy the sun",
+			want: Task{Title: "Enjoy the sun"},
+		},
+		{
+			line: "tomorrow 3pm",
+			want: Task{Date: date("2026-10-20"), DueTime: "15:00"},
+		},
+		{
+			line: "   ",
+			want: Task{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got := Parse(tt.line, today)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q)\n got %+v\nwant %+v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseEndOfMonth(t *testing.T) {
+	got := Parse("Close books next month", civil.Date{Year: 2027, Month: 1, Day: 31})
+	if want := date("2027-02-28"); got.Date != want {
+		t.Errorf("got %s, want %s", got.Date, want)
+	}
+}
+
+func TestAllTags(t *testing.T) {
+	tests := []struct {
+		task Task
+		want []string
+	}{
+		{Task{}, nil},
+		{Task{Tags: []string{"work"}}, []string{"work"}},
+		{Task{Tags: []string{"work"}, Priority: 2}, []string{"work", "p2"}},
+		{Task{Priority: 1}, []string{"p1"}},
+	}
+	for _, tt := range tests {
+		if got := tt.task.AllTags(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%+v.AllTags() = %v, want %v", tt.task, got, tt.want)
+		}
+	}
+}
+
+func TestAllTagsKeepsTask(t *testing.T) {
+	task := Task{Tags: make([]string, 1, 4), Priority: 1}
+	task.AllTags()
+	if len(task.Tags) != 1 || task.Tags[:2][1] != "" {
+		t.Errorf("AllTags changed the task's tags: %v", task.Tags[:2])
+	}
+}
@@ -0,0 +1,255 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Zenk41/go-gin-htmx/models"
+	"github.com/Zenk41/go-gin-htmx/notify"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// batchSize bounds how many due reminders are delivered per tick
+	batchSize = 100
+	// maxAttempts is how often delivery is tried before a reminder is marked failed
+	maxAttempts = 5
+)
+
+// job is a function run periodically alongside reminder delivery
+type job struct {
+	name  string
+	every time.Duration
+	run   func(ctx context.Context, now time.Time) error
+	// next and running are guarded by the scheduler's mutex
+	next    time.Time
+	running bool
+}
+
+// Scheduler delivers persisted reminders when they fall due and runs
+// periodic jobs. Pending reminders live in the ReminderRepository, so
+// nothing is lost across restarts; delivery is at-least-once.
+type Scheduler struct {
+	reminders models.ReminderRepository
+	tasks     models.TaskRepository
+	users     models.UserRepository
+	notifier  notify.Multi
+	interval  time.Duration
+
+	mu   sync.Mutex
+	jobs []*job
+	// running counts the jobs started by ticks, so Run can wait for them
+	running sync.WaitGroup
+}
+
+func New(reminders models.ReminderRepository,
+	tasks models.TaskRepository,
+	users models.UserRepository,
+	notifier notify.Multi,
+	interval time.Duration) *Scheduler {
+	return &Scheduler{
+		reminders: reminders,
+		tasks:     tasks,
+		users:     users,
+		notifier:  notifier,
+		interval:  interval,
+	}
+}
+
+// Every registers fn to run roughly every interval, starting on the next tick
+func (s *Scheduler) Every(name string, every time.Duration, fn func(ctx context.Context, now time.Time) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs = append(s.jobs, &job{name: name, every: every, run: fn})
+}
+
+// Run ticks until ctx is cancelled, then waits for the jobs still running
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	defer s.running.Wait()
+
+	for {
+		s.tick(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tick starts the jobs that are due, each in its own goroutine so a slow
+// webhook receiver or mail server cannot hold up reminders, then delivers
+// the due reminders. A job still running from an earlier tick is skipped.
+func (s *Scheduler) tick(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	for _, j := range s.jobs {
+		if j.running || now.Before(j.next) {
+			continue
+		}
+		j.running = true
+		j.next = now.Add(j.every)
+		s.running.Add(1)
+		go s.runJob(ctx, j, now)
+	}
+	s.mu.Unlock()
+
+	if err := s.deliverDue(ctx, now); err != nil {
+		logrus.WithError(err).Error("Failed to deliver reminders")
+	}
+}
+
+func (s *Scheduler) runJob(ctx context.Context, j *job, now time.Time) {
+	defer s.running.Done()
+	if err := j.run(ctx, now); err != nil {
+		logrus.WithError(err).WithField("job", j.name).Error("Scheduled job failed")
+	}
+	s.mu.Lock()
+	j.running = false
+	s.mu.Unlock()
+}
+
+func (s *Scheduler) deliverDue(ctx context.Context, now time.Time) error {
+	due, err := s.reminders.Due(ctx, now, batchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, reminder := range due {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		s.deliver(ctx, reminder, now)
+	}
+	return nil
+}
+
+func (s *Scheduler) deliver(ctx context.Context, reminder models.ScheduledReminder, now time.Time) {
+	log := logrus.WithFields(logrus.Fields{"reminder_id": reminder.ID, "task_id": reminder.TaskID})
+
+	task, err := s.tasks.GetTaskById(ctx, reminder.UserID, reminder.TaskID)
+	if err != nil || task.Status == "done" {
+		// The task is gone, moved to someone else or already finished
+		if err := s.reminders.Finish(ctx, reminder.ID, models.ReminderSkipped, ""); err != nil {
+			log.WithError(err).Error("Failed to skip reminder")
+		}
+		return
+	}
+
+	user, err := s.users.GetUser(ctx, reminder.UserID)
+	if err != nil {
+		s.retry(ctx, reminder, now, err)
+		return
+	}
+
+	msg := message(*task, reminder.Kind, now)
+
+	// A retry only goes to the channels that failed
+	delivered, err := s.notifier.NotifyPending(ctx, *user, msg, reminder.Delivered)
+	if err != nil {
+		reminder.Delivered = delivered
+		s.retry(ctx, reminder, now, err)
+		return
+	}
+	if err := s.reminders.Finish(ctx, reminder.ID, models.ReminderSent, ""); err != nil {
+		log.WithError(err).Error("Failed to mark reminder as sent")
+	}
+}
+
+// message is the notification an occurrence of kind sends about task
+func message(task models.Task, kind string, now time.Time) notify.Message {
+	when := task.Day().String()
+	if task.DueTime != "" {
+		when += " at " + task.DueTime
+	}
+	msg := notify.Message{
+		Kind:   "reminder",
+		Title:  "Reminder: " + task.Title,
+		Body:   "Scheduled for " + when,
+		Link:   "/?date=" + task.Day().String(),
+		TaskID: task.TaskID,
+		SentAt: now,
+	}
+	if kind == models.ReminderOverdue {
+		msg.Kind = "overdue"
+		msg.Title = "Overdue: " + task.Title
+		msg.Body = "Was due " + when
+	}
+	return msg
+}
+
+// retry backs off exponentially, one minute after the first failure, and
+// gives up after maxAttempts
+func (s *Scheduler) retry(ctx context.Context, reminder models.ScheduledReminder, now time.Time, cause error) {
+	log := logrus.WithFields(logrus.Fields{"reminder_id": reminder.ID, "task_id": reminder.TaskID}).WithError(cause)
+
+	attempts := reminder.Attempts + 1
+	if attempts >= maxAttempts {
+		log.Error("Giving up on reminder")
+		if err := s.reminders.Finish(ctx, reminder.ID, models.ReminderFailed, cause.Error()); err != nil {
+			log.WithError(err).Error("Failed to mark reminder as failed")
+		}
+		return
+	}
+
+	log.Warn("Reminder delivery failed, retrying")
+	next := now.Add(time.Minute << (attempts - 1))
+	if err := s.reminders.Retry(ctx, reminder.ID, next, attempts, reminder.Delivered, cause.Error()); err != nil {
+		log.WithError(err).Error("Failed to reschedule reminder")
+	}
+}
+
+// Reschedule replaces the pending reminders of task with fresh ones
+// computed in the owner's timezone. Reminders whose time has passed are
+// dropped.
+func (s *Scheduler) Reschedule(ctx context.Context, task models.Task, loc *time.Location) error {
+	return s.reminders.ReplaceForTask(ctx, task.TaskID, Plan(task, loc, time.Now()))
+}
+
+// Cancel drops every pending reminder of a task
+func (s *Scheduler) Cancel(ctx context.Context, taskID string) error {
+	return s.reminders.ReplaceForTask(ctx, taskID, nil)
+}
+
+// Plan computes the reminder occurrences of task that are still ahead of
+// now, along with the one telling the owner it is overdue
+func Plan(task models.Task, loc *time.Location, now time.Time) []models.ScheduledReminder {
+	if task.Status == "done" {
+		return nil
+	}
+
+	var planned []models.ScheduledReminder
+	seen := map[time.Time]bool{}
+	for _, r := range task.Reminders {
+		fireAt, ok := r.FireAt(task, loc)
+		if !ok || fireAt.Before(now) || seen[fireAt] {
+			continue
+		}
+		seen[fireAt] = true
+		planned = append(planned, models.ScheduledReminder{
+			TaskID:    task.TaskID,
+			UserID:    task.UserID,
+			FireAt:    fireAt.UTC(),
+			Status:    models.ReminderPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+	}
+
+	// Open tasks also tell the user once they are overdue, unless a
+	// reminder already fires at that moment
+	if overdue := task.OverdueAt(loc); !overdue.Before(now) && !seen[overdue] {
+		planned = append(planned, models.ScheduledReminder{
+			TaskID:    task.TaskID,
+			UserID:    task.UserID,
+			Kind:      models.ReminderOverdue,
+			FireAt:    overdue.UTC(),
+			Status:    models.ReminderPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+	}
+	return planned
+}
@@ -0,0 +1,59 @@
+// Package search finds tasks by the words in their title and description.
+//
+// Index is the abstraction handlers search through. Memory is the embedded
+// implementation: an inverted index held in memory and optionally saved to
+// a file, suited to single-instance deployments and local backends. An
+// external engine can replace it by implementing Index.
+package search
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Zenk41/go-gin-htmx/models"
+)
+
+// Hit is a task matching a query
+type Hit struct {
+	TaskID string
+	Score  float64
+}
+
+// Index finds tasks by their text
+type Index interface {
+	// Put adds a task or replaces its previous version
+	Put(ctx context.Context, task models.Task) error
+	// Delete removes a task; unknown IDs are ignored
+	Delete(ctx context.Context, taskID string) error
+	// Search returns up to limit tasks of the user matching q, best first
+	Search(ctx context.Context, userID string, q Query, limit int) ([]Hit, error)
+	// Reset removes every task, before a rebuild
+	Reset(ctx context.Context) error
+}
+
+// Rebuild refills the index with every task of every user and returns how
+// many tasks it indexed
+func Rebuild(ctx context.Context, index Index, users models.UserRepository, tasks models.TaskRepository) (int, error) {
+	all, err := users.ListUsers(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := index.Reset(ctx); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, user := range all {
+		list, err := tasks.GetAllTasks(ctx, user.UserID)
+		if err != nil {
+			return count, fmt.Errorf("tasks of %s: %w", user.UserID, err)
+		}
+		for _, task := range *list {
+			if err := index.Put(ctx, task); err != nil {
+				return count, fmt.Errorf("task %s: %w", task.TaskID, err)
+			}
+			count++
+		}
+	}
+	return count, nil
+}
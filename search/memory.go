@@ -0,0 +1,360 @@
+package search
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/Zenk41/go-gin-htmx/models"
+)
+
+// saveSlack is how long before a save a task write may have been made and
+// still be missing from the saved index: writes reach the index only after
+// the repository has saved them
+const saveSlack = 5 * time.Second
+
+// Title words weigh more than description words when ranking
+const (
+	titleWeight       = 3
+	descriptionWeight = 1
+	phraseWeight      = 5
+)
+
+// Memory is an inverted index kept in memory, one per user so prefix
+// lookups only scan that user's vocabulary. It is safe for concurrent use.
+type Memory struct {
+	mu    sync.RWMutex
+	users map[string]*userIndex
+	// owners maps task IDs to user IDs, for deletes by ID
+	owners map[string]string
+	// changed is set by writes and cleared by Save
+	changed bool
+}
+
+// userIndex is the index of one user's tasks. Its fields are exported for
+// gob.
+type userIndex struct {
+	Docs map[string]*document
+	// Postings maps each word to the tasks containing it
+	Postings map[string]map[string]bool
+}
+
+// document is what the index keeps of a task: the positions of its words
+// and the fields queries filter on
+type document struct {
+	Status string
+	Tags   []string
+	Date   civil.Date
+	// Words maps each word to its positions. Title words come first; the
+	// description starts one position after TitleWords so phrases never
+	// span both.
+	Words      map[string][]int
+	TitleWords int
+}
+
+// NewMemory returns an empty index
+func NewMemory() *Memory {
+	return &Memory{users: map[string]*userIndex{}, owners: map[string]string{}}
+}
+
+// OpenMemory loads the index saved at path, or returns an empty index and
+// false when there is no file yet
+func OpenMemory(path string) (*Memory, bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return NewMemory(), false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	m := NewMemory()
+	if err := gob.NewDecoder(f).Decode(&m.users); err != nil {
+		return nil, false, err
+	}
+	for userID, user := range m.users {
+		for taskID := range user.Docs {
+			m.owners[taskID] = userID
+		}
+	}
+	return m, true, nil
+}
+
+// Stale reports whether the index saved at path may be missing task
+// writes, as it would after a crash between saves or when the repository
+// was changed by something else than the app. The file is stale when the
+// repository saw a write after the file was saved.
+func Stale(ctx context.Context, path string, tasks models.TaskRepository) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	last, err := tasks.LastWriteAt(ctx)
+	if err != nil {
+		return false, err
+	}
+	return last.After(info.ModTime().Add(-saveSlack)), nil
+}
+
+// Save writes the index to path unless nothing changed since the last
+// save. The file is replaced atomically.
+func (m *Memory) Save(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.changed {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".search-*.idx")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := gob.NewEncoder(tmp).Encode(m.users); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	m.changed = false
+	return nil
+}
+
+func (m *Memory) Put(ctx context.Context, task models.Task) error {
+	doc := &document{
+		Status: task.Status,
+		Tags:   task.Tags,
+		Date:   task.Day(),
+		Words:  map[string][]int{},
+	}
+	title := Tokenize(task.Title)
+	doc.TitleWords = len(title)
+	for i, word := range title {
+		doc.Words[word] = append(doc.Words[word], i)
+	}
+	for i, word := range Tokenize(task.Description) {
+		doc.Words[word] = append(doc.Words[word], doc.TitleWords+1+i)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(task.TaskID)
+	user := m.users[task.UserID]
+	if user == nil {
+		user = &userIndex{Docs: map[string]*document{}, Postings: map[string]map[string]bool{}}
+		m.users[task.UserID] = user
+	}
+	user.Docs[task.TaskID] = doc
+	for word := range doc.Words {
+		if user.Postings[word] == nil {
+			user.Postings[word] = map[string]bool{}
+		}
+		user.Postings[word][task.TaskID] = true
+	}
+	m.owners[task.TaskID] = task.UserID
+	m.changed = true
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, taskID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(taskID)
+	return nil
+}
+
+func (m *Memory) Reset(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.users = map[string]*userIndex{}
+	m.owners = map[string]string{}
+	m.changed = true
+	return nil
+}
+
+// remove drops a task; the caller holds the write lock
+func (m *Memory) remove(taskID string) {
+	userID, ok := m.owners[taskID]
+	if !ok {
+		return
+	}
+	delete(m.owners, taskID)
+	user := m.users[userID]
+	doc := user.Docs[taskID]
+	for word := range doc.Words {
+		delete(user.Postings[word], taskID)
+		if len(user.Postings[word]) == 0 {
+			delete(user.Postings, word)
+		}
+	}
+	delete(user.Docs, taskID)
+	if len(user.Docs) == 0 {
+		delete(m.users, userID)
+	}
+	m.changed = true
+}
+
+func (m *Memory) Search(ctx context.Context, userID string, q Query, limit int) ([]Hit, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	user := m.users[userID]
+	if user == nil {
+		return nil, nil
+	}
+
+	// Intersect the tasks containing each word; without words every task
+	// is a candidate for the filters
+	var candidates map[string]bool
+	constrained := false
+	narrow := func(ids map[string]bool) {
+		if !constrained {
+			candidates, constrained = ids, true
+			return
+		}
+		kept := map[string]bool{}
+		for id := range candidates {
+			if ids[id] {
+				kept[id] = true
+			}
+		}
+		candidates = kept
+	}
+	for _, term := range q.Terms {
+		narrow(user.matching(term))
+	}
+	for _, phrase := range q.Phrases {
+		for _, word := range phrase {
+			narrow(user.Postings[word])
+		}
+	}
+	if !constrained {
+		candidates = map[string]bool{}
+		for id := range user.Docs {
+			candidates[id] = true
+		}
+	}
+
+	var hits []Hit
+	for id := range candidates {
+		doc := user.Docs[id]
+		if !doc.matches(q) {
+			continue
+		}
+		hits = append(hits, Hit{TaskID: id, Score: doc.score(q)})
+	}
+
+	// Best first, then the latest tasks
+	sort.Slice(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if da, db := user.Docs[a.TaskID].Date, user.Docs[b.TaskID].Date; da != db {
+			return da.After(db)
+		}
+		return a.TaskID > b.TaskID
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+// matching returns the tasks containing a term
+func (u *userIndex) matching(term Term) map[string]bool {
+	if !term.Prefix {
+		return u.Postings[term.Text]
+	}
+	ids := map[string]bool{}
+	for word, postings := range u.Postings {
+		if strings.HasPrefix(word, term.Text) {
+			for id := range postings {
+				ids[id] = true
+			}
+		}
+	}
+	return ids
+}
+
+// matches checks the filters and phrases of q; terms were matched through
+// the postings
+func (d *document) matches(q Query) bool {
+	switch {
+	case q.Status == "done" && d.Status != "done",
+		q.Status == "open" && d.Status == "done",
+		!q.From.IsZero() && d.Date.Before(q.From),
+		!q.To.IsZero() && d.Date.After(q.To):
+		return false
+	}
+	for _, tag := range q.Tags {
+		if !slices.Contains(d.Tags, tag) {
+			return false
+		}
+	}
+	for _, phrase := range q.Phrases {
+		if d.phraseCount(phrase) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *document) score(q Query) float64 {
+	var score float64
+	weigh := func(word string) {
+		for _, pos := range d.Words[word] {
+			if pos < d.TitleWords {
+				score += titleWeight
+			} else {
+				score += descriptionWeight
+			}
+		}
+	}
+	for _, term := range q.Terms {
+		if !term.Prefix {
+			weigh(term.Text)
+			continue
+		}
+		for word := range d.Words {
+			if strings.HasPrefix(word, term.Text) {
+				weigh(word)
+			}
+		}
+	}
+	for _, phrase := range q.Phrases {
+		score += float64(phraseWeight * d.phraseCount(phrase))
+	}
+	return score
+}
+
+// phraseCount counts where the words of phrase appear one after another
+func (d *document) phraseCount(phrase []string) int {
+	count := 0
+	for _, start := range d.Words[phrase[0]] {
+		found := true
+		for i, word := range phrase[1:] {
+			if !slices.Contains(d.Words[word], start+i+1) {
+				found = false
+				break
+			}
+		}
+		if found {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,188 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"cloud.google.com/go/civil"
+	"github.com/Zenk41/go-gin-htmx/models"
+)
+
+// Term is a word of a query
+type Term struct {
+	Text string
+	// Prefix matches every word starting with Text
+	Prefix bool
+}
+
+// Query is a parsed search. Every part must match.
+type Query struct {
+	Terms []Term
+	// Phrases are runs of words that must appear next to each other
+	Phrases [][]string
+	Tags    []string
+	// Status is "done", "open" or empty for both
+	Status string
+	// From and To bound the task date, both inclusive; zero when open
+	From, To civil.Date
+}
+
+// Empty reports whether the query matches nothing in particular
+func (q Query) Empty() bool {
+	return len(q.Terms) == 0 && len(q.Phrases) == 0 && len(q.Tags) == 0 &&
+		q.Status == "" && q.From.IsZero() && q.To.IsZero()
+}
+
+// PrefixLast makes the last word a prefix, for searching as the user types
+func (q *Query) PrefixLast() {
+	if n := len(q.Terms); n > 0 {
+		q.Terms[n-1].Prefix = true
+	}
+}
+
+// Parse reads a query such as
+//
+//	"weekly report" draft* #work is:open from:2024-05-01 to:2024-05-31
+//
+// Words match whole words in titles and descriptions, quoted phrases
+// consecutive words and words ending in * any word they start. #tag or
+// tag:name, is:done or is:open, on:, from:, to:, before:, after: and
+// date:first..last filter the results.
+func Parse(s string) (Query, error) {
+	var q Query
+	for _, field := range fields(s) {
+		if strings.HasPrefix(field, `"`) {
+			q.addWords(Tokenize(field), false)
+			continue
+		}
+		if strings.HasPrefix(field, "#") {
+			q.Tags = append(q.Tags, field)
+			continue
+		}
+		if key, value, ok := strings.Cut(field, ":"); ok && value != "" {
+			known, err := q.filter(strings.ToLower(key), value)
+			if err != nil {
+				return Query{}, err
+			}
+			if known {
+				continue
+			}
+		}
+		q.addWords(Tokenize(field), strings.HasSuffix(field, "*"))
+	}
+	q.Tags = models.NormalizeTags(q.Tags)
+	return q, nil
+}
+
+// addWords adds a single word as a term and several, as in a quoted
+// phrase or "e-mail", as a phrase
+func (q *Query) addWords(words []string, prefix bool) {
+	switch len(words) {
+	case 0:
+	case 1:
+		q.Terms = append(q.Terms, Term{Text: words[0], Prefix: prefix})
+	default:
+		q.Phrases = append(q.Phrases, words)
+	}
+}
+
+// filter applies key:value, returning false for keys it does not know so
+// the field is searched as text
+func (q *Query) filter(key string, value string) (bool, error) {
+	switch key {
+	case "tag":
+		q.Tags = append(q.Tags, value)
+	case "is", "status":
+		switch strings.ToLower(value) {
+		case "done", "completed":
+			q.Status = "done"
+		case "open", "todo":
+			q.Status = "open"
+		default:
+			return true, fmt.Errorf("unknown status %q, use done or open", value)
+		}
+	case "on", "from", "to", "before", "after":
+		day, err := parseDay(key, value)
+		if err != nil {
+			return true, err
+		}
+		switch key {
+		case "on":
+			q.From, q.To = day, day
+		case "from":
+			q.From = day
+		case "to":
+			q.To = day
+		case "before":
+			q.To = day.AddDays(-1)
+		case "after":
+			q.From = day.AddDays(1)
+		}
+	case "date":
+		first, last, ok := strings.Cut(value, "..")
+		if !ok {
+			last = first
+		}
+		var err error
+		if first != "" {
+			if q.From, err = parseDay(key, first); err != nil {
+				return true, err
+			}
+		}
+		if last != "" {
+			if q.To, err = parseDay(key, last); err != nil {
+				return true, err
+			}
+		}
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+func parseDay(key string, value string) (civil.Date, error) {
+	day, err := civil.ParseDate(value)
+	if err != nil {
+		return civil.Date{}, fmt.Errorf("%s: %q is not a date, use YYYY-MM-DD", key, value)
+	}
+	return day, nil
+}
+
+// fields splits a query on white space, keeping quoted phrases, quotes
+// included, together
+func fields(s string) []string {
+	var out []string
+	var b strings.Builder
+	quoted := false
+	flush := func() {
+		if b.Len() > 0 {
+			out = append(out, b.String())
+			b.Reset()
+		}
+	}
+	for _, r := range s {
+		switch {
+		case r == '"' && quoted:
+			b.WriteRune(r)
+			quoted = false
+			flush()
+		case r == '"' && b.Len() == 0:
+			b.WriteRune(r)
+			quoted = true
+		case unicode.IsSpace(r) && !quoted:
+			flush()
+		default:
+			b.WriteRune(r)
+		}
+	}
+	flush()
+	return out
+}
+
+// Tokenize splits text into the lowercase words the index is built from
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,92 @@
+package search
+
+import (
+	"context"
+
+	"cloud.google.com/go/civil"
+	"github.com/Zenk41/go-gin-htmx/logging"
+	"github.com/Zenk41/go-gin-htmx/models"
+)
+
+// indexedTaskRepository mirrors every task write into an index. Reads pass
+// through the embedded repository untouched.
+type indexedTaskRepository struct {
+	models.TaskRepository
+	index Index
+}
+
+// IndexTasks wraps a TaskRepository so the index follows every change to a
+// task, whichever handler or job makes it. Index failures are logged rather
+// than returned: the task itself was saved, and a rebuild repairs the
+// index.
+func IndexTasks(next models.TaskRepository, index Index) models.TaskRepository {
+	return &indexedTaskRepository{TaskRepository: next, index: index}
+}
+
+func (r *indexedTaskRepository) CreateTask(ctx context.Context, task models.TaskPayload, idempotencyKey string) (*models.Task, error) {
+	created, err := r.TaskRepository.CreateTask(ctx, task, idempotencyKey)
+	if err != nil {
+		return created, err
+	}
+	r.put(ctx, *created)
+	return created, nil
+}
+
+func (r *indexedTaskRepository) EditTaskById(ctx context.Context, task models.TaskPayload) ([]models.FieldChange, error) {
+	changes, err := r.TaskRepository.EditTaskById(ctx, task)
+	if err != nil {
+		return nil, err
+	}
+	r.put(ctx, models.Task(task))
+	return changes, nil
+}
+
+func (r *indexedTaskRepository) DeleteTaskById(ctx context.Context, userID string, taskID string) (*models.Task, error) {
+	task, err := r.TaskRepository.DeleteTaskById(ctx, userID, taskID)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.index.Delete(ctx, taskID); err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("task_id", taskID).Error("Failed to remove task from the search index")
+	}
+	return task, nil
+}
+
+// RestoreTaskById indexes the task again. Purges need no override: tasks
+// in the trash are already out of the index.
+func (r *indexedTaskRepository) RestoreTaskById(ctx context.Context, userID string, taskID string) (*models.Task, error) {
+	task, err := r.TaskRepository.RestoreTaskById(ctx, userID, taskID)
+	if err != nil {
+		return nil, err
+	}
+	r.put(ctx, *task)
+	return task, nil
+}
+
+func (r *indexedTaskRepository) DoneTaskById(ctx context.Context, userID string, taskID string) (*models.Task, []models.FieldChange, error) {
+	task, changes, err := r.TaskRepository.DoneTaskById(ctx, userID, taskID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(changes) > 0 {
+		r.put(ctx, *task)
+	}
+	return task, changes, nil
+}
+
+func (r *indexedTaskRepository) DoneAllTaskDayByDate(ctx context.Context, userID string, date civil.Date) ([]models.ChangedTask, error) {
+	changed, err := r.TaskRepository.DoneAllTaskDayByDate(ctx, userID, date)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range changed {
+		r.put(ctx, c.Task)
+	}
+	return changed, nil
+}
+
+func (r *indexedTaskRepository) put(ctx context.Context, task models.Task) {
+	if err := r.index.Put(ctx, task); err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("task_id", task.TaskID).Error("Failed to index task")
+	}
+}
@@ -0,0 +1,287 @@
+// Package stats summarizes how a user gets through their tasks: completion
+// rates by day, week and month, streaks of days with every task done, a year
+// of completed tasks for a heatmap and breakdowns by tag and priority.
+//
+// Only the date, status and tags of the tasks in the summarized year are
+// read, and all-time totals come from count aggregations, so the cost does
+// not grow with the age of the account.
+package stats
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/Zenk41/go-gin-htmx/models"
+)
+
+const (
+	// HeatmapWeeks is how many weeks the heatmap shows, today's included
+	HeatmapWeeks = 53
+	// dailyPeriods, weeklyPeriods and monthlyPeriods are how many of each
+	// the completion rates cover, the current one included
+	dailyPeriods   = 14
+	weeklyPeriods  = 12
+	monthlyPeriods = 12
+	// topTags is how many tags the tag breakdown lists
+	topTags = 10
+)
+
+// priorityTags are the tags recording a priority, highest first
+var priorityTags = []string{"p1", "p2", "p3"}
+
+// NoPriority names the group of tasks without a priority tag
+const NoPriority = "none"
+
+// Day is how the tasks of one day went
+type Day struct {
+	Date  civil.Date
+	Total int
+	Done  int
+}
+
+// Period is how the tasks of a stretch of days went
+type Period struct {
+	From  civil.Date
+	To    civil.Date
+	Total int
+	Done  int
+}
+
+// Group is how the tasks sharing a tag or a priority went
+type Group struct {
+	Name  string
+	Total int
+	Done  int
+}
+
+// Rate is the share of done tasks, 0 without tasks
+func (p Period) Rate() float64 {
+	return rate(p.Done, p.Total)
+}
+
+// Rate is the share of done tasks, 0 without tasks
+func (g Group) Rate() float64 {
+	return rate(g.Done, g.Total)
+}
+
+func rate(done int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(done) / float64(total)
+}
+
+// Summary is everything the statistics page shows
+type Summary struct {
+	Today civil.Date
+	// Days are the days of the heatmap, oldest first. The first one is a
+	// Sunday so the days fall into weeks of seven.
+	Days []Day
+	// MostDone is the most tasks done on a single day of Days
+	MostDone int
+	Daily    []Period
+	Weekly   []Period
+	Monthly  []Period
+	// A streak is a run of days with every task done. Days without tasks
+	// neither extend nor break it, and today only breaks it once it is over.
+	CurrentStreak int
+	LongestStreak int
+	// The averages run from the first day with tasks in the summarized year
+	AveragePerDay     float64
+	AverageDonePerDay float64
+	// Tags are the most used tags, priority tags aside
+	Tags []Group
+	// Priorities are p1 to p3 and NoPriority
+	Priorities []Group
+	// Counts are all-time totals
+	Counts models.TaskCounts
+}
+
+// Start returns the first day a summary for today reads tasks from
+func Start(today civil.Date) civil.Date {
+	heatmap := weekStart(today).AddDays(-7 * (HeatmapWeeks - 1))
+	months := addMonths(monthStart(today), -(monthlyPeriods - 1))
+	return earlier(months, heatmap)
+}
+
+// Load reads the tasks and totals a summary needs and summarizes them
+func Load(ctx context.Context, tasks models.TaskRepository, userID string, today civil.Date) (Summary, error) {
+	list, err := tasks.GetTaskStatsInRange(ctx, userID, Start(today), today)
+	if err != nil {
+		return Summary{}, err
+	}
+	counts, err := tasks.CountTasks(ctx, userID)
+	if err != nil {
+		return Summary{}, err
+	}
+	return Summarize(list, counts, today), nil
+}
+
+// Summarize computes the summary from the tasks dated from Start(today)
+// through today. Tasks outside that range are ignored.
+func Summarize(tasks []models.TaskStat, counts models.TaskCounts, today civil.Date) Summary {
+	start := Start(today)
+	days := map[civil.Date]*Day{}
+	tags := map[string]*Group{}
+	priorities := map[string]*Group{}
+	first := today
+	for _, task := range tasks {
+		date := task.Day()
+		if date.Before(start) || date.After(today) {
+			continue
+		}
+		done := task.Status == "done"
+		day := days[date]
+		if day == nil {
+			day = &Day{Date: date}
+			days[date] = day
+		}
+		count(&day.Total, &day.Done, done)
+		if date.Before(first) {
+			first = date
+		}
+
+		priority := NoPriority
+		for _, tag := range task.Tags {
+			if slices.Contains(priorityTags, tag) {
+				if priority == NoPriority || tag < priority {
+					priority = tag
+				}
+				continue
+			}
+			group(tags, tag, done)
+		}
+		group(priorities, priority, done)
+	}
+
+	s := Summary{Today: today, Counts: counts}
+	dayOf := func(date civil.Date) Day {
+		if day := days[date]; day != nil {
+			return *day
+		}
+		return Day{Date: date}
+	}
+
+	for date := weekStart(today).AddDays(-7 * (HeatmapWeeks - 1)); !date.After(today); date = date.AddDays(1) {
+		day := dayOf(date)
+		s.Days = append(s.Days, day)
+		s.MostDone = max(s.MostDone, day.Done)
+	}
+
+	period := func(from civil.Date, to civil.Date) Period {
+		p := Period{From: from, To: to}
+		for date := from; !date.After(to); date = date.AddDays(1) {
+			day := dayOf(date)
+			p.Total += day.Total
+			p.Done += day.Done
+		}
+		return p
+	}
+	for n := dailyPeriods - 1; n >= 0; n-- {
+		date := today.AddDays(-n)
+		s.Daily = append(s.Daily, period(date, date))
+	}
+	for n := weeklyPeriods - 1; n >= 0; n-- {
+		from := weekStart(today).AddDays(-7 * n)
+		s.Weekly = append(s.Weekly, period(from, earlier(from.AddDays(6), today)))
+	}
+	for n := monthlyPeriods - 1; n >= 0; n-- {
+		from := addMonths(monthStart(today), -n)
+		s.Monthly = append(s.Monthly, period(from, earlier(addMonths(from, 1).AddDays(-1), today)))
+	}
+
+	run := 0
+	for date := start; !date.After(today); date = date.AddDays(1) {
+		day := dayOf(date)
+		switch {
+		case day.Total == 0:
+		case day.Done == day.Total:
+			run++
+			s.LongestStreak = max(s.LongestStreak, run)
+		default:
+			run = 0
+		}
+	}
+	for date := today; !date.Before(start); date = date.AddDays(-1) {
+		day := dayOf(date)
+		if day.Total == 0 || (date == today && day.Done < day.Total) {
+			continue
+		}
+		if day.Done < day.Total {
+			break
+		}
+		s.CurrentStreak++
+	}
+
+	if len(days) > 0 {
+		span := float64(today.DaysSince(first) + 1)
+		total, done := 0, 0
+		for _, day := range days {
+			total += day.Total
+			done += day.Done
+		}
+		s.AveragePerDay = float64(total) / span
+		s.AverageDonePerDay = float64(done) / span
+	}
+
+	for _, g := range tags {
+		s.Tags = append(s.Tags, *g)
+	}
+	sort.Slice(s.Tags, func(i, j int) bool {
+		if s.Tags[i].Total != s.Tags[j].Total {
+			return s.Tags[i].Total > s.Tags[j].Total
+		}
+		return s.Tags[i].Name < s.Tags[j].Name
+	})
+	if len(s.Tags) > topTags {
+		s.Tags = s.Tags[:topTags]
+	}
+	for _, name := range append(slices.Clone(priorityTags), NoPriority) {
+		if g := priorities[name]; g != nil {
+			s.Priorities = append(s.Priorities, *g)
+		} else {
+			s.Priorities = append(s.Priorities, Group{Name: name})
+		}
+	}
+	return s
+}
+
+func count(total *int, done *int, isDone bool) {
+	*total++
+	if isDone {
+		*done++
+	}
+}
+
+func group(groups map[string]*Group, name string, done bool) {
+	g := groups[name]
+	if g == nil {
+		g = &Group{Name: name}
+		groups[name] = g
+	}
+	count(&g.Total, &g.Done, done)
+}
+
+// weekStart returns the Sunday starting the week of d
+func weekStart(d civil.Date) civil.Date {
+	return d.AddDays(-int(d.In(time.UTC).Weekday()))
+}
+
+func earlier(a civil.Date, b civil.Date) civil.Date {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func monthStart(d civil.Date) civil.Date {
+	return civil.Date{Year: d.Year, Month: d.Month, Day: 1}
+}
+
+// addMonths moves the first day of a month by n months
+func addMonths(first civil.Date, n int) civil.Date {
+	return civil.DateOf(first.In(time.UTC).AddDate(0, n, 0))
+}
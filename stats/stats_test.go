@@ -0,0 +1,261 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/civil"
+	"github.com/Zenk41/go-gin-htmx/models"
+)
+
+func date(s string) civil.Date {
+	d, err := civil.ParseDate(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func stat(day string, status string, tags ...string) models.TaskStat {
+	return models.TaskStat{Date: models.StoredDate(date(day)), Status: status, Tags: tags}
+}
+
+func TestStart(t *testing.T) {
+	tests := []struct {
+		today string
+		want  string
+	}{
+		// 53 weeks of heatmap starting on a Sunday
+		{today: "2024-03-13", want: "2023-03-12"},
+		{today: "2024-03-10", want: "2023-03-12"},
+		{today: "2024-03-16", want: "2023-03-12"},
+		// the first day of the year
+		{today: "2024-01-01", want: "2023-01-01"},
+		{today: "2023-12-31", want: "2023-01-01"},
+		// twelve months reach further back than the heatmap when the
+		// summarized year includes a 29th of February
+		{today: "2021-01-31", want: "2020-02-01"},
+	}
+
+	for _, tt := range tests {
+		if got := Start(date(tt.today)); got != date(tt.want) {
+			t.Errorf("Start(%s) = %s, want %s", tt.today, got, tt.want)
+		}
+	}
+}
+
+func TestSummarizeStreaks(t *testing.T) {
+	tests := []struct {
+		name        string
+		today       string
+		tasks       []models.TaskStat
+		wantCurrent int
+		wantLongest int
+	}{
+		{name: "no tasks", today: "2024-03-13"},
+		{
+			name:  "unfinished today does not break the streak",
+			today: "2024-03-13",
+			tasks: []models.TaskStat{
+				stat("2024-03-11", "done"),
+				stat("2024-03-12", "done"), stat("2024-03-12", "done"),
+				stat("2024-03-13", "done"), stat("2024-03-13", "pending"),
+			},
+			wantCurrent: 2,
+			wantLongest: 2,
+		},
+		{
+			name:  "finished today extends the streak",
+			today: "2024-03-13",
+			tasks: []models.TaskStat{
+				stat("2024-03-11", "done"),
+				stat("2024-03-12", "done"),
+				stat("2024-03-13", "done"),
+			},
+			wantCurrent: 3,
+			wantLongest: 3,
+		},
+		{
+			name:  "days without tasks neither extend nor break it",
+			today: "2024-03-13",
+			tasks: []models.TaskStat{
+				stat("2024-03-01", "done"),
+				stat("2024-03-08", "done"),
+				stat("2024-03-12", "done"),
+			},
+			wantCurrent: 3,
+			wantLongest: 3,
+		},
+		{
+			name:  "an unfinished day breaks it",
+			today: "2024-03-13",
+			tasks: []models.TaskStat{
+				stat("2024-03-01", "done"),
+				stat("2024-03-02", "done"),
+				stat("2024-03-03", "done"),
+				stat("2024-03-04", "done"), stat("2024-03-04", "pending"),
+				stat("2024-03-12", "done"),
+			},
+			wantCurrent: 1,
+			wantLongest: 3,
+		},
+		{
+			name:  "unfinished yesterday ends the current streak",
+			today: "2024-03-13",
+			tasks: []models.TaskStat{
+				stat("2024-03-11", "done"),
+				stat("2024-03-12", "pending"),
+				stat("2024-03-13", "done"),
+			},
+			wantCurrent: 1,
+			wantLongest: 1,
+		},
+		{
+			name:  "streak across the new year",
+			today: "2024-01-02",
+			tasks: []models.TaskStat{
+				stat("2023-12-30", "done"),
+				stat("2023-12-31", "done"),
+				stat("2024-01-01", "done"),
+				stat("2024-01-02", "pending"),
+			},
+			wantCurrent: 3,
+			wantLongest: 3,
+		},
+		{
+			name:  "streak starting on the first summarized day",
+			today: "2024-01-01",
+			tasks: []models.TaskStat{
+				stat("2022-12-31", "pending"),
+				stat("2023-01-01", "done"),
+				stat("2023-01-02", "done"),
+			},
+			wantCurrent: 2,
+			wantLongest: 2,
+		},
+		{
+			name:  "tasks before the summarized year are ignored",
+			today: "2024-01-01",
+			tasks: []models.TaskStat{
+				stat("2022-12-30", "done"),
+				stat("2022-12-31", "done"),
+				stat("2023-01-01", "done"),
+			},
+			wantCurrent: 1,
+			wantLongest: 1,
+		},
+		{
+			name:  "tasks after today are ignored",
+			today: "2024-03-13",
+			tasks: []models.TaskStat{
+				stat("2024-03-12", "done"),
+				stat("2024-03-14", "pending"),
+				stat("2024-03-15", "done"),
+			},
+			wantCurrent: 1,
+			wantLongest: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Summarize(tt.tasks, models.TaskCounts{}, date(tt.today))
+			if s.CurrentStreak != tt.wantCurrent || s.LongestStreak != tt.wantLongest {
+				t.Errorf("streaks = %d current, %d longest, want %d and %d",
+					s.CurrentStreak, s.LongestStreak, tt.wantCurrent, tt.wantLongest)
+			}
+		})
+	}
+}
+
+func TestSummarizeHeatmap(t *testing.T) {
+	today := date("2024-01-03")
+	s := Summarize([]models.TaskStat{
+		stat("2023-01-01", "done"),
+		stat("2024-01-01", "done"), stat("2024-01-01", "done"), stat("2024-01-01", "pending"),
+		stat("2024-01-03", "done"),
+	}, models.TaskCounts{}, today)
+
+	if n := len(s.Days); n != 7*(HeatmapWeeks-1)+4 {
+		t.Fatalf("%d heatmap days, want %d", n, 7*(HeatmapWeeks-1)+4)
+	}
+	if first := s.Days[0].Date; first != date("2023-01-01") {
+		t.Errorf("heatmap starts on %s, want the Sunday 2023-01-01", first)
+	}
+	if last := s.Days[len(s.Days)-1].Date; last != today {
+		t.Errorf("heatmap ends on %s, want %s", last, today)
+	}
+	if got, want := s.Days[len(s.Days)-3], (Day{Date: date("2024-01-01"), Total: 3, Done: 2}); got != want {
+		t.Errorf("new year's day = %+v, want %+v", got, want)
+	}
+	if s.MostDone != 2 {
+		t.Errorf("MostDone = %d, want 2", s.MostDone)
+	}
+}
+
+func TestSummarizePeriods(t *testing.T) {
+	today := date("2024-01-03")
+	s := Summarize([]models.TaskStat{
+		stat("2023-12-30", "done"),
+		stat("2023-12-31", "pending"),
+		stat("2024-01-01", "done"),
+		stat("2024-01-03", "done"), stat("2024-01-03", "pending"),
+	}, models.TaskCounts{}, today)
+
+	tests := []struct {
+		name string
+		got  Period
+		want Period
+	}{
+		{"today", s.Daily[len(s.Daily)-1], Period{From: today, To: today, Total: 2, Done: 1}},
+		{"this week", s.Weekly[len(s.Weekly)-1], Period{From: date("2023-12-31"), To: today, Total: 4, Done: 2}},
+		{"last week", s.Weekly[len(s.Weekly)-2], Period{From: date("2023-12-24"), To: date("2023-12-30"), Total: 1, Done: 1}},
+		{"this month", s.Monthly[len(s.Monthly)-1], Period{From: date("2024-01-01"), To: today, Total: 3, Done: 2}},
+		{"last month", s.Monthly[len(s.Monthly)-2], Period{From: date("2023-12-01"), To: date("2023-12-31"), Total: 2, Done: 1}},
+		{"first month", s.Monthly[0], Period{From: date("2023-02-01"), To: date("2023-02-28")}},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %+v, want %+v", tt.name, tt.got, tt.want)
+		}
+	}
+	if len(s.Daily) != dailyPeriods || len(s.Weekly) != weeklyPeriods || len(s.Monthly) != monthlyPeriods {
+		t.Errorf("%d days, %d weeks and %d months", len(s.Daily), len(s.Weekly), len(s.Monthly))
+	}
+}
+
+func TestSummarizeBreakdowns(t *testing.T) {
+	today := date("2024-03-13")
+	s := Summarize([]models.TaskStat{
+		stat("2024-03-04", "done", "work", "p2", "p1"),
+		stat("2024-03-04", "pending", "work", "p3"),
+		stat("2024-03-13", "done", "home"),
+		stat("2024-03-13", "pending", "errand", "p3"),
+	}, models.TaskCounts{Total: 40, Done: 30}, today)
+
+	wantTags := []Group{
+		{Name: "work", Total: 2, Done: 1},
+		{Name: "errand", Total: 1},
+		{Name: "home", Total: 1, Done: 1},
+	}
+	if !reflect.DeepEqual(s.Tags, wantTags) {
+		t.Errorf("Tags = %+v, want %+v", s.Tags, wantTags)
+	}
+	wantPriorities := []Group{
+		{Name: "p1", Total: 1, Done: 1},
+		{Name: "p2"},
+		{Name: "p3", Total: 2},
+		{Name: NoPriority, Total: 1, Done: 1},
+	}
+	if !reflect.DeepEqual(s.Priorities, wantPriorities) {
+		t.Errorf("Priorities = %+v, want %+v", s.Priorities, wantPriorities)
+	}
+
+	// ten days from the first day with tasks through today
+	if s.AveragePerDay != 0.4 || s.AverageDonePerDay != 0.2 {
+		t.Errorf("averages = %v and %v done per day, want 0.4 and 0.2", s.AveragePerDay, s.AverageDonePerDay)
+	}
+	if s.Counts != (models.TaskCounts{Total: 40, Done: 30}) {
+		t.Errorf("Counts = %+v, want the counts passed in", s.Counts)
+	}
+}
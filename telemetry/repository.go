@@ -0,0 +1,288 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/Zenk41/go-gin-htmx/models"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startSpan opens a client span for a repository call.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attrs, attribute.String("db.system", "firestore"))...),
+	)
+}
+
+// endSpan records err on span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+type tracedTaskRepository struct {
+	next models.TaskRepository
+}
+
+// TraceTaskRepository wraps a TaskRepository so every call gets its own span.
+func TraceTaskRepository(next models.TaskRepository) models.TaskRepository {
+	return &tracedTaskRepository{next: next}
+}
+
+func (r *tracedTaskRepository) GetTasksByDate(ctx context.Context, userID string, date civil.Date) (tasks *[]models.Task, err error) {
+	ctx, span := startSpan(ctx, "TaskRepository.GetTasksByDate",
+		attribute.String("user.id", userID), attribute.String("task.date", date.String()))
+	defer func() { endSpan(span, err) }()
+	return r.next.GetTasksByDate(ctx, userID, date)
+}
+
+func (r *tracedTaskRepository) GetTaskPageByDate(ctx context.Context, userID string, date civil.Date, page models.PageRequest) (tasks models.TaskPage, err error) {
+	ctx, span := startSpan(ctx, "TaskRepository.GetTaskPageByDate",
+		attribute.String("user.id", userID), attribute.String("task.date", date.String()),
+		attribute.Int("page.limit", page.Limit), attribute.Bool("page.first", page.Cursor == ""))
+	defer func() { endSpan(span, err) }()
+	return r.next.GetTaskPageByDate(ctx, userID, date, page)
+}
+
+func (r *tracedTaskRepository) GetTodayTasks(ctx context.Context, userID string, loc *time.Location) (tasks *[]models.Task, err error) {
+	ctx, span := startSpan(ctx, "TaskRepository.GetTodayTasks", attribute.String("user.id", userID))
+	defer func() { endSpan(span, err) }()
+	return r.next.GetTodayTasks(ctx, userID, loc)
+}
+
+func (r *tracedTaskRepository) GetTasksInRange(ctx context.Context, userID string, from civil.Date, to civil.Date) (tasks *[]models.Task, err error) {
+	ctx, span := startSpan(ctx, "TaskRepository.GetTasksInRange", attribute.String("user.id", userID),
+		attribute.String("task.date_from", from.String()), attribute.String("task.date_to", to.String()))
+	defer func() { endSpan(span, err) }()
+	return r.next.GetTasksInRange(ctx, userID, from, to)
+}
+
+func (r *tracedTaskRepository) GetAllTasks(ctx context.Context, userID string) (tasks *[]models.Task, err error) {
+	ctx, span := startSpan(ctx, "TaskRepository.GetAllTasks", attribute.String("user.id", userID))
+	defer func() { endSpan(span, err) }()
+	return r.next.GetAllTasks(ctx, userID)
+}
+
+func (r *tracedTaskRepository) FindICalUIDs(ctx context.Context, userID string, uids []string) (found map[string]bool, err error) {
+	ctx, span := startSpan(ctx, "TaskRepository.FindICalUIDs",
+		attribute.String("user.id", userID), attribute.Int("ical.uids", len(uids)))
+	defer func() { endSpan(span, err) }()
+	return r.next.FindICalUIDs(ctx, userID, uids)
+}
+
+func (r *tracedTaskRepository) GetTasksChangedSince(ctx context.Context, userID string, since time.Time, page models.PageRequest) (tasks models.TaskPage, err error) {
+	ctx, span := startSpan(ctx, "TaskRepository.GetTasksChangedSince",
+		attribute.String("user.id", userID), attribute.String("since", since.Format(time.RFC3339Nano)),
+		attribute.Int("page.limit", page.Limit), attribute.Bool("page.first", page.Cursor == ""))
+	defer func() { endSpan(span, err) }()
+	return r.next.GetTasksChangedSince(ctx, userID, since, page)
+}
+
+func (r *tracedTaskRepository) BackfillUpdatedAt(ctx context.Context) (count int, err error) {
+	ctx, span := startSpan(ctx, "TaskRepository.BackfillUpdatedAt")
+	defer func() {
+		span.SetAttributes(attribute.Int("task.backfilled", count))
+		endSpan(span, err)
+	}()
+	return r.next.BackfillUpdatedAt(ctx)
+}
+
+func (r *tracedTaskRepository) GetTombstonesSince(ctx context.Context, userID string, since time.Time) (tombstones []models.TaskTombstone, err error) {
+	ctx, span := startSpan(ctx, "TaskRepository.GetTombstonesSince",
+		attribute.String("user.id", userID), attribute.String("since", since.Format(time.RFC3339Nano)))
+	defer func() { endSpan(span, err) }()
+	return r.next.GetTombstonesSince(ctx, userID, since)
+}
+
+func (r *tracedTaskRepository) LastChangeAt(ctx context.Context, userID string) (last time.Time, err error) {
+	ctx, span := startSpan(ctx, "TaskRepository.LastChangeAt", attribute.String("user.id", userID))
+	defer func() { endSpan(span, err) }()
+	return r.next.LastChangeAt(ctx, userID)
+}
+
+func (r *tracedTaskRepository) LastWriteAt(ctx context.Context) (last time.Time, err error) {
+	ctx, span := startSpan(ctx, "TaskRepository.LastWriteAt")
+	defer func() { endSpan(span, err) }()
+	return r.next.LastWriteAt(ctx)
+}
+
+func (r *tracedTaskRepository) GetTaskStatsInRange(ctx context.Context, userID string, from civil.Date, to civil.Date) (stats []models.TaskStat, err error) {
+	ctx, span := startSpan(ctx, "TaskRepository.GetTaskStatsInRange", attribute.String("user.id", userID),
+		attribute.String("task.date_from", from.String()), attribute.String("task.date_to", to.String()))
+	defer func() { endSpan(span, err) }()
+	return r.next.GetTaskStatsInRange(ctx, userID, from, to)
+}
+
+func (r *tracedTaskRepository) CountTasks(ctx context.Context, userID string) (counts models.TaskCounts, err error) {
+	ctx, span := startSpan(ctx, "TaskRepository.CountTasks", attribute.String("user.id", userID))
+	defer func() { endSpan(span, err) }()
+	return r.next.CountTasks(ctx, userID)
+}
+
+func (r *tracedTaskRepository) CreateTask(ctx context.Context, task models.TaskPayload, idempotencyKey string) (created *models.Task, err error) {
+	ctx, span := startSpan(ctx, "TaskRepository.CreateTask",
+		attribute.String("user.id", task.UserID), attribute.Bool("task.idempotent", idempotencyKey != ""))
+	defer func() {
+		if created != nil {
+			span.SetAttributes(attribute.String("task.id", created.TaskID))
+		}
+		if errors.Is(err, models.ErrTaskAlreadyCreated) {
+			span.SetAttributes(attribute.Bool("task.replayed", true))
+			endSpan(span, nil)
+			return
+		}
+		endSpan(span, err)
+	}()
+	return r.next.CreateTask(ctx, task, idempotencyKey)
+}
+
+func (r *tracedTaskRepository) GetTaskById(ctx context.Context, userID string, taskID string) (task *models.Task, err error) {
+	ctx, span := startSpan(ctx, "TaskRepository.GetTaskById",
+		attribute.String("user.id", userID), attribute.String("task.id", taskID))
+	defer func() { endSpan(span, err) }()
+	return r.next.GetTaskById(ctx, userID, taskID)
+}
+
+func (r *tracedTaskRepository) DeleteTaskById(ctx context.Context, userID string, taskID string) (task *models.Task, err error) {
+	ctx, span := startSpan(ctx, "TaskRepository.DeleteTaskById",
+		attribute.String("user.id", userID), attribute.String("task.id", taskID))
+	defer func() { endSpan(span, err) }()
+	return r.next.DeleteTaskById(ctx, userID, taskID)
+}
+
+func (r *tracedTaskRepository) GetTrashedTasks(ctx context.Context, userID string, page models.PageRequest) (tasks models.TaskPage, err error) {
+	ctx, span := startSpan(ctx, "TaskRepository.GetTrashedTasks", attribute.String("user.id", userID),
+		attribute.Int("page.limit", page.Limit), attribute.Bool("page.first", page.Cursor == ""))
+	defer func() { endSpan(span, err) }()
+	return r.next.GetTrashedTasks(ctx, userID, page)
+}
+
+func (r *tracedTaskRepository) GetTrashedTaskById(ctx context.Context, userID string, taskID string) (task *models.Task, err error) {
+	ctx, span := startSpan(ctx, "TaskRepository.GetTrashedTaskById",
+		attribute.String("user.id", userID), attribute.String("task.id", taskID))
+	defer func() { endSpan(span, err) }()
+	return r.next.GetTrashedTaskById(ctx, userID, taskID)
+}
+
+func (r *tracedTaskRepository) RestoreTaskById(ctx context.Context, userID string, taskID string) (task *models.Task, err error) {
+	ctx, span := startSpan(ctx, "TaskRepository.RestoreTaskById",
+		attribute.String("user.id", userID), attribute.String("task.id", taskID))
+	defer func() { endSpan(span, err) }()
+	return r.next.RestoreTaskById(ctx, userID, taskID)
+}
+
+func (r *tracedTaskRepository) PurgeTaskById(ctx context.Context, userID string, taskID string) (err error) {
+	ctx, span := startSpan(ctx, "TaskRepository.PurgeTaskById",
+		attribute.String("user.id", userID), attribute.String("task.id", taskID))
+	defer func() { endSpan(span, err) }()
+	return r.next.PurgeTaskById(ctx, userID, taskID)
+}
+
+func (r *tracedTaskRepository) PurgeTrash(ctx context.Context, before time.Time) (tasks []models.Task, err error) {
+	ctx, span := startSpan(ctx, "TaskRepository.PurgeTrash", attribute.String("before", before.Format(time.RFC3339Nano)))
+	defer func() {
+		span.SetAttributes(attribute.Int("task.purged", len(tasks)))
+		endSpan(span, err)
+	}()
+	return r.next.PurgeTrash(ctx, before)
+}
+
+func (r *tracedTaskRepository) DoneAllTaskDayByDate(ctx context.Context, userID string, date civil.Date) (changed []models.ChangedTask, err error) {
+	ctx, span := startSpan(ctx, "TaskRepository.DoneAllTaskDayByDate",
+		attribute.String("user.id", userID), attribute.String("task.date", date.String()))
+	defer func() {
+		span.SetAttributes(attribute.Int("task.completed", len(changed)))
+		endSpan(span, err)
+	}()
+	return r.next.DoneAllTaskDayByDate(ctx, userID, date)
+}
+
+func (r *tracedTaskRepository) DoneTaskById(ctx context.Context, userID string, taskID string) (task *models.Task, changes []models.FieldChange, err error) {
+	ctx, span := startSpan(ctx, "TaskRepository.DoneTaskById",
+		attribute.String("user.id", userID), attribute.String("task.id", taskID))
+	defer func() { endSpan(span, err) }()
+	return r.next.DoneTaskById(ctx, userID, taskID)
+}
+
+func (r *tracedTaskRepository) EditTaskById(ctx context.Context, task models.TaskPayload) (changes []models.FieldChange, err error) {
+	ctx, span := startSpan(ctx, "TaskRepository.EditTaskById",
+		attribute.String("user.id", task.UserID), attribute.String("task.id", task.TaskID))
+	defer func() { endSpan(span, err) }()
+	return r.next.EditTaskById(ctx, task)
+}
+
+func (r *tracedTaskRepository) Close() error {
+	return r.next.Close()
+}
+
+type tracedUserRepository struct {
+	next models.UserRepository
+}
+
+// TraceUserRepository wraps a UserRepository so every call gets its own span.
+func TraceUserRepository(next models.UserRepository) models.UserRepository {
+	return &tracedUserRepository{next: next}
+}
+
+func (r *tracedUserRepository) CreateUser(ctx context.Context, user models.User) (err error) {
+	ctx, span := startSpan(ctx, "UserRepository.CreateUser", attribute.String("user.id", user.UserID))
+	defer func() { endSpan(span, err) }()
+	return r.next.CreateUser(ctx, user)
+}
+
+func (r *tracedUserRepository) UpdateUser(ctx context.Context, user models.User) (err error) {
+	ctx, span := startSpan(ctx, "UserRepository.UpdateUser", attribute.String("user.id", user.UserID))
+	defer func() { endSpan(span, err) }()
+	return r.next.UpdateUser(ctx, user)
+}
+
+func (r *tracedUserRepository) GetUser(ctx context.Context, userID string) (user *models.User, err error) {
+	ctx, span := startSpan(ctx, "UserRepository.GetUser", attribute.String("user.id", userID))
+	defer func() { endSpan(span, err) }()
+	return r.next.GetUser(ctx, userID)
+}
+
+func (r *tracedUserRepository) ListUsers(ctx context.Context) (users []models.User, err error) {
+	ctx, span := startSpan(ctx, "UserRepository.ListUsers")
+	defer func() { endSpan(span, err) }()
+	return r.next.ListUsers(ctx)
+}
+
+func (r *tracedUserRepository) ListDigestSubscribers(ctx context.Context) (users []models.User, err error) {
+	ctx, span := startSpan(ctx, "UserRepository.ListDigestSubscribers")
+	defer func() { endSpan(span, err) }()
+	return r.next.ListDigestSubscribers(ctx)
+}
+
+func (r *tracedUserRepository) MarkDigestSent(ctx context.Context, userID string, day civil.Date) (err error) {
+	ctx, span := startSpan(ctx, "UserRepository.MarkDigestSent", attribute.String("user.id", userID))
+	defer func() { endSpan(span, err) }()
+	return r.next.MarkDigestSent(ctx, userID, day)
+}
+
+func (r *tracedUserRepository) UnsubscribeDigest(ctx context.Context, userID string) (err error) {
+	ctx, span := startSpan(ctx, "UserRepository.UnsubscribeDigest", attribute.String("user.id", userID))
+	defer func() { endSpan(span, err) }()
+	return r.next.UnsubscribeDigest(ctx, userID)
+}
+
+func (r *tracedUserRepository) GetUserByCalendarToken(ctx context.Context, token string) (user *models.User, err error) {
+	ctx, span := startSpan(ctx, "UserRepository.GetUserByCalendarToken")
+	defer func() { endSpan(span, err) }()
+	return r.next.GetUserByCalendarToken(ctx, token)
+}
+
+func (r *tracedUserRepository) SetCalendarToken(ctx context.Context, userID string, token string) (err error) {
+	ctx, span := startSpan(ctx, "UserRepository.SetCalendarToken", attribute.String("user.id", userID))
+	defer func() { endSpan(span, err) }()
+	return r.next.SetCalendarToken(ctx, userID, token)
+}
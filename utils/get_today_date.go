@@ -1,13 +1,21 @@
 package utils
 
-import(
+import (
 	"time"
+
+	"cloud.google.com/go/civil"
 )
 
-func GetTodayDate()string{
-	now := time.Now()
+// GetTodayDate returns the current civil date in loc
+func GetTodayDate(loc *time.Location) civil.Date {
+	return civil.DateOf(time.Now().In(loc))
+}
 
-    // Format the date as YYYY-MM-DD
-    formattedDate := now.Format("2006-01-02")
-	return formattedDate
-}
\ No newline at end of file
+// IsValidTimezone reports whether name is an IANA timezone the server knows
+func IsValidTimezone(name string) bool {
+	if name == "" || name == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
@@ -0,0 +1,80 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is returned for URLs that reach the server's own
+// network: loopback, private, link-local, multicast or unspecified addresses
+var ErrForbiddenAddress = errors.New("address not allowed")
+
+// NewClient returns an HTTP client for calling user supplied URLs. The
+// address of every connection is checked after DNS resolution, redirects
+// included, so names pointing into the server's network are refused too.
+// Environment proxies are not used, as the proxy would be checked instead
+// of the target.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: checkDial}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// CheckURL resolves the host of raw and returns ErrForbiddenAddress when
+// any of its addresses is refused by NewClient's clients, so forms can turn
+// such URLs down before anything is sent to them
+func CheckURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if forbidden(addr) {
+			return ErrForbiddenAddress
+		}
+	}
+	return nil
+}
+
+// checkDial is the Control of the dialer, called with the resolved address
+// right before connecting
+func checkDial(network string, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil || forbidden(addrPort.Addr()) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+func forbidden(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast()
+}
+
+// describe turns a failed request into a message fit for the delivery
+// log, which users see; transport errors would show internal addresses
+func describe(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrForbiddenAddress):
+		return "address not allowed"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timed out"
+	default:
+		return "connection failed"
+	}
+}
@@ -0,0 +1,72 @@
+package webhooks
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckDial(t *testing.T) {
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		// Loopback
+		{address: "127.0.0.1:443"},
+		{address: "127.1.2.3:80"},
+		{address: "[::1]:443"},
+		// Private
+		{address: "10.0.0.5:443"},
+		{address: "172.16.3.4:443"},
+		{address: "172.31.255.255:443"},
+		{address: "192.168.1.1:443"},
+		{address: "[fd12:3456:789a::1]:443"},
+		// Link-local, cloud metadata services included
+		{address: "169.254.169.254:80"},
+		{address: "[fe80::1]:443"},
+		{address: "[fe80::1%eth0]:443"},
+		// IPv6-mapped IPv4
+		{address: "[::ffff:127.0.0.1]:443"},
+		{address: "[::ffff:10.0.0.5]:443"},
+		{address: "[::ffff:169.254.169.254]:80"},
+		// Unspecified and multicast
+		{address: "0.0.0.0:443"},
+		{address: "[::]:443"},
+		{address: "224.0.0.1:443"},
+		{address: "[ff02::1]:443"},
+		// Not a resolved address
+		{address: "example.com:443"},
+		{address: "93.184.216.34"},
+		// Public
+		{address: "93.184.216.34:443", allowed: true},
+		{address: "172.32.0.1:443", allowed: true},
+		{address: "[2606:2800:220:1:248:1893:25c8:1946]:443", allowed: true},
+		{address: "[::ffff:93.184.216.34]:443", allowed: true},
+	}
+	for _, tt := range tests {
+		err := checkDial("tcp", tt.address, nil)
+		if tt.allowed && err != nil {
+			t.Errorf("checkDial(%q) = %v, want it allowed", tt.address, err)
+		}
+		if !tt.allowed && !errors.Is(err, ErrForbiddenAddress) {
+			t.Errorf("checkDial(%q) = %v, want ErrForbiddenAddress", tt.address, err)
+		}
+	}
+}
+
+func TestNewClientRefusesLoopback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the request reached the server")
+	}))
+	defer srv.Close()
+
+	resp, err := NewClient(5 * time.Second).Get(srv.URL)
+	if err == nil {
+		resp.Body.Close()
+	}
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("Get(%s) = %v, want ErrForbiddenAddress", srv.URL, err)
+	}
+}
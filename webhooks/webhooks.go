@@ -0,0 +1,259 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Zenk41/go-gin-htmx/models"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// batchSize bounds how many due deliveries are attempted per run
+	batchSize = 100
+	// maxAttempts is how often a delivery is tried before it is marked failed
+	maxAttempts = 8
+	// firstRetry is the delay after the first failure, doubled on every further one
+	firstRetry = 30 * time.Second
+
+	// SignatureHeader carries "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">"
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// Payload is the JSON body of every delivery
+type Payload struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// Task is the task representation sent in task events
+type Task struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Status      string    `json:"status"`
+	Date        string    `json:"date"`
+	DueTime     string    `json:"due_time,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func taskData(task models.Task) Task {
+	return Task{
+		ID:          task.TaskID,
+		Title:       task.Title,
+		Description: task.Description,
+		Status:      task.Status,
+		Date:        task.Day().String(),
+		DueTime:     task.DueTime,
+		CreatedAt:   task.CreatedAt,
+		UpdatedAt:   task.UpdatedAt,
+	}
+}
+
+// Dispatcher queues task events for the webhooks subscribed to them and
+// delivers the queue. Deliveries are persisted before they are attempted,
+// so they survive restarts; delivery is at-least-once and receivers should
+// dedupe on the X-Webhook-Delivery header.
+type Dispatcher struct {
+	Repo   models.WebhookRepository
+	Client *http.Client // from NewClient, so receivers inside the network are refused
+}
+
+// EmitTaskEvent queues event about task for every webhook of the user subscribed to it
+func (d *Dispatcher) EmitTaskEvent(ctx context.Context, userID string, event string, task models.Task) error {
+	webhooks, err := d.Repo.ListSubscribed(ctx, userID, event)
+	if err != nil || len(webhooks) == 0 {
+		return err
+	}
+
+	now := time.Now()
+	deliveries := make([]models.WebhookDelivery, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		delivery, err := newDelivery(webhook, event, taskData(task), now)
+		if err != nil {
+			return err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	_, err = d.Repo.Enqueue(ctx, deliveries)
+	return err
+}
+
+// SendTest queues a ping for webhook and attempts it right away, returning
+// the delivery with its outcome
+func (d *Dispatcher) SendTest(ctx context.Context, webhook models.Webhook) (models.WebhookDelivery, error) {
+	now := time.Now()
+	delivery, err := newDelivery(webhook, models.EventPing, map[string]string{"message": "Test event"}, now)
+	if err != nil {
+		return delivery, err
+	}
+	queued, err := d.Repo.Enqueue(ctx, []models.WebhookDelivery{delivery})
+	if err != nil {
+		return delivery, err
+	}
+	return d.attempt(ctx, webhook.Secret, queued[0], now), nil
+}
+
+func newDelivery(webhook models.Webhook, event string, data any, now time.Time) (models.WebhookDelivery, error) {
+	id, err := newID()
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	body, err := json.Marshal(Payload{ID: id, Event: event, CreatedAt: now, Data: data})
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	return models.WebhookDelivery{
+		WebhookID:     webhook.ID,
+		UserID:        webhook.UserID,
+		URL:           webhook.URL,
+		Event:         event,
+		Payload:       string(body),
+		Status:        models.DeliveryPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}, nil
+}
+
+// Deliver attempts the deliveries that are due. Register it with the scheduler.
+func (d *Dispatcher) Deliver(ctx context.Context, now time.Time) error {
+	due, err := d.Repo.DueDeliveries(ctx, now, batchSize)
+	if err != nil {
+		return err
+	}
+
+	secrets := map[string]string{}
+	for _, delivery := range due {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		secret, ok := secrets[delivery.WebhookID]
+		if !ok {
+			webhook, err := d.Repo.GetWebhook(ctx, delivery.UserID, delivery.WebhookID)
+			switch {
+			case status.Code(err) == codes.NotFound:
+				// The webhook was deleted after the event was queued
+				if err := d.Repo.FinishDelivery(ctx, delivery.ID, models.DeliveryFailed, delivery.Attempts, 0, "webhook no longer exists"); err != nil {
+					logrus.WithError(err).WithField("delivery_id", delivery.ID).Error("Failed to drop webhook delivery")
+				}
+				continue
+			case err != nil:
+				// Firestore is unavailable; try again later without using up an attempt
+				log := logrus.WithError(err).WithField("delivery_id", delivery.ID)
+				log.Warn("Failed to look up the webhook of a delivery")
+				if err := d.Repo.RetryDelivery(ctx, delivery.ID, now.Add(firstRetry), delivery.Attempts, 0, delivery.LastError); err != nil {
+					log.WithError(err).Error("Failed to reschedule webhook delivery")
+				}
+				continue
+			}
+			secret = webhook.Secret
+			secrets[delivery.WebhookID] = secret
+		}
+		d.attempt(ctx, secret, delivery, now)
+	}
+	return nil
+}
+
+// attempt posts one delivery and records the outcome, backing off
+// exponentially after failures and giving up after maxAttempts
+func (d *Dispatcher) attempt(ctx context.Context, secret string, delivery models.WebhookDelivery, now time.Time) models.WebhookDelivery {
+	log := logrus.WithFields(logrus.Fields{"delivery_id": delivery.ID, "webhook_id": delivery.WebhookID})
+
+	delivery.Attempts++
+	delivery.ResponseStatus, delivery.LastError = 0, ""
+	status, err := d.post(ctx, secret, delivery, now)
+	delivery.ResponseStatus = status
+	if err == nil {
+		delivery.Status = models.DeliveryDelivered
+		if err := d.Repo.FinishDelivery(ctx, delivery.ID, delivery.Status, delivery.Attempts, status, ""); err != nil {
+			log.WithError(err).Error("Failed to mark webhook delivery as delivered")
+		}
+		return delivery
+	}
+
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= maxAttempts {
+		log.WithError(err).Warn("Giving up on webhook delivery")
+		delivery.Status = models.DeliveryFailed
+		if err := d.Repo.FinishDelivery(ctx, delivery.ID, delivery.Status, delivery.Attempts, status, delivery.LastError); err != nil {
+			log.WithError(err).Error("Failed to mark webhook delivery as failed")
+		}
+		return delivery
+	}
+
+	delivery.NextAttemptAt = now.Add(firstRetry << (delivery.Attempts - 1))
+	if err := d.Repo.RetryDelivery(ctx, delivery.ID, delivery.NextAttemptAt, delivery.Attempts, status, delivery.LastError); err != nil {
+		log.WithError(err).Error("Failed to reschedule webhook delivery")
+	}
+	return delivery
+}
+
+func (d *Dispatcher) post(ctx context.Context, secret string, delivery models.WebhookDelivery, now time.Time) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader([]byte(delivery.Payload)))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-gin-htmx-webhooks")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(SignatureHeader, Sign(secret, now, []byte(delivery.Payload)))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, errors.New(describe(err))
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // Drain so the connection can be reused
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign computes the signature header for body sent at t
+func Sign(secret string, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret generates a signing secret for a new webhook
+func NewSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.New("failed to generate webhook secret: " + err.Error())
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.New("failed to generate event ID: " + err.Error())
+	}
+	return "evt_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,185 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zenk41/go-gin-htmx/models"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"id":"evt_1"}`)
+	tests := []struct {
+		secret string
+		t      time.Time
+		body   []byte
+		want   string
+	}{
+		{
+			secret: "whsec_test",
+			t:      time.Unix(1700000000, 0),
+			body:   body,
+			want:   "t=1700000000,v1=c89214b5b5da833daed6f0b8c5bb6bd58cea9022bd80ccc78230f3942d632925",
+		},
+		// The timestamp is in whole seconds
+		{
+			secret: "whsec_test",
+			t:      time.Unix(1700000000, 999999999),
+			body:   body,
+			want:   "t=1700000000,v1=c89214b5b5da833daed6f0b8c5bb6bd58cea9022bd80ccc78230f3942d632925",
+		},
+		{
+			secret: "other",
+			t:      time.Unix(1700000000, 0),
+			body:   body,
+			want:   "t=1700000000,v1=e12ef238930e9a9dcbebaf3147df8d7a19ab1524ac7be39f4f8d50cb628f0ab5",
+		},
+		{
+			secret: "",
+			t:      time.Unix(0, 0),
+			body:   nil,
+			want:   "t=0,v1=b849d5a581847b281957065739df36df2463d1977ea8d6e1e4e6cf33fadc68c3",
+		},
+	}
+	for _, tt := range tests {
+		if got := Sign(tt.secret, tt.t, tt.body); got != tt.want {
+			t.Errorf("Sign(%q, %d, %q) = %q, want %q", tt.secret, tt.t.Unix(), tt.body, got, tt.want)
+		}
+	}
+}
+
+// roundTrip answers every request with status, remembering the last one
+type roundTrip struct {
+	status int
+	last   *http.Request
+}
+
+func (rt *roundTrip) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.last = req
+	return &http.Response{
+		StatusCode: rt.status,
+		Status:     http.StatusText(rt.status),
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+// fakeRepo records the outcomes the dispatcher writes
+type fakeRepo struct {
+	models.WebhookRepository
+	finished []string
+	retries  []time.Time
+}
+
+func (r *fakeRepo) FinishDelivery(ctx context.Context, id string, status string, attempts int, responseStatus int, lastError string) error {
+	r.finished = append(r.finished, status)
+	return nil
+}
+
+func (r *fakeRepo) RetryDelivery(ctx context.Context, id string, next time.Time, attempts int, responseStatus int, lastError string) error {
+	r.retries = append(r.retries, next)
+	return nil
+}
+
+func TestAttemptBackoff(t *testing.T) {
+	repo := &fakeRepo{}
+	d := &Dispatcher{Repo: repo, Client: &http.Client{Transport: &roundTrip{status: http.StatusInternalServerError}}}
+	now := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+
+	// Each failure doubles the delay, and the eighth gives up
+	tests := []struct {
+		attempts int
+		status   string
+		delay    time.Duration
+	}{
+		{attempts: 1, status: models.DeliveryPending, delay: 30 * time.Second},
+		{attempts: 2, status: models.DeliveryPending, delay: time.Minute},
+		{attempts: 3, status: models.DeliveryPending, delay: 2 * time.Minute},
+		{attempts: 4, status: models.DeliveryPending, delay: 4 * time.Minute},
+		{attempts: 5, status: models.DeliveryPending, delay: 8 * time.Minute},
+		{attempts: 6, status: models.DeliveryPending, delay: 16 * time.Minute},
+		{attempts: 7, status: models.DeliveryPending, delay: 32 * time.Minute},
+		{attempts: 8, status: models.DeliveryFailed},
+	}
+	delivery := models.WebhookDelivery{ID: "evt_1", URL: "https://example.com/hook", Status: models.DeliveryPending}
+	for _, tt := range tests {
+		delivery = d.attempt(context.Background(), "whsec_test", delivery, now)
+		if delivery.Attempts != tt.attempts || delivery.Status != tt.status {
+			t.Fatalf("after attempt %d: attempts %d, status %q, want %q", tt.attempts, delivery.Attempts, delivery.Status, tt.status)
+		}
+		if delivery.ResponseStatus != http.StatusInternalServerError || delivery.LastError == "" {
+			t.Errorf("attempt %d: response %d, error %q", tt.attempts, delivery.ResponseStatus, delivery.LastError)
+		}
+		if tt.status == models.DeliveryPending {
+			if want := now.Add(tt.delay); !delivery.NextAttemptAt.Equal(want) {
+				t.Errorf("attempt %d: next attempt at %v, want %v", tt.attempts, delivery.NextAttemptAt, want)
+			}
+		}
+		now = delivery.NextAttemptAt
+	}
+	if len(repo.retries) != maxAttempts-1 || len(repo.finished) != 1 || repo.finished[0] != models.DeliveryFailed {
+		t.Errorf("retries %v, finished %v", repo.retries, repo.finished)
+	}
+}
+
+func TestAttemptDelivered(t *testing.T) {
+	repo := &fakeRepo{}
+	rt := &roundTrip{status: http.StatusNoContent}
+	d := &Dispatcher{Repo: repo, Client: &http.Client{Transport: rt}}
+	now := time.Unix(1700000000, 0)
+	delivery := models.WebhookDelivery{
+		ID:      "evt_1",
+		URL:     "https://example.com/hook",
+		Event:   models.EventTaskCreated,
+		Payload: `{"id":"evt_1"}`,
+		Status:  models.DeliveryPending,
+	}
+
+	delivery = d.attempt(context.Background(), "whsec_test", delivery, now)
+	if delivery.Status != models.DeliveryDelivered || delivery.Attempts != 1 || delivery.LastError != "" {
+		t.Errorf("delivery = %+v", delivery)
+	}
+	if len(repo.finished) != 1 || len(repo.retries) != 0 {
+		t.Errorf("retries %v, finished %v", repo.retries, repo.finished)
+	}
+
+	headers := map[string]string{
+		SignatureHeader: "t=1700000000,v1=c89214b5b5da833daed6f0b8c5bb6bd58cea9022bd80ccc78230f3942d632925",
+		EventHeader:     models.EventTaskCreated,
+		DeliveryHeader:  "evt_1",
+		"Content-Type":  "application/json",
+	}
+	for name, want := range headers {
+		if got := rt.last.Header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: ErrForbiddenAddress, want: "address not allowed"},
+		{err: errors.New("dial tcp 10.0.0.5:443: connect: connection refused"), want: "connection failed"},
+		{err: context.DeadlineExceeded, want: "timed out"},
+		{err: timeoutError{}, want: "timed out"},
+	}
+	for _, tt := range tests {
+		if got := describe(tt.err); got != tt.want {
+			t.Errorf("describe(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }